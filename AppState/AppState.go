@@ -7,10 +7,10 @@ import (
 )
 
 type LBConfig struct {
-	ISOVolume      string
-	ISOPublisher   string
-	ISOApplication string
-	ISOImageName   string
+	ISOVolume      string `json:"iso_volume"`
+	ISOPublisher   string `json:"iso_publisher"`
+	ISOApplication string `json:"iso_application"`
+	ISOImageName   string `json:"iso_image_name"`
 }
 
 func initalLBconfig() *LBConfig {
@@ -30,6 +30,9 @@ type State struct {
 	selectedFiles map[string]selectedFileMap
 	WriteLock     sync.Mutex
 	LBcfg         *LBConfig
+	listeners     []func()
+	profileName   string
+	variables     map[string]string
 }
 
 var globalState *State
@@ -42,6 +45,7 @@ func GetGlobalState() *State {
 			globalState = &State{
 				selectedFiles: make(map[string]selectedFileMap),
 				LBcfg:         initalLBconfig(),
+				variables:     make(map[string]string),
 			}
 		}
 	}
@@ -55,6 +59,22 @@ func (state *State) GetDirectoryEntryMap(identifier string) selectedFileMap {
 	}
 	return state.selectedFiles[identifier]
 }
+
+// OnChange registers a callback run whenever the state is replaced wholesale (eg a profile load)
+func (state *State) OnChange(callback func()) {
+	state.WriteLock.Lock()
+	defer state.WriteLock.Unlock()
+	state.listeners = append(state.listeners, callback)
+}
+func (state *State) notifyChanged() {
+	state.WriteLock.Lock()
+	listeners := make([]func(), len(state.listeners))
+	copy(listeners, state.listeners)
+	state.WriteLock.Unlock()
+	for _, listener := range listeners {
+		listener()
+	}
+}
 func (state *State) setISOStringField(field *string, value string) {
 	state.WriteLock.Lock()
 	defer state.WriteLock.Unlock()
@@ -85,3 +105,14 @@ func (state *State) ISOApplication() string {
 func (state *State) ISOImageName() string {
 	return state.LBcfg.ISOImageName
 }
+
+// SelectOnly makes entry the only selected file of identifier, for pickers that allow one file
+func (state *State) SelectOnly(identifier string, entry filesystem.DirectoryEntry) {
+	fileMap := state.GetDirectoryEntryMap(identifier)
+	state.WriteLock.Lock()
+	defer state.WriteLock.Unlock()
+	for fileName := range fileMap {
+		delete(fileMap, fileName)
+	}
+	fileMap[entry.Name()] = entry
+}
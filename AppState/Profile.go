@@ -0,0 +1,224 @@
+package appstate
+
+/*
+Build profiles, a named snapshot of every file selection, the iso fields and the
+template variables persisted as json under <appdata>/Profiles/<name>.json
+*/
+
+import (
+	filesystem "LiveBuilder/Filesystem"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const PROFILE_EXT = ".json"
+
+type Profile struct {
+	Name       string              `json:"name"`
+	LBConfig   LBConfig            `json:"lb_config"`
+	Selections map[string][]string `json:"selections"` // filesystem identifier -> selected file names
+	Variables  map[string]string   `json:"variables,omitempty"`
+}
+
+func GetProfilesDir() (string, error) {
+	appdata, err := filesystem.GetAppDataDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(appdata, filesystem.PROFILES_DIR_ID)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+func validateProfileName(name string) error {
+	if strings.TrimSpace(name) == "" {
+		return fmt.Errorf("profile name can not be empty")
+	}
+	if strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return fmt.Errorf("invalid profile name: %s", name)
+	}
+	return nil
+}
+
+func profilePath(name string) (string, error) {
+	if err := validateProfileName(name); err != nil {
+		return "", err
+	}
+	dir, err := GetProfilesDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name+PROFILE_EXT), nil
+}
+
+// ListProfiles returns the names of all saved profiles, sorted
+func ListProfiles() ([]string, error) {
+	dir, err := GetProfilesDir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != PROFILE_EXT {
+			continue
+		}
+		names = append(names, strings.TrimSuffix(entry.Name(), PROFILE_EXT))
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func ReadProfile(name string) (Profile, error) {
+	path, err := profilePath(name)
+	if err != nil {
+		return Profile{}, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Profile{}, fmt.Errorf("reading profile %s: %w", name, err)
+	}
+	var profile Profile
+	if err := json.Unmarshal(data, &profile); err != nil {
+		return Profile{}, fmt.Errorf("parsing profile %s: %w", name, err)
+	}
+	profile.Name = name
+	return profile, nil
+}
+
+func WriteProfile(profile Profile) error {
+	path, err := profilePath(profile.Name)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(profile, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+func DuplicateProfile(source, destination string) error {
+	profile, err := ReadProfile(source)
+	if err != nil {
+		return err
+	}
+	if path, err := profilePath(destination); err != nil {
+		return err
+	} else if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("profile %s already exists", destination)
+	}
+	profile.Name = destination
+	return WriteProfile(profile)
+}
+
+func DeleteProfile(name string) error {
+	path, err := profilePath(name)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// SnapshotProfile captures the current selections, iso fields and variables under the given name
+func (state *State) SnapshotProfile(name string) Profile {
+	state.WriteLock.Lock()
+	defer state.WriteLock.Unlock()
+
+	profile := Profile{
+		Name:       name,
+		LBConfig:   *state.LBcfg,
+		Selections: make(map[string][]string),
+		Variables:  maps.Clone(state.variables),
+	}
+	for identifier, fileMap := range state.selectedFiles {
+		names := make([]string, 0, len(fileMap))
+		for fileName := range fileMap {
+			names = append(names, fileName)
+		}
+		sort.Strings(names)
+		profile.Selections[identifier] = names
+	}
+	return profile
+}
+
+// ApplyProfile replaces the current selections with the profiles, selection maps are
+// cleared in place so widgets holding them stay in sync. Files that no longer exist are
+// skipped and reported in the returned error
+func (state *State) ApplyProfile(profile Profile) error {
+	fileManager := filesystem.GetFileManager()
+	var missing []string
+
+	state.WriteLock.Lock()
+	*state.LBcfg = profile.LBConfig
+	state.profileName = profile.Name
+	state.variables = maps.Clone(profile.Variables)
+	if state.variables == nil {
+		state.variables = make(map[string]string)
+	}
+	for _, fileMap := range state.selectedFiles {
+		for fileName := range fileMap {
+			delete(fileMap, fileName)
+		}
+	}
+	state.WriteLock.Unlock()
+
+	for identifier, names := range profile.Selections {
+		fileMap := state.GetDirectoryEntryMap(identifier)
+		for _, name := range names {
+			entry, err := fileManager.GetEntryByName(identifier, name)
+			if err != nil {
+				log.Printf("Profile %s: %v\n", profile.Name, err)
+				missing = append(missing, filepath.Join(identifier, name))
+				continue
+			}
+			fileMap[entry.Name()] = entry
+		}
+	}
+	state.notifyChanged()
+
+	if len(missing) > 0 {
+		return fmt.Errorf("profile %s references missing files: %s", profile.Name, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+func (state *State) SaveProfile(name string) error {
+	if err := WriteProfile(state.SnapshotProfile(name)); err != nil {
+		return err
+	}
+	state.setActiveProfileName(name)
+	return nil
+}
+
+func (state *State) LoadProfile(name string) error {
+	profile, err := ReadProfile(name)
+	if err != nil {
+		return err
+	}
+	return state.ApplyProfile(profile)
+}
+
+// ActiveProfileName is the name of the last profile applied or saved, empty if none
+func (state *State) ActiveProfileName() string {
+	state.WriteLock.Lock()
+	defer state.WriteLock.Unlock()
+	return state.profileName
+}
+
+func (state *State) setActiveProfileName(name string) {
+	state.WriteLock.Lock()
+	defer state.WriteLock.Unlock()
+	state.profileName = name
+}
@@ -0,0 +1,125 @@
+package appstate
+
+/*
+Template variables, free form name -> value pairs saved with the profile. Selected files
+declare the variables they use in their .meta.json, which is how the gui knows what to
+prompt for and how a build knows to stop before anything runs when a value is missing
+*/
+
+import (
+	filesystem "LiveBuilder/Filesystem"
+	"fmt"
+	"maps"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// variable names have to work as {{.name}} in a template
+var VARIABLE_NAME = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func ValidateVariableName(name string) error {
+	if !VARIABLE_NAME.MatchString(name) {
+		return fmt.Errorf("invalid variable name %q, use letters, digits and _", name)
+	}
+	return nil
+}
+
+// Variables returns a copy of the variables set in the current profile
+func (state *State) Variables() map[string]string {
+	state.WriteLock.Lock()
+	defer state.WriteLock.Unlock()
+	return maps.Clone(state.variables)
+}
+
+func (state *State) SetVariable(name, value string) error {
+	if err := ValidateVariableName(name); err != nil {
+		return err
+	}
+	state.WriteLock.Lock()
+	defer state.WriteLock.Unlock()
+	state.variables[name] = value
+	return nil
+}
+
+func (state *State) UnsetVariable(name string) {
+	state.WriteLock.Lock()
+	defer state.WriteLock.Unlock()
+	delete(state.variables, name)
+}
+
+// DeclaredVariables merges the variable declarations of every selected file, sorted by name.
+// A variable is required if any file requires it, the first description and default win
+func (state *State) DeclaredVariables() []filesystem.VariableSpec {
+	declared := make(map[string]filesystem.VariableSpec)
+	for _, entry := range state.selectedEntries() {
+		for _, spec := range entry.MetaData.Variables {
+			merged, ok := declared[spec.Name]
+			if !ok {
+				declared[spec.Name] = spec
+				continue
+			}
+			merged.Required = merged.Required || spec.Required
+			if merged.Description == "" {
+				merged.Description = spec.Description
+			}
+			if merged.Default == "" {
+				merged.Default = spec.Default
+			}
+			declared[spec.Name] = merged
+		}
+	}
+
+	specs := make([]filesystem.VariableSpec, 0, len(declared))
+	for _, spec := range declared {
+		specs = append(specs, spec)
+	}
+	sort.Slice(specs, func(i, j int) bool { return specs[i].Name < specs[j].Name })
+	return specs
+}
+
+// MissingVariables returns the required variables that have neither a value nor a default
+func (state *State) MissingVariables() []filesystem.VariableSpec {
+	values := state.Variables()
+	var missing []filesystem.VariableSpec
+	for _, spec := range state.DeclaredVariables() {
+		if spec.Required && spec.Default == "" && strings.TrimSpace(values[spec.Name]) == "" {
+			missing = append(missing, spec)
+		}
+	}
+	return missing
+}
+
+// CheckVariables validates the declarations of the selected files and fails on missing values
+func (state *State) CheckVariables() error {
+	for _, entry := range state.selectedEntries() {
+		for _, spec := range entry.MetaData.Variables {
+			if err := ValidateVariableName(spec.Name); err != nil {
+				return fmt.Errorf("%s: %w", entry.Name(), err)
+			}
+		}
+	}
+	missing := state.MissingVariables()
+	if len(missing) == 0 {
+		return nil
+	}
+	names := make([]string, len(missing))
+	for i, spec := range missing {
+		names[i] = spec.Name
+	}
+	return fmt.Errorf("missing required variables: %s", strings.Join(names, ", "))
+}
+
+func (state *State) selectedEntries() []filesystem.DirectoryEntry {
+	state.WriteLock.Lock()
+	defer state.WriteLock.Unlock()
+	var entries []filesystem.DirectoryEntry
+	for _, fileMap := range state.selectedFiles {
+		for _, entry := range fileMap {
+			entries = append(entries, entry)
+		}
+	}
+	//map order would make the merge in DeclaredVariables change between runs
+	sort.Slice(entries, func(i, j int) bool { return entries[i].FullPath() < entries[j].FullPath() })
+	return entries
+}
@@ -0,0 +1,49 @@
+package appstate
+
+import (
+	filesystem "LiveBuilder/Filesystem"
+	"testing"
+)
+
+func TestProfileRoundTrip(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	state := GetGlobalState()
+
+	packages := filesystem.GetFileManager().GetFileSystem(filesystem.PACKAGE_DIR_ID)
+	if len(packages) == 0 {
+		t.Fatal("no embedded package lists extracted")
+	}
+	state.GetDirectoryEntryMap(filesystem.PACKAGE_DIR_ID)[packages[0].Name()] = packages[0]
+	state.SetISOImageName("weekly image")
+
+	if err := state.SaveProfile("weekly"); err != nil {
+		t.Fatal(err)
+	}
+	if err := DuplicateProfile("weekly", "weekly-copy"); err != nil {
+		t.Fatal(err)
+	}
+
+	state.SetISOImageName("other")
+	delete(state.GetDirectoryEntryMap(filesystem.PACKAGE_DIR_ID), packages[0].Name())
+
+	if err := state.LoadProfile("weekly-copy"); err != nil {
+		t.Fatal(err)
+	}
+	if state.ISOImageName() != "weekly_image" {
+		t.Errorf("image name not restored, got %s", state.ISOImageName())
+	}
+	if _, ok := state.GetDirectoryEntryMap(filesystem.PACKAGE_DIR_ID)[packages[0].Name()]; !ok {
+		t.Errorf("package selection %s not restored", packages[0].Name())
+	}
+
+	if err := DeleteProfile("weekly"); err != nil {
+		t.Fatal(err)
+	}
+	names, err := ListProfiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 || names[0] != "weekly-copy" {
+		t.Errorf("unexpected profiles after delete: %v", names)
+	}
+}
@@ -0,0 +1,40 @@
+package appstate
+
+import (
+	filesystem "LiveBuilder/Filesystem"
+	"testing"
+)
+
+func TestDeclaredVariables(t *testing.T) {
+	state := &State{
+		selectedFiles: make(map[string]selectedFileMap),
+		variables:     make(map[string]string),
+	}
+	configs := state.GetDirectoryEntryMap(filesystem.LBCONFIGS_DIR_ID)
+	configs["kiosk.json"] = filesystem.DirectoryEntry{MetaData: filesystem.FileMetadata{Variables: []filesystem.VariableSpec{
+		{Name: "hostname", Description: "name of the live system"},
+		{Name: "locale", Default: "en_US.UTF-8"},
+	}}}
+	customFiles := state.GetDirectoryEntryMap(filesystem.CUSTOMFILES_DIR_ID)
+	customFiles["hostname.hook.chroot"] = filesystem.DirectoryEntry{MetaData: filesystem.FileMetadata{Variables: []filesystem.VariableSpec{
+		{Name: "hostname", Required: true},
+		{Name: "timezone", Required: true, Default: "UTC"},
+	}}}
+
+	declared := state.DeclaredVariables()
+	if len(declared) != 3 || declared[0].Name != "hostname" || !declared[0].Required || declared[0].Description == "" {
+		t.Fatalf("expected the declarations to be merged, got %+v", declared)
+	}
+	if err := state.CheckVariables(); err == nil {
+		t.Error("expected the missing hostname to fail the check")
+	}
+	if err := state.SetVariable("hostname", "kiosk"); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.CheckVariables(); err != nil {
+		t.Errorf("expected defaults to satisfy required variables: %v", err)
+	}
+	if err := state.SetVariable("host-name", "kiosk"); err == nil {
+		t.Error("expected a name that is not usable in templates to be refused")
+	}
+}
@@ -0,0 +1,243 @@
+package buildmanager
+
+/*
+Every build gets its own directory under BuiltISOs holding the iso alongside
+	build.log      full transcript of every event, including all lb stdout/stderr
+	lb-config.txt  the rendered lb config command line
+	manifest.json  inputs, lb config and imported files + checksums, variables, versions, timings and result
+*/
+
+import (
+	appstate "LiveBuilder/AppState"
+	filesystem "LiveBuilder/Filesystem"
+	lbconfig "LiveBuilder/LBConfig"
+	preflightchecks "LiveBuilder/PreFlightChecks"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	RECORD_LOG_FILE      = "build.log"
+	RECORD_LBCONFIG_FILE = "lb-config.txt"
+	RECORD_MANIFEST_FILE = "manifest.json"
+
+	STATUS_SUCCESS   = "success"
+	STATUS_FAILED    = "failed"
+	STATUS_CANCELLED = "cancelled"
+)
+
+type ImportedFile struct {
+	Source      string `json:"source"`
+	InstallPath string `json:"install_path"`
+	SHA256      string `json:"sha256"`
+}
+
+type CommandRecord struct {
+	Command  []string      `json:"command"`
+	Stage    string        `json:"stage"`
+	ExitCode int           `json:"exit_code"`
+	Duration time.Duration `json:"duration"`
+}
+
+type BuildManifest struct {
+	ID              string            `json:"id"`
+	Inputs          appstate.Profile  `json:"inputs"`
+	AppVersion      string            `json:"app_version"`
+	LBVersion       string            `json:"lb_version"`
+	BuildPath       string            `json:"build_path"`
+	LBConfigCommand []string          `json:"lb_config_command"`
+	LBConfigFile    *ImportedFile     `json:"lb_config_file,omitempty"`
+	Variables       map[string]string `json:"variables,omitempty"`
+	ImportedFiles   []ImportedFile    `json:"imported_files"`
+	Commands        []CommandRecord   `json:"commands"`
+	Artifacts       []string          `json:"artifacts"`
+	StartTime       time.Time         `json:"start_time"`
+	EndTime         time.Time         `json:"end_time"`
+	Duration        time.Duration     `json:"duration"`
+	Status          string            `json:"status"`
+	Error           string            `json:"error,omitempty"`
+}
+
+type buildRecorder struct {
+	dir      string
+	logFile  *os.File
+	manifest BuildManifest
+	mutex    sync.Mutex
+}
+
+func GetBuildRecordsDir() (string, error) {
+	appdata, err := filesystem.GetAppDataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(appdata, filesystem.ISO_DIR_ID), nil
+}
+
+// newBuildRecorder creates the record directory for a build of the current global state
+func newBuildRecorder() (*buildRecorder, error) {
+	recordsDir, err := GetBuildRecordsDir()
+	if err != nil {
+		return nil, err
+	}
+	state := appstate.GetGlobalState()
+	started := time.Now()
+	imageName := strings.ReplaceAll(state.ISOImageName(), string(filepath.Separator), "_")
+	id, dir, err := makeRecordDir(recordsDir, fmt.Sprintf("%s-%s", started.Format("20060102-150405"), imageName))
+	if err != nil {
+		return nil, err
+	}
+	logFile, err := os.Create(filepath.Join(dir, RECORD_LOG_FILE))
+	if err != nil {
+		return nil, err
+	}
+
+	lbVersion, err := preflightchecks.GetLBVersion()
+	if err != nil {
+		log.Printf("Could not get lb version for build record: %v\n", err)
+		lbVersion = "unknown"
+	}
+
+	return &buildRecorder{
+		dir:     dir,
+		logFile: logFile,
+		manifest: BuildManifest{
+			ID:           id,
+			Inputs:       state.SnapshotProfile(state.ActiveProfileName()),
+			AppVersion:   filesystem.APPVERSION,
+			LBVersion:    lbVersion,
+			LBConfigFile: selectedLBConfigFile(),
+			Variables:    ResolveVariables(),
+			StartTime:    started,
+		},
+	}, nil
+}
+
+// selectedLBConfigFile is the config file the build reads with its checksum, nil when there
+// is not exactly one, the build fails on that itself
+func selectedLBConfigFile() *ImportedFile {
+	selected := appstate.GetGlobalState().GetDirectoryEntryMap(filesystem.LBCONFIGS_DIR_ID)
+	if len(selected) != 1 {
+		return nil
+	}
+	for _, entry := range selected {
+		sum, err := fileSHA256(entry.FullPath())
+		if err != nil {
+			log.Printf("Could not checksum lb config %s for build record: %v\n", entry.FullPath(), err)
+			return nil
+		}
+		return &ImportedFile{Source: entry.FullPath(), SHA256: sum}
+	}
+	return nil
+}
+
+// makeRecordDir creates a fresh record directory named id, builds started in the same second
+// get a -2, -3, ... suffix instead of sharing and truncating each others files
+func makeRecordDir(recordsDir, id string) (string, string, error) {
+	if err := os.MkdirAll(recordsDir, 0777); err != nil {
+		return "", "", err
+	}
+	unique := id
+	for i := 2; ; i++ {
+		dir := filepath.Join(recordsDir, unique)
+		err := os.Mkdir(dir, 0777)
+		if err == nil {
+			return unique, dir, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return "", "", err
+		}
+		unique = fmt.Sprintf("%s-%d", id, i)
+	}
+}
+
+func (self *buildRecorder) record(event BuildEvent) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	fmt.Fprintf(self.logFile, "%s [%s] %s\n", event.Time.Format(time.RFC3339), event.Stage, event.String())
+
+	switch event.Type {
+	case BUILD_STARTED:
+		self.manifest.BuildPath = event.Destination
+	case COMMAND_STARTED:
+		if event.Stage == STAGE_LB_CONFIG {
+			self.manifest.LBConfigCommand = event.Command
+		}
+	case COMMAND_EXITED:
+		self.manifest.Commands = append(self.manifest.Commands, CommandRecord{
+			Command:  event.Command,
+			Stage:    event.Stage,
+			ExitCode: event.ExitCode,
+			Duration: event.Duration,
+		})
+	case FILE_IMPORTED:
+		self.manifest.ImportedFiles = append(self.manifest.ImportedFiles, ImportedFile{
+			Source:      event.Source,
+			InstallPath: event.Destination,
+			SHA256:      event.Checksum,
+		})
+	case ARTIFACT:
+		self.manifest.Artifacts = append(self.manifest.Artifacts, event.Destination)
+	case BUILD_FINISHED:
+		self.manifest.EndTime = event.Time
+		self.manifest.Duration = event.Duration
+		self.manifest.Status = STATUS_SUCCESS
+		if event.Err != nil {
+			self.manifest.Status = STATUS_FAILED
+			self.manifest.Error = event.Err.Error()
+		}
+		if errors.Is(event.Err, context.Canceled) || errors.Is(event.Err, context.DeadlineExceeded) {
+			self.manifest.Status = STATUS_CANCELLED
+		}
+	}
+}
+
+// finish writes out the manifest and lb config command, must be called after BUILD_FINISHED was recorded
+func (self *buildRecorder) finish() error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	var errs []error
+	errs = append(errs, self.logFile.Close())
+	if len(self.manifest.LBConfigCommand) > 0 {
+		cmdline := shellQuoteCommand(self.manifest.LBConfigCommand) + "\n"
+		errs = append(errs, os.WriteFile(filepath.Join(self.dir, RECORD_LBCONFIG_FILE), []byte(cmdline), 0644))
+	}
+	errs = append(errs, WriteManifest(self.dir, self.manifest))
+	return errors.Join(errs...)
+}
+
+func WriteManifest(dir string, manifest BuildManifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, RECORD_MANIFEST_FILE), data, 0644)
+}
+
+func ReadManifest(dir string) (BuildManifest, error) {
+	var manifest BuildManifest
+	data, err := os.ReadFile(filepath.Join(dir, RECORD_MANIFEST_FILE))
+	if err != nil {
+		return manifest, err
+	}
+	err = json.Unmarshal(data, &manifest)
+	return manifest, err
+}
+
+// shellQuoteCommand renders argv so it can be pasted back into a shell
+func shellQuoteCommand(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = lbconfig.ShellQuote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
@@ -9,49 +9,39 @@ Master object for doing all the backend building
 
 import (
 	filesystem "LiveBuilder/Filesystem"
+	privileged "LiveBuilder/Privileged"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"sync"
+	"time"
 )
 
-type UpdateType string
-
-const (
-	START  UpdateType = "start"
-	UPDATE UpdateType = "start"
-	END    UpdateType = "start"
-)
-
-type LogUpdate struct {
-	UpdateType
-	Message string
-	Append  bool // true to append, false to replace
-}
-
 type BuildManager struct {
-	updateChannel   chan LogUpdate
-	stepFinished    chan bool
+	eventChannel    chan BuildEvent
+	events          *eventEmitter
 	importer        *Importer
 	lbconfigManager *LBConfigManager
 	lbBuildManager  *LBBuildManager
 	buildPath       string
-	subscribers     []chan LogUpdate
+	recordDir       string
+	subscribers     []chan BuildEvent
 	subMutex        sync.RWMutex
 }
 
 func NewBuilder() *BuildManager {
 	builder := &BuildManager{
-		updateChannel: make(chan LogUpdate, 100),
-		stepFinished:  make(chan bool),
+		eventChannel: make(chan BuildEvent, 100),
 	}
-	builder.importer = NewImporter(builder.updateChannel)
-	builder.lbconfigManager = NewLBConfigManager(builder.updateChannel)
-	builder.lbBuildManager = NewLBBuildManager(builder.updateChannel)
+	builder.events = newEventEmitter(builder.eventChannel)
+	builder.importer = NewImporter(builder.events)
+	builder.lbconfigManager = NewLBConfigManager(builder.events)
+	builder.lbBuildManager = NewLBBuildManager(builder.events)
 	go builder.listenForUpdates()
 	return builder
 }
@@ -68,61 +58,100 @@ func (self *BuildManager) GetDefaultBuildPath() string {
 
 }
 
-func (self *BuildManager) Build(buildPath string) {
+// Build runs every stage in BuildStages, a BUILD_FINISHED event is always sent before returning.
+// Cancelling ctx stops whichever stage is running and tears down the build directory
+func (self *BuildManager) Build(ctx context.Context, buildPath string) error {
+	started := time.Now()
 
-	if err := self.InitializeBuildPath(buildPath); err != nil {
-		self.updateChannel <- LogUpdate{
-			Append:  false,
-			Message: fmt.Sprintf("Error occured initializing build path: %v\n", err),
-		}
-		return
+	recorder, err := newBuildRecorder()
+	if err != nil {
+		err = fmt.Errorf("creating build record: %w", err)
+		self.events.emit(BuildEvent{Type: BUILD_FINISHED, Err: err})
+		return err
 	}
-	log.Printf("Building to path: %s\n", self.buildPath)
-	self.importer.SetBuildPath(self.buildPath)
-	self.lbconfigManager.SetBuildPath(self.buildPath)
-	self.lbBuildManager.SetBuildPath(self.buildPath)
+	self.recordDir = recorder.dir
+	self.events.setRecorder(recorder)
 
-	if err := self.lbconfigManager.ConfigureLB(); err != nil {
-		self.updateChannel <- LogUpdate{
-			Append:  true,
-			Message: fmt.Sprintf("Error occured in configuring LB: %v\n", err),
-		}
-		return
+	err = self.runBuild(ctx, buildPath)
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		self.teardown(true)
+	} else if err != nil {
+		self.teardown(false)
 	}
-	if err := self.importer.ImportAll(); err != nil {
-		self.updateChannel <- LogUpdate{
-			Append:  true,
-			Message: fmt.Sprintf("Error occured in importer: %v\n", err),
-		}
-		return
+	self.events.message("Build record written to %s", recorder.dir)
+	self.events.emit(BuildEvent{
+		Type:     BUILD_FINISHED,
+		Err:      err,
+		Duration: time.Since(started),
+	})
+
+	self.events.setRecorder(nil)
+	if recordErr := recorder.finish(); recordErr != nil {
+		log.Printf("Error writing build record %s: %v\n", recorder.dir, recordErr)
 	}
-	if err := self.lbBuildManager.Build(); err != nil {
-		self.updateChannel <- LogUpdate{
-			Append:  true,
-			Message: fmt.Sprintf("Error occured in lb build: %v\n", err),
-		}
-		return
+	return err
+}
+
+// GetRecordDir returns the record directory of the current or last build
+func (self *BuildManager) GetRecordDir() string {
+	return self.recordDir
+}
+
+func (self *BuildManager) runBuild(ctx context.Context, buildPath string) error {
+	stages := []struct {
+		name string
+		fn   func(context.Context) error
+	}{
+		{STAGE_INITIALIZE, func(context.Context) error { return self.initialize(buildPath) }},
+		{STAGE_LB_CONFIG, self.lbconfigManager.ConfigureLB},
+		{STAGE_IMPORT, self.importer.ImportAll},
+		{STAGE_LB_BUILD, self.lbBuildManager.Build},
+		{STAGE_COPY_ISO, self.copyISO},
 	}
-	if err := self.copyISO(); err != nil {
-		self.updateChannel <- LogUpdate{
-			Append:  true,
-			Message: fmt.Sprintf("Error occured copying iso file: %v\n", err),
+
+	for _, stage := range stages {
+		if err := self.runStage(ctx, stage.name, stage.fn); err != nil {
+			return fmt.Errorf("%s: %w", stage.name, err)
 		}
-		return
 	}
+	return nil
+}
+
+// runStage wraps fn in STAGE_STARTED/STAGE_FINISHED events, failures also emit an ERROR event
+func (self *BuildManager) runStage(ctx context.Context, name string, fn func(context.Context) error) error {
+	self.events.setStage(name)
+	defer self.events.setStage("")
 
+	//a cancelled build stops before the next stage starts, so every STAGE_STARTED gets its STAGE_FINISHED
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	started := time.Now()
+	self.events.emit(BuildEvent{Type: STAGE_STARTED})
+	err := fn(ctx)
+	if err != nil {
+		self.events.emit(BuildEvent{
+			Type: ERROR,
+			Err:  err,
+		})
+	}
+	self.events.emit(BuildEvent{
+		Type:     STAGE_FINISHED,
+		Err:      err,
+		Duration: time.Since(started),
+	})
+	return err
 }
 
-func (self *BuildManager) copyISO() error {
-	//create folder for iso to be copied to
-	appdata, _ := filesystem.GetAppDataDir()
-	iso_path := filepath.Join(appdata, filesystem.ISO_DIR_ID)
+func (self *BuildManager) copyISO(ctx context.Context) error {
+	//isos are copied into this builds record directory
+	iso_path := self.recordDir
 	if err := os.MkdirAll(iso_path, 0777); err != nil {
 		return err
 	}
 	//get iso filepath
 	var iso_files []string
-	filepath.WalkDir(self.buildPath, func(s string, d fs.DirEntry, e error) error {
+	err := filepath.WalkDir(self.buildPath, func(s string, d fs.DirEntry, e error) error {
 		if e != nil {
 			return e
 		}
@@ -131,37 +160,74 @@ func (self *BuildManager) copyISO() error {
 		}
 		return nil
 	})
+	if err != nil {
+		return err
+	}
+	if len(iso_files) == 0 {
+		return fmt.Errorf("no iso file found in %s", self.buildPath)
+	}
 	for _, iso_file := range iso_files {
-		dest := filepath.Join(iso_path, filepath.Base(iso_file))
-		self.updateChannel <- LogUpdate{
-			Append:  true,
-			Message: fmt.Sprintf("Copying:%s -> %s\n", iso_file, dest),
+		if err := ctx.Err(); err != nil {
+			return err
 		}
+		dest := filepath.Join(iso_path, filepath.Base(iso_file))
+		self.events.message("Copying:%s -> %s", iso_file, dest)
 
 		err := copyFile(iso_file, dest)
 		if err != nil {
 			log.Printf("Error copying iso file %s\n", err)
 			return err
 		}
+		self.events.emit(BuildEvent{
+			Type:        ARTIFACT,
+			Source:      iso_file,
+			Destination: dest,
+		})
 	}
 	return nil
 }
 
-func (self *BuildManager) GetSubscriber() <-chan LogUpdate {
+func (self *BuildManager) GetSubscriber() <-chan BuildEvent {
 	self.subMutex.Lock()
 	defer self.subMutex.Unlock()
 
-	subscriber := make(chan LogUpdate, 100)
+	subscriber := make(chan BuildEvent, 100)
 	self.subscribers = append(self.subscribers, subscriber)
 	return subscriber
 }
 
+// Unsubscribe closes subscriber once every event sent before the call has been passed on,
+// so ranging over it ends after the last of those events instead of waiting forever
+func (self *BuildManager) Unsubscribe(subscriber <-chan BuildEvent) {
+	//queued behind the pending events so none of them get cut off
+	self.eventChannel <- BuildEvent{unsubscribe: subscriber}
+}
+
+func (self *BuildManager) removeSubscriber(subscriber <-chan BuildEvent) {
+	self.subMutex.Lock()
+	defer self.subMutex.Unlock()
+
+	for i, candidate := range self.subscribers {
+		if candidate == subscriber {
+			close(candidate)
+			self.subscribers = append(self.subscribers[:i], self.subscribers[i+1:]...)
+			return
+		}
+	}
+}
+
+// listenForUpdates fans events out to the subscribers, a full subscriber misses events rather
+// than stalling the build. Callers wait on Build's result, not on BUILD_FINISHED, to know it's over
 func (self *BuildManager) listenForUpdates() {
-	for update := range self.updateChannel {
+	for event := range self.eventChannel {
+		if event.unsubscribe != nil {
+			self.removeSubscriber(event.unsubscribe)
+			continue
+		}
 		self.subMutex.RLock()
 		for _, subscriber := range self.subscribers {
 			select {
-			case subscriber <- update:
+			case subscriber <- event:
 			default:
 			}
 		}
@@ -169,6 +235,18 @@ func (self *BuildManager) listenForUpdates() {
 	}
 }
 
+// initialize resolves the template variables before the build directory is touched, so a
+// missing value fails the build before anything runs
+func (self *BuildManager) initialize(buildPath string) error {
+	variables, err := BuildVariables()
+	if err != nil {
+		return err
+	}
+	self.lbconfigManager.SetVariables(variables)
+	self.importer.SetVariables(variables)
+	return self.InitializeBuildPath(buildPath)
+}
+
 func (self *BuildManager) InitializeBuildPath(buildPath string) error {
 	if buildPath == "" {
 		self.buildPath = self.GetDefaultBuildPath()
@@ -183,21 +261,33 @@ func (self *BuildManager) InitializeBuildPath(buildPath string) error {
 	if err := os.MkdirAll(self.buildPath, 0777); err != nil {
 		return err
 	}
+	self.events.emit(BuildEvent{
+		Type:        BUILD_STARTED,
+		Destination: self.buildPath,
+	})
+	self.importer.SetBuildPath(self.buildPath)
+	self.lbconfigManager.SetBuildPath(self.buildPath)
+	self.lbBuildManager.SetBuildPath(self.buildPath)
 	return nil
 }
 
 func (self *BuildManager) NukeBuild() error {
-	if err := os.RemoveAll(self.buildPath); err != nil {
+	//a leftover chroot mount would make RemoveAll recurse into the host's /proc, /sys or /dev
+	if err := unmountBelow(self.buildPath); err != nil {
 		return err
 	}
-	return nil
-	cmd := exec.Command("lb", "clean")
-	cmd.Env = os.Environ()
-	if err := cmd.Start(); err != nil {
-		log.Printf("Error starting command: %v\n", err)
+	//lb build ran as root, chroot/, binary/ and the iso belong to root
+	if _, err := os.Stat(filepath.Join(self.buildPath, "chroot")); err == nil {
+		cmd := privileged.Command("lb", "clean", "--purge")
+		cmd.Dir = self.buildPath
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("lb clean --purge: %w", err)
+		}
+	}
+	if err := os.RemoveAll(self.buildPath); err != nil {
 		return err
 	}
-	return cmd.Wait()
+	return nil
 }
 
 func copyFile(src, dst string) error {
@@ -1,13 +1,17 @@
 package buildmanager
 
 import (
+	privileged "LiveBuilder/Privileged"
 	"bufio"
+	"context"
+	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/exec"
-	"strings"
 	"sync"
-	"unicode"
+	"syscall"
+	"time"
 )
 
 type OutputType string
@@ -17,15 +21,71 @@ const (
 	STDOUT OutputType = "STDOUT"
 )
 
-type CommandOut struct {
-	OutType OutputType
-	OutPut  string
+// how long a cancelled command gets to exit after SIGTERM before the group is SIGKILLed
+const KILL_GRACE_PERIOD = 10 * time.Second
+
+// process is a command executeCommand can run, a local exec.Cmd or a privileged.Cmd run as
+// root by the helper. Signal has to reach the whole process group
+type process interface {
+	StdoutPipe() (io.ReadCloser, error)
+	StderrPipe() (io.ReadCloser, error)
+	Start() error
+	Wait() error
+	ExitCode() int
+	Signal(signal syscall.Signal) error
 }
 
-func executeCommand(cmd *exec.Cmd, outputChannel chan CommandOut) error {
+// localProcess runs an exec.Cmd in its own process group
+type localProcess struct {
+	cmd *exec.Cmd
+}
+
+func (self *localProcess) StdoutPipe() (io.ReadCloser, error) {
+	return self.cmd.StdoutPipe()
+}
+
+func (self *localProcess) StderrPipe() (io.ReadCloser, error) {
+	return self.cmd.StderrPipe()
+}
+
+func (self *localProcess) Wait() error {
+	return self.cmd.Wait()
+}
+
+func (self *localProcess) ExitCode() int {
+	return self.cmd.ProcessState.ExitCode()
+}
+
+func (self *localProcess) Start() error {
+	self.cmd.Env = os.Environ()
+	self.cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	return self.cmd.Start()
+}
+
+func (self *localProcess) Signal(signal syscall.Signal) error {
+	return syscall.Kill(-self.cmd.Process.Pid, signal)
+}
+
+// executeCommand runs cmd in its own process group, emitting its start, every output line and
+// its exit. Cancelling ctx terminates the whole group so children of lb (debootstrap, chroot apt etc) die with it
+func executeCommand(ctx context.Context, cmd *exec.Cmd, events *eventEmitter) error {
+	return execute(ctx, cmd.Args, &localProcess{cmd}, events)
+}
+
+// executePrivileged is executeCommand for commands that need root, run in dir by the privileged helper
+func executePrivileged(ctx context.Context, dir string, events *eventEmitter, name string, args ...string) error {
+	cmd := privileged.Command(name, args...)
+	cmd.Dir = dir
+	return execute(ctx, cmd.Args, cmd, events)
+}
+
+func execute(ctx context.Context, args []string, cmd process, events *eventEmitter) error {
 	log.Println("executing command")
-	log.Println(cmd.Args)
-	cmd.Env = os.Environ()
+	log.Println(args)
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
@@ -38,97 +98,72 @@ func executeCommand(cmd *exec.Cmd, outputChannel chan CommandOut) error {
 		return err
 	}
 
+	started := time.Now()
 	if err := cmd.Start(); err != nil {
 		log.Printf("Error starting command: %v\n", err)
 		return err
 	}
+	events.emit(BuildEvent{
+		Type:    COMMAND_STARTED,
+		Command: args,
+	})
+
+	exited := make(chan struct{})
+	defer close(exited)
+	go killOnCancel(ctx, cmd, exited)
 
 	var wg sync.WaitGroup
 	wg.Add(2)
-
-	// Read stdout
-	go func() {
-		defer wg.Done()
-		defer stdout.Close()
-		scanner := bufio.NewScanner(stdout)
-		for scanner.Scan() {
-			msg := scanner.Text()
-			select {
-			case outputChannel <- CommandOut{
-				OutType: STDOUT,
-				OutPut:  msg,
-			}:
-			default:
-				log.Printf("stdout full skipping\n")
-			}
-			if err := scanner.Err(); err != nil {
-				log.Printf("Error reading stdout: %v", err)
-			}
-		}
-	}()
-
-	// Read stderr
-	go func() {
-		defer wg.Done()
-		defer stderr.Close()
-		scanner := bufio.NewScanner(stderr)
-		for scanner.Scan() {
-			msg := scanner.Text()
-			select {
-			case outputChannel <- CommandOut{
-				OutType: STDERR,
-				OutPut:  msg,
-			}:
-			default:
-				log.Printf("stderr full skipping\n")
-			}
-			if err := scanner.Err(); err != nil {
-				log.Printf("Error reading stderr: %v", err)
-			}
-		}
-	}()
+	go streamOutput(&wg, stdout, STDOUT, events)
+	go streamOutput(&wg, stderr, STDERR, events)
 	wg.Wait()
-	return cmd.Wait()
-}
 
-func parseShellCommand(input string) []string {
-	var tokens []string
-	var current strings.Builder
-	var inQuotes bool
-	var quoteChar rune
-
-	//Stanitize input
-	input = strings.ReplaceAll(input, "\\\n", " ")
-	input = strings.ReplaceAll(input, "\\\r\n", " ")
-	input = strings.Join(strings.Fields(input), " ")
-
-	for _, char := range input {
-		switch {
-		case char == '"' || char == '\'':
-			if !inQuotes {
-				inQuotes = true
-				quoteChar = char
-			} else if char == quoteChar {
-				inQuotes = false
-				quoteChar = 0
-			} else {
-				current.WriteRune(char)
-			}
-
-		case unicode.IsSpace(char) && !inQuotes:
-			if current.Len() > 0 {
-				tokens = append(tokens, current.String())
-				current.Reset()
-			}
-
-		default:
-			current.WriteRune(char)
-		}
+	err = cmd.Wait()
+	events.emit(BuildEvent{
+		Type:     COMMAND_EXITED,
+		Command:  args,
+		ExitCode: cmd.ExitCode(),
+		Duration: time.Since(started),
+	})
+	if ctx.Err() != nil {
+		return fmt.Errorf("%s cancelled: %w", args[0], ctx.Err())
 	}
+	return err
+}
 
-	if current.Len() > 0 {
-		tokens = append(tokens, current.String())
+// streamOutput emits every line read from pipe, the send blocks rather than dropping lines
+// so the event stream holds the complete transcript
+func streamOutput(wg *sync.WaitGroup, pipe io.ReadCloser, stream OutputType, events *eventEmitter) {
+	defer wg.Done()
+	defer pipe.Close()
+	scanner := bufio.NewScanner(pipe)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		events.emit(BuildEvent{
+			Type:   OUTPUT_LINE,
+			Stream: stream,
+			Line:   scanner.Text(),
+		})
 	}
+	if err := scanner.Err(); err != nil {
+		log.Printf("Error reading %s: %v", stream, err)
+	}
+}
 
-	return tokens
+func killOnCancel(ctx context.Context, cmd process, exited <-chan struct{}) {
+	select {
+	case <-exited:
+		return
+	case <-ctx.Done():
+	}
+	log.Println("Context cancelled, terminating process group")
+	if err := cmd.Signal(syscall.SIGTERM); err != nil {
+		log.Printf("Error terminating process group: %v\n", err)
+	}
+	select {
+	case <-exited:
+	case <-time.After(KILL_GRACE_PERIOD):
+		log.Println("Process group still running, killing")
+		cmd.Signal(syscall.SIGKILL)
+	}
 }
@@ -0,0 +1,175 @@
+package buildmanager
+
+/*
+Typed events emitted by every part of the build, subscribers switch on EventType
+instead of scraping message text. String() renders an event as a single log line
+*/
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+type EventType string
+
+const (
+	BUILD_STARTED   EventType = "build_started"
+	BUILD_FINISHED  EventType = "build_finished" // always the last event of a build, Err set on failure
+	STAGE_STARTED   EventType = "stage_started"
+	STAGE_FINISHED  EventType = "stage_finished"
+	COMMAND_STARTED EventType = "command_started"
+	COMMAND_EXITED  EventType = "command_exited"
+	OUTPUT_LINE     EventType = "output_line"
+	FILE_IMPORTED   EventType = "file_imported"
+	ARTIFACT        EventType = "artifact"
+	ERROR           EventType = "error"
+	MESSAGE         EventType = "message"
+)
+
+// Build stages, in the order Build runs them
+const (
+	STAGE_INITIALIZE = "initialize"
+	STAGE_LB_CONFIG  = "lb config"
+	STAGE_IMPORT     = "import"
+	STAGE_LB_BUILD   = "lb build"
+	STAGE_COPY_ISO   = "copy iso"
+	STAGE_TEARDOWN   = "teardown"
+)
+
+var BuildStages = []string{
+	STAGE_INITIALIZE,
+	STAGE_LB_CONFIG,
+	STAGE_IMPORT,
+	STAGE_LB_BUILD,
+	STAGE_COPY_ISO,
+}
+
+type BuildEvent struct {
+	Type     EventType
+	Time     time.Time
+	Stage    string
+	Duration time.Duration // STAGE_FINISHED, COMMAND_EXITED, BUILD_FINISHED
+	Err      error         // STAGE_FINISHED, ERROR, BUILD_FINISHED
+
+	Command  []string // COMMAND_STARTED, COMMAND_EXITED
+	ExitCode int      // COMMAND_EXITED, -1 if the command never exited normally
+
+	Stream OutputType // OUTPUT_LINE
+	Line   string     // OUTPUT_LINE
+
+	Source      string // FILE_IMPORTED, ARTIFACT
+	Destination string // FILE_IMPORTED, ARTIFACT, BUILD_STARTED (build path)
+	Checksum    string // FILE_IMPORTED, sha256 of the source file
+
+	Message string // MESSAGE
+
+	unsubscribe <-chan BuildEvent // internal, see BuildManager.Unsubscribe
+}
+
+func (event BuildEvent) String() string {
+	switch event.Type {
+	case BUILD_STARTED:
+		return fmt.Sprintf("Build started in %s", event.Destination)
+	case BUILD_FINISHED:
+		if event.Err != nil {
+			return fmt.Sprintf("Build failed after %s: %v", roundDuration(event.Duration), event.Err)
+		}
+		return fmt.Sprintf("Build finished in %s", roundDuration(event.Duration))
+	case STAGE_STARTED:
+		return fmt.Sprintf("==> %s", event.Stage)
+	case STAGE_FINISHED:
+		if event.Err != nil {
+			return fmt.Sprintf("<== %s failed after %s", event.Stage, roundDuration(event.Duration))
+		}
+		return fmt.Sprintf("<== %s finished in %s", event.Stage, roundDuration(event.Duration))
+	case COMMAND_STARTED:
+		return fmt.Sprintf("$ %s", strings.Join(event.Command, " "))
+	case COMMAND_EXITED:
+		return fmt.Sprintf("%s exited with code %d after %s", commandName(event.Command), event.ExitCode, roundDuration(event.Duration))
+	case OUTPUT_LINE:
+		if event.Stream == STDERR {
+			return fmt.Sprintf("STD Error: %s", event.Line)
+		}
+		return event.Line
+	case FILE_IMPORTED:
+		return fmt.Sprintf("Added %s file to %s", filepath.Base(event.Source), event.Destination)
+	case ARTIFACT:
+		return fmt.Sprintf("Produced %s", event.Destination)
+	case ERROR:
+		return fmt.Sprintf("Error occured in %s: %v", event.Stage, event.Err)
+	default:
+		return event.Message
+	}
+}
+
+// commandName shortens argv to the program and its subcommand, eg "lb build"
+func commandName(args []string) string {
+	if len(args) == 0 {
+		return ""
+	}
+	name := filepath.Base(args[0])
+	if len(args) > 1 && !strings.HasPrefix(args[1], "-") {
+		name += " " + args[1]
+	}
+	return name
+}
+
+func roundDuration(duration time.Duration) time.Duration {
+	return duration.Round(time.Millisecond)
+}
+
+// eventEmitter is shared by every component of one BuildManager, it stamps events with
+// the time and the stage currently running before sending them on
+type eventEmitter struct {
+	channel  chan BuildEvent
+	stage    string
+	recorder *buildRecorder
+	mutex    sync.Mutex
+}
+
+func newEventEmitter(channel chan BuildEvent) *eventEmitter {
+	return &eventEmitter{
+		channel: channel,
+	}
+}
+
+func (self *eventEmitter) setStage(stage string) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.stage = stage
+}
+
+// setRecorder makes every following event get written to recorder, nil stops recording
+func (self *eventEmitter) setRecorder(recorder *buildRecorder) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.recorder = recorder
+}
+
+func (self *eventEmitter) emit(event BuildEvent) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	self.mutex.Lock()
+	if event.Stage == "" {
+		event.Stage = self.stage
+	}
+	recorder := self.recorder
+	self.mutex.Unlock()
+
+	//recorded synchronously so the record is complete even if a subscriber drops events
+	if recorder != nil {
+		recorder.record(event)
+	}
+	self.channel <- event
+}
+
+func (self *eventEmitter) message(format string, args ...any) {
+	self.emit(BuildEvent{
+		Type:    MESSAGE,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
@@ -0,0 +1,218 @@
+package buildmanager
+
+/*
+Build history, every record directory under BuiltISOs with a manifest.json is one past build.
+The directories themselves are the index so manually removed builds simply drop out of it
+*/
+
+import (
+	appstate "LiveBuilder/AppState"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// statuses a build can finish with, for filter widgets
+var HistoryStatuses = []string{STATUS_SUCCESS, STATUS_FAILED, STATUS_CANCELLED}
+
+type HistoryEntry struct {
+	Dir      string
+	Manifest BuildManifest
+}
+
+type HistoryFilter struct {
+	Profile string // exact profile name, empty matches all
+	Status  string // one of the STATUS_ constants, empty matches all
+	Search  string // case insensitive match against id, profile, error and selected file names
+}
+
+func (self HistoryEntry) ID() string {
+	return self.Manifest.ID
+}
+
+func (self HistoryEntry) LogPath() string {
+	return filepath.Join(self.Dir, RECORD_LOG_FILE)
+}
+
+// ExistingArtifacts returns the recorded artifacts that are still on disk
+func (self HistoryEntry) ExistingArtifacts() []string {
+	var existing []string
+	for _, artifact := range self.Manifest.Artifacts {
+		if _, err := os.Stat(artifact); err == nil {
+			existing = append(existing, artifact)
+		}
+	}
+	return existing
+}
+
+func (self HistoryEntry) Summary() string {
+	profile := self.Manifest.Inputs.Name
+	if profile == "" {
+		profile = "-"
+	}
+	return fmt.Sprintf("%s  %-9s  %-12s  %s", self.Manifest.StartTime.Format("2006-01-02 15:04"), self.Manifest.Status, roundDuration(self.Manifest.Duration), profile)
+}
+
+func (self HistoryEntry) matches(filter HistoryFilter) bool {
+	if filter.Profile != "" && self.Manifest.Inputs.Name != filter.Profile {
+		return false
+	}
+	if filter.Status != "" && self.Manifest.Status != filter.Status {
+		return false
+	}
+	if filter.Search == "" {
+		return true
+	}
+	haystack := []string{self.Manifest.ID, self.Manifest.Inputs.Name, self.Manifest.Error}
+	for _, names := range self.Manifest.Inputs.Selections {
+		haystack = append(haystack, names...)
+	}
+	search := strings.ToLower(filter.Search)
+	for _, value := range haystack {
+		if strings.Contains(strings.ToLower(value), search) {
+			return true
+		}
+	}
+	return false
+}
+
+// ListHistory returns every recorded build, newest first
+func ListHistory() ([]HistoryEntry, error) {
+	recordsDir, err := GetBuildRecordsDir()
+	if err != nil {
+		return nil, err
+	}
+	dirs, err := os.ReadDir(recordsDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []HistoryEntry
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+		path := filepath.Join(recordsDir, dir.Name())
+		manifest, err := ReadManifest(path)
+		if err != nil {
+			log.Printf("Skipping build record %s: %v\n", path, err)
+			continue
+		}
+		entries = append(entries, HistoryEntry{Dir: path, Manifest: manifest})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Manifest.StartTime.After(entries[j].Manifest.StartTime)
+	})
+	return entries, nil
+}
+
+func FilterHistory(entries []HistoryEntry, filter HistoryFilter) []HistoryEntry {
+	var filtered []HistoryEntry
+	for _, entry := range entries {
+		if entry.matches(filter) {
+			filtered = append(filtered, entry)
+		}
+	}
+	return filtered
+}
+
+func GetHistoryEntry(id string) (HistoryEntry, error) {
+	entries, err := ListHistory()
+	if err != nil {
+		return HistoryEntry{}, err
+	}
+	for _, entry := range entries {
+		if entry.ID() == id {
+			return entry, nil
+		}
+	}
+	return HistoryEntry{}, fmt.Errorf("no build with id %s", id)
+}
+
+// DeleteArtifacts removes the builds isos but keeps its log and manifest
+func (self HistoryEntry) DeleteArtifacts() error {
+	for _, artifact := range self.ExistingArtifacts() {
+		log.Printf("Removing build artifact %s\n", artifact)
+		if err := os.Remove(artifact); err != nil {
+			return err
+		}
+	}
+	self.Manifest.Artifacts = nil
+	return WriteManifest(self.Dir, self.Manifest)
+}
+
+// Delete removes the whole record directory, artifacts included
+func (self HistoryEntry) Delete() error {
+	recordsDir, err := GetBuildRecordsDir()
+	if err != nil {
+		return err
+	}
+	if filepath.Dir(self.Dir) != recordsDir {
+		return fmt.Errorf("refusing to delete %s, not a build record", self.Dir)
+	}
+	return os.RemoveAll(self.Dir)
+}
+
+// ChangedInputs lists the imported files and lb config whose content differs from when this
+// build ran, and the template variables that would now resolve to another value
+func (self HistoryEntry) ChangedInputs() []string {
+	files := self.Manifest.ImportedFiles
+	if self.Manifest.LBConfigFile != nil {
+		files = append([]ImportedFile{*self.Manifest.LBConfigFile}, files...)
+	}
+	var changed []string
+	for _, imported := range files {
+		sum, err := fileSHA256(imported.Source)
+		if err != nil || sum != imported.SHA256 {
+			changed = append(changed, imported.Source)
+		}
+	}
+	//records from before variables were kept have nothing to compare
+	if self.Manifest.Variables == nil {
+		return changed
+	}
+	return append(changed, changedVariables(self.Manifest.Variables, ResolveVariables())...)
+}
+
+// changedVariables names the variables that differ between two builds, the build date
+// differs between any two days so it does not count
+func changedVariables(recorded, current map[string]string) []string {
+	names := maps.Clone(recorded)
+	maps.Copy(names, current)
+	var changed []string
+	for name := range names {
+		if name != BUILD_DATE_VAR && recorded[name] != current[name] {
+			changed = append(changed, "variable "+name)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// ApplyInputs loads this builds selections and iso fields into the global state so it can be re-run
+func (self HistoryEntry) ApplyInputs() error {
+	return appstate.GetGlobalState().ApplyProfile(self.Manifest.Inputs)
+}
+
+func fileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
@@ -7,21 +7,41 @@ Imports (Drops/copies) selected files/customizations into the config directory o
 import (
 	appstate "LiveBuilder/AppState"
 	filesystem "LiveBuilder/Filesystem"
+	lbconfig "LiveBuilder/LBConfig"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 )
 
+// OWNERSHIP_HOOK applies the owner and group metadata inside the chroot, live-build copies
+// config/includes.chroot without the owners but keeps the modes
+const OWNERSHIP_HOOK = "config/hooks/normal/9990-livebuilder-ownership.hook.chroot"
+
+type fileOwnership struct {
+	owner string // chown owner[:group]
+	mode  fs.FileMode
+}
+
 type Importer struct {
-	buildPath     string
-	updateChannel chan LogUpdate
+	buildPath string
+	variables map[string]string
+	ownership map[string]fileOwnership // path inside the chroot -> owner and mode to apply
+	events    *eventEmitter
 }
 
-func NewImporter(updateChan chan LogUpdate) *Importer {
+func NewImporter(events *eventEmitter) *Importer {
 	return &Importer{
-		updateChannel: updateChan,
+		ownership: make(map[string]fileOwnership),
+		events:    events,
 	}
 }
 
@@ -29,18 +49,19 @@ func (self *Importer) SetBuildPath(buildPath string) {
 	self.buildPath = buildPath
 }
 
-func (self *Importer) ImportAll() error {
+// SetVariables sets what files marked as templates are rendered with
+func (self *Importer) SetVariables(variables map[string]string) {
+	self.variables = variables
+}
+
+func (self *Importer) ImportAll(ctx context.Context) error {
 	if self.buildPath == "" {
 		return fmt.Errorf("Build Path Not set")
 	}
-	self.updateChannel <- LogUpdate{
-		Append:     false,
-		Message:    "Starting file import\n\n",
-		UpdateType: START,
-	}
+	self.ownership = make(map[string]fileOwnership)
 	// fancy shit
 	operations := []struct {
-		fn   func() error
+		fn   func(context.Context) error
 		name string
 	}{
 		{self.DropCustomFiles, "DropCustomFiles"},
@@ -49,72 +70,91 @@ func (self *Importer) ImportAll() error {
 	}
 
 	for _, op := range operations {
-		if err := op.fn(); err != nil {
-			return fmt.Errorf("%s error: %v", op.name, err)
+		if err := op.fn(ctx); err != nil {
+			return fmt.Errorf("%s error: %w", op.name, err)
 		}
 	}
-	self.updateChannel <- LogUpdate{
-		Append:     true,
-		Message:    "Importing finished\n",
-		UpdateType: END,
-	}
-	return nil
+	return self.writeOwnershipHook()
 }
 
-func (self *Importer) DropPackages() error {
-	self.updateChannel <- LogUpdate{
-		Append:     true,
-		Message:    "Dropping Packages\n",
-		UpdateType: UPDATE,
-	}
+func (self *Importer) DropPackages(ctx context.Context) error {
+	self.events.message("Dropping Packages")
 	packageMap := appstate.GetGlobalState().GetDirectoryEntryMap(filesystem.PACKAGE_DIR_ID)
 
 	for _, value := range packageMap {
-		self.dropFileFromDirectoryEntry(value)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := self.dropFileFromDirectoryEntry(value); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
-func (self *Importer) DropSplashImages() error {
-	self.updateChannel <- LogUpdate{
-		Append:     true,
-		Message:    "Dropping Splash images\n",
-		UpdateType: UPDATE,
-	}
+func (self *Importer) DropSplashImages(ctx context.Context) error {
+	self.events.message("Dropping Splash images")
 	log.Println("Dropping Splash images")
 	splashMap := filesystem.GetFileManager().GetFileSystem(filesystem.SPLASH_SCREENS_ID)
 	for _, value := range splashMap {
-		self.dropFileFromDirectoryEntry(value)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := self.dropFileFromDirectoryEntry(value); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
-func (self *Importer) DropCustomFiles() error {
-	self.updateChannel <- LogUpdate{
-		Append:     true,
-		Message:    "Dropping Custom Files\n",
-		UpdateType: UPDATE,
-	}
+func (self *Importer) DropCustomFiles(ctx context.Context) error {
+	self.events.message("Dropping Custom Files")
 	customFileMap := appstate.GetGlobalState().GetDirectoryEntryMap(filesystem.CUSTOMFILES_DIR_ID)
 
 	for _, value := range customFileMap {
-		self.dropFileFromDirectoryEntry(value)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := self.dropFileFromDirectoryEntry(value); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
 func (self *Importer) dropFileFromDirectoryEntry(file filesystem.DirectoryEntry) error {
-	var inFile, outFile *os.File
+	var inFile io.Reader
+	var outFile *os.File
 	var err error
 
 	outFilePath := filepath.Join(self.buildPath, file.MetaData.InstallPath)
 	inFIlePath := file.FullPath()
 
-	if inFile, err = os.Open(inFIlePath); err != nil {
-		return err
+	mode, err := file.MetaData.FileMode()
+	if err != nil {
+		return fmt.Errorf("%s: %w", file.Name(), err)
+	}
+	ownership, err := file.MetaData.Ownership()
+	if err != nil {
+		return fmt.Errorf("%s: %w", file.Name(), err)
+	}
+
+	//the checksum is of the source, not of the rendered output, so history can compare it to the file
+	hasher := sha256.New()
+	if file.MetaData.Template {
+		//rendered before the output is opened so a template error leaves nothing half written
+		if inFile, err = self.renderFile(file, hasher); err != nil {
+			return err
+		}
+	} else {
+		sourceFile, err := os.Open(inFIlePath)
+		if err != nil {
+			return err
+		}
+		defer sourceFile.Close()
+		inFile = io.TeeReader(sourceFile, hasher)
 	}
-	defer inFile.Close()
-	if err := os.MkdirAll(filepath.Dir(outFilePath), 0777); err != nil {
+	if err := self.makeDirs(filepath.Dir(outFilePath)); err != nil {
 		return err
 	}
 	if outFile, err = os.OpenFile(outFilePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644); err != nil {
@@ -125,13 +165,105 @@ func (self *Importer) dropFileFromDirectoryEntry(file filesystem.DirectoryEntry)
 		return err
 	}
 	outFile.WriteString("\n")
+	//set explicitly, the create mode is masked by the umask and an existing file keeps its own
+	if err := outFile.Chmod(mode); err != nil {
+		return err
+	}
+	if ownership != "" {
+		chrootPath, _ := file.MetaData.ChrootPath()
+		self.ownership[chrootPath] = fileOwnership{owner: ownership, mode: mode}
+	}
 
-	msg := fmt.Sprintf("Added %s file to %s\n", file.Name(), file.MetaData.InstallPath)
-	self.updateChannel <- LogUpdate{
-		Append:     true,
-		Message:    msg,
-		UpdateType: UPDATE,
+	self.events.emit(BuildEvent{
+		Type:        FILE_IMPORTED,
+		Source:      inFIlePath,
+		Destination: file.MetaData.InstallPath,
+		Checksum:    hex.EncodeToString(hasher.Sum(nil)),
+	})
+
+	return nil
+}
+
+// renderFile renders a template file, the unrendered source is written to hasher
+func (self *Importer) renderFile(file filesystem.DirectoryEntry, hasher io.Writer) (io.Reader, error) {
+	data, err := os.ReadFile(file.FullPath())
+	if err != nil {
+		return nil, err
+	}
+	hasher.Write(data)
+	rendered, err := filesystem.RenderTemplate(file.Name(), data, self.variables)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(rendered), nil
+}
+
+// makeDirs creates the missing directories of dir as 0755 whatever the umask, they are
+// copied into the chroot as they are and a 0700 /etc/... would break the live system
+func (self *Importer) makeDirs(dir string) error {
+	var missing []string
+	for current := dir; ; current = filepath.Dir(current) {
+		if _, err := os.Stat(current); err == nil || current == filepath.Dir(current) {
+			break
+		}
+		missing = append(missing, current)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, created := range missing {
+		if err := os.Chmod(created, 0755); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeOwnershipHook writes a chroot hook chowning the imported files that set an owner or group,
+// each chown is followed by a chmod since chown clears the setuid and setgid bits
+func (self *Importer) writeOwnershipHook() error {
+	if len(self.ownership) == 0 {
+		return nil
+	}
+	paths := make([]string, 0, len(self.ownership))
+	for chrootPath := range self.ownership {
+		paths = append(paths, chrootPath)
+	}
+	sort.Strings(paths)
+
+	var script strings.Builder
+	script.WriteString("#!/bin/sh\nset -e\n\necho \"P: Setting owners of imported files\"\n")
+	for _, chrootPath := range paths {
+		ownership := self.ownership[chrootPath]
+		fmt.Fprintf(&script, "chown %s %s\n", lbconfig.ShellQuote(ownership.owner), lbconfig.ShellQuote(chrootPath))
+		fmt.Fprintf(&script, "chmod %s %s\n", octalMode(ownership.mode), lbconfig.ShellQuote(chrootPath))
 	}
 
+	hookPath := filepath.Join(self.buildPath, OWNERSHIP_HOOK)
+	if err := self.makeDirs(filepath.Dir(hookPath)); err != nil {
+		return err
+	}
+	if err := os.WriteFile(hookPath, []byte(script.String()), filesystem.DEFAULT_HOOK_MODE); err != nil {
+		return err
+	}
+	if err := os.Chmod(hookPath, filesystem.DEFAULT_HOOK_MODE); err != nil {
+		return err
+	}
+	self.events.message("Wrote %s for %d file(s)", OWNERSHIP_HOOK, len(paths))
 	return nil
 }
+
+// octalMode renders mode as chmod takes it, with the setuid, setgid and sticky bits
+func octalMode(mode fs.FileMode) string {
+	octal := uint32(mode.Perm())
+	if mode&fs.ModeSetuid != 0 {
+		octal |= 0o4000
+	}
+	if mode&fs.ModeSetgid != 0 {
+		octal |= 0o2000
+	}
+	if mode&fs.ModeSticky != 0 {
+		octal |= 0o1000
+	}
+	return fmt.Sprintf("%04o", octal)
+}
@@ -0,0 +1,81 @@
+package buildmanager
+
+/*
+Cleans up after a cancelled or failed build, live-build leaves proc/sys/dev bind mounts
+inside chroot/ if it is interrupted mid stage
+*/
+
+import (
+	filesystem "LiveBuilder/Filesystem"
+	privileged "LiveBuilder/Privileged"
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// teardown unmounts anything left under the build path, when clean is set `lb clean` is run first
+func (self *BuildManager) teardown(clean bool) {
+	if self.buildPath == "" {
+		return
+	}
+	self.events.setStage(STAGE_TEARDOWN)
+	defer self.events.setStage("")
+
+	if clean {
+		//teardown has to run to completion even though the build context is already cancelled
+		if err := executePrivileged(context.Background(), self.buildPath, self.events, "lb", "clean"); err != nil {
+			log.Printf("lb clean failed: %v\n", err)
+		}
+	}
+	if err := unmountBelow(self.buildPath); err != nil {
+		self.events.emit(BuildEvent{
+			Type: ERROR,
+			Err:  fmt.Errorf("unmounting build path: %w", err),
+		})
+	}
+}
+
+// unmountBelow lazily unmounts every mount point under root, deepest first
+func unmountBelow(root string) error {
+	mounts, err := mountsBelow(root)
+	if err != nil {
+		return err
+	}
+	var failed []string
+	for _, mount := range mounts {
+		log.Printf("Unmounting leftover mount %s\n", mount)
+		if _, stderr, err := privileged.Run("umount", "-l", mount); err != nil {
+			log.Printf("umount %s failed: %v %s\n", mount, err, stderr)
+			failed = append(failed, mount)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("could not unmount: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func mountsBelow(root string) ([]string, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	mountInfo, err := filesystem.ReadMountInfo(filesystem.MOUNTINFO)
+	if err != nil {
+		return nil, err
+	}
+
+	var mounts []string
+	for _, mount := range mountInfo {
+		if strings.HasPrefix(mount.MountPoint, root+string(filepath.Separator)) {
+			mounts = append(mounts, mount.MountPoint)
+		}
+	}
+	sort.Slice(mounts, func(i, j int) bool {
+		return len(mounts[i]) > len(mounts[j])
+	})
+	return mounts, nil
+}
@@ -0,0 +1,101 @@
+package buildmanager
+
+import (
+	appstate "LiveBuilder/AppState"
+	filesystem "LiveBuilder/Filesystem"
+	"log"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	BUILD_DATE_VAR   = "build_date"
+	GIT_REVISION_VAR = "git_revision"
+
+	// how long previews reuse the git revision before asking git again
+	GIT_REVISION_PREVIEW_TTL = 30 * time.Second
+)
+
+var previewRevision = struct {
+	value   string
+	fetched time.Time
+	mutex   sync.Mutex
+}{}
+
+// BuildVariables resolves the template variables of a build. Defaults declared by the selected
+// files come first, the profiles values override them, built ins are only set where the profile
+// has not set them and the iso fields are available under their old template names
+func BuildVariables() (map[string]string, error) {
+	if err := appstate.GetGlobalState().CheckVariables(); err != nil {
+		return nil, err
+	}
+	return ResolveVariables(), nil
+}
+
+// ResolveVariables is BuildVariables without the required check, for records and comparisons
+// where a missing value should show up as a template error instead
+func ResolveVariables() map[string]string {
+	return resolveVariables(gitRevision)
+}
+
+// PreviewVariables is ResolveVariables with a cached git revision, file previews render on every
+// tap and should not run git on the ui thread each time
+func PreviewVariables() map[string]string {
+	return resolveVariables(cachedGitRevision)
+}
+
+func resolveVariables(revision func() string) map[string]string {
+	state := appstate.GetGlobalState()
+	variables := make(map[string]string)
+	for _, spec := range state.DeclaredVariables() {
+		if spec.Default != "" {
+			variables[spec.Name] = spec.Default
+		}
+	}
+	for name, value := range state.Variables() {
+		if strings.TrimSpace(value) != "" {
+			variables[name] = value
+		}
+	}
+
+	builtins := map[string]func() string{
+		BUILD_DATE_VAR:   func() string { return time.Now().Format("2006-01-02") },
+		GIT_REVISION_VAR: revision,
+		"ISOVolume":      state.ISOVolumeName,
+		"ISOPublisher":   state.ISOPublisher,
+		"ISOApplication": state.ISOApplication,
+		"ISOImageName":   state.ISOImageName,
+	}
+	for name, value := range builtins {
+		if _, set := variables[name]; !set {
+			variables[name] = value()
+		}
+	}
+	return variables
+}
+
+// gitRevision is the revision of the app data dir when it is kept in git, empty otherwise
+func gitRevision() string {
+	appdata, err := filesystem.GetAppDataDir()
+	if err != nil {
+		return ""
+	}
+	output, err := exec.Command("git", "-C", appdata, "rev-parse", "--short", "HEAD").Output()
+	if err != nil {
+		log.Printf("No git revision for %s: %v\n", appdata, err)
+		return ""
+	}
+	return strings.TrimSpace(string(output))
+}
+
+func cachedGitRevision() string {
+	previewRevision.mutex.Lock()
+	defer previewRevision.mutex.Unlock()
+	if time.Since(previewRevision.fetched) > GIT_REVISION_PREVIEW_TTL {
+		previewRevision.value = gitRevision()
+		previewRevision.fetched = time.Now()
+	}
+	return previewRevision.value
+}
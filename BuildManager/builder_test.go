@@ -0,0 +1,87 @@
+package buildmanager
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// stageEvents runs fn as a stage and returns the types of the events it emitted
+func stageEvents(t *testing.T, ctx context.Context, fn func(context.Context) error) ([]EventType, []BuildEvent, error) {
+	eventChannel := make(chan BuildEvent, 10)
+	builder := &BuildManager{events: newEventEmitter(eventChannel)}
+	err := builder.runStage(ctx, STAGE_IMPORT, fn)
+	close(eventChannel)
+
+	var types []EventType
+	var events []BuildEvent
+	for event := range eventChannel {
+		if event.Stage != STAGE_IMPORT {
+			t.Errorf("%s event has stage %q", event.Type, event.Stage)
+		}
+		types = append(types, event.Type)
+		events = append(events, event)
+	}
+	return types, events, err
+}
+
+func equalTypes(a, b []EventType) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestStageEventOrder(t *testing.T) {
+	failure := errors.New("broken")
+	cases := []struct {
+		name     string
+		fn       func(context.Context) error
+		expected []EventType
+	}{
+		{"success", func(context.Context) error { return nil }, []EventType{STAGE_STARTED, STAGE_FINISHED}},
+		{"failure", func(context.Context) error { return failure }, []EventType{STAGE_STARTED, ERROR, STAGE_FINISHED}},
+	}
+	for _, test := range cases {
+		types, events, _ := stageEvents(t, context.Background(), test.fn)
+		if !equalTypes(types, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, types)
+			continue
+		}
+		finished := events[len(events)-1]
+		if test.name == "failure" && !errors.Is(finished.Err, failure) {
+			t.Errorf("%s: STAGE_FINISHED carries %v", test.name, finished.Err)
+		}
+	}
+}
+
+func TestStageFinishedOnCancel(t *testing.T) {
+	//cancelled while the stage runs, the stage still finishes with the error
+	ctx, cancel := context.WithCancel(context.Background())
+	types, events, err := stageEvents(t, ctx, func(ctx context.Context) error {
+		cancel()
+		return ctx.Err()
+	})
+	expected := []EventType{STAGE_STARTED, ERROR, STAGE_FINISHED}
+	if !errors.Is(err, context.Canceled) || !equalTypes(types, expected) {
+		t.Fatalf("expected %v and context.Canceled, got %v and %v", expected, types, err)
+	}
+	if !errors.Is(events[len(events)-1].Err, context.Canceled) {
+		t.Errorf("STAGE_FINISHED carries %v", events[len(events)-1].Err)
+	}
+
+	//cancelled before the stage, it never starts so there is nothing to finish
+	ran := false
+	types, _, err = stageEvents(t, ctx, func(context.Context) error {
+		ran = true
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) || ran || len(types) != 0 {
+		t.Errorf("expected a cancelled stage to emit nothing, ran %t with %v and %v", ran, types, err)
+	}
+}
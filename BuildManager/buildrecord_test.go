@@ -0,0 +1,30 @@
+package buildmanager
+
+import "testing"
+
+func TestRecordDirsOfTheSameSecondDiffer(t *testing.T) {
+	recordsDir := t.TempDir()
+	seen := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		id, _, err := makeRecordDir(recordsDir, "20260101-120000-live")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seen[id] {
+			t.Fatalf("record dir %s handed out twice", id)
+		}
+		seen[id] = true
+	}
+	if !seen["20260101-120000-live"] || !seen["20260101-120000-live-2"] {
+		t.Fatalf("unexpected record ids %v", seen)
+	}
+}
+
+func TestChangedVariables(t *testing.T) {
+	recorded := map[string]string{"hostname": "kiosk", "removed": "x", BUILD_DATE_VAR: "2026-01-01"}
+	current := map[string]string{"hostname": "kiosk", "added": "y", BUILD_DATE_VAR: "2026-01-02"}
+	changed := changedVariables(recorded, current)
+	if len(changed) != 2 || changed[0] != "variable added" || changed[1] != "variable removed" {
+		t.Fatalf("unexpected changed variables %v", changed)
+	}
+}
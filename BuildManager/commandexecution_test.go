@@ -0,0 +1,67 @@
+package buildmanager
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestExecuteCommandCancelKillsGroup(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	eventChannel := make(chan BuildEvent, 10)
+	events := newEventEmitter(eventChannel)
+
+	//the backgrounded sleep holds stdout open, so only a group kill lets executeCommand return
+	cmd := exec.Command("sh", "-c", "sleep 60 & echo started; wait")
+	go func() {
+		for event := range eventChannel {
+			if event.Type == OUTPUT_LINE {
+				cancel()
+			}
+		}
+	}()
+
+	start := time.Now()
+	err := executeCommand(ctx, cmd, events)
+	close(eventChannel)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > KILL_GRACE_PERIOD {
+		t.Errorf("command took %s to stop", elapsed)
+	}
+}
+
+func TestUnsubscribeEndsAfterQueuedEvents(t *testing.T) {
+	builder := NewBuilder()
+	subscriber := builder.GetSubscriber()
+	stalled := builder.GetSubscriber()
+	//more output than the subscribers buffer, nobody reads until the build is over
+	for i := 0; i < 3*cap(subscriber); i++ {
+		builder.events.message("line %d", i)
+	}
+	builder.events.emit(BuildEvent{Type: BUILD_FINISHED})
+	builder.Unsubscribe(subscriber)
+
+	done := make(chan int)
+	go func() {
+		received := 0
+		for range subscriber {
+			received++
+		}
+		done <- received
+	}()
+	select {
+	case received := <-done:
+		if received < cap(subscriber) {
+			t.Errorf("received %d events, want at least the %d buffered ones", received, cap(subscriber))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("subscriber was never closed, a full subscriber stalled the fan-out")
+	}
+	if len(stalled) != cap(stalled) {
+		t.Errorf("stalled subscriber holds %d events, want %d", len(stalled), cap(stalled))
+	}
+}
@@ -0,0 +1,157 @@
+package buildmanager
+
+import (
+	filesystem "LiveBuilder/Filesystem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestImportRendersTemplates(t *testing.T) {
+	sourceDir := t.TempDir()
+	files := map[string]string{
+		"hostname.cfg":           "{{.hostname}}",
+		"hostname.cfg.meta.json": `{"install_path": "config/includes.chroot/etc/hostname", "template": true}`,
+		"raw.cfg":                "{{.hostname}}",
+		"raw.cfg.meta.json":      `{"install_path": "config/includes.chroot/etc/raw"}`,
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(sourceDir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := filesystem.ScanDirectory(sourceDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	importer := NewImporter(newEventEmitter(make(chan BuildEvent, 10)))
+	importer.SetBuildPath(t.TempDir())
+	for _, entry := range entries {
+		if entry.MetaData.Template {
+			if err := importer.dropFileFromDirectoryEntry(entry); err == nil {
+				t.Errorf("%s: expected the unset variable to fail the template", entry.Name())
+			}
+		}
+	}
+	if _, err := os.Stat(filepath.Join(importer.buildPath, "config/includes.chroot/etc/hostname")); !os.IsNotExist(err) {
+		t.Error("expected a failed template to write nothing")
+	}
+
+	importer.SetVariables(map[string]string{"hostname": "kiosk"})
+	for _, entry := range entries {
+		if err := importer.dropFileFromDirectoryEntry(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := map[string]string{
+		"config/includes.chroot/etc/hostname": "kiosk\n",
+		"config/includes.chroot/etc/raw":      "{{.hostname}}\n",
+	}
+	for path, content := range expected {
+		data, err := os.ReadFile(filepath.Join(importer.buildPath, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != content {
+			t.Errorf("%s: expected %q, got %q", path, content, data)
+		}
+	}
+}
+
+func TestImportedTemplateIsNotChanged(t *testing.T) {
+	sourceDir := t.TempDir()
+	files := map[string]string{
+		"hostname.cfg":           "{{.hostname}}",
+		"hostname.cfg.meta.json": `{"install_path": "config/includes.chroot/etc/hostname", "template": true}`,
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(sourceDir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := filesystem.ScanDirectory(sourceDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := make(chan BuildEvent, 10)
+	importer := NewImporter(newEventEmitter(events))
+	importer.SetBuildPath(t.TempDir())
+	importer.SetVariables(map[string]string{"hostname": "kiosk"})
+	for _, entry := range entries {
+		if err := importer.dropFileFromDirectoryEntry(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	event := <-events
+	entry := HistoryEntry{Manifest: BuildManifest{ImportedFiles: []ImportedFile{
+		{Source: event.Source, InstallPath: event.Destination, SHA256: event.Checksum},
+	}}}
+	if changed := entry.ChangedInputs(); len(changed) != 0 {
+		t.Fatalf("expected no changed inputs right after the import, got %v", changed)
+	}
+}
+
+func TestImportModesAndOwnership(t *testing.T) {
+	sourceDir := t.TempDir()
+	files := map[string]string{
+		"fix.cfg":             "#!/bin/sh",
+		"fix.cfg.meta.json":   `{"install_path": "config/hooks/normal/0001-fix.hook.chroot"}`,
+		"motd.cfg":            "hello",
+		"motd.cfg.meta.json":  `{"install_path": "config/includes.chroot/etc/motd", "mode": "0640", "owner": "root", "group": "adm"}`,
+		"other.cfg":           "x",
+		"other.cfg.meta.json": `{"install_path": "config/includes.chroot/etc/other"}`,
+		"su.cfg":              "x",
+		"su.cfg.meta.json":    `{"install_path": "config/includes.chroot/usr/bin/su", "mode": "4755", "owner": "root"}`,
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(sourceDir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := filesystem.ScanDirectory(sourceDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	importer := NewImporter(newEventEmitter(make(chan BuildEvent, 10)))
+	importer.SetBuildPath(t.TempDir())
+	for _, entry := range entries {
+		if err := importer.dropFileFromDirectoryEntry(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := importer.writeOwnershipHook(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]os.FileMode{
+		"config/hooks/normal/0001-fix.hook.chroot": 0755,
+		"config/includes.chroot/etc/motd":          0640,
+		"config/includes.chroot/etc/other":         0644,
+		"config/includes.chroot/etc":               0755 | os.ModeDir,
+		OWNERSHIP_HOOK:                             0755,
+	}
+	for path, mode := range expected {
+		info, err := os.Stat(filepath.Join(importer.buildPath, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode() != mode {
+			t.Errorf("%s: expected %v, got %v", path, mode, info.Mode())
+		}
+	}
+	hook, err := os.ReadFile(filepath.Join(importer.buildPath, OWNERSHIP_HOOK))
+	if err != nil {
+		t.Fatal(err)
+	}
+	//chown clears setuid, the chmod after it puts it back
+	if !strings.Contains(string(hook), "chown root /usr/bin/su\nchmod 4755 /usr/bin/su\n") {
+		t.Errorf("setuid file not chmodded after chown:\n%s", hook)
+	}
+	if !strings.Contains(string(hook), "chown root:adm /etc/motd\nchmod 0640 /etc/motd\n") || strings.Contains(string(hook), "/etc/other") {
+		t.Errorf("unexpected ownership hook:\n%s", hook)
+	}
+}
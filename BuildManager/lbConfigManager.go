@@ -3,24 +3,22 @@ package buildmanager
 import (
 	appstate "LiveBuilder/AppState"
 	filesystem "LiveBuilder/Filesystem"
-	"bytes"
+	lbconfig "LiveBuilder/LBConfig"
+	"context"
 	"fmt"
 	"log"
-	"os"
 	"os/exec"
-	//"path/filepath"
-	"sync"
-	"text/template"
 )
 
 type LBConfigManager struct {
-	buildPath     string
-	updateChannel chan LogUpdate
+	buildPath string
+	variables map[string]string
+	events    *eventEmitter
 }
 
-func NewLBConfigManager(updateChannel chan LogUpdate) *LBConfigManager {
+func NewLBConfigManager(events *eventEmitter) *LBConfigManager {
 	return &LBConfigManager{
-		updateChannel: updateChannel,
+		events: events,
 	}
 }
 
@@ -28,7 +26,11 @@ func (self *LBConfigManager) SetBuildPath(buildPath string) {
 	self.buildPath = buildPath
 }
 
-func (self *LBConfigManager) ConfigureLB() error {
+func (self *LBConfigManager) SetVariables(variables map[string]string) {
+	self.variables = variables
+}
+
+func (self *LBConfigManager) ConfigureLB(ctx context.Context) error {
 	if self.buildPath == "" {
 		return fmt.Errorf("buildPath Not set")
 	}
@@ -36,88 +38,54 @@ func (self *LBConfigManager) ConfigureLB() error {
 	if err != nil {
 		return err
 	}
-	cmdOutChan := make(chan CommandOut, 100)
-	var wg sync.WaitGroup
-	wg.Add(1)
-
-	go func() {
-		defer wg.Done()
-		for cmdOut := range cmdOutChan {
-			logUpdate := self.transformToLogUpdate(cmdOut)
-			select {
-			case self.updateChannel <- logUpdate:
-			default:
-				log.Println("Warning: GUI update channel is full, dropping message")
-			}
-		}
-	}()
-	err = executeCommand(lb_config_command, cmdOutChan)
-	close(cmdOutChan)
-	wg.Wait()
-
-	return err
-
-}
-
-func (self *LBConfigManager) transformToLogUpdate(cmdout CommandOut) LogUpdate {
-	var msg string
-	if cmdout.OutType == STDERR {
-		msg = fmt.Sprintf("STD Error: %s\n", cmdout.OutPut)
-	} else {
-		msg = fmt.Sprintf("%s\n", cmdout.OutPut)
-	}
-	return LogUpdate{
-		Append:  true,
-		Message: msg,
-	}
+	return executeCommand(ctx, lb_config_command, self.events)
 }
 
+// parseLBCommand turns the selected config into an lb config command, only lb is ever run
 func (self *LBConfigManager) parseLBCommand() (*exec.Cmd, error) {
 
-	selectedCommandTemplate := appstate.GetGlobalState().GetDirectoryEntryMap(filesystem.LBCONFIGS_DIR_ID)
+	selectedConfigs := appstate.GetGlobalState().GetDirectoryEntryMap(filesystem.LBCONFIGS_DIR_ID)
 
-	if len(selectedCommandTemplate) != 1 {
+	if len(selectedConfigs) != 1 {
 		return nil, fmt.Errorf("Incorrect number of lb configs selected, must be only 1")
 	}
 
-	var lb_cmd string
+	var config *lbconfig.Config
 	var err error
-	for _, val := range selectedCommandTemplate {
-		lb_cmd, err = self.loadLBConfigTemplate(val)
+	for _, val := range selectedConfigs {
+		config, err = self.loadLBConfig(val)
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	tokens := parseShellCommand(lb_cmd)
-	if len(tokens) == 0 {
-		log.Println("empty command")
-		return nil, fmt.Errorf("empty command")
+	args, err := config.Args()
+	if err != nil {
+		return nil, err
 	}
-	cmd := exec.Command(tokens[0], tokens[1:]...)
+	log.Printf("Build lb config from config: %s\n", lbconfig.CommandLine(args))
+	cmd := exec.Command("lb", args...)
 	cmd.Dir = self.buildPath
 
 	return cmd, nil
 }
 
-func (self *LBConfigManager) loadLBConfigTemplate(dir filesystem.DirectoryEntry) (string, error) {
-	state := appstate.GetGlobalState()
-
-	content, err := os.ReadFile(dir.FullPath())
+// loadLBConfig reads the config file and fills in its variables, iso fields it leaves empty
+// come from the build settings
+func (self *LBConfigManager) loadLBConfig(entry filesystem.DirectoryEntry) (*lbconfig.Config, error) {
+	if !lbconfig.IsConfigFile(entry.Name()) {
+		return nil, fmt.Errorf("%s is not an lb config file, expected one of %v", entry.Name(), lbconfig.CONFIG_EXTENSIONS)
+	}
+	config, err := lbconfig.Load(entry.FullPath())
 	if err != nil {
-		log.Fatalf("Error reading file: %v", err)
+		return nil, err
 	}
-
-	tmpl, err := template.New("lbconfig").Parse(string(content))
+	config, err = config.Render(self.variables)
 	if err != nil {
-		return "", err
+		return nil, fmt.Errorf("%s: %w", entry.Name(), err)
 	}
 
-	var buf bytes.Buffer
-	if err := tmpl.Execute(&buf, state.LBcfg); err != nil {
-		return "", err
-	}
-	str := buf.String()
-	log.Printf("Build lb config from template: %s\n", str)
-	return str, nil
+	state := appstate.GetGlobalState()
+	config.FillISOFields(state.ISOVolumeName(), state.ISOPublisher(), state.ISOApplication(), state.ISOImageName())
+	return config, nil
 }
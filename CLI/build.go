@@ -0,0 +1,173 @@
+package cli
+
+import (
+	appstate "LiveBuilder/AppState"
+	buildmanager "LiveBuilder/BuildManager"
+	filesystem "LiveBuilder/Filesystem"
+	privileged "LiveBuilder/Privileged"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+// selectionFlags are the flags shared by every command that sets up a build selection
+type selectionFlags struct {
+	profile     *string
+	lbconfig    *string
+	packages    *string
+	customFiles *string
+	volume      *string
+	publisher   *string
+	application *string
+	imageName   *string
+	variables   []string
+}
+
+func addSelectionFlags(flags *flag.FlagSet) *selectionFlags {
+	selection := &selectionFlags{
+		profile:     flags.String("profile", "", "saved profile to start from, other flags add to it"),
+		lbconfig:    flags.String("lbconfig", "", "name of the lb config to use"),
+		packages:    flags.String("packages", "", "comma separated package list names"),
+		customFiles: flags.String("custom", "", "comma separated custom file names"),
+		volume:      flags.String("volume", "", "iso volume name"),
+		publisher:   flags.String("publisher", "", "iso publisher"),
+		application: flags.String("application", "", "iso application"),
+		imageName:   flags.String("image-name", "", "iso image name"),
+	}
+	flags.Func("var", "template variable as name=value, can be repeated", func(value string) error {
+		if !strings.Contains(value, "=") {
+			return fmt.Errorf("expected name=value, got %q", value)
+		}
+		selection.variables = append(selection.variables, value)
+		return nil
+	})
+	return selection
+}
+
+// apply loads the profile (if any) into the global state then layers the other flags on top
+func (self *selectionFlags) apply() error {
+	state := appstate.GetGlobalState()
+	if *self.profile != "" {
+		if err := state.LoadProfile(*self.profile); err != nil {
+			return err
+		}
+	}
+	if *self.lbconfig != "" {
+		lbconfigs := state.GetDirectoryEntryMap(filesystem.LBCONFIGS_DIR_ID)
+		for name := range lbconfigs {
+			delete(lbconfigs, name)
+		}
+	}
+
+	selections := map[string][]string{
+		filesystem.LBCONFIGS_DIR_ID:   splitList(*self.lbconfig),
+		filesystem.PACKAGE_DIR_ID:     splitList(*self.packages),
+		filesystem.CUSTOMFILES_DIR_ID: splitList(*self.customFiles),
+	}
+	for identifier, names := range selections {
+		if err := selectFiles(identifier, names); err != nil {
+			return err
+		}
+	}
+	setISOFields(*self.volume, *self.publisher, *self.application, *self.imageName)
+	for _, variable := range self.variables {
+		name, value, _ := strings.Cut(variable, "=")
+		if err := state.SetVariable(name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runBuild(args []string) int {
+	flags := flag.NewFlagSet("build", flag.ContinueOnError)
+	selection := addSelectionFlags(flags)
+	buildPath := flags.String("path", "", "build directory, defaults to a new temp directory")
+	if err := flags.Parse(args); err != nil {
+		return EXIT_USAGE
+	}
+	if *selection.profile == "" && *selection.lbconfig == "" {
+		fmt.Fprintln(os.Stderr, "build: -lbconfig or -profile is required")
+		flags.Usage()
+		return EXIT_USAGE
+	}
+	if err := selection.apply(); err != nil {
+		fmt.Fprintf(os.Stderr, "build: %v\n", err)
+		return EXIT_USAGE
+	}
+
+	return executeBuild(*buildPath)
+}
+
+// executeBuild runs the build against the current global state and streams updates to stdout
+func executeBuild(buildPath string) int {
+	defer privileged.Shutdown()
+	builder := buildmanager.NewBuilder()
+	subscriber := builder.GetSubscriber()
+
+	//ctrl-c cancels the build so lb gets torn down instead of leaving chroot mounts behind
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	result := make(chan error, 1)
+	go func() {
+		err := builder.Build(ctx, buildPath)
+		//ends the loop below after the last event, even if a full buffer dropped BUILD_FINISHED
+		builder.Unsubscribe(subscriber)
+		result <- err
+	}()
+
+	for event := range subscriber {
+		printEvent(event)
+	}
+
+	if err := <-result; err != nil {
+		return EXIT_FAILURE
+	}
+	return EXIT_OK
+}
+
+// printEvent writes command stderr to stderr and everything else to stdout
+func printEvent(event buildmanager.BuildEvent) {
+	if event.Type == buildmanager.OUTPUT_LINE && event.Stream == buildmanager.STDERR {
+		fmt.Fprintln(os.Stderr, event.Line)
+		return
+	}
+	fmt.Println(event.String())
+}
+
+// selectFiles marks the named files of a filesystem as selected in the global state
+func selectFiles(identifier string, names []string) error {
+	fileManager := filesystem.GetFileManager()
+	selected := appstate.GetGlobalState().GetDirectoryEntryMap(identifier)
+	for _, name := range names {
+		entry, err := fileManager.GetEntryByName(identifier, name)
+		if err != nil {
+			return err
+		}
+		selected[entry.Name()] = entry
+	}
+	return nil
+}
+
+func setISOFields(volume, publisher, application, imageName string) {
+	state := appstate.GetGlobalState()
+	fields := []struct {
+		value  string
+		setter func(string)
+	}{
+		{volume, state.SetISOVolumeName},
+		{publisher, state.SetISOPublisher},
+		{application, state.SetISOApplication},
+		{imageName, state.SetISOImageName},
+	}
+	for _, field := range fields {
+		if field.value != "" {
+			field.setter(field.value)
+		}
+	}
+}
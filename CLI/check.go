@@ -0,0 +1,29 @@
+package cli
+
+import (
+	preflightchecks "LiveBuilder/PreFlightChecks"
+	"fmt"
+	"strings"
+)
+
+func runCheck(args []string) int {
+	checks := []struct {
+		name string
+		fn   func() error
+	}{
+		{"lb version", preflightchecks.CheckLBversion},
+		{"required commands", preflightchecks.CheckCommands},
+		{"privilege elevation", preflightchecks.CheckElevation},
+	}
+
+	code := EXIT_OK
+	for _, check := range checks {
+		if err := check.fn(); err != nil {
+			fmt.Printf("[FAIL] %s: %s\n", check.name, strings.TrimSpace(err.Error()))
+			code = EXIT_FAILURE
+			continue
+		}
+		fmt.Printf("[ OK ] %s\n", check.name)
+	}
+	return code
+}
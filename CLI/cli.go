@@ -0,0 +1,86 @@
+package cli
+
+/*
+Command line front end, lets everything the gui can do be driven headless (ci runners etc)
+running with no arguments starts the gui
+*/
+
+import (
+	privileged "LiveBuilder/Privileged"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	PROGNAME = "livebuilder"
+
+	EXIT_OK      = 0
+	EXIT_FAILURE = 1
+	EXIT_USAGE   = 2
+)
+
+type command struct {
+	name        string
+	description string
+	run         func(args []string) int
+}
+
+func getCommands() []command {
+	return []command{
+		{"build", "build an iso from selected lb config, package lists and custom files", runBuild},
+		{"image", "image an iso onto a usb device or image file", runImage},
+		{"list", "list available lb configs, package lists, custom files, splash screens, built isos, block devices or partition layouts", runList},
+		{"history", "list, inspect, delete or re-run past builds", runHistory},
+		{"profile", "list, show, save, duplicate or delete saved build profiles", runProfile},
+		{"check", "run preflight checks for required tools", runCheck},
+		{"gui", "start the graphical interface (default when no command is given)", runGUI},
+	}
+}
+
+// Run dispatches args (without the program name) to a subcommand and returns the exit code
+func Run(args []string) int {
+	if len(args) == 0 {
+		return runGUI(args)
+	}
+
+	name := args[0]
+	//not listed in the usage, only ever started through pkexec or sudo by the app itself
+	if name == privileged.HELPER_COMMAND {
+		return runHelper(args[1:])
+	}
+	if name == "help" || name == "-h" || name == "--help" {
+		printUsage()
+		return EXIT_OK
+	}
+
+	for _, cmd := range getCommands() {
+		if cmd.name == name {
+			return cmd.run(args[1:])
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "unknown command: %s\n\n", name)
+	printUsage()
+	return EXIT_USAGE
+}
+
+func printUsage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [options]\n\nCommands:\n", PROGNAME)
+	for _, cmd := range getCommands() {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", cmd.name, cmd.description)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun '%s <command> -h' for command options\n", PROGNAME)
+}
+
+// splitList turns "a,b, c" into [a b c], dropping empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
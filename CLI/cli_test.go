@@ -0,0 +1,37 @@
+package cli
+
+import "testing"
+
+func TestExitCodes(t *testing.T) {
+	cases := []struct {
+		args     []string
+		expected int
+	}{
+		{[]string{"help"}, EXIT_OK},
+		{[]string{"--help"}, EXIT_OK},
+		{[]string{"frobnicate"}, EXIT_USAGE},
+		{[]string{"build"}, EXIT_USAGE},
+		{[]string{"build", "-no-such-flag"}, EXIT_USAGE},
+		{[]string{"image"}, EXIT_USAGE},
+		{[]string{"image", "-iso", "live.iso"}, EXIT_USAGE},
+		{[]string{"list"}, EXIT_USAGE},
+		{[]string{"list", "everything"}, EXIT_USAGE},
+		{[]string{"history"}, EXIT_USAGE},
+		{[]string{"history", "delete"}, EXIT_USAGE},
+		{[]string{"profile"}, EXIT_USAGE},
+	}
+	for _, test := range cases {
+		if code := Run(test.args); code != test.expected {
+			t.Errorf("%v: expected exit code %d, got %d", test.args, test.expected, code)
+		}
+	}
+}
+
+func TestGUIWithoutFrontend(t *testing.T) {
+	//the cli package alone does not link the gui, main sets ShowGUI
+	defer func(showGUI func()) { ShowGUI = showGUI }(ShowGUI)
+	ShowGUI = nil
+	if code := Run(nil); code != EXIT_FAILURE {
+		t.Errorf("expected exit code %d without a gui, got %d", EXIT_FAILURE, code)
+	}
+}
@@ -0,0 +1,29 @@
+package cli
+
+import (
+	preflightchecks "LiveBuilder/PreFlightChecks"
+	privileged "LiveBuilder/Privileged"
+	usbimager "LiveBuilder/USBImager"
+	"fmt"
+	"os"
+)
+
+// ShowGUI opens the main window and blocks until it is closed. main sets it, so this package
+// and with it the headless commands build without fyne and the X11 headers it needs
+var ShowGUI func()
+
+func runGUI(args []string) int {
+	if ShowGUI == nil {
+		fmt.Fprintf(os.Stderr, "%s was built without the graphical interface\n", PROGNAME)
+		return EXIT_FAILURE
+	}
+	preflightchecks.CheckAll(false)
+	//the helper is launched on the first privileged command and asked for a password once per session
+	defer privileged.Shutdown()
+	prepareImaging()
+	loadLayouts()
+	defer usbimager.DetachAllLoops()
+
+	ShowGUI()
+	return EXIT_OK
+}
@@ -0,0 +1,24 @@
+package cli
+
+import (
+	privileged "LiveBuilder/Privileged"
+	"fmt"
+	"log"
+	"os"
+)
+
+// runHelper is the elevated side of the privileged helper, stdin and stdout carry the
+// protocol so everything it logs goes to stderr, which the app forwards to its log
+func runHelper(args []string) int {
+	log.SetOutput(os.Stderr)
+	log.SetPrefix(privileged.HELPER_COMMAND + ": ")
+	if os.Geteuid() != 0 {
+		fmt.Fprintf(os.Stderr, "%s has to be started as root by %s itself\n", privileged.HELPER_COMMAND, PROGNAME)
+		return EXIT_USAGE
+	}
+	if err := privileged.Serve(os.Stdin, os.Stdout); err != nil {
+		log.Println(err)
+		return EXIT_FAILURE
+	}
+	return EXIT_OK
+}
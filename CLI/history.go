@@ -0,0 +1,140 @@
+package cli
+
+import (
+	buildmanager "LiveBuilder/BuildManager"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const historyUsage = `Usage: %[1]s history <command>
+
+  list [-profile name] [-status status] [-search text]  list past builds, newest first
+  show <id>                                             print a builds manifest summary
+  log <id>                                              print a builds full log
+  delete <id> [-artifacts-only]                         delete a build record or just its isos
+  rerun <id> [-path dir]                                build again with the same selections
+`
+
+func runHistory(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, historyUsage, PROGNAME)
+		return EXIT_USAGE
+	}
+	if args[0] == "list" {
+		return listHistory(args[1:])
+	}
+	if len(args) < 2 {
+		fmt.Fprintf(os.Stderr, historyUsage, PROGNAME)
+		return EXIT_USAGE
+	}
+
+	entry, err := buildmanager.GetHistoryEntry(args[1])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "history: %v\n", err)
+		return EXIT_FAILURE
+	}
+
+	switch args[0] {
+	case "show":
+		showHistoryEntry(entry)
+		return EXIT_OK
+	case "log":
+		data, err := os.ReadFile(entry.LogPath())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "history: %v\n", err)
+			return EXIT_FAILURE
+		}
+		os.Stdout.Write(data)
+		return EXIT_OK
+	case "delete":
+		return deleteHistoryEntry(entry, args[2:])
+	case "rerun":
+		return rerunHistoryEntry(entry, args[2:])
+	}
+	fmt.Fprintf(os.Stderr, "history: unknown command %s\n", args[0])
+	fmt.Fprintf(os.Stderr, historyUsage, PROGNAME)
+	return EXIT_USAGE
+}
+
+func listHistory(args []string) int {
+	flags := flag.NewFlagSet("history list", flag.ContinueOnError)
+	profile := flags.String("profile", "", "only builds of this profile")
+	status := flags.String("status", "", "only builds with this status ("+strings.Join(buildmanager.HistoryStatuses, ", ")+")")
+	search := flags.String("search", "", "only builds whose id, profile, error or selected files contain this text")
+	if err := flags.Parse(args); err != nil {
+		return EXIT_USAGE
+	}
+
+	entries, err := buildmanager.ListHistory()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "history: %v\n", err)
+		return EXIT_FAILURE
+	}
+	filter := buildmanager.HistoryFilter{Profile: *profile, Status: *status, Search: *search}
+	for _, entry := range buildmanager.FilterHistory(entries, filter) {
+		fmt.Printf("%-40s %s\n", entry.ID(), entry.Summary())
+	}
+	return EXIT_OK
+}
+
+func showHistoryEntry(entry buildmanager.HistoryEntry) {
+	manifest := entry.Manifest
+	fmt.Printf("Build: %s\n", manifest.ID)
+	fmt.Printf("\tStatus: %s\n", manifest.Status)
+	if manifest.Error != "" {
+		fmt.Printf("\tError: %s\n", manifest.Error)
+	}
+	fmt.Printf("\tProfile: %s\n", manifest.Inputs.Name)
+	fmt.Printf("\tStarted: %s\n", manifest.StartTime.Format(time.RFC1123))
+	fmt.Printf("\tDuration: %s\n", manifest.Duration.Round(time.Second))
+	fmt.Printf("\tApp version: %s, lb version: %s\n", manifest.AppVersion, manifest.LBVersion)
+	fmt.Printf("\tRecord: %s\n", entry.Dir)
+	for identifier, names := range manifest.Inputs.Selections {
+		if len(names) > 0 {
+			fmt.Printf("\t%s: %s\n", identifier, strings.Join(names, ", "))
+		}
+	}
+	for _, artifact := range entry.ExistingArtifacts() {
+		fmt.Printf("\tISO: %s\n", artifact)
+	}
+}
+
+func deleteHistoryEntry(entry buildmanager.HistoryEntry, args []string) int {
+	flags := flag.NewFlagSet("history delete", flag.ContinueOnError)
+	artifactsOnly := flags.Bool("artifacts-only", false, "only delete the isos, keep the log and manifest")
+	if err := flags.Parse(args); err != nil {
+		return EXIT_USAGE
+	}
+
+	var err error
+	if *artifactsOnly {
+		err = entry.DeleteArtifacts()
+	} else {
+		err = entry.Delete()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "history: %v\n", err)
+		return EXIT_FAILURE
+	}
+	return EXIT_OK
+}
+
+func rerunHistoryEntry(entry buildmanager.HistoryEntry, args []string) int {
+	flags := flag.NewFlagSet("history rerun", flag.ContinueOnError)
+	buildPath := flags.String("path", "", "build directory, defaults to a new temp directory")
+	if err := flags.Parse(args); err != nil {
+		return EXIT_USAGE
+	}
+
+	if err := entry.ApplyInputs(); err != nil {
+		fmt.Fprintf(os.Stderr, "history: %v\n", err)
+		return EXIT_FAILURE
+	}
+	for _, changed := range entry.ChangedInputs() {
+		fmt.Fprintf(os.Stderr, "warning: %s has changed since build %s\n", changed, entry.ID())
+	}
+	return executeBuild(*buildPath)
+}
@@ -0,0 +1,208 @@
+package cli
+
+import (
+	filesystem "LiveBuilder/Filesystem"
+	privileged "LiveBuilder/Privileged"
+	usbimager "LiveBuilder/USBImager"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// LOOP_REGISTRY_FILE lives in the app data dir and lists image files we attached to loop devices
+const LOOP_REGISTRY_FILE = "loops"
+
+func runImage(args []string) int {
+	loadLayouts()
+	flags := flag.NewFlagSet("image", flag.ContinueOnError)
+	iso := flags.String("iso", "", "path to the iso to image (required)")
+	target := flags.String("target", "", "block device or image file to write to, comma separated to duplicate onto several (required)")
+	parallel := flags.Int("parallel", usbimager.DEFAULT_PARALLELISM, "how many targets are written at the same time when duplicating")
+	strategy := flags.String("strategy", usbimager.DEFAULT_STRATEGY, fmt.Sprintf("how the iso is written, one of %s. raw writes the iso-hybrid image as is for read only sticks", strings.Join(usbimager.StrategyNames(), ", ")))
+	layout := flags.String("layout", usbimager.DEFAULT_LAYOUT, fmt.Sprintf("partition layout, one of %s", strings.Join(usbimager.LayoutNames(), ", ")))
+	persistence := flags.Bool("persistence", false, "add a live-boot persistence partition")
+	persistenceSize := flags.String("persistence-size", "", "size of the persistence partition eg 4G, default is the rest of the disk")
+	persistencePaths := flags.String("persistence-paths", "", "comma separated persistence.conf lines, default \"/ union\"")
+	verify := flags.Bool("verify", true, "read the target back and compare it to the iso after writing")
+	imageSize := flags.String("image-size", "", "size of an image file target eg 8G, default fits the iso, headroom and persistence")
+	compress := flags.String("compress", usbimager.COMPRESSION_NONE, fmt.Sprintf("compress an image file target when done, one of %s", strings.Join(usbimager.CompressionNames(), ", ")))
+	checksum := flags.Bool("checksum", false, "write a .sha256 file next to an image file target")
+	force := flags.Bool("force", false, "image the target even if it looks ineligible, system and non removable disks are always refused")
+	if err := flags.Parse(args); err != nil {
+		return EXIT_USAGE
+	}
+	if *iso == "" || *target == "" {
+		fmt.Fprintln(os.Stderr, "image: -iso and -target are required")
+		flags.Usage()
+		return EXIT_USAGE
+	}
+
+	imager := usbimager.NewUSBImager()
+	imager.Strategy = *strategy
+	imager.Layout = *layout
+	imager.Force = *force
+	imager.Verify = *verify
+	if *persistence || *persistenceSize != "" || *persistencePaths != "" {
+		imager.Persistence = &usbimager.PersistenceOptions{
+			Size:       *persistenceSize,
+			UnionPaths: splitList(*persistencePaths),
+		}
+	}
+	if *imageSize != "" || *compress != usbimager.COMPRESSION_NONE || *checksum {
+		imager.ImageFile = &usbimager.ImageFileOptions{
+			Size:        *imageSize,
+			Compression: *compress,
+			Checksum:    *checksum,
+		}
+	}
+	defer privileged.Shutdown()
+	prepareImaging()
+	defer usbimager.DetachAllLoops()
+	stop := cancelOnInterrupt(imager)
+	defer stop()
+
+	targets := splitList(*target)
+	if len(targets) > 1 {
+		return executeBatch(imager, *iso, targets, *parallel)
+	}
+	return executeImage(imager, *iso, *target)
+}
+
+// prepareImaging records loop devices in the app data dir and sweeps up loops a crashed run left attached
+func prepareImaging() {
+	appdata, err := filesystem.GetAppDataDir()
+	if err != nil {
+		log.Printf("No app data dir, loop devices are not recorded: %v\n", err)
+		return
+	}
+	usbimager.LOOP_REGISTRY = filepath.Join(appdata, LOOP_REGISTRY_FILE)
+	swept, err := usbimager.SweepLoops()
+	if err != nil {
+		log.Printf("Sweeping leftover loop devices failed: %v\n", err)
+	}
+	if swept > 0 {
+		fmt.Printf("Detached %d loop device(s) left over from an earlier run\n", swept)
+	}
+}
+
+// loadLayouts adds the layout files of the app data dir to the shipped ones, a broken file
+// only costs that layout
+func loadLayouts() {
+	dir := filepath.Join(filesystem.GetFileManager().GetAppDataDir(), filesystem.LAYOUTS_DIR_ID)
+	if err := usbimager.LoadLayouts(dir); err != nil {
+		fmt.Fprintf(os.Stderr, "Skipping broken partition layouts: %v\n", err)
+	}
+}
+
+// cancelOnInterrupt cancels the imager on ctrl-c so targets are unmounted and detached before exiting
+func cancelOnInterrupt(imager *usbimager.USBImager) func() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		if _, ok := <-signals; ok {
+			fmt.Fprintln(os.Stderr, "\nCancelling, cleaning up the target...")
+			imager.Cancel()
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(signals)
+	}
+}
+
+func executeImage(imager *usbimager.USBImager, iso, target string) int {
+	subscriber := imager.GetSubscriber()
+	result := make(chan error, 1)
+	go func() {
+		err := imager.ImageUSB(iso, target)
+		//closing ends the loop below even if the subscriber missed events
+		imager.Close()
+		result <- err
+	}()
+
+	progress := &progressLine{}
+	var artifacts []string
+	for event := range subscriber {
+		if event.Type == usbimager.PROGRESS {
+			progress.update(event.String())
+			continue
+		}
+		progress.finish()
+		if event.Type == usbimager.IMAGE_FINISHED {
+			artifacts = event.Artifacts
+			continue
+		}
+		fmt.Println(event.String())
+	}
+
+	if err := <-result; err != nil {
+		fmt.Fprintf(os.Stderr, "imaging failed: %v\n", err)
+		return EXIT_FAILURE
+	}
+	fmt.Printf("Imaged %s onto %s\n", iso, target)
+	for _, artifact := range artifacts {
+		fmt.Printf("\t%s\n", artifact)
+	}
+	return EXIT_OK
+}
+
+// executeBatch prints stage changes prefixed with their target, byte progress of many
+// devices does not fit a single line so only the final status of each is shown
+func executeBatch(imager *usbimager.USBImager, iso string, targets []string, parallel int) int {
+	subscriber := imager.GetSubscriber()
+	results := make(chan []usbimager.DeviceResult, 1)
+	go func() {
+		batchResults := imager.ImageBatch(iso, targets, parallel)
+		//closing ends the loop below even if the subscriber missed BATCH_FINISHED
+		imager.Close()
+		results <- batchResults
+	}()
+
+	for event := range subscriber {
+		if event.Type == usbimager.PROGRESS || event.Type == usbimager.STAGE_FINISHED || event.Type == usbimager.BATCH_FINISHED {
+			continue
+		}
+		if event.Target == "" {
+			fmt.Println(event.String())
+			continue
+		}
+		fmt.Printf("[%s] %s\n", event.Target, event.String())
+	}
+
+	status := EXIT_OK
+	for _, result := range <-results {
+		fmt.Printf("%s: %s after %s\n", result.Target, result.Status(), result.Duration.Round(time.Second))
+		if result.Err != nil {
+			fmt.Fprintf(os.Stderr, "\t%v\n", result.Err)
+			status = EXIT_FAILURE
+		}
+	}
+	return status
+}
+
+// progressLine redraws a single status line in place until finish moves past it
+type progressLine struct {
+	width int
+}
+
+func (self *progressLine) update(text string) {
+	padding := ""
+	if len(text) < self.width {
+		padding = strings.Repeat(" ", self.width-len(text))
+	}
+	fmt.Printf("\r%s%s", text, padding)
+	self.width = len(text)
+}
+
+func (self *progressLine) finish() {
+	if self.width > 0 {
+		fmt.Println()
+		self.width = 0
+	}
+}
@@ -0,0 +1,100 @@
+package cli
+
+import (
+	filesystem "LiveBuilder/Filesystem"
+	usbimager "LiveBuilder/USBImager"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var listTargets = map[string]string{
+	"packages":  filesystem.PACKAGE_DIR_ID,
+	"custom":    filesystem.CUSTOMFILES_DIR_ID,
+	"lbconfigs": filesystem.LBCONFIGS_DIR_ID,
+	"splash":    filesystem.SPLASH_SCREENS_ID,
+}
+
+func runList(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: %s list <packages|custom|lbconfigs|splash|isos|devices|layouts>\n", PROGNAME)
+		return EXIT_USAGE
+	}
+	switch args[0] {
+	case "isos":
+		return listISOs()
+	case "devices":
+		return listDevices()
+	case "layouts":
+		return listLayouts()
+	}
+
+	identifier, ok := listTargets[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "list: unknown target %s\n", args[0])
+		return EXIT_USAGE
+	}
+
+	for _, entry := range filesystem.GetFileManager().GetFileSystem(identifier) {
+		fmt.Printf("%s\n", entry.Name())
+		if entry.MetaData.Description != "" {
+			fmt.Printf("\tdescription: %s\n", entry.MetaData.Description)
+		}
+		if tags := strings.Join(entry.MetaData.Tags, ", "); strings.TrimSpace(tags) != "" {
+			fmt.Printf("\ttags: %s\n", tags)
+		}
+	}
+	return EXIT_OK
+}
+
+func listISOs() int {
+	appdata, err := filesystem.GetAppDataDir()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "list: %v\n", err)
+		return EXIT_FAILURE
+	}
+	//isos live in per build record directories, older builds copied them straight into BuiltISOs
+	var isos []string
+	for _, pattern := range []string{"*.iso", filepath.Join("*", "*.iso")} {
+		matches, err := filepath.Glob(filepath.Join(appdata, filesystem.ISO_DIR_ID, pattern))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "list: %v\n", err)
+			return EXIT_FAILURE
+		}
+		isos = append(isos, matches...)
+	}
+	for _, iso := range isos {
+		fmt.Println(iso)
+	}
+	return EXIT_OK
+}
+
+// listDevices prints every disk, the ones image would refuse without -force are marked
+func listDevices() int {
+	devices, err := usbimager.ListBlockDevices()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "list: %v\n", err)
+		return EXIT_FAILURE
+	}
+	for _, device := range devices {
+		fmt.Println(device.Description())
+		if reason := device.IneligibleReason(); reason != "" {
+			fmt.Printf("\tnot eligible: %s\n", reason)
+		}
+	}
+	return EXIT_OK
+}
+
+// listLayouts prints the shipped and app data dir partition layouts image -layout accepts
+func listLayouts() int {
+	loadLayouts()
+	for _, name := range usbimager.LayoutNames() {
+		layout, _ := usbimager.GetLayout(name)
+		fmt.Printf("%s (%s, %d partitions)\n", name, layout.Table, len(layout.Partitions))
+		if layout.Description != "" {
+			fmt.Printf("\tdescription: %s\n", layout.Description)
+		}
+	}
+	return EXIT_OK
+}
@@ -0,0 +1,134 @@
+package cli
+
+import (
+	appstate "LiveBuilder/AppState"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+)
+
+const profileUsage = `Usage: %[1]s profile <command>
+
+  list                          list saved profiles
+  show <name>                   print a profiles selections and variables
+  save <name> [selection flags] save a profile, see '%[1]s profile save -h'
+  duplicate <source> <dest>     copy a profile under a new name
+  delete <name>                 delete a profile
+`
+
+func runProfile(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, profileUsage, PROGNAME)
+		return EXIT_USAGE
+	}
+
+	var err error
+	switch args[0] {
+	case "list":
+		err = listProfiles()
+	case "show":
+		if len(args) != 2 {
+			return profileArgsError(args[0])
+		}
+		err = showProfile(args[1])
+	case "save":
+		if len(args) < 2 {
+			return profileArgsError(args[0])
+		}
+		return saveProfile(args[1], args[2:])
+	case "duplicate":
+		if len(args) != 3 {
+			return profileArgsError(args[0])
+		}
+		err = appstate.DuplicateProfile(args[1], args[2])
+	case "delete":
+		if len(args) != 2 {
+			return profileArgsError(args[0])
+		}
+		err = appstate.DeleteProfile(args[1])
+	default:
+		fmt.Fprintf(os.Stderr, "profile: unknown command %s\n", args[0])
+		fmt.Fprintf(os.Stderr, profileUsage, PROGNAME)
+		return EXIT_USAGE
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "profile: %v\n", err)
+		return EXIT_FAILURE
+	}
+	return EXIT_OK
+}
+
+// profileArgsError reports a profile command given the wrong number of arguments
+func profileArgsError(command string) int {
+	fmt.Fprintf(os.Stderr, "profile %s: wrong number of arguments\n", command)
+	fmt.Fprintf(os.Stderr, profileUsage, PROGNAME)
+	return EXIT_USAGE
+}
+
+func listProfiles() error {
+	names, err := appstate.ListProfiles()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		fmt.Println(name)
+	}
+	return nil
+}
+
+func showProfile(name string) error {
+	profile, err := appstate.ReadProfile(name)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Profile: %s\n", profile.Name)
+	fmt.Printf("\tISO Volume: %s\n", profile.LBConfig.ISOVolume)
+	fmt.Printf("\tISO Publisher: %s\n", profile.LBConfig.ISOPublisher)
+	fmt.Printf("\tISO Application: %s\n", profile.LBConfig.ISOApplication)
+	fmt.Printf("\tISO ImageName: %s\n", profile.LBConfig.ISOImageName)
+
+	identifiers := make([]string, 0, len(profile.Selections))
+	for identifier := range profile.Selections {
+		identifiers = append(identifiers, identifier)
+	}
+	sort.Strings(identifiers)
+	for _, identifier := range identifiers {
+		fmt.Printf("%s:\n", identifier)
+		for _, file := range profile.Selections[identifier] {
+			fmt.Printf("\t%s\n", file)
+		}
+	}
+
+	names := make([]string, 0, len(profile.Variables))
+	for variable := range profile.Variables {
+		names = append(names, variable)
+	}
+	sort.Strings(names)
+	if len(names) > 0 {
+		fmt.Println("Variables:")
+	}
+	for _, variable := range names {
+		fmt.Printf("\t%s=%s\n", variable, profile.Variables[variable])
+	}
+	return nil
+}
+
+func saveProfile(name string, args []string) int {
+	flags := flag.NewFlagSet("profile save", flag.ContinueOnError)
+	selection := addSelectionFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return EXIT_USAGE
+	}
+	if err := selection.apply(); err != nil {
+		fmt.Fprintf(os.Stderr, "profile: %v\n", err)
+		return EXIT_USAGE
+	}
+	if err := appstate.GetGlobalState().SaveProfile(name); err != nil {
+		fmt.Fprintf(os.Stderr, "profile: %v\n", err)
+		return EXIT_FAILURE
+	}
+	fmt.Printf("Saved profile %s\n", name)
+	return EXIT_OK
+}
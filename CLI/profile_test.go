@@ -0,0 +1,21 @@
+package cli
+
+import "testing"
+
+func TestProfileArgumentCounts(t *testing.T) {
+	for _, args := range [][]string{
+		{},
+		{"show"},
+		{"show", "a", "b"},
+		{"save"},
+		{"duplicate", "a"},
+		{"duplicate", "a", "b", "c"},
+		{"delete"},
+		{"delete", "a", "b"},
+		{"rename", "a"},
+	} {
+		if code := runProfile(args); code != EXIT_USAGE {
+			t.Errorf("profile %v: expected exit code %d, got %d", args, EXIT_USAGE, code)
+		}
+	}
+}
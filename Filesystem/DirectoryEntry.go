@@ -35,7 +35,8 @@ func ScanDirectory(dirPath string) ([]DirectoryEntry, error) {
 
 	for _, entry := range entries {
 
-		if strings.HasSuffix(entry.Name(), ".meta.json") {
+		//hidden entries hold app bookkeeping like imported lb config templates
+		if strings.HasSuffix(entry.Name(), ".meta.json") || strings.HasPrefix(entry.Name(), ".") {
 			continue
 		}
 
@@ -66,6 +66,28 @@ func GetAppDataDir() (string, error) {
 	return dir, nil
 }
 
+// ReadEmbeddedDir returns the files shipped in one of the staticfiles directories keyed by
+// file name, for defaults that have to exist before anything is extracted
+func ReadEmbeddedDir(identifier string) (map[string][]byte, error) {
+	dir := EMBEDDED_FS_ROOT + "/" + identifier
+	entries, err := embeddedFiles.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("no embedded directory %s: %v", identifier, err)
+	}
+	files := make(map[string][]byte)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		content, err := embeddedFiles.ReadFile(dir + "/" + entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read embedded file %s: %v", entry.Name(), err)
+		}
+		files[entry.Name()] = content
+	}
+	return files, nil
+}
+
 // extractEmbeddedFiles extracts all embedded files to the target directory
 func extractEmbeddedFiles(targetDir string) error {
 	// Create target directory if it doesn't exist
@@ -1,18 +1,24 @@
 package filesystem
 
 import (
+	lbconfig "LiveBuilder/LBConfig"
+	"fmt"
 	"log"
 	"path/filepath"
+	"strings"
 	"sync"
 )
 
 const (
 	APPNAME            = "LiveBuidler"
+	APPVERSION         = "0.1.0"
 	PACKAGE_DIR_ID     = "PackageLists"
 	CUSTOMFILES_DIR_ID = "CustomFiles"
 	LBCONFIGS_DIR_ID   = "LBConfigs"
 	SPLASH_SCREENS_ID  = "SplashScreens"
 	ISO_DIR_ID         = "BuiltISOs"
+	PROFILES_DIR_ID    = "Profiles"
+	LAYOUTS_DIR_ID     = "DiskLayouts"
 )
 
 var lock = &sync.Mutex{}
@@ -46,6 +52,9 @@ func (self *FileManager) InializeFilesystem() {
 	if err := extractEmbeddedFiles(appDir); err != nil {
 		log.Fatalf("Error extracting embedded files: %v", err)
 	}
+	if err := migrateLBConfigTemplates(filepath.Join(appDir, LBCONFIGS_DIR_ID)); err != nil {
+		log.Printf("Some lb config templates could not be imported: %v\n", err)
+	}
 	self.buildFilesystemMap()
 }
 func (self *FileManager) buildFilesystemMap() {
@@ -63,9 +72,32 @@ func (self *FileManager) buildFilesystemMap() {
 		self.fileSystems[value], _ = ScanDirectory(path)
 	}
 }
+
+// RescanFileSystem rereads one directory, for files the app wrote itself
+func (self *FileManager) RescanFileSystem(fs_identifier string) {
+	path := filepath.Join(self.GetAppDataDir(), fs_identifier)
+	self.fileSystems[fs_identifier], _ = ScanDirectory(path)
+}
 func (self *FileManager) GetFileSystem(fs_identifier string) []DirectoryEntry {
 	return self.fileSystems[fs_identifier]
 }
+func (self *FileManager) GetEntryByName(fs_identifier string, name string) (DirectoryEntry, error) {
+	for _, entry := range self.fileSystems[fs_identifier] {
+		if entry.Name() == name {
+			return entry, nil
+		}
+	}
+	//profiles saved before the lb config migration still name the template
+	if fs_identifier == LBCONFIGS_DIR_ID && strings.HasSuffix(name, lbconfig.TEMPLATE_EXT) {
+		dir := filepath.Join(self.appDriectory, LBCONFIGS_DIR_ID)
+		for _, migrated := range MigratedConfigCandidates(dir, name) {
+			if entry, err := self.GetEntryByName(fs_identifier, migrated); err == nil {
+				return entry, nil
+			}
+		}
+	}
+	return DirectoryEntry{}, fmt.Errorf("no file named %s in %s", name, fs_identifier)
+}
 func (self *FileManager) GetAppDataDir() string {
 	return self.appDriectory
 }
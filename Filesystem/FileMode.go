@@ -0,0 +1,80 @@
+package filesystem
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	DEFAULT_FILE_MODE    fs.FileMode = 0644
+	DEFAULT_HOOK_MODE    fs.FileMode = 0755
+	HOOKS_PATH                       = "config/hooks/"
+	INCLUDES_CHROOT_PATH             = "config/includes.chroot/"
+)
+
+// user and group names as useradd accepts them, or numeric ids
+var OWNER_NAME = regexp.MustCompile(`^([a-z_][a-z0-9_-]*\$?|[0-9]+)$`)
+
+// FileMode parses the mode field, live-build only runs hooks that are executable so
+// anything under config/hooks defaults to 0755
+func (self FileMetadata) FileMode() (fs.FileMode, error) {
+	if self.Mode == "" {
+		if self.IsHook() {
+			return DEFAULT_HOOK_MODE, nil
+		}
+		return DEFAULT_FILE_MODE, nil
+	}
+	mode, err := strconv.ParseUint(self.Mode, 8, 32)
+	if err != nil || mode > 0o7777 {
+		return 0, fmt.Errorf("invalid mode %q, expected octal like 0644", self.Mode)
+	}
+	//fs.FileMode keeps setuid, setgid and sticky outside the permission bits
+	fileMode := fs.FileMode(mode & 0o777)
+	if mode&0o4000 != 0 {
+		fileMode |= fs.ModeSetuid
+	}
+	if mode&0o2000 != 0 {
+		fileMode |= fs.ModeSetgid
+	}
+	if mode&0o1000 != 0 {
+		fileMode |= fs.ModeSticky
+	}
+	return fileMode, nil
+}
+
+func (self FileMetadata) IsHook() bool {
+	return strings.HasPrefix(path.Clean(self.InstallPath), HOOKS_PATH)
+}
+
+// ChrootPath is where a config/includes.chroot file ends up in the live system, ok is false
+// for every other install path
+func (self FileMetadata) ChrootPath() (string, bool) {
+	installPath := path.Clean(self.InstallPath)
+	if !strings.HasPrefix(installPath, INCLUDES_CHROOT_PATH) {
+		return "", false
+	}
+	return "/" + strings.TrimPrefix(installPath, INCLUDES_CHROOT_PATH), true
+}
+
+// Ownership returns the owner[:group] argument for chown, empty when neither is set
+func (self FileMetadata) Ownership() (string, error) {
+	if self.Owner == "" && self.Group == "" {
+		return "", nil
+	}
+	if _, ok := self.ChrootPath(); !ok {
+		return "", fmt.Errorf("owner and group only apply to files under %s, got %s", INCLUDES_CHROOT_PATH, self.InstallPath)
+	}
+	for _, name := range []string{self.Owner, self.Group} {
+		if name != "" && !OWNER_NAME.MatchString(name) {
+			return "", fmt.Errorf("invalid owner or group %q", name)
+		}
+	}
+	if self.Group == "" {
+		return self.Owner, nil
+	}
+	return self.Owner + ":" + self.Group, nil
+}
@@ -0,0 +1,157 @@
+package filesystem
+
+/*
+Older versions kept lb configs as .template files of shell text. They are converted to
+json configs once, the originals move to LBConfigs/.imported so they are neither listed
+nor imported again. Which config each template became is kept in .imported/migrated.json,
+profiles naming the template are resolved through it
+*/
+
+import (
+	lbconfig "LiveBuilder/LBConfig"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const (
+	IMPORTED_TEMPLATES_DIR = ".imported"
+	MIGRATED_CONFIGS_FILE  = "migrated.json" // template name -> config name, inside IMPORTED_TEMPLATES_DIR
+)
+
+// migrateLBConfigTemplates converts every .template in dir, a template that fails to import
+// is left in place and reported so it can be fixed and picked up on the next start
+func migrateLBConfigTemplates(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	var errs []error
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != lbconfig.TEMPLATE_EXT {
+			continue
+		}
+		if err := migrateLBConfigTemplate(dir, entry.Name()); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", entry.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func migrateLBConfigTemplate(dir, templateName string) error {
+	templatePath := filepath.Join(dir, templateName)
+	text, err := os.ReadFile(templatePath)
+	if err != nil {
+		return err
+	}
+	config, err := lbconfig.ImportTemplate(string(text))
+	if err != nil {
+		return err
+	}
+
+	//a shipped config of the same name is only kept when the template was never changed
+	configName, exists, err := migratedConfigName(dir, templateName, config)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		configPath := filepath.Join(dir, configName)
+		if err := config.Save(configPath); err != nil {
+			return err
+		}
+		if meta, err := os.ReadFile(templatePath + ".meta.json"); err == nil {
+			if err := os.WriteFile(configPath+".meta.json", meta, 0644); err != nil {
+				return err
+			}
+		}
+		log.Printf("Imported lb config template %s as %s\n", templateName, configName)
+	}
+
+	imported := filepath.Join(dir, IMPORTED_TEMPLATES_DIR)
+	if err := os.MkdirAll(imported, 0755); err != nil {
+		return err
+	}
+	for _, name := range []string{templateName, templateName + ".meta.json"} {
+		if err := os.Rename(filepath.Join(dir, name), filepath.Join(imported, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return recordMigratedConfig(dir, templateName, configName)
+}
+
+// migratedConfigName picks the file a template is imported to, exists is set when an identical
+// config is already there under that name
+func migratedConfigName(dir, templateName string, config *lbconfig.Config) (string, bool, error) {
+	args, err := config.Args()
+	if err != nil {
+		return "", false, err
+	}
+	for _, name := range MigratedLBConfigNames(templateName) {
+		existing, err := lbconfig.Load(filepath.Join(dir, name))
+		if errors.Is(err, os.ErrNotExist) {
+			return name, false, nil
+		}
+		if err != nil {
+			continue
+		}
+		if existingArgs, err := existing.Args(); err == nil && slices.Equal(args, existingArgs) {
+			return name, true, nil
+		}
+	}
+	return "", false, fmt.Errorf("every name it could be imported as is taken")
+}
+
+func readMigratedConfigs(dir string) (map[string]string, error) {
+	migrated := make(map[string]string)
+	data, err := os.ReadFile(filepath.Join(dir, IMPORTED_TEMPLATES_DIR, MIGRATED_CONFIGS_FILE))
+	if os.IsNotExist(err) {
+		return migrated, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return migrated, json.Unmarshal(data, &migrated)
+}
+
+func recordMigratedConfig(dir, templateName, configName string) error {
+	migrated, err := readMigratedConfigs(dir)
+	if err != nil {
+		return err
+	}
+	migrated[templateName] = configName
+	data, err := json.MarshalIndent(migrated, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, IMPORTED_TEMPLATES_DIR, MIGRATED_CONFIGS_FILE), data, 0644)
+}
+
+// MigratedConfigCandidates are the configs a template in dir may have become, best match first.
+// The recorded migration wins, templates migrated before it was recorded try the -imported
+// name first since it only exists when the template differed from the shipped config
+func MigratedConfigCandidates(dir, templateName string) []string {
+	names := MigratedLBConfigNames(templateName)
+	slices.Reverse(names)
+	migrated, err := readMigratedConfigs(dir)
+	if err != nil {
+		log.Printf("Reading %s failed: %v\n", MIGRATED_CONFIGS_FILE, err)
+	}
+	if configName, ok := migrated[templateName]; ok {
+		names = append([]string{configName}, names...)
+	}
+	return names
+}
+
+// MigratedLBConfigNames are the names a template may be imported as, in the order migration tries them
+func MigratedLBConfigNames(templateName string) []string {
+	base := strings.TrimSuffix(templateName, lbconfig.TEMPLATE_EXT)
+	return []string{base + ".json", base + "-imported.json"}
+}
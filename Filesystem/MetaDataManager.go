@@ -9,10 +9,23 @@ import (
 )
 
 type FileMetadata struct {
-	InstallPath string   `json:"install_path"`
-	Tags        []string `json:"tags"`
-	Description string   `json:"description"`
-	FileType    string   `json:"file_type"`
+	InstallPath string         `json:"install_path"`
+	Tags        []string       `json:"tags"`
+	Description string         `json:"description"`
+	FileType    string         `json:"file_type"`
+	Variables   []VariableSpec `json:"variables,omitempty"`
+	Template    bool           `json:"template,omitempty"` // render through text/template with the builds variables on import
+	Mode        string         `json:"mode,omitempty"`     // octal, eg "0755", empty picks the default for the install path
+	Owner       string         `json:"owner,omitempty"`    // user and group inside the live system, config/includes.chroot only
+	Group       string         `json:"group,omitempty"`
+}
+
+// VariableSpec declares a template variable the file uses, the values come from the profile
+type VariableSpec struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Required    bool   `json:"required,omitempty"`
+	Default     string `json:"default,omitempty"`
 }
 
 // LoadFileMetadata loads metadata from a sidecar .meta.json file
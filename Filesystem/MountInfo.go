@@ -0,0 +1,68 @@
+package filesystem
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const MOUNTINFO = "/proc/self/mountinfo"
+
+// MountInfo is one line of a mountinfo file
+type MountInfo struct {
+	DevNumber  string // major:minor of the mounted device
+	MountPoint string
+	Source     string // eg /dev/sdb1, or whatever the filesystem reports for virtual mounts
+}
+
+// ReadMountInfo parses a mountinfo file, lines it can not make sense of are skipped
+func ReadMountInfo(path string) ([]MountInfo, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var mounts []MountInfo
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		//id parent major:minor root mount_point options [optional...] - type source super_options
+		fields := strings.Fields(scanner.Text())
+		separator := -1
+		for i, field := range fields {
+			if field == "-" {
+				separator = i
+				break
+			}
+		}
+		if len(fields) < 5 || separator < 0 || separator+2 >= len(fields) {
+			continue
+		}
+		mounts = append(mounts, MountInfo{
+			DevNumber:  fields[2],
+			MountPoint: UnescapeMountPath(fields[4]),
+			Source:     UnescapeMountPath(fields[separator+2]),
+		})
+	}
+	return mounts, scanner.Err()
+}
+
+// UnescapeMountPath decodes the octal escapes (\040 for space etc) used in mountinfo
+func UnescapeMountPath(path string) string {
+	if !strings.Contains(path, `\`) {
+		return path
+	}
+	var out strings.Builder
+	for i := 0; i < len(path); i++ {
+		if path[i] == '\\' && i+3 < len(path) {
+			if value, err := strconv.ParseUint(path[i+1:i+4], 8, 8); err == nil {
+				out.WriteByte(byte(value))
+				i += 3
+				continue
+			}
+		}
+		out.WriteByte(path[i])
+	}
+	return out.String()
+}
@@ -0,0 +1,21 @@
+package filesystem
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+)
+
+// RenderTemplate runs a file marked "template": true through text/template, variables are
+// used as {{.name}} and one that is not set is an error instead of an empty string
+func RenderTemplate(name string, data []byte, variables map[string]string) ([]byte, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("parsing template %s: %w", name, err)
+	}
+	var rendered bytes.Buffer
+	if err := tmpl.Execute(&rendered, variables); err != nil {
+		return nil, fmt.Errorf("rendering template %s: %w", name, err)
+	}
+	return rendered.Bytes(), nil
+}
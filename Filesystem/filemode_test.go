@@ -0,0 +1,45 @@
+package filesystem
+
+import (
+	"io/fs"
+	"testing"
+)
+
+func TestFileModeAndOwnership(t *testing.T) {
+	modes := []struct {
+		meta     FileMetadata
+		expected fs.FileMode
+	}{
+		{FileMetadata{InstallPath: "config/hooks/normal/0001-fix.hook.chroot"}, 0755},
+		{FileMetadata{InstallPath: "./config/hooks/live/0999-pip.chroot"}, 0755},
+		{FileMetadata{InstallPath: "config/includes.chroot/etc/systemd/logind.conf"}, 0644},
+		{FileMetadata{InstallPath: "config/hooks/normal/0002-data.hook.chroot", Mode: "0600"}, 0600},
+		{FileMetadata{InstallPath: "config/includes.chroot/usr/bin/tool", Mode: "4755"}, 0755 | fs.ModeSetuid},
+	}
+	for _, test := range modes {
+		mode, err := test.meta.FileMode()
+		if err != nil || mode != test.expected {
+			t.Errorf("%s: expected %v, got %v %v", test.meta.InstallPath, test.expected, mode, err)
+		}
+	}
+	if _, err := (FileMetadata{Mode: "rwxr-xr-x"}).FileMode(); err == nil {
+		t.Error("expected a symbolic mode to be refused")
+	}
+
+	meta := FileMetadata{InstallPath: "config/includes.chroot/home/user/.bashrc", Owner: "1000", Group: "users"}
+	if ownership, err := meta.Ownership(); err != nil || ownership != "1000:users" {
+		t.Errorf("expected 1000:users, got %q %v", ownership, err)
+	}
+	if chrootPath, _ := meta.ChrootPath(); chrootPath != "/home/user/.bashrc" {
+		t.Errorf("unexpected chroot path %s", chrootPath)
+	}
+	refused := []FileMetadata{
+		{InstallPath: "config/hooks/normal/0001-fix.hook.chroot", Owner: "root"},
+		{InstallPath: "config/includes.chroot/etc/motd", Owner: "root; reboot"},
+	}
+	for _, meta := range refused {
+		if _, err := meta.Ownership(); err == nil {
+			t.Errorf("expected %+v to be refused", meta)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package filesystem
+
+import (
+	lbconfig "LiveBuilder/LBConfig"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMigrateLBConfigTemplates(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		//unchanged shipped template next to the shipped config it became
+		"original.template": "lb config --distribution trixie --cache true",
+		"original.json":     `{"distribution": "trixie", "cache": true}`,
+		//edited template next to the shipped config
+		"custom.template":           "lb config --distribution sid",
+		"custom.json":               `{"distribution": "trixie"}`,
+		"custom.template.meta.json": `{"description": "my config"}`,
+		"broken.template":           "lb config --distribution trixie && reboot",
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := migrateLBConfigTemplates(dir); err == nil {
+		t.Error("expected the broken template to be reported")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "original-imported.json")); !os.IsNotExist(err) {
+		t.Error("expected an unchanged template not to be imported a second time")
+	}
+	imported, err := lbconfig.Load(filepath.Join(dir, "custom-imported.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported.Distribution != "sid" {
+		t.Errorf("expected the edited template to be imported, got %+v", imported)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "custom-imported.json.meta.json")); err != nil {
+		t.Errorf("expected the metadata to follow the import: %v", err)
+	}
+	for _, name := range []string{"original.template", "custom.template", "custom.template.meta.json"} {
+		if _, err := os.Stat(filepath.Join(dir, IMPORTED_TEMPLATES_DIR, name)); err != nil {
+			t.Errorf("expected %s to be moved aside: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "broken.template")); err != nil {
+		t.Errorf("expected the broken template to stay in place: %v", err)
+	}
+
+	//the edited template has to resolve to its own import, not the shipped config of its name
+	expected := map[string]string{"original.template": "original.json", "custom.template": "custom-imported.json"}
+	for templateName, configName := range expected {
+		if candidates := MigratedConfigCandidates(dir, templateName); candidates[0] != configName {
+			t.Errorf("%s: expected %s first, got %v", templateName, configName, candidates)
+		}
+	}
+
+	entries, err := ScanDirectory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if entry.Name() == IMPORTED_TEMPLATES_DIR {
+			t.Error("expected the imported templates directory to be hidden")
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package filesystem
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadMountInfo(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mountinfo")
+	content := "22 1 254:0 / / rw,relatime shared:1 - ext4 /dev/dm-0 rw\n" +
+		"40 22 8:17 / /media/usb\\040stick rw - vfat /dev/sdb1 rw\n" +
+		"broken line\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mounts, err := ReadMountInfo(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mounts) != 2 {
+		t.Fatalf("expected 2 mounts, got %+v", mounts)
+	}
+	expected := MountInfo{DevNumber: "8:17", MountPoint: "/media/usb stick", Source: "/dev/sdb1"}
+	if mounts[1] != expected {
+		t.Errorf("expected %+v, got %+v", expected, mounts[1])
+	}
+}
@@ -0,0 +1,240 @@
+package lbconfig
+
+/*
+Typed model of the lb config options we use. A Config serializes to the argv of
+`lb config` so nothing but lb is ever run and no value goes through a shell, it is stored
+as json or yaml next to the older .template files it replaces
+*/
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type Config struct {
+	Distribution       string   `json:"distribution,omitempty" yaml:"distribution,omitempty"`
+	Architectures      []string `json:"architectures,omitempty" yaml:"architectures,omitempty"`
+	ArchiveAreas       []string `json:"archive_areas,omitempty" yaml:"archive_areas,omitempty"`
+	MirrorBootstrap    string   `json:"mirror_bootstrap,omitempty" yaml:"mirror_bootstrap,omitempty"`
+	MirrorBinary       string   `json:"mirror_binary,omitempty" yaml:"mirror_binary,omitempty"`
+	Security           *bool    `json:"security,omitempty" yaml:"security,omitempty"`
+	Updates            *bool    `json:"updates,omitempty" yaml:"updates,omitempty"`
+	Backports          *bool    `json:"backports,omitempty" yaml:"backports,omitempty"`
+	Apt                string   `json:"apt,omitempty" yaml:"apt,omitempty"`
+	AptOptions         string   `json:"apt_options,omitempty" yaml:"apt_options,omitempty"`
+	AptRecommends      *bool    `json:"apt_recommends,omitempty" yaml:"apt_recommends,omitempty"`
+	DebootstrapOptions string   `json:"debootstrap_options,omitempty" yaml:"debootstrap_options,omitempty"`
+	FirmwareChroot     *bool    `json:"firmware_chroot,omitempty" yaml:"firmware_chroot,omitempty"`
+	FirmwareBinary     *bool    `json:"firmware_binary,omitempty" yaml:"firmware_binary,omitempty"`
+	LinuxFlavours      []string `json:"linux_flavours,omitempty" yaml:"linux_flavours,omitempty"`
+	BinaryImages       string   `json:"binary_images,omitempty" yaml:"binary_images,omitempty"`
+	Bootloaders        []string `json:"bootloaders,omitempty" yaml:"bootloaders,omitempty"`
+	BootappendLive     string   `json:"bootappend_live,omitempty" yaml:"bootappend_live,omitempty"`
+	DebianInstaller    string   `json:"debian_installer,omitempty" yaml:"debian_installer,omitempty"`
+	Memtest            string   `json:"memtest,omitempty" yaml:"memtest,omitempty"`
+	UEFISecureBoot     string   `json:"uefi_secure_boot,omitempty" yaml:"uefi_secure_boot,omitempty"`
+	Cache              *bool    `json:"cache,omitempty" yaml:"cache,omitempty"`
+	CacheIndices       *bool    `json:"cache_indices,omitempty" yaml:"cache_indices,omitempty"`
+	CachePackages      *bool    `json:"cache_packages,omitempty" yaml:"cache_packages,omitempty"`
+	CacheStages        []string `json:"cache_stages,omitempty" yaml:"cache_stages,omitempty"`
+	ISOVolume          string   `json:"iso_volume,omitempty" yaml:"iso_volume,omitempty"` // empty takes the builds iso fields
+	ISOPublisher       string   `json:"iso_publisher,omitempty" yaml:"iso_publisher,omitempty"`
+	ISOApplication     string   `json:"iso_application,omitempty" yaml:"iso_application,omitempty"`
+	ImageName          string   `json:"image_name,omitempty" yaml:"image_name,omitempty"`
+	ExtraArgs          []string `json:"extra_args,omitempty" yaml:"extra_args,omitempty"` // passed to lb config as is, for options the model does not cover
+}
+
+// Option ties an lb config flag to its field. Value returns a *string, **bool or *[]string,
+// Label, Group and Suggestions are what the config editor shows
+type Option struct {
+	Flag        string
+	Label       string
+	Group       string
+	Separator   string   // lists only, how the items are joined into one argument
+	Choices     []string // allowed values or list items, empty for free text
+	Suggestions []string // offered values that do not restrict the text
+	MaxItems    int      // lists only, 0 for no limit
+	Value       func(*Config) any
+}
+
+const (
+	GROUP_DISTRIBUTION = "Distribution"
+	GROUP_PACKAGES     = "Packages"
+	GROUP_BOOT         = "Boot"
+	GROUP_CACHE        = "Cache"
+	GROUP_ISO          = "ISO"
+)
+
+// GROUPS is the order the editor shows the option groups in
+var GROUPS = []string{GROUP_DISTRIBUTION, GROUP_PACKAGES, GROUP_BOOT, GROUP_CACHE, GROUP_ISO}
+
+var DISTRIBUTIONS = []string{"bullseye", "bookworm", "trixie", "forky", "sid"}
+
+// OPTIONS are written to the argv in this order
+var OPTIONS = []Option{
+	{Flag: "--distribution", Label: "Distribution", Group: GROUP_DISTRIBUTION, Suggestions: DISTRIBUTIONS, Value: func(c *Config) any { return &c.Distribution }},
+	{Flag: "--architectures", Label: "Architecture", Group: GROUP_DISTRIBUTION, Separator: " ", MaxItems: 1, Choices: []string{"amd64", "i386", "arm64", "armhf", "armel", "ppc64el", "riscv64", "s390x"}, Value: func(c *Config) any { return &c.Architectures }},
+	{Flag: "--archive-areas", Label: "Archive areas", Group: GROUP_DISTRIBUTION, Separator: " ", Choices: []string{"main", "contrib", "non-free", "non-free-firmware", "restricted", "universe", "multiverse"}, Value: func(c *Config) any { return &c.ArchiveAreas }},
+	{Flag: "--mirror-bootstrap", Label: "Bootstrap mirror", Group: GROUP_DISTRIBUTION, Suggestions: []string{"http://deb.debian.org/debian/"}, Value: func(c *Config) any { return &c.MirrorBootstrap }},
+	{Flag: "--mirror-binary", Label: "Binary mirror", Group: GROUP_DISTRIBUTION, Suggestions: []string{"http://deb.debian.org/debian/"}, Value: func(c *Config) any { return &c.MirrorBinary }},
+	{Flag: "--security", Label: "Security updates", Group: GROUP_DISTRIBUTION, Value: func(c *Config) any { return &c.Security }},
+	{Flag: "--updates", Label: "Updates", Group: GROUP_DISTRIBUTION, Value: func(c *Config) any { return &c.Updates }},
+	{Flag: "--backports", Label: "Backports", Group: GROUP_DISTRIBUTION, Value: func(c *Config) any { return &c.Backports }},
+	{Flag: "--apt", Label: "Package manager", Group: GROUP_PACKAGES, Choices: []string{"apt", "aptitude"}, Value: func(c *Config) any { return &c.Apt }},
+	{Flag: "--apt-options", Label: "apt options", Group: GROUP_PACKAGES, Value: func(c *Config) any { return &c.AptOptions }},
+	{Flag: "--apt-recommends", Label: "Install recommends", Group: GROUP_PACKAGES, Value: func(c *Config) any { return &c.AptRecommends }},
+	{Flag: "--debootstrap-options", Label: "debootstrap options", Group: GROUP_PACKAGES, Suggestions: []string{"--variant=minbase"}, Value: func(c *Config) any { return &c.DebootstrapOptions }},
+	{Flag: "--firmware-chroot", Label: "Firmware in the live system", Group: GROUP_PACKAGES, Value: func(c *Config) any { return &c.FirmwareChroot }},
+	{Flag: "--firmware-binary", Label: "Firmware on the image", Group: GROUP_PACKAGES, Value: func(c *Config) any { return &c.FirmwareBinary }},
+	{Flag: "--linux-flavours", Label: "Kernel flavours", Group: GROUP_PACKAGES, Separator: " ", Value: func(c *Config) any { return &c.LinuxFlavours }},
+	{Flag: "--binary-images", Label: "Image type", Group: GROUP_BOOT, Choices: []string{"iso", "iso-hybrid", "netboot", "tar", "hdd"}, Value: func(c *Config) any { return &c.BinaryImages }},
+	{Flag: "--bootloaders", Label: "Bootloaders", Group: GROUP_BOOT, Separator: ",", Choices: []string{"grub-efi", "grub-pc", "grub-legacy", "syslinux"}, Value: func(c *Config) any { return &c.Bootloaders }},
+	{Flag: "--bootappend-live", Label: "Kernel parameters", Group: GROUP_BOOT, Value: func(c *Config) any { return &c.BootappendLive }},
+	{Flag: "--debian-installer", Label: "Debian installer", Group: GROUP_BOOT, Choices: []string{"none", "live", "netinst", "cdrom", "businesscard", "true", "false"}, Value: func(c *Config) any { return &c.DebianInstaller }},
+	{Flag: "--memtest", Label: "Memtest", Group: GROUP_BOOT, Choices: []string{"none", "memtest86+", "memtest86"}, Value: func(c *Config) any { return &c.Memtest }},
+	{Flag: "--uefi-secure-boot", Label: "UEFI secure boot", Group: GROUP_BOOT, Choices: []string{"auto", "enable", "disable"}, Value: func(c *Config) any { return &c.UEFISecureBoot }},
+	{Flag: "--cache", Label: "Cache", Group: GROUP_CACHE, Value: func(c *Config) any { return &c.Cache }},
+	{Flag: "--cache-indices", Label: "Cache indices", Group: GROUP_CACHE, Value: func(c *Config) any { return &c.CacheIndices }},
+	{Flag: "--cache-packages", Label: "Cache packages", Group: GROUP_CACHE, Value: func(c *Config) any { return &c.CachePackages }},
+	{Flag: "--cache-stages", Label: "Cached stages", Group: GROUP_CACHE, Separator: ",", Choices: []string{"bootstrap", "chroot", "installer", "binary", "source"}, Value: func(c *Config) any { return &c.CacheStages }},
+	{Flag: "--iso-volume", Label: "Volume", Group: GROUP_ISO, Value: func(c *Config) any { return &c.ISOVolume }},
+	{Flag: "--iso-publisher", Label: "Publisher", Group: GROUP_ISO, Value: func(c *Config) any { return &c.ISOPublisher }},
+	{Flag: "--iso-application", Label: "Application", Group: GROUP_ISO, Value: func(c *Config) any { return &c.ISOApplication }},
+	{Flag: "--image-name", Label: "Image name", Group: GROUP_ISO, Value: func(c *Config) any { return &c.ImageName }},
+}
+
+// OPTION_ALIASES are spellings lb config also accepts, used when importing templates
+var OPTION_ALIASES = map[string]string{
+	"--architecture":  "--architectures",
+	"--linux-flavour": "--linux-flavours",
+	"--bootloader":    "--bootloaders",
+}
+
+func GetOption(flag string) (Option, bool) {
+	if alias, exists := OPTION_ALIASES[flag]; exists {
+		flag = alias
+	}
+	for _, option := range OPTIONS {
+		if option.Flag == flag {
+			return option, true
+		}
+	}
+	return Option{}, false
+}
+
+// Args is the argv for lb, starting with the config subcommand. Unset options are left out
+// so lb falls back to its own defaults
+func (self *Config) Args() ([]string, error) {
+	if err := self.Validate(); err != nil {
+		return nil, err
+	}
+	args := []string{"config"}
+	for _, option := range OPTIONS {
+		switch value := option.Value(self).(type) {
+		case *string:
+			if *value != "" {
+				args = append(args, option.Flag, *value)
+			}
+		case **bool:
+			if *value != nil {
+				args = append(args, option.Flag, strconv.FormatBool(**value))
+			}
+		case *[]string:
+			if len(*value) > 0 {
+				args = append(args, option.Flag, strings.Join(*value, option.Separator))
+			}
+		}
+	}
+	return append(args, self.ExtraArgs...), nil
+}
+
+// Validate checks values against the options choices and keeps extra args from repeating
+// or smuggling in a modelled option
+func (self *Config) Validate() error {
+	for _, option := range OPTIONS {
+		var values []string
+		switch value := option.Value(self).(type) {
+		case *string:
+			if *value != "" {
+				values = []string{*value}
+			}
+		case *[]string:
+			values = *value
+			if option.MaxItems > 0 && len(values) > option.MaxItems {
+				return fmt.Errorf("%s takes at most %d value(s), got %d", option.Flag, option.MaxItems, len(values))
+			}
+			for _, item := range values {
+				if item == "" || strings.ContainsAny(item, " ,") {
+					return fmt.Errorf("%s: invalid item %q", option.Flag, item)
+				}
+			}
+		}
+		for _, value := range values {
+			if strings.ContainsAny(value, "\n\r\x00") {
+				return fmt.Errorf("%s: value contains a line break", option.Flag)
+			}
+			//a template value is checked against the choices once Render has filled it in
+			if len(option.Choices) > 0 && !contains(option.Choices, value) && !isTemplate(value) {
+				return fmt.Errorf("%s: %q is not one of %s", option.Flag, value, strings.Join(option.Choices, ", "))
+			}
+		}
+	}
+	if len(self.ExtraArgs) > 0 && !strings.HasPrefix(self.ExtraArgs[0], "--") {
+		return fmt.Errorf("extra args have to start with an option, got %q", self.ExtraArgs[0])
+	}
+	for _, arg := range self.ExtraArgs {
+		if strings.ContainsAny(arg, "\n\r\x00") {
+			return fmt.Errorf("extra arg %q contains a line break", arg)
+		}
+		flag, _, _ := strings.Cut(arg, "=")
+		if _, modelled := GetOption(flag); modelled {
+			return fmt.Errorf("%s has its own field, set it there instead of in the extra args", flag)
+		}
+	}
+	return nil
+}
+
+// FillISOFields sets the iso fields the config leaves empty, builds pass their iso settings
+func (self *Config) FillISOFields(volume, publisher, application, imageName string) {
+	fields := []struct {
+		field *string
+		value string
+	}{
+		{&self.ISOVolume, volume},
+		{&self.ISOPublisher, publisher},
+		{&self.ISOApplication, application},
+		{&self.ImageName, imageName},
+	}
+	for _, field := range fields {
+		if *field.field == "" {
+			*field.field = field.value
+		}
+	}
+}
+
+// CommandLine renders args as an lb command that could be pasted into a shell, for previews and logs
+func CommandLine(args []string) string {
+	words := []string{"lb"}
+	for _, arg := range args {
+		words = append(words, ShellQuote(arg))
+	}
+	return strings.Join(words, " ")
+}
+
+// ShellQuote quotes word for sh, words made only of safe characters are left as they are
+func ShellQuote(word string) string {
+	if word != "" && strings.Trim(word, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_=+,./:@%") == "" {
+		return word
+	}
+	return "'" + strings.ReplaceAll(word, "'", `'\''`) + "'"
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
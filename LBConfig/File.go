@@ -0,0 +1,219 @@
+package lbconfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const TEMPLATE_EXT = ".template"
+
+// CONFIG_EXTENSIONS are the file types Load and Save understand, yaml is picked by extension
+var CONFIG_EXTENSIONS = []string{".json", ".yaml", ".yml"}
+
+// IsConfigFile reports whether name is an lb config file rather than a template or other file
+func IsConfigFile(name string) bool {
+	return contains(CONFIG_EXTENSIONS, filepath.Ext(name)) && !strings.HasSuffix(name, ".meta.json")
+}
+
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading lb config: %w", err)
+	}
+	config, err := Parse(filepath.Base(path), data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return config, nil
+}
+
+// Parse decodes and validates a config, unknown fields are errors so typos do not go unnoticed
+func Parse(fileName string, data []byte) (*Config, error) {
+	if !IsConfigFile(fileName) {
+		return nil, fmt.Errorf("%s is not an lb config file, expected one of %v", fileName, CONFIG_EXTENSIONS)
+	}
+	config := &Config{}
+	if filepath.Ext(fileName) == ".json" {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(config); err != nil {
+			return nil, err
+		}
+	} else {
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(config); err != nil {
+			return nil, err
+		}
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func (self *Config) Save(path string) error {
+	if !IsConfigFile(filepath.Base(path)) {
+		return fmt.Errorf("%s is not an lb config file, expected one of %v", path, CONFIG_EXTENSIONS)
+	}
+	if err := self.Validate(); err != nil {
+		return err
+	}
+	var data []byte
+	var err error
+	if filepath.Ext(path) == ".json" {
+		data, err = json.MarshalIndent(self, "", "  ")
+		data = append(data, '\n')
+	} else {
+		data, err = yaml.Marshal(self)
+	}
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// ImportTemplate converts the shell text of an older .template file. Values that were only a
+// template action like {{.ISOVolume}} are left empty so the builds iso fields fill them in,
+// options the model does not know are kept in ExtraArgs
+func ImportTemplate(text string) (*Config, error) {
+	words, err := splitShellWords(text)
+	if err != nil {
+		return nil, err
+	}
+	if len(words) < 2 || words[0] != "lb" || words[1] != "config" {
+		return nil, fmt.Errorf("template is not an lb config command")
+	}
+
+	config := &Config{}
+	words = words[2:]
+	for i := 0; i < len(words); i++ {
+		flag, value, inline := strings.Cut(words[i], "=")
+		if !strings.HasPrefix(flag, "--") {
+			return nil, fmt.Errorf("unexpected argument %q", words[i])
+		}
+		//every lb config option takes a value, which may itself start with -- like --apt-options
+		if !inline {
+			if i+1 >= len(words) {
+				return nil, fmt.Errorf("%s has no value", flag)
+			}
+			i++
+			value = words[i]
+		}
+		if strings.Contains(value, "{{") {
+			if !isTemplateAction(value) {
+				return nil, fmt.Errorf("%s: %q mixes text and template actions", flag, value)
+			}
+			continue
+		}
+
+		option, known := GetOption(flag)
+		if !known {
+			config.ExtraArgs = append(config.ExtraArgs, flag, value)
+			continue
+		}
+		switch field := option.Value(config).(type) {
+		case *string:
+			*field = value
+		case **bool:
+			enabled, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: expected true or false, got %q", flag, value)
+			}
+			*field = &enabled
+		case *[]string:
+			*field = strings.FieldsFunc(value, func(char rune) bool {
+				return char == ',' || char == ' '
+			})
+		}
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func isTemplateAction(value string) bool {
+	return strings.HasPrefix(value, "{{") && strings.HasSuffix(value, "}}") && strings.Count(value, "{{") == 1
+}
+
+// splitShellWords tokenizes a single simple command the way sh would, with quotes, backslash
+// escapes and line continuations. Anything that would make sh run more than that command is refused
+func splitShellWords(text string) ([]string, error) {
+	var words []string
+	var current strings.Builder
+	inWord := false
+	runes := []rune(text)
+	for i := 0; i < len(runes); i++ {
+		char := runes[i]
+		switch {
+		case char == '\\':
+			if i+1 >= len(runes) {
+				return nil, fmt.Errorf("trailing backslash")
+			}
+			i++
+			if runes[i] == '\n' {
+				continue
+			}
+			current.WriteRune(runes[i])
+			inWord = true
+		case char == '\'':
+			//everything up to the closing quote is literal
+			closed := false
+			for i++; i < len(runes); i++ {
+				if runes[i] == '\'' {
+					closed = true
+					break
+				}
+				current.WriteRune(runes[i])
+			}
+			if !closed {
+				return nil, fmt.Errorf("unterminated single quote")
+			}
+			inWord = true
+		case char == '"':
+			closed := false
+			for i++; i < len(runes); i++ {
+				if runes[i] == '"' {
+					closed = true
+					break
+				}
+				if runes[i] == '\\' && i+1 < len(runes) && strings.ContainsRune("\"\\$`\n", runes[i+1]) {
+					i++
+					if runes[i] == '\n' {
+						continue
+					}
+				} else if runes[i] == '$' || runes[i] == '`' {
+					return nil, fmt.Errorf("shell expansion %q is not supported", string(runes[i]))
+				}
+				current.WriteRune(runes[i])
+			}
+			if !closed {
+				return nil, fmt.Errorf("unterminated double quote")
+			}
+			inWord = true
+		case char == ' ' || char == '\t' || char == '\n' || char == '\r':
+			if inWord {
+				words = append(words, current.String())
+				current.Reset()
+				inWord = false
+			}
+		case strings.ContainsRune(";&|<>()$`", char) || (char == '#' && !inWord):
+			return nil, fmt.Errorf("shell syntax %q is not supported, templates may only hold one lb config command", string(char))
+		default:
+			current.WriteRune(char)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, current.String())
+	}
+	return words, nil
+}
@@ -0,0 +1,77 @@
+package lbconfig
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// Render returns a copy of the config with {{.name}} actions in its values filled in from
+// variables, a variable that is not set is an error rather than an empty value
+func (self *Config) Render(variables map[string]string) (*Config, error) {
+	rendered := *self
+	for _, option := range OPTIONS {
+		switch value := option.Value(&rendered).(type) {
+		case *string:
+			text, err := renderValue(option.Flag, *value, variables)
+			if err != nil {
+				return nil, err
+			}
+			*value = text
+		case *[]string:
+			items, err := renderList(option.Flag, *value, variables)
+			if err != nil {
+				return nil, err
+			}
+			*value = items
+		}
+	}
+	extraArgs, err := renderList("extra args", rendered.ExtraArgs, variables)
+	if err != nil {
+		return nil, err
+	}
+	rendered.ExtraArgs = extraArgs
+
+	if err := rendered.Validate(); err != nil {
+		return nil, err
+	}
+	return &rendered, nil
+}
+
+func renderList(flag string, items []string, variables map[string]string) ([]string, error) {
+	if items == nil {
+		return nil, nil
+	}
+	rendered := make([]string, len(items))
+	for i, item := range items {
+		text, err := renderValue(flag, item, variables)
+		if err != nil {
+			return nil, err
+		}
+		rendered[i] = text
+	}
+	return rendered, nil
+}
+
+func renderValue(flag, value string, variables map[string]string) (string, error) {
+	if !isTemplate(value) {
+		return value, nil
+	}
+	tmpl, err := template.New(flag).Option("missingkey=error").Parse(value)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", flag, err)
+	}
+	var text strings.Builder
+	if err := tmpl.Execute(&text, variables); err != nil {
+		return "", fmt.Errorf("%s: %w", flag, err)
+	}
+	//a value that still looks like a template would skip the choice checks in Validate
+	if isTemplate(text.String()) {
+		return "", fmt.Errorf("%s: variables can not contain template actions", flag)
+	}
+	return text.String(), nil
+}
+
+func isTemplate(value string) bool {
+	return strings.Contains(value, "{{")
+}
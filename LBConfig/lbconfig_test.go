@@ -0,0 +1,157 @@
+package lbconfig
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const legacyTemplate = `lb config --apt apt \
+    --distribution trixie \
+    --cache true \
+    --cache-stages "bootstrap,chroot" \
+    --archive-areas 'main contrib' \
+    --apt-options "--no-install-recommends --yes" \
+    --iso-volume "{{.ISOVolume}}" \
+    --bootappend-live "boot=live hostname=\"live host\"" \
+    --bootloaders syslinux,grub-efi \
+    --architecture amd64 \
+    --checksums sha256 \
+    --uefi-secure-boot=enable`
+
+func TestImportTemplateToArgs(t *testing.T) {
+	config, err := ImportTemplate(legacyTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.ISOVolume != "" {
+		t.Errorf("expected the template action to be dropped, got %q", config.ISOVolume)
+	}
+	args, err := config.Args()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"config",
+		"--distribution", "trixie",
+		"--architectures", "amd64",
+		"--archive-areas", "main contrib",
+		"--apt", "apt",
+		"--apt-options", "--no-install-recommends --yes",
+		"--bootloaders", "syslinux,grub-efi",
+		"--bootappend-live", `boot=live hostname="live host"`,
+		"--uefi-secure-boot", "enable",
+		"--cache", "true",
+		"--cache-stages", "bootstrap,chroot",
+		"--checksums", "sha256",
+	}
+	if !slices.Equal(args, expected) {
+		t.Fatalf("expected\n%q\ngot\n%q", expected, args)
+	}
+
+	refused := []string{
+		"lb config --distribution trixie; rm -rf ~",
+		"rm -rf / --no-preserve-root",
+		"lb config --image-name $(whoami)",
+		`lb config --image-name "$HOME"`,
+		"lb config --apt 'apt",
+		"lb config --apt yum",
+		`lb config --iso-volume "live-{{.ISOVolume}}"`,
+		"lb config --cache maybe",
+		"lb config trixie",
+	}
+	for _, text := range refused {
+		if _, err := ImportTemplate(text); err == nil {
+			t.Errorf("expected %q to be refused", text)
+		}
+	}
+}
+
+func TestConfigSaveAndLoad(t *testing.T) {
+	config, err := ImportTemplate(legacyTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.ImageName = "live-image"
+	expected, _ := config.Args()
+
+	dir := t.TempDir()
+	for _, name := range []string{"live.json", "live.yaml"} {
+		path := filepath.Join(dir, name)
+		if err := config.Save(path); err != nil {
+			t.Fatal(err)
+		}
+		loaded, err := Load(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if args, _ := loaded.Args(); !slices.Equal(args, expected) {
+			t.Errorf("%s did not round trip: %q", name, args)
+		}
+	}
+
+	if _, err := Parse("live.json", []byte(`{"distrbution": "trixie"}`)); err == nil || !strings.Contains(err.Error(), "distrbution") {
+		t.Errorf("expected a misspelt field to be reported, got %v", err)
+	}
+	if _, err := Parse("live.yaml", []byte("extra_args: [\"--distribution\", \"sid\"]\n")); err == nil {
+		t.Error("expected extra args repeating a modelled option to be refused")
+	}
+	if err := config.Save(filepath.Join(dir, "live.template")); err == nil {
+		t.Error("expected saving as a template to be refused")
+	}
+}
+
+func TestCommandLinePreview(t *testing.T) {
+	config := &Config{
+		Distribution:   "trixie",
+		BootappendLive: "boot=live quiet",
+		ISOPublisher:   "Me & Co",
+	}
+	config.FillISOFields("LIVE", "Default", "", "live-image")
+	args, err := config.Args()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `lb config --distribution trixie --bootappend-live 'boot=live quiet' --iso-volume LIVE --iso-publisher 'Me & Co' --image-name live-image`
+	if line := CommandLine(args); line != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, line)
+	}
+
+	config.Architectures = []string{"amd64", "arm64"}
+	if _, err := config.Args(); err == nil {
+		t.Error("expected a second architecture to be refused")
+	}
+}
+
+func TestRenderVariables(t *testing.T) {
+	config := &Config{
+		Distribution:   "{{.release}}",
+		Architectures:  []string{"{{.arch}}"},
+		BootappendLive: "boot=live hostname={{.hostname}}",
+		ExtraArgs:      []string{"--apt-http-proxy", "{{.proxy}}"},
+	}
+	variables := map[string]string{"release": "trixie", "arch": "amd64", "hostname": "kiosk", "proxy": "http://proxy:3128"}
+	rendered, err := config.Render(variables)
+	if err != nil {
+		t.Fatal(err)
+	}
+	args, _ := rendered.Args()
+	expected := []string{"config", "--distribution", "trixie", "--architectures", "amd64", "--bootappend-live", "boot=live hostname=kiosk", "--apt-http-proxy", "http://proxy:3128"}
+	if !slices.Equal(args, expected) {
+		t.Errorf("expected\n%q\ngot\n%q", expected, args)
+	}
+	if config.Distribution != "{{.release}}" {
+		t.Error("expected Render to leave the original config alone")
+	}
+
+	delete(variables, "hostname")
+	if _, err := config.Render(variables); err == nil || !strings.Contains(err.Error(), "hostname") {
+		t.Errorf("expected the missing variable to be reported, got %v", err)
+	}
+	variables["hostname"] = "kiosk"
+	variables["arch"] = "sparc"
+	if _, err := config.Render(variables); err == nil {
+		t.Error("expected a rendered value outside the choices to be refused")
+	}
+}
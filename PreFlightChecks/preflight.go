@@ -13,6 +13,7 @@ func CheckAll(exitOnError bool) {
 	funcs := []func() error{
 		CheckLBversion,
 		CheckCommands,
+		CheckElevation,
 	}
 
 	for _, fun := range funcs {
@@ -31,11 +32,15 @@ func CheckAll(exitOnError bool) {
 func CheckCommands() error {
 	commands := []string{
 		"lb",
-		"grub-installer",
+		"grub-install",
+		"losetup",
+		"mount",
+		"wipefs",
+		"blockdev",
+		"dd",
 		"mkfs.vfat",
 		"mkfs.ext4",
 		"parted",
-		"sfdisk",
 	}
 	var missingCommands []string
 	for _, command := range commands {
@@ -52,19 +57,40 @@ func CheckCommands() error {
 	return nil
 }
 
+// CheckElevation makes sure the privileged helper can be launched, root needs nothing and
+// everyone else needs pkexec (gui) or sudo (terminal)
+func CheckElevation() error {
+	if os.Geteuid() == 0 {
+		return nil
+	}
+	for _, command := range []string{"pkexec", "sudo"} {
+		if _, err := exec.LookPath(command); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("Neither pkexec nor sudo is installed, building and imaging need one of them to run commands as root\n")
+}
+
 const (
 	LB_VERSION = "20250505"
 )
 
-func CheckLBversion() error {
+// GetLBVersion returns the output of `lb --version`
+func GetLBVersion() (string, error) {
 	var outbuf bytes.Buffer
 	cmd := exec.Command("lb", "--version")
 	cmd.Stdout = &outbuf
 	if err := cmd.Run(); err != nil {
-		return err
+		return "", err
 	}
+	return strings.TrimSpace(outbuf.String()), nil
+}
 
-	vers := strings.TrimSpace(outbuf.String())
+func CheckLBversion() error {
+	vers, err := GetLBVersion()
+	if err != nil {
+		return err
+	}
 	if vers != LB_VERSION {
 		log.Printf("Untested lb version\ntested version: %s, installed version %s\n", LB_VERSION, vers)
 		return fmt.Errorf("Untested lb version\ntested version: %s, installed version %s\n", LB_VERSION, vers)
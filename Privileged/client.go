@@ -0,0 +1,459 @@
+package privileged
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+)
+
+// Helper is a connection to a running privileged helper, all commands of the process share one
+type Helper struct {
+	requests  io.WriteCloser
+	encoder   *json.Encoder
+	sendMutex sync.Mutex
+
+	commands map[uint64]*Cmd
+	nextID   uint64
+	mutex    sync.Mutex
+
+	process *exec.Cmd     // nil when serving in process because we are root already
+	done    chan struct{} // closed once the helper stopped answering
+}
+
+var shared struct {
+	helper *Helper
+	mutex  sync.Mutex
+}
+
+// Get returns the shared helper, launching it on first use. This is the one point the user
+// is asked for a password, a helper that died is relaunched on the next call
+func Get() (*Helper, error) {
+	shared.mutex.Lock()
+	defer shared.mutex.Unlock()
+	if shared.helper != nil && !shared.helper.stopped() {
+		return shared.helper, nil
+	}
+	helper, err := launch()
+	if err != nil {
+		return nil, err
+	}
+	shared.helper = helper
+	return helper, nil
+}
+
+// Shutdown stops the shared helper if one was launched, commands still running are terminated
+func Shutdown() {
+	shared.mutex.Lock()
+	defer shared.mutex.Unlock()
+	if shared.helper != nil {
+		shared.helper.Close()
+		shared.helper = nil
+	}
+}
+
+func launch() (*Helper, error) {
+	if os.Geteuid() == 0 {
+		return newInProcessHelper(), nil
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	elevation, err := elevationCommand()
+	if err != nil {
+		return nil, err
+	}
+	args := append(elevation[1:], executable, HELPER_COMMAND)
+	cmd := exec.Command(elevation[0], args...)
+	cmd.Stderr = log.Writer()
+	requests, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	responses, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Launching privileged helper with %s\n", elevation[0])
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("launching privileged helper: %w", err)
+	}
+	helper, err := newHelper(requests, responses)
+	if err != nil {
+		requests.Close()
+		cmd.Wait()
+		return nil, fmt.Errorf("privileged helper did not start, elevation refused? %w", err)
+	}
+	helper.process = cmd
+	return helper, nil
+}
+
+// elevationCommand picks sudo when there is a terminal to ask the password on and pkexec
+// (a polkit dialog) when running from the gui
+func elevationCommand() ([]string, error) {
+	candidates := [][]string{{"sudo"}, {"pkexec"}}
+	if !isTerminal(os.Stdin) {
+		candidates = [][]string{{"pkexec"}, {"sudo", "--non-interactive"}}
+	}
+	for _, candidate := range candidates {
+		if _, err := exec.LookPath(candidate[0]); err == nil {
+			return candidate, nil
+		}
+	}
+	return nil, fmt.Errorf("neither pkexec nor sudo is installed, cannot run privileged commands")
+}
+
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// newInProcessHelper serves requests from a goroutine, the same whitelist applies as for
+// an elevated helper
+func newInProcessHelper() *Helper {
+	requestReader, requestWriter := io.Pipe()
+	responseReader, responseWriter := io.Pipe()
+	go func() {
+		if err := Serve(requestReader, responseWriter); err != nil {
+			log.Printf("In process helper stopped: %v\n", err)
+		}
+		responseWriter.Close()
+	}()
+	helper, err := newHelper(requestWriter, responseReader)
+	if err != nil {
+		//only fails when the serving goroutine is gone, which cannot happen before it said ready
+		panic(err)
+	}
+	return helper
+}
+
+// newHelper waits for the ready message and starts dispatching responses
+func newHelper(requests io.WriteCloser, responses io.Reader) (*Helper, error) {
+	decoder := json.NewDecoder(responses)
+	var ready Response
+	if err := decoder.Decode(&ready); err != nil {
+		return nil, err
+	}
+	if ready.Type != RESPONSE_READY {
+		return nil, fmt.Errorf("expected %s from the helper, got %s", RESPONSE_READY, ready.Type)
+	}
+	helper := &Helper{
+		requests: requests,
+		encoder:  json.NewEncoder(requests),
+		commands: make(map[uint64]*Cmd),
+		done:     make(chan struct{}),
+	}
+	go helper.dispatch(decoder)
+	return helper, nil
+}
+
+func (self *Helper) dispatch(decoder *json.Decoder) {
+	for {
+		var response Response
+		if err := decoder.Decode(&response); err != nil {
+			if !errors.Is(err, io.EOF) {
+				log.Printf("Reading from privileged helper failed: %v\n", err)
+			}
+			break
+		}
+		self.mutex.Lock()
+		cmd := self.commands[response.ID]
+		if response.Type == RESPONSE_EXITED {
+			delete(self.commands, response.ID)
+		}
+		self.mutex.Unlock()
+		if cmd == nil {
+			log.Printf("Dropping %s response for unknown request %d\n", response.Type, response.ID)
+			continue
+		}
+		cmd.receive(response)
+	}
+
+	close(self.done)
+	self.mutex.Lock()
+	commands := self.commands
+	self.commands = make(map[uint64]*Cmd)
+	self.mutex.Unlock()
+	for _, cmd := range commands {
+		cmd.receive(Response{Type: RESPONSE_EXITED, ExitCode: -1, Error: "privileged helper exited"})
+	}
+}
+
+func (self *Helper) stopped() bool {
+	select {
+	case <-self.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (self *Helper) send(request Request) error {
+	self.sendMutex.Lock()
+	defer self.sendMutex.Unlock()
+	if self.stopped() {
+		return fmt.Errorf("privileged helper exited")
+	}
+	return self.encoder.Encode(request)
+}
+
+// Close ends the request stream, the helper terminates what is still running and exits
+func (self *Helper) Close() error {
+	self.requests.Close()
+	<-self.done
+	if self.process != nil {
+		return self.process.Wait()
+	}
+	return nil
+}
+
+// Command prepares a whitelisted command run by this helper
+func (self *Helper) Command(name string, args ...string) *Cmd {
+	cmd := Command(name, args...)
+	cmd.helper = self
+	return cmd
+}
+
+// Cmd is a command run as root by the helper, used like an exec.Cmd
+type Cmd struct {
+	Args   []string // command name first, like exec.Cmd
+	Dir    string
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	helper   *Helper
+	id       uint64
+	stdin    *stdinWriter
+	closers  []io.Closer //pipe ends closed once the command exited
+	started  chan error
+	written  chan error
+	exited   chan struct{}
+	exitCode int
+	err      error
+}
+
+// Command prepares name to run as root through the shared helper, which is launched by Start if needed
+func Command(name string, args ...string) *Cmd {
+	return &Cmd{
+		Args:     append([]string{name}, args...),
+		started:  make(chan error, 1),
+		written:  make(chan error, 1),
+		exited:   make(chan struct{}),
+		exitCode: -1,
+	}
+}
+
+// Run runs a whitelisted command as root and returns stdout and stderr, on failure too
+func Run(name string, args ...string) (string, string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := Command(name, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	return stdout.String(), stderr.String(), err
+}
+
+func (self *Cmd) StdoutPipe() (io.ReadCloser, error) {
+	if self.Stdout != nil {
+		return nil, fmt.Errorf("stdout already set")
+	}
+	reader, writer := io.Pipe()
+	self.Stdout = writer
+	self.closers = append(self.closers, writer)
+	return reader, nil
+}
+
+func (self *Cmd) StderrPipe() (io.ReadCloser, error) {
+	if self.Stderr != nil {
+		return nil, fmt.Errorf("stderr already set")
+	}
+	reader, writer := io.Pipe()
+	self.Stderr = writer
+	self.closers = append(self.closers, writer)
+	return reader, nil
+}
+
+// StdinPipe returns a writer whose Write returns once the command received the data
+func (self *Cmd) StdinPipe() (io.WriteCloser, error) {
+	if self.Stdin != nil || self.stdin != nil {
+		return nil, fmt.Errorf("stdin already set")
+	}
+	self.stdin = &stdinWriter{cmd: self}
+	return self.stdin, nil
+}
+
+func (self *Cmd) Start() error {
+	if self.helper == nil {
+		helper, err := Get()
+		if err != nil {
+			return err
+		}
+		self.helper = helper
+	}
+	if self.Stdin != nil && self.stdin == nil {
+		self.stdin = &stdinWriter{cmd: self}
+	}
+
+	self.helper.mutex.Lock()
+	self.helper.nextID++
+	self.id = self.helper.nextID
+	self.helper.commands[self.id] = self
+	self.helper.mutex.Unlock()
+
+	err := self.helper.send(Request{
+		ID:      self.id,
+		Type:    REQUEST_START,
+		Command: self.Args[0],
+		Args:    self.Args[1:],
+		Dir:     self.Dir,
+		Stdin:   self.stdin != nil,
+	})
+	if err == nil {
+		err = <-self.started
+	}
+	if err != nil {
+		self.helper.mutex.Lock()
+		delete(self.helper.commands, self.id)
+		self.helper.mutex.Unlock()
+		return fmt.Errorf("starting %s: %w", self.Args[0], err)
+	}
+
+	if self.Stdin != nil {
+		go func() {
+			if _, err := io.Copy(self.stdin, self.Stdin); err != nil {
+				log.Printf("Feeding stdin of %s failed: %v\n", self.Args[0], err)
+			}
+			self.stdin.Close()
+		}()
+	}
+	return nil
+}
+
+// Wait waits for the command to exit, a non zero exit status is returned as *ExitError
+func (self *Cmd) Wait() error {
+	<-self.exited
+	if self.err != nil {
+		return self.err
+	}
+	if self.exitCode != 0 {
+		return &ExitError{Code: self.exitCode}
+	}
+	return nil
+}
+
+func (self *Cmd) Run() error {
+	if err := self.Start(); err != nil {
+		return err
+	}
+	return self.Wait()
+}
+
+// ExitCode is the exit status once Wait returned, -1 before or when the command was killed
+func (self *Cmd) ExitCode() int {
+	return self.exitCode
+}
+
+// Signal signals the process group of the command
+func (self *Cmd) Signal(signal syscall.Signal) error {
+	return self.helper.send(Request{ID: self.id, Type: REQUEST_SIGNAL, Signal: int(signal)})
+}
+
+func (self *Cmd) receive(response Response) {
+	var err error
+	if response.Error != "" {
+		err = errors.New(response.Error)
+	}
+	switch response.Type {
+	case RESPONSE_STARTED:
+		self.started <- err
+	case RESPONSE_STDOUT, RESPONSE_STDERR:
+		out := self.Stdout
+		if response.Type == RESPONSE_STDERR {
+			out = self.Stderr
+		}
+		if out != nil {
+			//a reader that stopped reading only loses the rest of the output
+			out.Write(response.Data)
+		}
+	case RESPONSE_WRITTEN:
+		self.written <- err
+	case RESPONSE_EXITED:
+		select {
+		case <-self.exited:
+			return
+		default:
+		}
+		self.exitCode = response.ExitCode
+		self.err = err
+		for _, closer := range self.closers {
+			closer.Close()
+		}
+		//the command is gone, a failed start or a pending write must not wait forever
+		select {
+		case self.started <- err:
+		default:
+		}
+		select {
+		case self.written <- fmt.Errorf("%s exited", self.Args[0]):
+		default:
+		}
+		close(self.exited)
+	}
+}
+
+type ExitError struct {
+	Code int
+}
+
+func (self *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", self.Code)
+}
+
+type stdinWriter struct {
+	cmd    *Cmd
+	closed bool
+	mutex  sync.Mutex
+}
+
+func (self *stdinWriter) Write(data []byte) (int, error) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.closed {
+		return 0, io.ErrClosedPipe
+	}
+	select {
+	case <-self.cmd.exited:
+		return 0, fmt.Errorf("%s exited", self.cmd.Args[0])
+	default:
+	}
+	written := 0
+	for written < len(data) {
+		chunk := data[written:min(len(data), written+MAX_CHUNK)]
+		if err := self.cmd.helper.send(Request{ID: self.cmd.id, Type: REQUEST_STDIN, Data: chunk}); err != nil {
+			return written, err
+		}
+		if err := <-self.cmd.written; err != nil {
+			return written, err
+		}
+		written += len(chunk)
+	}
+	return written, nil
+}
+
+func (self *stdinWriter) Close() error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.closed {
+		return nil
+	}
+	self.closed = true
+	return self.cmd.helper.send(Request{ID: self.cmd.id, Type: REQUEST_CLOSE_STDIN})
+}
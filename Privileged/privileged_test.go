@@ -0,0 +1,200 @@
+package privileged
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// allowDevices stands in for the check USBImager installs, devices with one of prefixes pass
+func allowDevices(t *testing.T, prefixes ...string) {
+	TARGET_CHECK = func(device string, mounted func(string) bool) error {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(device, prefix) {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s is not a test device", device)
+	}
+	t.Cleanup(func() { TARGET_CHECK = nil })
+}
+
+func TestWhitelist(t *testing.T) {
+	allowDevices(t, "/dev/sdb")
+	mountDir, err := os.MkdirTemp("", MOUNT_DIR_PREFIX+"*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(mountDir)
+	freshDir, err := os.MkdirTemp("", MOUNT_DIR_PREFIX+"*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(freshDir)
+	buildDir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(buildDir, "config"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	state := &helperState{
+		uid:    1000,
+		gid:    1000,
+		loops:  map[string]bool{"/dev/loop7": true},
+		mounts: map[string]string{mountDir: "/dev/sdb1"},
+	}
+	allowed := []struct {
+		command string
+		args    []string
+		dir     string
+	}{
+		{"lb", []string{"build"}, buildDir},
+		{"lb", []string{"clean", "--purge"}, buildDir},
+		{"blockdev", []string{"--getsize64", "/dev/sdb"}, ""},
+		{"mkfs.vfat", []string{"-F", "32", "-n", "LIVE", "/dev/sdb1"}, ""},
+		{"mkfs.ext4", []string{"-L", "live", "/dev/loop7p2"}, ""},
+		{"mount", []string{"-t", "vfat", "-o", "uid=1000,gid=1000", "/dev/sdb1", freshDir}, ""},
+		{"mount", []string{"-o", "ro", "/dev/loop7", freshDir}, ""},
+		{"chown", []string{"1000:1000", mountDir}, ""},
+		{"umount", []string{"-l", filepath.Join(buildDir, "chroot", "proc")}, ""},
+		{"dd", []string{"of=/dev/sdb", "bs=4194304", "conv=fsync,notrunc", "status=none"}, ""},
+		{"dd", []string{"if=/dev/sdb", "bs=512", "skip=1", "count=33", "status=none"}, ""},
+		{"grub-install", []string{"--target=i386-pc", "--boot-directory=" + mountDir, "--recheck", "/dev/sdb"}, ""},
+	}
+	for _, request := range allowed {
+		if err := state.Validate(request.command, request.args, request.dir); err != nil {
+			t.Errorf("expected %s %v to be allowed: %v", request.command, request.args, err)
+		}
+	}
+
+	refused := []struct {
+		command string
+		args    []string
+		dir     string
+	}{
+		{"rm", []string{"-rf", "/"}, ""},
+		{"lb", []string{"config"}, buildDir},
+		{"lb", []string{"build"}, "/"},
+		{"lb", []string{"build", "--purge"}, buildDir},
+		{"dd", []string{"of=/etc/passwd"}, ""},
+		{"dd", []string{"if=/dev/sda", "of=/dev/sdb"}, ""},
+		{"dd", []string{"of=/dev/../etc/shadow"}, ""},
+		{"dd", []string{"of=/dev/nvme0n1"}, ""},
+		{"dd", []string{"if=/dev/sda", "count=1"}, ""},
+		{"sfdisk", []string{"--dump", "/dev/sdb"}, ""},
+		{"wipefs", []string{"-af", "/dev/sda"}, ""},
+		{"mkfs.ext4", []string{"/dev/loop8p1"}, ""},
+		{"grub-install", []string{"--target=i386-pc", "/dev/nvme0n1"}, ""},
+		{"grub-install", []string{"--target=i386-pc", "--boot-directory=" + freshDir, "/dev/sdb"}, ""},
+		{"mount", []string{"-o", "suid", "/dev/sdb1", freshDir}, ""},
+		{"mount", []string{"/dev/sdb1", "/etc"}, ""},
+		{"mount", []string{"/dev/sda2", freshDir}, ""},
+		{"mount", []string{"-o", "uid=0", "/dev/sdb1", freshDir}, ""},
+		{"mount", []string{"/dev/sdb1", mountDir}, ""},
+		{"chown", []string{"1000:1000", "/etc"}, ""},
+		{"chown", []string{"0:0", mountDir}, ""},
+		{"chown", []string{"1000:1000", freshDir}, ""},
+		{"chown", []string{"-R", "1000:1000", mountDir}, ""},
+		{"umount", []string{"/home"}, ""},
+		{"umount", []string{"/dev/sda2"}, ""},
+		{"wipefs", []string{"-af", "/home/user/file"}, ""},
+		{"grub-install", []string{"--target=arm64-efi", "/dev/sdb"}, ""},
+	}
+	for _, request := range refused {
+		if err := state.Validate(request.command, request.args, request.dir); err == nil {
+			t.Errorf("expected %s %v to be refused", request.command, request.args)
+		}
+	}
+}
+
+func TestHelperTracksLoopsAndMounts(t *testing.T) {
+	state := newHelperState()
+	state.record("losetup", []string{"--find", "--show", "--partscan", "/images/stick.img"}, "/dev/loop3\n")
+	if !state.isHelperLoop("/dev/loop3p1") || state.isHelperLoop("/dev/loop30") {
+		t.Fatalf("unexpected loops %v", state.loops)
+	}
+	state.record("mount", harden("mount", []string{"-t", "vfat", "/dev/loop3p1", "/tmp/" + MOUNT_DIR_PREFIX + "1"}), "")
+	if !state.isHelperMount("/tmp/" + MOUNT_DIR_PREFIX + "1") {
+		t.Fatalf("unexpected mounts %v", state.mounts)
+	}
+	state.record("umount", []string{"/tmp/" + MOUNT_DIR_PREFIX + "1"}, "")
+	state.record("losetup", []string{"--detach", "/dev/loop3"}, "")
+	if len(state.mounts) != 0 || len(state.loops) != 0 {
+		t.Fatalf("expected everything torn down, got %v %v", state.mounts, state.loops)
+	}
+
+	hardened := harden("mount", []string{"-o", "ro", "/dev/loop3", "/mnt"})
+	if strings.Join(hardened, " ") != "-o ro,"+FORCED_MOUNT_OPTIONS+" /dev/loop3 /mnt" {
+		t.Errorf("unexpected hardened mount %v", hardened)
+	}
+	if hardened = harden("mount", []string{"/dev/loop3", "/mnt"}); hardened[1] != FORCED_MOUNT_OPTIONS {
+		t.Errorf("unexpected hardened mount %v", hardened)
+	}
+}
+
+func TestHelperStreamsStdinAndStdout(t *testing.T) {
+	allowDevices(t, "/dev/null", "/dev/zero")
+	helper := newInProcessHelper()
+	defer helper.Close()
+
+	//larger than MAX_CHUNK so stdin goes over in several acknowledged requests
+	input := bytes.Repeat([]byte("live"), MAX_CHUNK)
+	var stderr bytes.Buffer
+	write := helper.Command("dd", "of=/dev/null", "bs=65536")
+	write.Stdin = bytes.NewReader(input)
+	write.Stderr = &stderr
+	if err := write.Run(); err != nil {
+		t.Fatalf("dd of=/dev/null: %v %s", err, stderr.String())
+	}
+	if !strings.Contains(stderr.String(), "4194304 bytes") {
+		t.Errorf("expected dd to report 4194304 bytes, got %q", stderr.String())
+	}
+
+	read := helper.Command("dd", "if=/dev/zero", "bs=1048576", "count=3", "status=none")
+	stdout, err := read.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := read.Start(); err != nil {
+		t.Fatal(err)
+	}
+	output, err := io.ReadAll(stdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := read.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if len(output) != 3*1048576 {
+		t.Errorf("expected 3MiB of output, got %d bytes", len(output))
+	}
+}
+
+func TestHelperRefusesAndSignals(t *testing.T) {
+	allowDevices(t, "/dev/zero")
+	helper := newInProcessHelper()
+	defer helper.Close()
+
+	err := helper.Command("rm", "-rf", t.TempDir()).Run()
+	if err == nil || !strings.Contains(err.Error(), "not a permitted command") {
+		t.Fatalf("expected rm to be refused, got %v", err)
+	}
+
+	endless := helper.Command("dd", "if=/dev/zero", "status=none")
+	endless.Stdout = io.Discard
+	if err := endless.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := endless.Signal(syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	var exitErr *ExitError
+	if err := endless.Wait(); !errors.As(err, &exitErr) {
+		t.Fatalf("expected a terminated dd to fail with an exit status, got %v", err)
+	}
+}
@@ -0,0 +1,50 @@
+package privileged
+
+/*
+Single elevation for everything that needs root. The app launches itself once through
+pkexec or sudo as a helper that reads json requests on stdin and answers on stdout, one
+message per line. The helper only runs the whitelisted commands in whitelist.go, streams
+their output back and forwards stdin one acknowledged chunk at a time so a slow device
+applies back pressure instead of piling data up in the helper
+*/
+
+// HELPER_COMMAND is the hidden cli subcommand the elevated helper is started with
+const HELPER_COMMAND = "privileged-helper"
+
+// largest stdin chunk sent in a single request
+const MAX_CHUNK = 1024 * 1024
+
+const (
+	REQUEST_START       = "start"
+	REQUEST_STDIN       = "stdin"
+	REQUEST_CLOSE_STDIN = "close_stdin"
+	REQUEST_SIGNAL      = "signal"
+)
+
+const (
+	RESPONSE_READY   = "ready"
+	RESPONSE_STARTED = "started"
+	RESPONSE_STDOUT  = "stdout"
+	RESPONSE_STDERR  = "stderr"
+	RESPONSE_WRITTEN = "written"
+	RESPONSE_EXITED  = "exited"
+)
+
+type Request struct {
+	ID      uint64   `json:"id"`
+	Type    string   `json:"type"`
+	Command string   `json:"command,omitempty"`
+	Args    []string `json:"args,omitempty"`
+	Dir     string   `json:"dir,omitempty"`
+	Stdin   bool     `json:"stdin,omitempty"` //start: stdin requests will follow
+	Data    []byte   `json:"data,omitempty"`
+	Signal  int      `json:"signal,omitempty"`
+}
+
+type Response struct {
+	ID       uint64 `json:"id"`
+	Type     string `json:"type"`
+	Data     []byte `json:"data,omitempty"`
+	ExitCode int    `json:"exit_code,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
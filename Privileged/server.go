@@ -0,0 +1,245 @@
+package privileged
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+)
+
+// signals a client may send to a running command
+var ALLOWED_SIGNALS = map[syscall.Signal]bool{
+	syscall.SIGINT:  true,
+	syscall.SIGTERM: true,
+	syscall.SIGKILL: true,
+}
+
+type server struct {
+	state     *helperState
+	encoder   *json.Encoder
+	sendMutex sync.Mutex
+	processes map[uint64]*process
+	mutex     sync.Mutex
+}
+
+// process is a running whitelisted command, input feeds its stdin one acknowledged chunk at a time
+type process struct {
+	cmd         *exec.Cmd
+	input       chan []byte
+	inputClosed bool
+	mutex       sync.Mutex
+}
+
+// Serve answers requests until the requests stream ends, which is how the helper notices the
+// app went away. Commands still running at that point are terminated
+func Serve(requests io.Reader, responses io.Writer) error {
+	server := &server{
+		state:     newHelperState(),
+		encoder:   json.NewEncoder(responses),
+		processes: make(map[uint64]*process),
+	}
+	server.send(Response{Type: RESPONSE_READY})
+
+	decoder := json.NewDecoder(requests)
+	for {
+		var request Request
+		if err := decoder.Decode(&request); err != nil {
+			server.terminateAll()
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("reading request: %w", err)
+		}
+		server.handle(request)
+	}
+}
+
+func (self *server) send(response Response) {
+	self.sendMutex.Lock()
+	defer self.sendMutex.Unlock()
+	if err := self.encoder.Encode(response); err != nil {
+		log.Printf("Sending %s response failed: %v\n", response.Type, err)
+	}
+}
+
+func (self *server) handle(request Request) {
+	switch request.Type {
+	case REQUEST_START:
+		if err := self.start(request); err != nil {
+			log.Printf("Refused %s %v: %v\n", request.Command, request.Args, err)
+			self.send(Response{ID: request.ID, Type: RESPONSE_STARTED, Error: err.Error()})
+		}
+	case REQUEST_STDIN, REQUEST_CLOSE_STDIN:
+		process := self.lookup(request.ID)
+		if process == nil || !process.feed(request) {
+			if request.Type == REQUEST_STDIN {
+				self.send(Response{ID: request.ID, Type: RESPONSE_WRITTEN, Error: "stdin of the command is closed"})
+			}
+		}
+	case REQUEST_SIGNAL:
+		signal := syscall.Signal(request.Signal)
+		process := self.lookup(request.ID)
+		if process == nil || !ALLOWED_SIGNALS[signal] {
+			log.Printf("Ignoring signal %d for request %d\n", request.Signal, request.ID)
+			return
+		}
+		//commands run in their own group so children (lb's chroot apt etc) get the signal too
+		if err := syscall.Kill(-process.cmd.Process.Pid, signal); err != nil {
+			log.Printf("Signalling request %d failed: %v\n", request.ID, err)
+		}
+	default:
+		log.Printf("Unknown request type %q\n", request.Type)
+	}
+}
+
+func (self *server) start(request Request) error {
+	if err := self.state.Validate(request.Command, request.Args, request.Dir); err != nil {
+		return err
+	}
+	args := harden(request.Command, request.Args)
+	self.mutex.Lock()
+	_, exists := self.processes[request.ID]
+	self.mutex.Unlock()
+	if exists {
+		return fmt.Errorf("request %d is already running", request.ID)
+	}
+
+	cmd := exec.Command(request.Command, args...)
+	cmd.Dir = request.Dir
+	cmd.Env = os.Environ()
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	var stdin io.WriteCloser
+	if request.Stdin {
+		if stdin, err = cmd.StdinPipe(); err != nil {
+			return err
+		}
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	log.Printf("Started %s %v as pid %d\n", request.Command, args, cmd.Process.Pid)
+
+	process := &process{cmd: cmd, input: make(chan []byte, 1)}
+	self.mutex.Lock()
+	self.processes[request.ID] = process
+	self.mutex.Unlock()
+	self.send(Response{ID: request.ID, Type: RESPONSE_STARTED})
+
+	if stdin != nil {
+		go self.writeInput(request.ID, process, stdin)
+	} else {
+		process.closeInput()
+	}
+	//losetup --show prints the loop it attached, the helper has to know it
+	var output bytes.Buffer
+	var capture io.Writer = io.Discard
+	if request.Command == "losetup" {
+		capture = &output
+	}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go self.streamOutput(&wg, request.ID, RESPONSE_STDOUT, stdout, capture)
+	go self.streamOutput(&wg, request.ID, RESPONSE_STDERR, stderr, io.Discard)
+	go func() {
+		//output has to be drained before Wait closes the pipes
+		wg.Wait()
+		err := cmd.Wait()
+		process.closeInput()
+		self.mutex.Lock()
+		delete(self.processes, request.ID)
+		self.mutex.Unlock()
+		//recorded before the client hears of the exit, its next request may rely on it
+		if err == nil {
+			self.state.record(request.Command, args, output.String())
+		}
+
+		response := Response{ID: request.ID, Type: RESPONSE_EXITED, ExitCode: cmd.ProcessState.ExitCode()}
+		var exitErr *exec.ExitError
+		if err != nil && !errors.As(err, &exitErr) {
+			response.Error = err.Error()
+		}
+		self.send(response)
+	}()
+	return nil
+}
+
+func (self *server) lookup(id uint64) *process {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	return self.processes[id]
+}
+
+func (self *server) writeInput(id uint64, process *process, stdin io.WriteCloser) {
+	for data := range process.input {
+		response := Response{ID: id, Type: RESPONSE_WRITTEN}
+		if _, err := stdin.Write(data); err != nil {
+			response.Error = err.Error()
+		}
+		self.send(response)
+	}
+	stdin.Close()
+}
+
+func (self *server) streamOutput(wg *sync.WaitGroup, id uint64, stream string, pipe io.Reader, capture io.Writer) {
+	defer wg.Done()
+	buffer := make([]byte, 64*1024)
+	for {
+		count, err := pipe.Read(buffer)
+		if count > 0 {
+			capture.Write(buffer[:count])
+			self.send(Response{ID: id, Type: stream, Data: append([]byte(nil), buffer[:count]...)})
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (self *server) terminateAll() {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	for id, process := range self.processes {
+		log.Printf("Client went away, terminating request %d\n", id)
+		syscall.Kill(-process.cmd.Process.Pid, syscall.SIGTERM)
+	}
+}
+
+// feed queues a stdin chunk or closes stdin, false once stdin is already closed. The client
+// waits for each chunk to be acknowledged so the channel never holds more than one
+func (self *process) feed(request Request) bool {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.inputClosed {
+		return false
+	}
+	if request.Type == REQUEST_CLOSE_STDIN {
+		self.inputClosed = true
+		close(self.input)
+		return true
+	}
+	self.input <- request.Data
+	return true
+}
+
+func (self *process) closeInput() {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if !self.inputClosed {
+		self.inputClosed = true
+		close(self.input)
+	}
+}
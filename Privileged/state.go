@@ -0,0 +1,161 @@
+package privileged
+
+/*
+What the helper set up itself. Loops it attached and mounts it made are remembered so later
+requests can be checked against them, a client can not name some other device or directory
+and have the helper treat it as part of an imaging run
+*/
+
+import (
+	filesystem "LiveBuilder/Filesystem"
+	"fmt"
+	"log"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// TARGET_CHECK refuses block devices the helper may not write to, USBImager installs the rules
+// of its CheckTarget here so the helper enforces them and not only the client. mounted decides
+// which mount points on the disk are acceptable. Without a check only helper loops pass
+var TARGET_CHECK func(device string, mounted func(mountPoint string) bool) error
+
+type helperState struct {
+	uid    int               // the user the helper was elevated for
+	gid    int               //
+	loops  map[string]bool   // loop devices attached by losetup --find --show
+	mounts map[string]string // mount directory -> source of mounts the helper made
+	mutex  sync.Mutex
+}
+
+func newHelperState() *helperState {
+	uid, gid := callerIDs()
+	return &helperState{
+		uid:    uid,
+		gid:    gid,
+		loops:  make(map[string]bool),
+		mounts: make(map[string]string),
+	}
+}
+
+// callerIDs is the uid and gid of the user that elevated, sudo and pkexec both tell
+func callerIDs() (int, int) {
+	if uid, err := strconv.Atoi(os.Getenv("SUDO_UID")); err == nil {
+		if gid, err := strconv.Atoi(os.Getenv("SUDO_GID")); err == nil {
+			return uid, gid
+		}
+	}
+	if uid, err := strconv.Atoi(os.Getenv("PKEXEC_UID")); err == nil {
+		if account, err := user.LookupId(strconv.Itoa(uid)); err == nil {
+			if gid, err := strconv.Atoi(account.Gid); err == nil {
+				return uid, gid
+			}
+		}
+	}
+	return os.Getuid(), os.Getgid()
+}
+
+// checkTarget accepts loops the helper attached and devices that pass TARGET_CHECK
+func (self *helperState) checkTarget(device string, mounted func(string) bool) error {
+	if err := checkDevice(device); err != nil {
+		return err
+	}
+	if self.isHelperLoop(device) {
+		return nil
+	}
+	if TARGET_CHECK == nil {
+		return fmt.Errorf("%s is not a loop device attached by the helper", device)
+	}
+	return TARGET_CHECK(device, mounted)
+}
+
+// isHelperLoop reports whether device is a loop the helper attached or one of its partitions
+func (self *helperState) isHelperLoop(device string) bool {
+	if resolved, err := filepath.EvalSymlinks(device); err == nil {
+		device = resolved
+	}
+	name := filepath.Base(device)
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	for loop := range self.loops {
+		if isPartitionName(name, filepath.Base(loop)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (self *helperState) isHelperMount(dir string) bool {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	_, mounted := self.mounts[filepath.Clean(dir)]
+	return mounted
+}
+
+func anyMount(string) bool { return true }
+
+func noMount(string) bool { return false }
+
+// isMounted reports whether device or one of its partitions is mounted anywhere
+func isMounted(device string) bool {
+	mounts, err := filesystem.ReadMountInfo(filesystem.MOUNTINFO)
+	if err != nil {
+		log.Printf("Reading mounts failed: %v\n", err)
+		return true
+	}
+	for _, mount := range mounts {
+		if strings.HasPrefix(mount.Source, "/dev/") && isPartitionName(filepath.Base(mount.Source), filepath.Base(device)) {
+			return true
+		}
+	}
+	return false
+}
+
+// isPartitionName reports whether name is disk itself or a pN partition of it (loop0, loop0p2)
+func isPartitionName(name, disk string) bool {
+	if name == disk {
+		return true
+	}
+	number, found := strings.CutPrefix(name, disk+"p")
+	return found && number != "" && strings.Trim(number, "0123456789") == ""
+}
+
+// record remembers what a command that succeeded set up or tore down
+func (self *helperState) record(command string, args []string, stdout string) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	switch command {
+	case "losetup":
+		options, positional, err := argSpec{flags: []string{"--find", "--show", "--partscan", "--detach"}}.parse(args)
+		if err != nil || len(positional) != 1 {
+			return
+		}
+		if _, detach := options["--detach"]; detach {
+			delete(self.loops, positional[0])
+			return
+		}
+		if loop := strings.TrimSpace(stdout); strings.HasPrefix(loop, "/dev/loop") {
+			self.loops[loop] = true
+		}
+	case "mount":
+		_, positional, err := argSpec{valued: []string{"-t", "-o"}}.parse(args)
+		if err == nil && len(positional) == 2 {
+			self.mounts[filepath.Clean(positional[1])] = positional[0]
+		}
+	case "umount":
+		_, positional, err := argSpec{flags: []string{"-l"}}.parse(args)
+		if err != nil || len(positional) != 1 {
+			return
+		}
+		//by device umount takes the most recent mount, forgetting any one of them is close enough
+		for dir, source := range self.mounts {
+			if dir == filepath.Clean(positional[0]) || source == positional[0] {
+				delete(self.mounts, dir)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,401 @@
+package privileged
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// MOUNT_DIR_PREFIX names the temp directories usbimager mounts on, the only directories the
+// helper mounts on, chowns or installs grub into
+const MOUNT_DIR_PREFIX = "LiveBuilder-mnt-"
+
+// FORCED_MOUNT_OPTIONS are added to every mount whatever the client asked for, nothing on a
+// stick or image the user brought may run as root or expose device nodes
+const FORCED_MOUNT_OPTIONS = "nosuid,nodev,noexec"
+
+type validator func(state *helperState, args []string, dir string) error
+
+// WHITELIST is every command the helper runs as root, each with the only argument shapes
+// BuildManager and USBImager use
+var WHITELIST = map[string]validator{
+	"lb":           validateLB,
+	"losetup":      validateLosetup,
+	"blockdev":     validateBlockdev,
+	"wipefs":       validateWipefs,
+	"mkfs.vfat":    validateMkfs(argSpec{valued: []string{"-F", "-n", "-i"}}),
+	"mkfs.ext4":    validateMkfs(argSpec{flags: []string{"-F"}, valued: []string{"-L", "-U", "-m"}}),
+	"mkfs.ntfs":    validateMkfs(argSpec{flags: []string{"-F", "-Q"}, valued: []string{"-L"}}),
+	"mkfs.exfat":   validateMkfs(argSpec{valued: []string{"-L"}}),
+	"mkfs.btrfs":   validateMkfs(argSpec{flags: []string{"-f"}, valued: []string{"-L", "-U"}}),
+	"mkswap":       validateMkfs(argSpec{valued: []string{"-L", "-U"}}),
+	"mount":        validateMount,
+	"umount":       validateUmount,
+	"chown":        validateChown,
+	"dd":           validateDD,
+	"grub-install": validateGrubInstall,
+}
+
+// Validate returns an error unless command with args and dir is on the whitelist, devices
+// are checked against what this helper attached and mounted itself
+func (self *helperState) Validate(command string, args []string, dir string) error {
+	check, exists := WHITELIST[command]
+	if !exists {
+		return fmt.Errorf("%s is not a permitted command", command)
+	}
+	if err := check(self, args, dir); err != nil {
+		return fmt.Errorf("%s %s is not permitted: %v", command, strings.Join(args, " "), err)
+	}
+	return nil
+}
+
+// harden returns the arguments the helper actually runs, mounts always get FORCED_MOUNT_OPTIONS
+func harden(command string, args []string) []string {
+	if command != "mount" {
+		return args
+	}
+	hardened := slices.Clone(args)
+	for i, arg := range hardened {
+		if arg == "-o" && i+1 < len(hardened) {
+			hardened[i+1] += "," + FORCED_MOUNT_OPTIONS
+			return hardened
+		}
+	}
+	return append([]string{"-o", FORCED_MOUNT_OPTIONS}, hardened...)
+}
+
+// argSpec lists the options a command may take, anything not starting with - is positional
+type argSpec struct {
+	flags  []string // options without a value, an entry ending in = takes an inline value
+	valued []string // options taking the following argument as value
+}
+
+func (self argSpec) parse(args []string) (map[string]string, []string, error) {
+	options := make(map[string]string)
+	var positional []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		name, value, inline := strings.Cut(arg, "=")
+		switch {
+		case slices.Contains(self.valued, arg):
+			if i+1 >= len(args) {
+				return nil, nil, fmt.Errorf("%s needs a value", arg)
+			}
+			options[arg] = args[i+1]
+			i++
+		case slices.Contains(self.flags, arg):
+			options[arg] = ""
+		case inline && slices.Contains(self.flags, name+"="):
+			options[name+"="] = value
+		case strings.HasPrefix(arg, "-"):
+			return nil, nil, fmt.Errorf("option %s is not allowed", arg)
+		default:
+			positional = append(positional, arg)
+		}
+	}
+	return options, positional, nil
+}
+
+func validateLB(state *helperState, args []string, dir string) error {
+	options, positional, err := argSpec{flags: []string{"--verbose", "--debug", "--purge"}}.parse(args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 || (positional[0] != "build" && positional[0] != "clean") {
+		return fmt.Errorf("only lb build and lb clean are allowed")
+	}
+	if _, purge := options["--purge"]; purge && positional[0] != "clean" {
+		return fmt.Errorf("--purge only goes with lb clean")
+	}
+	if err := checkAbsolute(dir); err != nil {
+		return err
+	}
+	if !isDir(filepath.Join(dir, "config")) {
+		return fmt.Errorf("%s is not a live-build tree", dir)
+	}
+	return nil
+}
+
+func validateLosetup(state *helperState, args []string, dir string) error {
+	options, positional, err := argSpec{flags: []string{"--find", "--show", "--partscan", "--detach"}}.parse(args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return fmt.Errorf("expected a single device or file")
+	}
+	if _, detach := options["--detach"]; detach {
+		if len(options) != 1 || !strings.HasPrefix(positional[0], "/dev/loop") {
+			return fmt.Errorf("--detach only takes a loop device")
+		}
+		if err := checkDevice(positional[0]); err != nil {
+			return err
+		}
+		//loops of a crashed run are swept by a later helper, those must not be in use
+		if !state.isHelperLoop(positional[0]) && isMounted(positional[0]) {
+			return fmt.Errorf("%s is mounted and was not attached by this helper", positional[0])
+		}
+		return nil
+	}
+	if _, find := options["--find"]; !find {
+		return fmt.Errorf("loop devices are only attached with --find")
+	}
+	if _, show := options["--show"]; !show {
+		return fmt.Errorf("loop devices are only attached with --show so the helper learns the device")
+	}
+	return checkRegularFile(positional[0])
+}
+
+func validateBlockdev(state *helperState, args []string, dir string) error {
+	options, positional, err := argSpec{flags: []string{"--rereadpt", "--flushbufs", "--getsize64"}}.parse(args)
+	if err != nil {
+		return err
+	}
+	if len(options) != 1 || len(positional) != 1 {
+		return fmt.Errorf("expected one operation on one device")
+	}
+	if _, query := options["--getsize64"]; query {
+		return state.checkTarget(positional[0], anyMount)
+	}
+	return state.checkTarget(positional[0], state.isHelperMount)
+}
+
+func validateWipefs(state *helperState, args []string, dir string) error {
+	_, positional, err := argSpec{flags: []string{"-af", "-b"}}.parse(args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return fmt.Errorf("expected a single device")
+	}
+	return state.checkTarget(positional[0], noMount)
+}
+
+func validateMkfs(spec argSpec) validator {
+	return func(state *helperState, args []string, dir string) error {
+		_, positional, err := spec.parse(args)
+		if err != nil {
+			return err
+		}
+		if len(positional) != 1 {
+			return fmt.Errorf("expected a single device")
+		}
+		return state.checkTarget(positional[0], noMount)
+	}
+}
+
+// nosuid, nodev and noexec are forced by harden anyway, a client spelling them out is fine
+var MOUNT_OPTION = regexp.MustCompile(`^(ro|nosuid|nodev|noexec|uid=\d+|gid=\d+)$`)
+var FS_TYPE = regexp.MustCompile(`^[a-z0-9]+$`)
+
+// validateMount only mounts loops this helper attached or devices that pass the target check,
+// and only onto fresh mount directories. Files on vfat can only be handed to the caller
+func validateMount(state *helperState, args []string, dir string) error {
+	options, positional, err := argSpec{valued: []string{"-t", "-o"}}.parse(args)
+	if err != nil {
+		return err
+	}
+	if fsType, set := options["-t"]; set && !FS_TYPE.MatchString(fsType) {
+		return fmt.Errorf("bad filesystem type %q", fsType)
+	}
+	if mountOptions, set := options["-o"]; set {
+		for _, option := range strings.Split(mountOptions, ",") {
+			if !MOUNT_OPTION.MatchString(option) {
+				return fmt.Errorf("mount option %q is not allowed", option)
+			}
+			if id, isUID := strings.CutPrefix(option, "uid="); isUID && id != strconv.Itoa(state.uid) {
+				return fmt.Errorf("files can only be owned by the calling user")
+			}
+			if id, isGID := strings.CutPrefix(option, "gid="); isGID && id != strconv.Itoa(state.gid) {
+				return fmt.Errorf("files can only be owned by the calling user")
+			}
+		}
+	}
+	if len(positional) != 2 {
+		return fmt.Errorf("expected a source and a mount point")
+	}
+	if err := state.checkTarget(positional[0], state.isHelperMount); err != nil {
+		return err
+	}
+	if state.isHelperMount(positional[1]) {
+		return fmt.Errorf("%s is already mounted", positional[1])
+	}
+	return checkMountDir(positional[1])
+}
+
+func validateUmount(state *helperState, args []string, dir string) error {
+	_, positional, err := argSpec{flags: []string{"-l"}}.parse(args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return fmt.Errorf("expected a single device or mount point")
+	}
+	target := positional[0]
+	//targets are unmounted by device wherever the desktop automounted them
+	if strings.HasPrefix(target, "/dev/") {
+		return state.checkTarget(target, anyMount)
+	}
+	//leftover chroot mounts of an interrupted build are unmounted by path
+	if checkMountDir(target) == nil || checkInBuildTree(target) == nil {
+		return nil
+	}
+	return fmt.Errorf("%s is not a device, a %s directory or inside a live-build tree", target, MOUNT_DIR_PREFIX)
+}
+
+var OWNER = regexp.MustCompile(`^\d+:\d+$`)
+
+// validateChown hands a mount the helper made to the calling user and nobody else
+func validateChown(state *helperState, args []string, dir string) error {
+	if len(args) != 2 || !OWNER.MatchString(args[0]) {
+		return fmt.Errorf("expected uid:gid and a mount point")
+	}
+	if args[0] != fmt.Sprintf("%d:%d", state.uid, state.gid) {
+		return fmt.Errorf("mounts can only be handed to the calling user")
+	}
+	if err := checkMountDir(args[1]); err != nil {
+		return err
+	}
+	if !state.isHelperMount(args[1]) {
+		return fmt.Errorf("%s is not a mount made by the helper", args[1])
+	}
+	return nil
+}
+
+func validateDD(state *helperState, args []string, dir string) error {
+	options, positional, err := argSpec{flags: []string{"if=", "of=", "bs=", "seek=", "skip=", "count=", "conv=", "iflag=", "oflag=", "status="}}.parse(args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 0 {
+		return fmt.Errorf("unexpected arguments %v", positional)
+	}
+	input, hasInput := options["if="]
+	output, hasOutput := options["of="]
+	if hasInput == hasOutput {
+		return fmt.Errorf("exactly one of if= and of= must be given, the other end is the pipe")
+	}
+	//reading the partition table back happens while the helper still has the partitions mounted
+	if hasInput {
+		return state.checkTarget(input, state.isHelperMount)
+	}
+	return state.checkTarget(output, noMount)
+}
+
+func validateGrubInstall(state *helperState, args []string, dir string) error {
+	options, positional, err := argSpec{flags: []string{"--target=", "--boot-directory=", "--efi-directory=", "--recheck", "--removable", "--no-nvram"}}.parse(args)
+	if err != nil {
+		return err
+	}
+	if target := options["--target="]; target != "i386-pc" && target != "x86_64-efi" {
+		return fmt.Errorf("grub target %q is not allowed", target)
+	}
+	for _, option := range []string{"--boot-directory=", "--efi-directory="} {
+		if path, set := options[option]; set {
+			if err := state.checkInHelperMount(path); err != nil {
+				return err
+			}
+		}
+	}
+	if len(positional) > 1 {
+		return fmt.Errorf("expected at most one disk")
+	}
+	for _, disk := range positional {
+		if err := state.checkTarget(disk, state.isHelperMount); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkAbsolute(path string) error {
+	if !filepath.IsAbs(path) || filepath.Clean(path) != path {
+		return fmt.Errorf("%q is not a clean absolute path", path)
+	}
+	return nil
+}
+
+// checkDevice accepts paths under /dev that do not lead out of it through a symlink
+func checkDevice(path string) error {
+	if err := checkAbsolute(path); err != nil {
+		return err
+	}
+	if !strings.HasPrefix(path, "/dev/") {
+		return fmt.Errorf("%s is not under /dev", path)
+	}
+	if resolved, err := filepath.EvalSymlinks(path); err == nil && !strings.HasPrefix(resolved, "/dev/") {
+		return fmt.Errorf("%s resolves to %s outside /dev", path, resolved)
+	}
+	return nil
+}
+
+func checkRegularFile(path string) error {
+	if err := checkAbsolute(path); err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", path)
+	}
+	return nil
+}
+
+// checkMountDir accepts real directories named like the temp dirs usbimager mounts on
+func checkMountDir(path string) error {
+	if err := checkAbsolute(path); err != nil {
+		return err
+	}
+	if !strings.HasPrefix(filepath.Base(path), MOUNT_DIR_PREFIX) {
+		return fmt.Errorf("%s is not a %s directory", path, MOUNT_DIR_PREFIX)
+	}
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", path)
+	}
+	return nil
+}
+
+// checkInHelperMount accepts paths inside a mount the helper made
+func (self *helperState) checkInHelperMount(path string) error {
+	if err := checkAbsolute(path); err != nil {
+		return err
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return err
+	}
+	for dir := resolved; dir != "/"; dir = filepath.Dir(dir) {
+		if checkMountDir(dir) == nil && self.isHelperMount(dir) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s is not inside a mount made by the helper", path)
+}
+
+// checkInBuildTree accepts paths below a directory holding a live-build config/
+func checkInBuildTree(path string) error {
+	if err := checkAbsolute(path); err != nil {
+		return err
+	}
+	for dir := filepath.Dir(path); dir != "/"; dir = filepath.Dir(dir) {
+		if isDir(filepath.Join(dir, "config")) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s is not inside a live-build tree", path)
+}
+
+func isDir(path string) bool {
+	info, err := os.Lstat(path)
+	return err == nil && info.IsDir()
+}
@@ -0,0 +1,89 @@
+package usbimager
+
+/*
+Batch duplication. One iso is imaged onto many targets at once with at most parallelism
+runs in flight, every run is an ordinary ImageUSB job so a failing stick only ends its own
+run. Events carry their Target so subscribers can keep a row per device
+*/
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// sticks imaged at the same time when the caller does not say, usb hubs rarely keep up with more
+const DEFAULT_PARALLELISM = 4
+
+type DeviceResult struct {
+	Target   string
+	Err      error
+	Duration time.Duration
+	Verified bool // the target was read back and matched the iso
+}
+
+// Status is a short summary for a results table
+func (self DeviceResult) Status() string {
+	var verifyErr *VerificationError
+	switch {
+	case errors.As(self.Err, &verifyErr):
+		return "verification failed"
+	case self.Err != nil:
+		return "failed"
+	case self.Verified:
+		return "verified"
+	}
+	return "written"
+}
+
+// ImageBatch images iso onto every target and returns one result per target in the same
+// order. Targets naming the same device twice are only imaged once, BATCH_FINISHED is the
+// last event sent before returning
+func (self *USBImager) ImageBatch(iso string, targets []string, parallelism int) []DeviceResult {
+	if parallelism <= 0 {
+		parallelism = DEFAULT_PARALLELISM
+	}
+	started := time.Now()
+	self.emit(ImageEvent{Type: BATCH_STARTED, Source: iso})
+	log.Printf("Duplicating %s onto %d targets, %d at a time\n", iso, len(targets), parallelism)
+
+	results := make([]DeviceResult, len(targets))
+	seen := make(map[string]string)
+	slots := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		results[i].Target = target
+		resolved, err := filepath.EvalSymlinks(target)
+		if err != nil {
+			resolved = target
+		}
+		if first, duplicate := seen[resolved]; duplicate {
+			results[i].Err = fmt.Errorf("%s is the same device as %s", target, first)
+			continue
+		}
+		seen[resolved] = target
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			runStarted := time.Now()
+			err := self.ImageUSB(iso, target)
+			if err != nil {
+				log.Printf("Duplicating onto %s failed: %v\n", target, err)
+			}
+			results[i].Err = err
+			results[i].Duration = time.Since(runStarted)
+			results[i].Verified = err == nil && self.Verify
+		}()
+	}
+	wg.Wait()
+
+	self.emit(ImageEvent{Type: BATCH_FINISHED, Duration: time.Since(started), Results: results})
+	return results
+}
@@ -0,0 +1,57 @@
+package usbimager
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestImageBatchContinuesPastFailures(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.img")
+	second := filepath.Join(dir, "second.img")
+	link := filepath.Join(dir, "link.img")
+	for _, path := range []string{first, second} {
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(first, link); err != nil {
+		t.Fatal(err)
+	}
+
+	imager := NewUSBImager()
+	subscriber := imager.GetSubscriber()
+	//the iso does not exist so every run fails early, the batch still has to visit each target
+	results := imager.ImageBatch(filepath.Join(dir, "missing.iso"), []string{first, second, link}, 2)
+
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(results))
+	}
+	for i, target := range []string{first, second, link} {
+		if results[i].Target != target || results[i].Err == nil || results[i].Status() != "failed" {
+			t.Errorf("unexpected result %d: %+v", i, results[i])
+		}
+	}
+
+	finished := make(map[string]bool)
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event := <-subscriber:
+			if event.Type == IMAGE_FINISHED {
+				finished[event.Target] = true
+			}
+			if event.Type != BATCH_FINISHED {
+				continue
+			}
+			if !finished[first] || !finished[second] || finished[link] {
+				t.Fatalf("expected runs for the two distinct targets only, got %v", finished)
+			}
+			return
+		case <-timeout:
+			t.Fatal("no BATCH_FINISHED event")
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package usbimager
+
+import (
+	privileged "LiveBuilder/Privileged"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+const (
+	SHIM_SIGNED = "/usr/lib/shim/shimx64.efi.signed"
+	GRUB_SIGNED = "/usr/lib/grub/x86_64-efi-signed/grubx64.efi.signed"
+
+	DEFAULT_KERNEL_PARAMS = "boot=live components quiet splash"
+)
+
+// stub config on the esp, hands over to the real config on the live partition
+const STUB_GRUB_CFG = `search --no-floppy --set=root --label "{{.Label}}"
+configfile /boot/grub/grub.cfg
+`
+
+const FULL_GRUB_CFG = `set default=0
+set timeout=10
+
+insmod search_fs_label
+search --no-floppy --set=root --label "{{.Label}}"
+
+menuentry "{{.Title}}" {
+    linux {{.Kernel}} {{.KernelParams}}
+    initrd {{.Initrd}}
+}
+`
+
+type GrubConfig struct {
+	Label        string // filesystem label of the live partition
+	Title        string
+	Kernel       string // path of the kernel relative to the live partition
+	Initrd       string
+	KernelParams string
+}
+
+func renderGrubConfig(text string, config GrubConfig) (string, error) {
+	tmpl, err := template.New("grub").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var out strings.Builder
+	if err := tmpl.Execute(&out, config); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// findBootFiles locates the kernel and initrd in the copied /live directory, live-build
+// names them with a version suffix (vmlinuz-6.1.0-18-amd64) unless only one flavour is built
+func findBootFiles(liveRoot string) (string, string, error) {
+	find := func(pattern string) (string, error) {
+		matches, err := filepath.Glob(filepath.Join(liveRoot, "live", pattern))
+		if err != nil {
+			return "", err
+		}
+		if len(matches) == 0 {
+			return "", fmt.Errorf("no %s found in /live", pattern)
+		}
+		sort.Strings(matches)
+		//prefer the unversioned name, otherwise the highest version
+		match := matches[len(matches)-1]
+		if matches[0] == filepath.Join(liveRoot, "live", strings.TrimSuffix(pattern, "*")) {
+			match = matches[0]
+		}
+		return "/" + filepath.ToSlash(strings.TrimPrefix(match, liveRoot+string(filepath.Separator))), nil
+	}
+	kernel, err := find("vmlinuz*")
+	if err != nil {
+		return "", "", err
+	}
+	initrd, err := find("initrd.img*")
+	if err != nil {
+		return "", "", err
+	}
+	return kernel, initrd, nil
+}
+
+// installBootloaders installs bios grub to the disk and efi grub to the esp, both reading
+// their modules from the live partitions /boot
+func installBootloaders(disk, espRoot, liveRoot string) error {
+	bootDir := "--boot-directory=" + filepath.Join(liveRoot, "boot")
+
+	log.Printf("Installing i386-pc grub to %s\n", disk)
+	if _, stderr, err := privileged.Run("grub-install", "--target=i386-pc", bootDir, "--recheck", disk); err != nil {
+		return fmt.Errorf("grub-install i386-pc: %v %s", err, stderr)
+	}
+
+	log.Printf("Installing x86_64-efi grub to %s\n", espRoot)
+	if _, stderr, err := privileged.Run("grub-install", "--target=x86_64-efi", "--efi-directory="+espRoot, bootDir, "--removable", "--recheck", "--no-nvram"); err != nil {
+		return fmt.Errorf("grub-install x86_64-efi: %v %s", err, stderr)
+	}
+	return installShim(espRoot)
+}
+
+// installShim replaces the removable efi loader with shim and signed grub when both are
+// installed on the host, so the stick boots with secure boot enabled
+func installShim(espRoot string) error {
+	for _, signed := range []string{SHIM_SIGNED, GRUB_SIGNED} {
+		if _, err := os.Stat(signed); err != nil {
+			log.Printf("%s not found, skipping secure boot support\n", signed)
+			return nil
+		}
+	}
+	efiBoot := filepath.Join(espRoot, "EFI", "BOOT")
+	if err := os.MkdirAll(efiBoot, 0755); err != nil {
+		return err
+	}
+	if err := copyFile(SHIM_SIGNED, filepath.Join(efiBoot, "BOOTX64.EFI"), 0644, nil); err != nil {
+		return err
+	}
+	return copyFile(GRUB_SIGNED, filepath.Join(efiBoot, "grubx64.efi"), 0644, nil)
+}
+
+// writeGrubConfigs writes the stub config everywhere an efi grub may look for it on the
+// esp (signed grub reads EFI/debian) and the real menu onto the live partition
+func writeGrubConfigs(espRoot, liveRoot string, config GrubConfig) error {
+	stub, err := renderGrubConfig(STUB_GRUB_CFG, config)
+	if err != nil {
+		return err
+	}
+	for _, dir := range []string{
+		filepath.Join(espRoot, "boot", "grub"),
+		filepath.Join(espRoot, "EFI", "BOOT"),
+		filepath.Join(espRoot, "EFI", "debian"),
+	} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dir, "grub.cfg"), []byte(stub), 0644); err != nil {
+			return err
+		}
+	}
+
+	full, err := renderGrubConfig(FULL_GRUB_CFG, config)
+	if err != nil {
+		return err
+	}
+	grubDir := filepath.Join(liveRoot, "boot", "grub")
+	if err := os.MkdirAll(grubDir, 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(grubDir, "grub.cfg"), []byte(full), 0644)
+}
@@ -0,0 +1,51 @@
+package usbimager
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFindBootFilesAndGrubConfig(t *testing.T) {
+	liveRoot := t.TempDir()
+	espRoot := t.TempDir()
+	os.MkdirAll(filepath.Join(liveRoot, "live"), 0755)
+	for _, name := range []string{"vmlinuz-6.1.0-17-amd64", "vmlinuz-6.1.0-18-amd64", "initrd.img-6.1.0-18-amd64"} {
+		os.WriteFile(filepath.Join(liveRoot, "live", name), nil, 0644)
+	}
+
+	kernel, initrd, err := findBootFiles(liveRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kernel != "/live/vmlinuz-6.1.0-18-amd64" || initrd != "/live/initrd.img-6.1.0-18-amd64" {
+		t.Fatalf("unexpected boot files %s %s", kernel, initrd)
+	}
+
+	config := GrubConfig{Label: "SYSTEM", Title: "Live", Kernel: kernel, Initrd: initrd, KernelParams: DEFAULT_KERNEL_PARAMS}
+	if err := writeGrubConfigs(espRoot, liveRoot, config); err != nil {
+		t.Fatal(err)
+	}
+	stub, _ := os.ReadFile(filepath.Join(espRoot, "EFI", "BOOT", "grub.cfg"))
+	if !strings.Contains(string(stub), `--label "SYSTEM"`) {
+		t.Fatalf("stub config does not search for the live label:\n%s", stub)
+	}
+	full, _ := os.ReadFile(filepath.Join(liveRoot, "boot", "grub", "grub.cfg"))
+	if !strings.Contains(string(full), "linux "+kernel+" "+DEFAULT_KERNEL_PARAMS) {
+		t.Fatalf("full config has the wrong linux line:\n%s", full)
+	}
+}
+
+func TestPartitionDevicePath(t *testing.T) {
+	cases := map[string]string{
+		"/dev/sdb":     "/dev/sdb2",
+		"/dev/loop0":   "/dev/loop0p2",
+		"/dev/nvme0n1": "/dev/nvme0n1p2",
+	}
+	for disk, expected := range cases {
+		if got := partitionDevicePath(disk, 2); got != expected {
+			t.Errorf("partitionDevicePath(%s) = %s, expected %s", disk, got, expected)
+		}
+	}
+}
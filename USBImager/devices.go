@@ -0,0 +1,441 @@
+package usbimager
+
+/*
+Block device discovery from /sys/block, used to pick imaging targets and to refuse
+disks the running system lives on. A disk counts as a system disk when it, one of its
+partitions or anything stacked on top of them (lvm, dm-crypt, raid) is mounted on a
+system mount point or used as swap
+*/
+
+import (
+	filesystem "LiveBuilder/Filesystem"
+	privileged "LiveBuilder/Privileged"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	SYS_BLOCK   = "/sys/block"
+	PROC_SWAPS  = "/proc/swaps"
+	SECTOR_SIZE = 512
+)
+
+// mount points that make the disk backing them a system disk
+var SYSTEM_MOUNT_POINTS = []string{"/", "/boot", "/boot/efi", "/efi", "/usr", "/var", "/home", "/opt", "/srv"}
+
+// virtual devices that are never imaging targets
+var IGNORED_DEVICE_PREFIXES = []string{"loop", "ram", "zram", "dm-", "md", "sr", "fd", "nbd"}
+
+type BlockDevice struct {
+	Name        string
+	Path        string
+	Removable   bool
+	ReadOnly    bool
+	Transport   string // usb, sata, nvme, mmc, virtio, scsi or empty when unknown
+	Vendor      string
+	Model       string
+	Size        int64
+	Partitions  []string // partition device names eg sdb1
+	MountPoints []string // mount points of the disk, its partitions and their holders
+	Swap        bool     // the disk or something on it is active swap
+}
+
+// IsSystemDisk reports whether the running system depends on this disk
+func (self BlockDevice) IsSystemDisk() bool {
+	if self.Swap {
+		return true
+	}
+	for _, mountPoint := range self.MountPoints {
+		for _, systemMount := range SYSTEM_MOUNT_POINTS {
+			if mountPoint == systemMount {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// IsEligible reports whether the disk may be imaged without forcing
+func (self BlockDevice) IsEligible() bool {
+	return self.IneligibleReason() == ""
+}
+
+// IneligibleReason explains why the disk may not be imaged without forcing, empty when it may
+func (self BlockDevice) IneligibleReason() string {
+	switch {
+	case self.IsSystemDisk():
+		return "it backs the running system"
+	case self.ReadOnly:
+		return "it is read only"
+	case self.Size == 0:
+		return "it has no media"
+	case !self.Removable && self.Transport != "usb":
+		return "it is not a removable or usb device"
+	}
+	return ""
+}
+
+func (self BlockDevice) Description() string {
+	name := strings.TrimSpace(self.Vendor + " " + self.Model)
+	if name == "" {
+		name = "unknown device"
+	}
+	transport := self.Transport
+	if transport == "" {
+		transport = "unknown"
+	}
+	description := fmt.Sprintf("%s  %s  %s (%s)", self.Path, formatBytes(self.Size), name, transport)
+	if len(self.MountPoints) > 0 {
+		description += "  mounted: " + strings.Join(self.MountPoints, ", ")
+	}
+	return description
+}
+
+// ListBlockDevices returns every physical disk on the machine, sorted by name
+func ListBlockDevices() ([]BlockDevice, error) {
+	return readBlockDevices(SYS_BLOCK, filesystem.MOUNTINFO, PROC_SWAPS)
+}
+
+// ListEligibleDevices returns only the disks that may be imaged without forcing
+func ListEligibleDevices() ([]BlockDevice, error) {
+	devices, err := ListBlockDevices()
+	if err != nil {
+		return nil, err
+	}
+	var eligible []BlockDevice
+	for _, device := range devices {
+		if device.IsEligible() {
+			eligible = append(eligible, device)
+		}
+	}
+	return eligible, nil
+}
+
+// CheckTarget refuses to image a disk that is not eligible unless force is set, with force
+// only the reason is logged. Partitions are always refused, the whole disk gets repartitioned.
+// Force does not get past the privileged helper, it refuses system and non removable disks itself
+func CheckTarget(path string, force bool) error {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return err
+	}
+	name := filepath.Base(resolved)
+	if _, err := os.Stat(filepath.Join("/sys/class/block", name, "partition")); err == nil {
+		return fmt.Errorf("%s is a partition, select the whole disk", path)
+	}
+
+	devices, err := ListBlockDevices()
+	if err != nil {
+		return err
+	}
+	for _, device := range devices {
+		if device.Name != name {
+			continue
+		}
+		reason := device.IneligibleReason()
+		if reason == "" {
+			return nil
+		}
+		if !force {
+			return fmt.Errorf("refusing to image %s, %s (force to override)", path, reason)
+		}
+		log.Printf("Forcing imaging of %s even though %s\n", path, reason)
+		return nil
+	}
+	if !force {
+		return fmt.Errorf("refusing to image %s, it is not a known disk (force to override)", path)
+	}
+	return nil
+}
+
+func init() {
+	privileged.TARGET_CHECK = checkHelperTarget
+}
+
+// checkHelperTarget is what the privileged helper enforces on every device it touches, force
+// can not override it. The disk of device has to be removable or on usb, must not back the
+// running system and everything mounted from it has to be accepted by mounted
+func checkHelperTarget(device string, mounted func(string) bool) error {
+	resolved, err := filepath.EvalSymlinks(device)
+	if err != nil {
+		return err
+	}
+	disk := diskOf(filepath.Base(resolved))
+	devices, err := ListBlockDevices()
+	if err != nil {
+		return err
+	}
+	for _, candidate := range devices {
+		if candidate.Name != disk {
+			continue
+		}
+		if candidate.IsSystemDisk() {
+			return fmt.Errorf("%s is on %s which backs the running system", device, candidate.Path)
+		}
+		if !candidate.Removable && candidate.Transport != "usb" {
+			return fmt.Errorf("%s is on %s which is not a removable or usb device", device, candidate.Path)
+		}
+		for _, mountPoint := range candidate.MountPoints {
+			if !mounted(mountPoint) {
+				return fmt.Errorf("%s is mounted on %s", candidate.Path, mountPoint)
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("%s is not on a known disk", device)
+}
+
+// diskOf is the disk a kernel device name belongs to, the name itself when it is no partition
+func diskOf(name string) string {
+	sysDir, err := filepath.EvalSymlinks(filepath.Join("/sys/class/block", name))
+	if err != nil {
+		return name
+	}
+	if _, err := os.Stat(filepath.Join(sysDir, "partition")); err == nil {
+		return filepath.Base(filepath.Dir(sysDir))
+	}
+	return name
+}
+
+func readBlockDevices(sysBlock, mountInfo, swaps string) ([]BlockDevice, error) {
+	entries, err := os.ReadDir(sysBlock)
+	if err != nil {
+		return nil, err
+	}
+	mounts, err := readMounts(mountInfo)
+	if err != nil {
+		return nil, err
+	}
+	swapDevices := readSwaps(swaps)
+
+	var devices []BlockDevice
+	for _, entry := range entries {
+		name := entry.Name()
+		if isIgnoredDevice(name) {
+			continue
+		}
+		dir := filepath.Join(sysBlock, name)
+		device := BlockDevice{
+			Name:      name,
+			Path:      "/dev/" + name,
+			Removable: readSysFile(dir, "removable") == "1",
+			ReadOnly:  readSysFile(dir, "ro") == "1",
+			Transport: detectTransport(dir, name),
+			Vendor:    readSysFile(dir, "device", "vendor"),
+			Model:     readSysFile(dir, "device", "model"),
+		}
+		if sectors, err := strconv.ParseInt(readSysFile(dir, "size"), 10, 64); err == nil {
+			device.Size = sectors * SECTOR_SIZE
+		}
+
+		names := []string{name}
+		partitions, _ := filepath.Glob(filepath.Join(dir, name+"*", "partition"))
+		for _, partition := range partitions {
+			partitionName := filepath.Base(filepath.Dir(partition))
+			device.Partitions = append(device.Partitions, partitionName)
+			names = append(names, partitionName)
+		}
+		sort.Strings(device.Partitions)
+
+		seen := make(map[string]bool)
+		for _, node := range names {
+			nodeDir := filepath.Join(dir, node)
+			if node == name {
+				nodeDir = dir
+			}
+			stackedDirs := append([]string{nodeDir}, holdersOf(sysBlock, nodeDir)...)
+			for _, stackedDir := range stackedDirs {
+				stacked := filepath.Base(stackedDir)
+				if swapDevices[stacked] {
+					device.Swap = true
+				}
+				//sources like /dev/root only match by device number
+				mountPoints := append(mounts[stacked], mounts[readSysFile(stackedDir, "dev")]...)
+				for _, mountPoint := range mountPoints {
+					if !seen[mountPoint] {
+						seen[mountPoint] = true
+						device.MountPoints = append(device.MountPoints, mountPoint)
+					}
+				}
+			}
+		}
+		sort.Strings(device.MountPoints)
+		devices = append(devices, device)
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].Name < devices[j].Name })
+	return devices, nil
+}
+
+func isIgnoredDevice(name string) bool {
+	for _, prefix := range IGNORED_DEVICE_PREFIXES {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// deviceSectors is the size of a block device from sysfs, 0 when it can not be read
+func deviceSectors(path string) uint64 {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return 0
+	}
+	sectors, err := strconv.ParseUint(readSysFile("/sys/class/block", filepath.Base(resolved), "size"), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return sectors
+}
+
+func readSysFile(parts ...string) string {
+	data, err := os.ReadFile(filepath.Join(parts...))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// detectTransport guesses the bus from where the device sits in the sysfs device tree
+func detectTransport(dir, name string) string {
+	if strings.HasPrefix(name, "nvme") {
+		return "nvme"
+	}
+	if strings.HasPrefix(name, "mmcblk") {
+		return "mmc"
+	}
+	target, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		target = dir
+	}
+	for _, bus := range []string{"usb", "ata", "virtio", "mmc"} {
+		if strings.Contains(target, "/"+bus) {
+			if bus == "ata" {
+				return "sata"
+			}
+			return bus
+		}
+	}
+	if strings.HasPrefix(name, "vd") {
+		return "virtio"
+	}
+	if strings.HasPrefix(name, "sd") {
+		return "scsi"
+	}
+	return ""
+}
+
+// holdersOf returns the sysfs dirs of every device stacked on top of the node, recursively
+func holdersOf(sysBlock, nodeDir string) []string {
+	entries, err := os.ReadDir(filepath.Join(nodeDir, "holders"))
+	if err != nil {
+		return nil
+	}
+	var holders []string
+	for _, entry := range entries {
+		holderDir := filepath.Join(sysBlock, entry.Name())
+		holders = append(holders, holderDir)
+		holders = append(holders, holdersOf(sysBlock, holderDir)...)
+	}
+	return holders
+}
+
+// readMounts maps kernel device names (sda1, dm-0) and device numbers (8:1) to their mount points
+func readMounts(mountInfo string) (map[string][]string, error) {
+	entries, err := filesystem.ReadMountInfo(mountInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	mounts := make(map[string][]string)
+	for _, entry := range entries {
+		source := entry.Source
+		if !strings.HasPrefix(source, "/dev/") {
+			continue
+		}
+		mounts[entry.DevNumber] = append(mounts[entry.DevNumber], entry.MountPoint)
+		//mapper names are symlinks to dm-N, resolve so holders match
+		if resolved, err := filepath.EvalSymlinks(source); err == nil {
+			source = resolved
+		}
+		name := filepath.Base(source)
+		mounts[name] = append(mounts[name], entry.MountPoint)
+	}
+	return mounts, nil
+}
+
+// unmountPartitions unmounts the disk and its partitions wherever they are mounted, by device
+// node so the privileged helper accepts it for desktop automounts too
+func unmountPartitions(disk string) {
+	mounts, err := readMounts(filesystem.MOUNTINFO)
+	if err != nil {
+		log.Printf("Reading mounts failed: %v\n", err)
+		return
+	}
+	for name, mountPoints := range mounts {
+		if !isPartitionOf(name, filepath.Base(disk)) {
+			continue
+		}
+		//umount by device takes the most recent mount, once per mount point gets them all
+		for _, mountPoint := range mountPoints {
+			if _, stderr, err := privileged.Run("umount", "/dev/"+name); err != nil {
+				log.Printf("Unmounting %s from %s failed: %v %s\n", name, mountPoint, err, stderr)
+			}
+		}
+	}
+}
+
+// isPartitionOf reports whether kernel device name is disk itself or one of its partitions (sdb1, loop0p2)
+func isPartitionOf(name string, disk string) bool {
+	number, found := strings.CutPrefix(name, disk)
+	if !found {
+		return false
+	}
+	if number == "" {
+		return true
+	}
+	if last := disk[len(disk)-1]; last >= '0' && last <= '9' {
+		if number, found = strings.CutPrefix(number, "p"); !found || number == "" {
+			return false
+		}
+	}
+	return strings.Trim(number, "0123456789") == ""
+}
+
+func readSwaps(swaps string) map[string]bool {
+	devices := make(map[string]bool)
+	data, err := os.ReadFile(swaps)
+	if err != nil {
+		return devices
+	}
+	for _, line := range strings.Split(string(data), "\n")[1:] {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || !strings.HasPrefix(fields[0], "/dev/") {
+			continue
+		}
+		source := fields[0]
+		if resolved, err := filepath.EvalSymlinks(source); err == nil {
+			source = resolved
+		}
+		devices[filepath.Base(source)] = true
+	}
+	return devices
+}
+
+func formatBytes(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,55 @@
+package usbimager
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeSysFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadBlockDevicesRefusesSystemDisk(t *testing.T) {
+	root := t.TempDir()
+	sysBlock := filepath.Join(root, "block")
+
+	//sda holds root through dm-0, sdb is a removable stick mounted under /media
+	writeSysFile(t, filepath.Join(sysBlock, "sda", "size"), "1000000")
+	writeSysFile(t, filepath.Join(sysBlock, "sda", "removable"), "0")
+	writeSysFile(t, filepath.Join(sysBlock, "sda", "sda2", "partition"), "2")
+	writeSysFile(t, filepath.Join(sysBlock, "sda", "sda2", "holders", "dm-0", "dev"), "")
+	writeSysFile(t, filepath.Join(sysBlock, "dm-0", "dev"), "254:0")
+	writeSysFile(t, filepath.Join(sysBlock, "sdb", "size"), "2000")
+	writeSysFile(t, filepath.Join(sysBlock, "sdb", "removable"), "1")
+	writeSysFile(t, filepath.Join(sysBlock, "sdb", "sdb1", "partition"), "1")
+	writeSysFile(t, filepath.Join(sysBlock, "loop0", "size"), "100")
+
+	mountInfo := filepath.Join(root, "mountinfo")
+	writeSysFile(t, mountInfo, "22 1 254:0 / / rw,relatime shared:1 - ext4 /dev/dm-0 rw\n"+
+		"40 22 8:17 / /media/usb\\040stick rw - vfat /dev/sdb1 rw")
+
+	devices, err := readBlockDevices(sysBlock, mountInfo, filepath.Join(root, "swaps"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 2 {
+		t.Fatalf("expected sda and sdb, got %+v", devices)
+	}
+	sda, sdb := devices[0], devices[1]
+	if !sda.IsSystemDisk() || sda.IsEligible() {
+		t.Errorf("sda should be a system disk, mounts %v", sda.MountPoints)
+	}
+	if !sdb.IsEligible() || sdb.Size != 2000*SECTOR_SIZE {
+		t.Errorf("sdb should be eligible: %+v reason %q", sdb, sdb.IneligibleReason())
+	}
+	if len(sdb.MountPoints) != 1 || sdb.MountPoints[0] != "/media/usb stick" {
+		t.Errorf("unexpected sdb mounts %v", sdb.MountPoints)
+	}
+}
@@ -0,0 +1,335 @@
+package usbimager
+
+/*
+Native partition table handling. A PartitionTabelBuilder is planned into a DiskLabel with
+absolute sector positions, which the mbr and gpt encoders turn into raw sectors written
+straight to the target. ReadDiskLabel decodes an existing table back into a DiskLabel so
+tables on plain image files can be inspected without root
+*/
+
+import (
+	privileged "LiveBuilder/Privileged"
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	// partitions start on 1MiB boundaries
+	ALIGNMENT_SECTORS = 1024 * 1024 / SECTOR_SIZE
+)
+
+type DiskLabel struct {
+	Type       TableType
+	DiskID     string // mbr disk signature as 0x%08x or the gpt disk guid
+	Sectors    uint64 // total sectors of the disk the label was planned for or read from
+	Partitions []LabelPartition
+}
+
+type LabelPartition struct {
+	Number   int
+	Start    uint64 // first sector
+	Sectors  uint64
+	Type     PartitionType // mbr code (two hex digits) or gpt type guid
+	GUID     string        // gpt only, unique partition guid
+	Name     string        // gpt only
+	Bootable bool          // mbr active flag, gpt legacy bios bootable attribute
+}
+
+func (self LabelPartition) End() uint64 {
+	return self.Start + self.Sectors - 1
+}
+
+func (self LabelPartition) Size() int64 {
+	return int64(self.Sectors) * SECTOR_SIZE
+}
+
+// Plan resolves every definition to absolute sectors on a disk of diskSectors. Partitions
+// without a start follow the previous one on the next 1MiB boundary, sizes like "25%" are a
+// share of the usable disk and only the last partition may omit its size to take the rest
+func (table *PartitionTabelBuilder) Plan(diskSectors uint64) (*DiskLabel, error) {
+	label := &DiskLabel{Type: table.label, Sectors: diskSectors}
+	firstUsable, lastUsable := uint64(1), diskSectors-1
+	switch table.label {
+	case TABLETYPE_MBR:
+		label.DiskID = table.label_id
+		if label.DiskID == "" {
+			label.DiskID = fmt.Sprintf("0x%08x", randomUint32())
+		}
+		if len(table.partitions) > MBR_MAX_PARTITIONS {
+			return nil, fmt.Errorf("mbr tables hold at most %d partitions, got %d", MBR_MAX_PARTITIONS, len(table.partitions))
+		}
+	case TABLETYPE_GPT:
+		label.DiskID = table.label_id
+		if label.DiskID == "" {
+			label.DiskID = randomGUID()
+		}
+		if len(table.partitions) > GPT_ENTRY_COUNT {
+			return nil, fmt.Errorf("gpt tables hold at most %d partitions, got %d", GPT_ENTRY_COUNT, len(table.partitions))
+		}
+		firstUsable, lastUsable = gptUsableRange(diskSectors)
+	default:
+		return nil, fmt.Errorf("unknown table type %s", table.label)
+	}
+
+	next := alignUp(firstUsable)
+	//percentage sizes are taken of the usable sectors behind the first alignment boundary
+	var usable uint64
+	if lastUsable >= next {
+		usable = lastUsable - next + 1
+	}
+	for i, definition := range table.partitions {
+		number := i + 1
+		partition := LabelPartition{
+			Number:   number,
+			Start:    next,
+			Bootable: definition.bootable,
+		}
+		if start := definition.stringAttributes["start"]; start != "" {
+			sectors, err := strconv.ParseUint(start, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("partition %d: invalid start %q", number, start)
+			}
+			partition.Start = sectors
+		}
+		if partition.Start < firstUsable {
+			return nil, fmt.Errorf("partition %d starts at sector %d, before the first usable sector %d", number, partition.Start, firstUsable)
+		}
+
+		if size := definition.Size(); size != "" {
+			percent, isPercent, err := parsePercentSize(size)
+			if err != nil {
+				return nil, fmt.Errorf("partition %d: %w", number, err)
+			}
+			if isPercent {
+				//rounded down so the partitions behind it stay aligned
+				partition.Sectors = usable * uint64(percent) / 100 / ALIGNMENT_SECTORS * ALIGNMENT_SECTORS
+			} else {
+				bytes, err := parseSize(size)
+				if err != nil {
+					return nil, fmt.Errorf("partition %d: %w", number, err)
+				}
+				partition.Sectors = uint64((bytes + SECTOR_SIZE - 1) / SECTOR_SIZE)
+			}
+		} else {
+			if number != len(table.partitions) {
+				return nil, fmt.Errorf("partition %d has no size, only the last partition can take the rest of the disk", number)
+			}
+			if partition.Start <= lastUsable {
+				partition.Sectors = lastUsable - partition.Start + 1
+			}
+		}
+		if partition.Sectors == 0 || partition.End() > lastUsable {
+			return nil, fmt.Errorf("partition %d does not fit on a disk of %s", number, formatBytes(int64(diskSectors)*SECTOR_SIZE))
+		}
+
+		partType, err := definition.partType.ForTable(table.label)
+		if err != nil {
+			return nil, fmt.Errorf("partition %d: %w", number, err)
+		}
+		partition.Type = partType
+		if table.label == TABLETYPE_GPT {
+			partition.Name = definition.volumeName
+			partition.GUID = randomGUID()
+		}
+		label.Partitions = append(label.Partitions, partition)
+		next = alignUp(partition.End() + 1)
+	}
+	for i := 1; i < len(label.Partitions); i++ {
+		if label.Partitions[i].Start <= label.Partitions[i-1].End() {
+			return nil, fmt.Errorf("partition %d overlaps partition %d", i+1, i)
+		}
+	}
+	return label, nil
+}
+
+// diskRegion is a run of raw bytes at an absolute offset on the disk
+type diskRegion struct {
+	offset int64
+	data   []byte
+}
+
+// Encode renders the label into the regions that have to be written to the disk
+func (self *DiskLabel) Encode() ([]diskRegion, error) {
+	switch self.Type {
+	case TABLETYPE_MBR:
+		return encodeMBRLabel(self)
+	case TABLETYPE_GPT:
+		return encodeGPTLabel(self)
+	}
+	return nil, fmt.Errorf("unknown table type %s", self.Type)
+}
+
+// WriteTo writes the encoded label to w, which must be at least Sectors long
+func (self *DiskLabel) WriteTo(w io.WriterAt) error {
+	regions, err := self.Encode()
+	if err != nil {
+		return err
+	}
+	for _, region := range regions {
+		if _, err := w.WriteAt(region.data, region.offset); err != nil {
+			return fmt.Errorf("writing partition table at offset %d: %w", region.offset, err)
+		}
+	}
+	return nil
+}
+
+// ReadDiskLabel decodes the partition table of a disk of diskSectors, gpt is detected by the
+// protective mbr and falls back to the backup header when the primary one is damaged
+func ReadDiskLabel(r io.ReaderAt, diskSectors uint64) (*DiskLabel, error) {
+	sector := make([]byte, SECTOR_SIZE)
+	if _, err := r.ReadAt(sector, 0); err != nil {
+		return nil, fmt.Errorf("reading mbr: %w", err)
+	}
+	mbr, err := decodeMBR(sector)
+	if err != nil {
+		return nil, err
+	}
+	for _, partition := range mbr.Partitions {
+		if partition.Type == MBR_TYPE_GPT_PROTECTIVE {
+			return decodeGPTLabel(r, diskSectors)
+		}
+	}
+	mbr.Sectors = diskSectors
+	return mbr, nil
+}
+
+// ReadDiskLabelFromFile opens path read only and decodes its partition table
+func ReadDiskLabelFromFile(path string) (*DiskLabel, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	return ReadDiskLabel(file, uint64(size)/SECTOR_SIZE)
+}
+
+// diskWriter writes raw regions to a target, directly when the target can be opened and
+// through dd run by the privileged helper when it is a device only root may write to
+type diskWriter interface {
+	sectors() (uint64, error)
+	WriteAt(data []byte, offset int64) (int, error)
+	Close() error
+}
+
+func openDiskWriter(path string) (diskWriter, error) {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err == nil {
+		return &fileDiskWriter{file: file}, nil
+	}
+	if !os.IsPermission(err) {
+		return nil, err
+	}
+	log.Printf("No write access to %s, writing the partition table as root\n", path)
+	return &privilegedDisk{path: path}, nil
+}
+
+// readDiskLabel decodes the partition table of path, reading it through dd run by the
+// privileged helper when it is a device only root may read
+func readDiskLabel(path string) (*DiskLabel, error) {
+	label, err := ReadDiskLabelFromFile(path)
+	if !os.IsPermission(err) {
+		return label, err
+	}
+	log.Printf("No read access to %s, reading the partition table as root\n", path)
+	disk := &privilegedDisk{path: path}
+	sectors, err := disk.sectors()
+	if err != nil {
+		return nil, err
+	}
+	return ReadDiskLabel(disk, sectors)
+}
+
+type fileDiskWriter struct {
+	file *os.File
+}
+
+func (self *fileDiskWriter) sectors() (uint64, error) {
+	size, err := self.file.Seek(0, io.SeekEnd)
+	return uint64(size) / SECTOR_SIZE, err
+}
+
+func (self *fileDiskWriter) WriteAt(data []byte, offset int64) (int, error) {
+	return self.file.WriteAt(data, offset)
+}
+
+func (self *fileDiskWriter) Close() error {
+	if err := self.file.Sync(); err != nil {
+		self.file.Close()
+		return err
+	}
+	return self.file.Close()
+}
+
+// privilegedDisk reads and writes a device through dd run by the privileged helper
+type privilegedDisk struct {
+	path string
+}
+
+func (self *privilegedDisk) sectors() (uint64, error) {
+	stdout, stderr, err := privileged.Run("blockdev", "--getsize64", self.path)
+	if err != nil {
+		return 0, fmt.Errorf("getting size of %s: %v %s", self.path, err, stderr)
+	}
+	size, err := strconv.ParseUint(strings.TrimSpace(stdout), 10, 64)
+	return size / SECTOR_SIZE, err
+}
+
+// WriteAt only supports sector aligned regions, which is all the encoders produce
+func (self *privilegedDisk) WriteAt(data []byte, offset int64) (int, error) {
+	if offset%SECTOR_SIZE != 0 || len(data)%SECTOR_SIZE != 0 {
+		return 0, fmt.Errorf("unaligned write of %d bytes at %d", len(data), offset)
+	}
+	var stderr bytes.Buffer
+	cmd := privileged.Command("dd", "of="+self.path, fmt.Sprintf("bs=%d", SECTOR_SIZE),
+		fmt.Sprintf("seek=%d", offset/SECTOR_SIZE), "conv=notrunc,fsync", "status=none")
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return 0, fmt.Errorf("dd to %s: %v %s", self.path, err, stderr.String())
+	}
+	return len(data), nil
+}
+
+// ReadAt reads the whole sectors covering the region, the decoders only ever ask for whole sectors
+func (self *privilegedDisk) ReadAt(data []byte, offset int64) (int, error) {
+	first := offset / SECTOR_SIZE
+	last := (offset + int64(len(data)) + SECTOR_SIZE - 1) / SECTOR_SIZE
+	var stdout, stderr bytes.Buffer
+	cmd := privileged.Command("dd", "if="+self.path, fmt.Sprintf("bs=%d", SECTOR_SIZE),
+		fmt.Sprintf("skip=%d", first), fmt.Sprintf("count=%d", last-first), "status=none")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return 0, fmt.Errorf("dd from %s: %v %s", self.path, err, stderr.String())
+	}
+	read := copy(data, stdout.Bytes()[min(int(offset-first*SECTOR_SIZE), stdout.Len()):])
+	if read < len(data) {
+		return read, io.ErrUnexpectedEOF
+	}
+	return read, nil
+}
+
+func (self *privilegedDisk) Close() error {
+	return nil
+}
+
+func alignUp(sector uint64) uint64 {
+	return (sector + ALIGNMENT_SECTORS - 1) / ALIGNMENT_SECTORS * ALIGNMENT_SECTORS
+}
+
+func randomUint32() uint32 {
+	var buf [4]byte
+	rand.Read(buf[:])
+	return binary.LittleEndian.Uint32(buf[:])
+}
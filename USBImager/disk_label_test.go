@@ -0,0 +1,104 @@
+package usbimager
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func sparseImage(t *testing.T, size int64) string {
+	path := filepath.Join(t.TempDir(), "disk.img")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := file.Truncate(size); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestMBRLabelRoundTrip(t *testing.T) {
+	path := sparseImage(t, 2<<30)
+	diskpart := buildLayout(t, LAYOUT_MBR, FileObject{Path: path, Type: TypeRegularFile})
+	if err := diskpart.PartitionDisk(); err != nil {
+		t.Fatal(err)
+	}
+
+	label, err := ReadDiskLabelFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if label.Type != TABLETYPE_MBR || label.DiskID == "" || label.DiskID == "0x00000000" || len(label.Partitions) != 2 {
+		t.Fatalf("unexpected label %+v", label)
+	}
+	boot, system := label.Partitions[0], label.Partitions[1]
+	if boot.Start != 2048 || boot.Size() != 512<<20 || !boot.Bootable || boot.Type != W95_FAT32_LBA {
+		t.Errorf("unexpected boot partition %+v", boot)
+	}
+	if system.Start != 2048+1048576 || system.End() != label.Sectors-1 || system.Bootable || system.Type != Linux {
+		t.Errorf("unexpected system partition %+v", system)
+	}
+}
+
+func TestGPTLabelRoundTripAndBackup(t *testing.T) {
+	path := sparseImage(t, 2<<30)
+	diskpart := buildLayout(t, LAYOUT_HYBRID_GPT, FileObject{Path: path, Type: TypeRegularFile})
+	if err := diskpart.PartitionDisk(); err != nil {
+		t.Fatal(err)
+	}
+
+	label, err := ReadDiskLabelFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if label.Type != TABLETYPE_GPT || len(label.Partitions) != 3 {
+		t.Fatalf("unexpected label %+v", label)
+	}
+	expected := []struct {
+		name  string
+		start uint64
+		typ   PartitionType
+	}{
+		{"BIOSBOOT", 2048, GPT_BIOSBoot},
+		{"BOOT", 4096, GPT_EFISystem},
+		{"SYSTEM", 4096 + 1048576, GPT_LinuxFilesystem},
+	}
+	for i, partition := range label.Partitions {
+		if partition.Name != expected[i].name || partition.Start != expected[i].start || partition.Type != expected[i].typ {
+			t.Errorf("partition %d: unexpected %+v", i+1, partition)
+		}
+	}
+	_, lastUsable := gptUsableRange(label.Sectors)
+	if label.Partitions[2].End() != lastUsable {
+		t.Errorf("system partition ends at %d, expected %d", label.Partitions[2].End(), lastUsable)
+	}
+
+	//damage the primary header, the reader has to fall back to the backup at the end of the disk
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.WriteAt([]byte("garbage"), SECTOR_SIZE+24); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	backup, err := ReadDiskLabelFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backup.DiskID != label.DiskID || len(backup.Partitions) != 3 || backup.Partitions[2].GUID != label.Partitions[2].GUID {
+		t.Fatalf("backup gpt does not match the primary: %+v", backup)
+	}
+}
+
+func TestPlanRejectsUnsizedMiddlePartition(t *testing.T) {
+	table := NewPartitionTable(TABLETYPE_MBR).
+		WithPartitionDefinition(NewPartitionBuilder("1").OfType(Linux)).
+		WithPartitionDefinition(NewPartitionBuilder("2").WithSize("1M").OfType(Linux))
+	if _, err := table.Plan(1 << 20); err == nil {
+		t.Fatal("expected an error for an unsized partition that is not the last")
+	}
+}
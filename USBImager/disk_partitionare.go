@@ -1,17 +1,15 @@
 package usbimager
 
 import (
-	"bytes"
+	privileged "LiveBuilder/Privileged"
 	"fmt"
 	"log"
-	"os/exec"
-	"strings"
 )
 
 type DiskPartitionare struct {
 	Device         FileObject
 	PartitionTable *PartitionTabelBuilder
-	loopPath       string
+	loop           *LoopDevice // attached for regular file targets, they have no partition nodes of their own
 }
 
 func NewDiskPartionare(device FileObject) *DiskPartitionare {
@@ -27,72 +25,124 @@ func (self *DiskPartitionare) PartitionDisk() error {
 		return fmt.Errorf("No FileObject supplied")
 	}
 
-	log.Println("PARTITION TABLE")
-	log.Println(self.PartitionTable.ToSfdisk())
-
-	partitionReader := strings.NewReader(self.PartitionTable.ToSfdisk())
+	writer, err := openDiskWriter(self.Device.Path)
+	if err != nil {
+		return err
+	}
+	sectors, err := writer.sectors()
+	if err != nil {
+		writer.Close()
+		return err
+	}
+	label, err := self.PartitionTable.Plan(sectors)
+	if err != nil {
+		writer.Close()
+		return err
+	}
+	if script, err := self.PartitionTable.ToSfdisk(); err == nil {
+		log.Println("PARTITION TABLE")
+		log.Println(script)
+	}
+	for _, partition := range label.Partitions {
+		log.Printf("partition %d: start=%d sectors=%d type=%s\n", partition.Number, partition.Start, partition.Sectors, partition.Type)
+	}
 
-	var out bytes.Buffer
-	cmd := exec.Command("sudo", "sfdisk", "-f", self.Device.Path)
-	cmd.Stdin = partitionReader
-	cmd.Stdout = &out
-	cmd.Stderr = &out
-	if err := cmd.Run(); err != nil {
-		log.Printf("sfdisk error: %s\n", out.String())
+	if err := label.WriteTo(writer); err != nil {
+		writer.Close()
+		return err
+	}
+	if err := writer.Close(); err != nil {
 		return err
 	}
-	log.Printf("sfdisk out: %s\n", out.String())
 
+	//the kernel only picks up the new table once asked to, image files are read on loop setup
+	if self.Device.Type != TypeRegularFile {
+		if _, stderr, err := privileged.Run("blockdev", "--rereadpt", self.Device.Path); err != nil {
+			log.Printf("rereading partition table of %s failed: %v %s\n", self.Device.Path, err, stderr)
+		}
+	}
 	return nil
 }
 func (self *DiskPartitionare) WriteFileSystems() error {
-	if err := self.openLoop(); err != nil {
-		fmt.Println(err)
-		return err
+	//regular files have no partition nodes, a loop device gives us /dev/loopNpX
+	if self.Device.Type == TypeRegularFile && self.loop == nil {
+		if err := self.openLoop(); err != nil {
+			return err
+		}
+	}
+	//a bad label should not leave the disk half formatted
+	for _, partition := range self.PartitionTable.partitions {
+		if err := partition.CheckFormat(); err != nil {
+			return err
+		}
 	}
 	for i, partition := range self.PartitionTable.partitions {
-		device := fmt.Sprintf("%sp%d", self.loopPath, i+1)
-		cmd, _ := getFormatCommandForDeivce(partition.partType, device, partition.volumeName)
-		stdout, stderr, err := run("sudo", strings.Split(cmd, " ")...)
-		log.Printf("MKFS COMMAND: %s\n", cmd)
-		log.Printf("stdout (%s)\nstderr (%s)\n", stdout, stderr)
-		if err != nil {
-			fmt.Println(err)
+		device := self.PartitionPath(i + 1)
+		filesystem := partition.Filesystem()
+		if filesystem == "" {
+			log.Printf("Partition %s (%s) is not formatted\n", device, PartitionsCodeToName[partition.partType])
+			continue
+		}
+		if err := FormatPartition(filesystem, device, partition.FormatOptions()); err != nil {
 			return err
 		}
 	}
-	//self.closeLoop()
-
 	return nil
 }
 func (self *DiskPartitionare) openLoop() error {
-	_, _, err := run("sudo", "losetup", "-Pf", self.Device.Path)
+	loop, err := AttachLoop(self.Device.Path)
 	if err != nil {
-		log.Printf("Looping setup error: %+v\n", err)
 		return err
 	}
+	if err := loop.WaitForPartitions(len(self.PartitionTable.partitions), LOOP_PARTITION_TIMEOUT); err != nil {
+		if detachErr := loop.Detach(); detachErr != nil {
+			log.Printf("Detaching %s failed: %v\n", loop.Path, detachErr)
+		}
+		return err
+	}
+	self.loop = loop
+	return nil
+}
 
-	// Find loop device
-	stdout, _, err := run("sudo", "losetup", "-j", self.Device.Path)
-
-	if err != nil {
-		log.Printf("Looping detection error: %+v\n", err)
+// Close detaches the loop device if one was attached for a regular file target
+func (self *DiskPartitionare) Close() error {
+	if self.loop == nil {
+		return nil
+	}
+	if err := self.loop.Detach(); err != nil {
 		return err
 	}
+	self.loop = nil
+	return nil
+}
 
-	var loopDev string
-	fmt.Sscanf(string(stdout), "%s:", &loopDev)
+// DiskDevice is the whole disk device, the loop device when imaging a regular file
+func (self *DiskPartitionare) DiskDevice() string {
+	if self.loop != nil {
+		return self.loop.Path
+	}
+	return self.Device.Path
+}
 
-	loopDev = loopDev[:len(loopDev)-1]
+// PartitionPath returns the device node of the 1 based partition number
+func (self *DiskPartitionare) PartitionPath(number int) string {
+	return partitionDevicePath(self.DiskDevice(), number)
+}
 
-	fmt.Println(loopDev)
-	self.loopPath = loopDev
-	return nil
+// PartitionWithRole returns the 1 based number and definition of the first partition with role
+func (self *DiskPartitionare) PartitionWithRole(role PartitionRole) (int, *PartitionDefinitionBuilder, error) {
+	for i, partition := range self.PartitionTable.partitions {
+		if partition.role == role {
+			return i + 1, partition, nil
+		}
+	}
+	return 0, nil, fmt.Errorf("partition table has no %s partition", role)
 }
-func (self *DiskPartitionare) closeLoop() {
-	_, _, err := run("sudo", "losetup", "-d", self.loopPath)
-	if err != nil {
-		log.Printf("ERROR UNLOOPING: %+v\n", err)
-		panic(err)
+
+// partitionDevicePath follows the kernel naming, disks ending in a digit (loop0, nvme0n1, mmcblk0) get a p separator
+func partitionDevicePath(disk string, number int) string {
+	if last := disk[len(disk)-1]; last >= '0' && last <= '9' {
+		return fmt.Sprintf("%sp%d", disk, number)
 	}
+	return fmt.Sprintf("%s%d", disk, number)
 }
@@ -1,23 +1,85 @@
 package usbimager
 
-func StandardLinuxMBRBootPart(fileobject FileObject) *DiskPartitionare {
-	partition1 := NewPartitionBuilder(fileobject.Path + "1").
-		WithName("BOOT").
-		StartAt("2048").
-		WithSize("512M").
-		OfType(W95_FAT32_LBA).
-		SetBootable(true)
+import (
+	filesystem "LiveBuilder/Filesystem"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+)
 
-	partition2 := NewPartitionBuilder(fileobject.Path + "2").
-		WithName("SYSTEM").
-		OfType(Linux)
+// LAYOUTS are the partition layouts ImageUSB can write, keyed by the name used on the command
+// line. They start out as the shipped layouts, LoadLayouts adds the ones from the app data dir
+var LAYOUTS = embeddedLayouts()
 
-	partitionTable := NewPartitionTable(TABLETYPE_MBR).
-		WithPartitionDefinition(partition1).
-		WithPartitionDefinition(partition2)
+const (
+	LAYOUT_MBR        = "mbr"
+	LAYOUT_HYBRID_GPT = "gpt-hybrid"
+	DEFAULT_LAYOUT    = LAYOUT_MBR
+)
 
-	diskpart := NewDiskPartionare(fileobject)
-	diskpart.SetPartitionTable(partitionTable)
+func LayoutNames() []string {
+	var names []string
+	for name := range LAYOUTS {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func GetLayout(name string) (*LayoutFile, error) {
+	layout, exists := LAYOUTS[name]
+	if !exists {
+		return nil, fmt.Errorf("unknown partition layout %s, expected one of %v", name, LayoutNames())
+	}
+	return layout, nil
+}
+
+// LoadLayouts adds every layout file in dir to LAYOUTS, replacing shipped layouts of the same
+// name. Broken files are skipped and reported together, the valid ones are loaded either way
+func LoadLayouts(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, entry := range entries {
+		if entry.IsDir() || layoutName(entry.Name()) == "" {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		layout, err := ParseLayout(entry.Name(), data)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", path, err))
+			continue
+		}
+		log.Printf("Loaded partition layout %s from %s\n", layout.Name, path)
+		LAYOUTS[layout.Name] = layout
+	}
+	return errors.Join(errs...)
+}
 
-	return diskpart
+func embeddedLayouts() map[string]*LayoutFile {
+	layouts := make(map[string]*LayoutFile)
+	files, err := filesystem.ReadEmbeddedDir(filesystem.LAYOUTS_DIR_ID)
+	if err != nil {
+		log.Printf("No shipped partition layouts: %v\n", err)
+		return layouts
+	}
+	for fileName, data := range files {
+		layout, err := ParseLayout(fileName, data)
+		if err != nil {
+			log.Printf("Skipping shipped partition layout %s: %v\n", fileName, err)
+			continue
+		}
+		layouts[layout.Name] = layout
+	}
+	return layouts
 }
@@ -0,0 +1,228 @@
+package usbimager
+
+/*
+Imaging events, the same subscriber model as the BuildManager. Stage events bracket every
+imaging stage and PROGRESS events report bytes, throughput and ETA while the payload is
+copied and verified
+*/
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+type ImageEventType string
+
+const (
+	IMAGE_STARTED  ImageEventType = "image_started"
+	IMAGE_FINISHED ImageEventType = "image_finished" // always the last event of a run, Err set on failure
+	STAGE_STARTED  ImageEventType = "stage_started"
+	STAGE_FINISHED ImageEventType = "stage_finished"
+	PROGRESS       ImageEventType = "progress"
+	BATCH_STARTED  ImageEventType = "batch_started"
+	BATCH_FINISHED ImageEventType = "batch_finished" // last event of ImageBatch, after every IMAGE_FINISHED
+)
+
+// how often PROGRESS events are sent at most
+const PROGRESS_INTERVAL = 250 * time.Millisecond
+
+type ImageEvent struct {
+	Type     ImageEventType
+	Time     time.Time
+	Stage    string
+	Duration time.Duration // STAGE_FINISHED, IMAGE_FINISHED
+	Err      error         // STAGE_FINISHED, IMAGE_FINISHED
+
+	BytesDone  int64         // PROGRESS
+	BytesTotal int64         // PROGRESS
+	Throughput float64       // PROGRESS, bytes per second
+	ETA        time.Duration // PROGRESS
+
+	Source    string   // IMAGE_STARTED, BATCH_STARTED, the iso
+	Target    string   // the device or image file the event belongs to, empty for batch events
+	Artifacts []string // IMAGE_FINISHED, the image and checksum files written for image file targets
+
+	Results []DeviceResult // BATCH_FINISHED
+}
+
+// Fraction is the completed part of a PROGRESS event between 0 and 1
+func (event ImageEvent) Fraction() float64 {
+	if event.BytesTotal <= 0 {
+		return 0
+	}
+	return float64(event.BytesDone) / float64(event.BytesTotal)
+}
+
+func (event ImageEvent) String() string {
+	switch event.Type {
+	case IMAGE_STARTED:
+		return fmt.Sprintf("Imaging %s onto %s", event.Source, event.Target)
+	case IMAGE_FINISHED:
+		if event.Err != nil {
+			return fmt.Sprintf("Imaging failed after %s: %v", event.Duration.Round(time.Second), event.Err)
+		}
+		return fmt.Sprintf("Imaging finished in %s", event.Duration.Round(time.Second))
+	case STAGE_STARTED:
+		return fmt.Sprintf("==> %s", event.Stage)
+	case STAGE_FINISHED:
+		if event.Err != nil {
+			return fmt.Sprintf("<== %s failed after %s", event.Stage, event.Duration.Round(time.Millisecond))
+		}
+		return fmt.Sprintf("<== %s finished in %s", event.Stage, event.Duration.Round(time.Millisecond))
+	case BATCH_STARTED:
+		return fmt.Sprintf("Duplicating %s", event.Source)
+	case BATCH_FINISHED:
+		failed := 0
+		for _, result := range event.Results {
+			if result.Err != nil {
+				failed++
+			}
+		}
+		return fmt.Sprintf("Duplication finished in %s, %d of %d devices failed", event.Duration.Round(time.Second), failed, len(event.Results))
+	case PROGRESS:
+		return fmt.Sprintf("%s %3.0f%% %s / %s  %s/s  ETA %s", event.Stage, event.Fraction()*100,
+			formatBytes(event.BytesDone), formatBytes(event.BytesTotal), formatBytes(int64(event.Throughput)), event.ETA.Round(time.Second))
+	}
+	return string(event.Type)
+}
+
+func (self *USBImager) emit(event ImageEvent) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	self.eventChannel <- event
+}
+
+// emitFor returns an emit that stamps every event with target
+func (self *USBImager) emitFor(target string) func(ImageEvent) {
+	return func(event ImageEvent) {
+		event.Target = target
+		self.emit(event)
+	}
+}
+
+// GetSubscriber returns a channel of the imagers events, it is closed once the imager is
+func (self *USBImager) GetSubscriber() <-chan ImageEvent {
+	self.subMutex.Lock()
+	defer self.subMutex.Unlock()
+
+	subscriber := make(chan ImageEvent, 100)
+	if self.closed {
+		close(subscriber)
+		return subscriber
+	}
+	self.subscribers = append(self.subscribers, subscriber)
+	return subscriber
+}
+
+// Close stops the listener once the queued events are delivered and closes every subscriber,
+// call it after the last ImageUSB or ImageBatch returned since emitting afterwards panics
+func (self *USBImager) Close() {
+	self.closeOnce.Do(func() {
+		close(self.eventChannel)
+	})
+}
+
+// listenForUpdates fans events out to the subscribers, a full subscriber misses output but
+// IMAGE_FINISHED is always delivered since it carries the artifacts of the run
+func (self *USBImager) listenForUpdates() {
+	for event := range self.eventChannel {
+		self.subMutex.RLock()
+		for _, subscriber := range self.subscribers {
+			if event.Type == IMAGE_FINISHED {
+				subscriber <- event
+				continue
+			}
+			select {
+			case subscriber <- event:
+			default:
+			}
+		}
+		self.subMutex.RUnlock()
+	}
+
+	self.subMutex.Lock()
+	defer self.subMutex.Unlock()
+	self.closed = true
+	for _, subscriber := range self.subscribers {
+		close(subscriber)
+	}
+	self.subscribers = nil
+}
+
+// progressTracker counts bytes for one stage and sends throttled PROGRESS events,
+// a nil tracker ignores everything so helpers can be used without reporting
+type progressTracker struct {
+	stage    string
+	total    int64
+	done     int64
+	started  time.Time
+	lastSent time.Time
+	emit     func(ImageEvent)
+	ctx      context.Context // Write fails once it is cancelled so copies stop mid file
+	mutex    sync.Mutex
+}
+
+func newProgressTracker(stage string, emit func(ImageEvent)) *progressTracker {
+	return &progressTracker{
+		stage:   stage,
+		started: time.Now(),
+		emit:    emit,
+	}
+}
+
+// setTotal sets the number of bytes the stage will process and restarts the throughput clock
+func (self *progressTracker) setTotal(total int64) {
+	if self == nil {
+		return
+	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.total = total
+	self.started = time.Now()
+}
+
+func (self *progressTracker) add(bytes int64) {
+	if self == nil {
+		return
+	}
+	self.mutex.Lock()
+	self.done += bytes
+	now := time.Now()
+	if now.Sub(self.lastSent) < PROGRESS_INTERVAL && self.done < self.total {
+		self.mutex.Unlock()
+		return
+	}
+	self.lastSent = now
+	event := self.event(now)
+	self.mutex.Unlock()
+	self.emit(event)
+}
+
+func (self *progressTracker) event(now time.Time) ImageEvent {
+	event := ImageEvent{
+		Type:       PROGRESS,
+		Time:       now,
+		Stage:      self.stage,
+		BytesDone:  self.done,
+		BytesTotal: self.total,
+	}
+	if elapsed := now.Sub(self.started).Seconds(); elapsed > 0 {
+		event.Throughput = float64(self.done) / elapsed
+	}
+	if event.Throughput > 0 && self.total > self.done {
+		event.ETA = time.Duration(float64(self.total-self.done) / event.Throughput * float64(time.Second))
+	}
+	return event
+}
+
+// Write lets the tracker sit in an io.MultiWriter next to the real destination
+func (self *progressTracker) Write(data []byte) (int, error) {
+	if self.ctx != nil && self.ctx.Err() != nil {
+		return 0, self.ctx.Err()
+	}
+	self.add(int64(len(data)))
+	return len(data), nil
+}
@@ -0,0 +1,57 @@
+package usbimager
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProgressTrackerReportsCompletion(t *testing.T) {
+	var events []ImageEvent
+	tracker := newProgressTracker("copy payload", func(event ImageEvent) {
+		events = append(events, event)
+	})
+	tracker.setTotal(100)
+	tracker.add(10)
+	tracker.add(40) //throttled, inside PROGRESS_INTERVAL of the first
+	time.Sleep(time.Millisecond)
+	tracker.add(50)
+
+	if len(events) != 2 {
+		t.Fatalf("expected the first and the completing event, got %d", len(events))
+	}
+	last := events[len(events)-1]
+	if last.Fraction() != 1 || last.ETA != 0 || last.Throughput <= 0 || last.Stage != "copy payload" {
+		t.Fatalf("unexpected final progress %+v", last)
+	}
+}
+
+func TestCloseEndsSubscribers(t *testing.T) {
+	imager := NewUSBImager()
+	subscriber := imager.GetSubscriber()
+	//more output than the subscriber buffers, nobody reads until the run is over
+	for i := 0; i < 3*cap(subscriber); i++ {
+		imager.emit(ImageEvent{Type: STAGE_STARTED})
+	}
+	imager.emit(ImageEvent{Type: IMAGE_FINISHED, Artifacts: []string{"out.img"}})
+	imager.Close()
+
+	finished := false
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event, ok := <-subscriber:
+			if !ok {
+				if !finished {
+					t.Fatal("IMAGE_FINISHED was dropped")
+				}
+				if _, ok := <-imager.GetSubscriber(); ok {
+					t.Fatal("a subscriber of a closed imager should be closed")
+				}
+				return
+			}
+			finished = finished || event.Type == IMAGE_FINISHED
+		case <-timeout:
+			t.Fatal("subscriber was not closed")
+		}
+	}
+}
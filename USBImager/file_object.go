@@ -1,6 +1,7 @@
 package usbimager
 
 import (
+	privileged "LiveBuilder/Privileged"
 	"fmt"
 	"log"
 	"os"
@@ -12,17 +13,15 @@ type FileObject struct {
 	Info *SystemFileInfo
 }
 
-func (self *FileObject) resize(size int64) error {
+// allocate discards the files content and leaves a sparse file of size bytes, blocks are
+// only allocated once something is written to them
+func (self *FileObject) allocate(size int64) error {
 	if self.Type != TypeRegularFile {
 		//might not be an error
-		log.Println("Cant make outfile larger, not of type Regular File")
+		log.Println("Cant allocate outfile, not of type Regular File")
 		return nil
 	}
-	//if self.Info.Size > 1 {
-	//	log.Println("attemping to resize file that has a predefined size, skipping resize")
-	//	return nil
-	//}
-	file, err := os.OpenFile(self.Path, os.O_RDWR, 0644)
+	file, err := os.OpenFile(self.Path, os.O_RDWR|os.O_TRUNC, 0644)
 	if err != nil {
 		return fmt.Errorf("Error opening file: %v\n", err)
 	}
@@ -31,22 +30,17 @@ func (self *FileObject) resize(size int64) error {
 	if err != nil {
 		return fmt.Errorf("Error truncating file: %v\n", err)
 	}
-	return err
+	self.Info.Size = size
+	return nil
 }
 func (self *FileObject) umountPartitions() error {
-
-	_, stderr, err := run("sudo", "umount", self.Path+"*")
-
-	if err != nil {
-		//erroring doesnt necessarily mean the umount failed, could also mean it wasnt mounted in the first place, fuck it we ball
-		log.Printf("umount command failed: %+v\n", err)
-		log.Printf("%s\n\n", stderr)
-	}
+	//erroring doesnt necessarily mean the umount failed, could also mean it wasnt mounted in the first place, so only log
+	unmountPartitions(self.Path)
 	return nil
 }
 func (self *FileObject) wipeFS() error {
 
-	stdout, stderr, err := run("sudo", "wipefs", "-af", "-b", self.Path)
+	stdout, stderr, err := privileged.Run("wipefs", "-af", "-b", self.Path)
 
 	if err != nil {
 		log.Printf("wipefs command failed: %+v\n", err)
@@ -0,0 +1,466 @@
+package usbimager
+
+/*
+Formatters create the filesystem on a partition. Each one knows the label and uuid rules of
+its filesystem so a bad layout fails before mkfs runs, and builds the mkfs argv directly so
+labels with spaces survive. Partition types map to a default filesystem in
+PARTITION_FILESYSTEMS, types without one (extended, lvm, raid, bios boot) are left unformatted
+*/
+
+import (
+	privileged "LiveBuilder/Privileged"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	FS_VFAT  = "vfat"
+	FS_EXT4  = "ext4"
+	FS_NTFS  = "ntfs"
+	FS_EXFAT = "exfat"
+	FS_BTRFS = "btrfs"
+	FS_SWAP  = "swap"
+)
+
+// Formatter builds and checks the mkfs command line of one filesystem
+type Formatter interface {
+	// Tool is the mkfs binary
+	Tool() string
+	// MountType is the mount -t type, empty for filesystems that are not mounted (swap)
+	MountType() string
+	// Label returns label the way the filesystem stores it, or a *LabelError
+	Label(label string) (string, error)
+	// Args validates options and returns the arguments for Tool
+	Args(device string, options FormatOptions) ([]string, error)
+}
+
+// FormatOptions are the knobs a partition definition can set, zero values keep the mkfs defaults
+type FormatOptions struct {
+	Label           string
+	UUID            string // ext4, btrfs and swap take a uuid, vfat an 8 hex digit volume id
+	ReservedPercent *int   // ext4 blocks reserved for root, mke2fs defaults to 5
+	FATSize         int    // 12, 16 or 32, vfat only
+}
+
+// FORMATTERS are the filesystems partitions can be formatted with, keyed by mount type name
+var FORMATTERS = map[string]Formatter{
+	FS_VFAT:  vfatFormatter{},
+	FS_EXT4:  ext4Formatter{},
+	FS_NTFS:  ntfsFormatter{},
+	FS_EXFAT: exfatFormatter{},
+	FS_BTRFS: btrfsFormatter{},
+	FS_SWAP:  swapFormatter{},
+}
+
+// PARTITION_FILESYSTEMS is the filesystem a partition type gets unless its definition picks one
+var PARTITION_FILESYSTEMS = map[PartitionType]string{
+	FAT12:                  FS_VFAT,
+	FAT16Small:             FS_VFAT,
+	FAT16:                  FS_VFAT,
+	W95_FAT32:              FS_VFAT,
+	W95_FAT32_LBA:          FS_VFAT,
+	EFI_FAT:                FS_VFAT,
+	HiddenFAT12:            FS_VFAT,
+	HiddenFAT16:            FS_VFAT,
+	HPFS_NTFS_exFAT:        FS_NTFS,
+	HiddenHPFS_NTFS:        FS_NTFS,
+	Linux:                  FS_EXT4,
+	LinuxSwap_Solaris:      FS_SWAP,
+	GPT_EFISystem:          FS_VFAT,
+	GPT_LinuxFilesystem:    FS_EXT4,
+	GPT_MicrosoftBasicData: FS_VFAT,
+}
+
+// FAT_SIZES pins the fat width of the types that only exist for one, the rest get FAT32
+var FAT_SIZES = map[PartitionType]int{
+	FAT12:       12,
+	HiddenFAT12: 12,
+	FAT16Small:  16,
+	FAT16:       16,
+	HiddenFAT16: 16,
+}
+
+func FilesystemNames() []string {
+	var names []string
+	for name := range FORMATTERS {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func GetFormatter(name string) (Formatter, error) {
+	formatter, exists := FORMATTERS[name]
+	if !exists {
+		return nil, fmt.Errorf("unknown filesystem %s, expected one of %v", name, FilesystemNames())
+	}
+	return formatter, nil
+}
+
+// LabelError is a label the filesystem cannot store
+type LabelError struct {
+	Filesystem string
+	Label      string
+	Reason     string
+}
+
+func (self *LabelError) Error() string {
+	return fmt.Sprintf("%s label %q %s", self.Filesystem, self.Label, self.Reason)
+}
+
+// OptionError is a format option the filesystem does not support or a value out of range
+type OptionError struct {
+	Filesystem string
+	Option     string
+	Reason     string
+}
+
+func (self *OptionError) Error() string {
+	return fmt.Sprintf("%s option %s %s", self.Filesystem, self.Option, self.Reason)
+}
+
+// MissingToolError means the mkfs binary for a filesystem is not installed
+type MissingToolError struct {
+	Filesystem string
+	Tool       string
+}
+
+func (self *MissingToolError) Error() string {
+	return fmt.Sprintf("%s is needed to create %s filesystems but is not installed", self.Tool, self.Filesystem)
+}
+
+// FormatError is a mkfs run that failed, Stderr holds what it printed
+type FormatError struct {
+	Filesystem string
+	Device     string
+	Stderr     string
+	Err        error
+}
+
+func (self *FormatError) Error() string {
+	return fmt.Sprintf("creating %s on %s: %v %s", self.Filesystem, self.Device, self.Err, strings.TrimSpace(self.Stderr))
+}
+
+func (self *FormatError) Unwrap() error {
+	return self.Err
+}
+
+// FormatPartition creates filesystem on device
+func FormatPartition(filesystem string, device string, options FormatOptions) error {
+	formatter, err := GetFormatter(filesystem)
+	if err != nil {
+		return err
+	}
+	args, err := formatter.Args(device, options)
+	if err != nil {
+		return err
+	}
+	if _, err := lookupTool(formatter.Tool()); err != nil {
+		return &MissingToolError{Filesystem: filesystem, Tool: formatter.Tool()}
+	}
+	log.Printf("MKFS COMMAND: %s %q\n", formatter.Tool(), args)
+	stdout, stderr, err := privileged.Run(formatter.Tool(), args...)
+	log.Printf("stdout (%s)\nstderr (%s)\n", stdout, stderr)
+	if err != nil {
+		return &FormatError{Filesystem: filesystem, Device: device, Stderr: stderr, Err: err}
+	}
+	return nil
+}
+
+// ToolAvailable reports whether the mkfs binary of filesystem is installed
+func ToolAvailable(filesystem string) bool {
+	formatter, err := GetFormatter(filesystem)
+	if err != nil {
+		return false
+	}
+	_, err = lookupTool(formatter.Tool())
+	return err == nil
+}
+
+// lookupTool also searches the sbin directories, which are not in a normal users PATH on debian
+func lookupTool(tool string) (string, error) {
+	if path, err := exec.LookPath(tool); err == nil {
+		return path, nil
+	}
+	for _, dir := range []string{"/usr/local/sbin", "/usr/sbin", "/sbin"} {
+		path := filepath.Join(dir, tool)
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() && info.Mode()&0111 != 0 {
+			return path, nil
+		}
+	}
+	return "", exec.ErrNotFound
+}
+
+// checkLabel rejects labels longer than maxLength (counted by count) or holding control characters
+func checkLabel(filesystem string, label string, maxLength int, count func(string) int) error {
+	if length := count(label); length > maxLength {
+		return &LabelError{Filesystem: filesystem, Label: label, Reason: fmt.Sprintf("is %d long, the limit is %d", length, maxLength)}
+	}
+	for _, char := range label {
+		if char < 0x20 || char == 0x7f {
+			return &LabelError{Filesystem: filesystem, Label: label, Reason: "contains control characters"}
+		}
+	}
+	return nil
+}
+
+func byteCount(label string) int {
+	return len(label)
+}
+
+var UUID_PATTERN = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+func checkUUID(filesystem string, uuid string) error {
+	if !UUID_PATTERN.MatchString(uuid) {
+		return &OptionError{Filesystem: filesystem, Option: "uuid", Reason: fmt.Sprintf("%q is not a uuid", uuid)}
+	}
+	return nil
+}
+
+// rejectOptions fails for options that are set but the filesystem does not support
+func rejectOptions(filesystem string, options FormatOptions, uuid, reserved, fatSize bool) error {
+	unsupported := []struct {
+		option string
+		set    bool
+	}{
+		{"uuid", !uuid && options.UUID != ""},
+		{"reserved percent", !reserved && options.ReservedPercent != nil},
+		{"fat size", !fatSize && options.FATSize != 0},
+	}
+	for _, check := range unsupported {
+		if check.set {
+			return &OptionError{Filesystem: filesystem, Option: check.option, Reason: "is not supported"}
+		}
+	}
+	return nil
+}
+
+type vfatFormatter struct{}
+
+// FAT_LABEL_FORBIDDEN are the characters a FAT volume label may not contain
+const FAT_LABEL_FORBIDDEN = `"*+,./:;<=>?[\]|`
+
+var FAT_VOLUME_ID = regexp.MustCompile(`^[0-9a-fA-F]{4}-?[0-9a-fA-F]{4}$`)
+
+func (vfatFormatter) Tool() string {
+	return "mkfs.vfat"
+}
+
+func (vfatFormatter) MountType() string {
+	return FS_VFAT
+}
+
+// Label uppercases, FAT stores labels in the OEM code page and windows shows them uppercase anyway
+func (vfatFormatter) Label(label string) (string, error) {
+	label = strings.ToUpper(label)
+	if err := checkLabel(FS_VFAT, label, 11, byteCount); err != nil {
+		return "", err
+	}
+	for _, char := range label {
+		if char > 0x7e || strings.ContainsRune(FAT_LABEL_FORBIDDEN, char) {
+			return "", &LabelError{Filesystem: FS_VFAT, Label: label, Reason: fmt.Sprintf("contains %q, which FAT does not allow", char)}
+		}
+	}
+	return label, nil
+}
+
+func (self vfatFormatter) Args(device string, options FormatOptions) ([]string, error) {
+	if err := rejectOptions(FS_VFAT, options, true, false, true); err != nil {
+		return nil, err
+	}
+	fatSize := options.FATSize
+	if fatSize == 0 {
+		fatSize = 32
+	}
+	if fatSize != 12 && fatSize != 16 && fatSize != 32 {
+		return nil, &OptionError{Filesystem: FS_VFAT, Option: "fat size", Reason: fmt.Sprintf("is %d, expected 12, 16 or 32", fatSize)}
+	}
+	args := []string{"-F", strconv.Itoa(fatSize)}
+	if options.Label != "" {
+		label, err := self.Label(options.Label)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, "-n", label)
+	}
+	if options.UUID != "" {
+		if !FAT_VOLUME_ID.MatchString(options.UUID) {
+			return nil, &OptionError{Filesystem: FS_VFAT, Option: "uuid", Reason: fmt.Sprintf("%q is not a volume id like 1234-ABCD", options.UUID)}
+		}
+		args = append(args, "-i", strings.ReplaceAll(options.UUID, "-", ""))
+	}
+	return append(args, device), nil
+}
+
+type ext4Formatter struct{}
+
+func (ext4Formatter) Tool() string {
+	return "mkfs.ext4"
+}
+
+func (ext4Formatter) MountType() string {
+	return FS_EXT4
+}
+
+func (ext4Formatter) Label(label string) (string, error) {
+	return label, checkLabel(FS_EXT4, label, 16, byteCount)
+}
+
+func (self ext4Formatter) Args(device string, options FormatOptions) ([]string, error) {
+	if err := rejectOptions(FS_EXT4, options, true, true, false); err != nil {
+		return nil, err
+	}
+	args := []string{"-F"}
+	if options.Label != "" {
+		if _, err := self.Label(options.Label); err != nil {
+			return nil, err
+		}
+		args = append(args, "-L", options.Label)
+	}
+	if options.UUID != "" {
+		if err := checkUUID(FS_EXT4, options.UUID); err != nil {
+			return nil, err
+		}
+		args = append(args, "-U", options.UUID)
+	}
+	if options.ReservedPercent != nil {
+		if reserved := *options.ReservedPercent; reserved < 0 || reserved > 50 {
+			return nil, &OptionError{Filesystem: FS_EXT4, Option: "reserved percent", Reason: fmt.Sprintf("is %d, expected 0 to 50", reserved)}
+		}
+		args = append(args, "-m", strconv.Itoa(*options.ReservedPercent))
+	}
+	return append(args, device), nil
+}
+
+type ntfsFormatter struct{}
+
+func (ntfsFormatter) Tool() string {
+	return "mkfs.ntfs"
+}
+
+func (ntfsFormatter) MountType() string {
+	return FS_NTFS
+}
+
+func (ntfsFormatter) Label(label string) (string, error) {
+	return label, checkLabel(FS_NTFS, label, 32, utf8.RuneCountInString)
+}
+
+// Args always quick formats, a full format zeroes the whole partition first
+func (self ntfsFormatter) Args(device string, options FormatOptions) ([]string, error) {
+	if err := rejectOptions(FS_NTFS, options, false, false, false); err != nil {
+		return nil, err
+	}
+	args := []string{"-F", "-Q"}
+	if options.Label != "" {
+		if _, err := self.Label(options.Label); err != nil {
+			return nil, err
+		}
+		args = append(args, "-L", options.Label)
+	}
+	return append(args, device), nil
+}
+
+type exfatFormatter struct{}
+
+func (exfatFormatter) Tool() string {
+	return "mkfs.exfat"
+}
+
+func (exfatFormatter) MountType() string {
+	return FS_EXFAT
+}
+
+func (exfatFormatter) Label(label string) (string, error) {
+	return label, checkLabel(FS_EXFAT, label, 11, utf8.RuneCountInString)
+}
+
+func (self exfatFormatter) Args(device string, options FormatOptions) ([]string, error) {
+	if err := rejectOptions(FS_EXFAT, options, false, false, false); err != nil {
+		return nil, err
+	}
+	var args []string
+	if options.Label != "" {
+		if _, err := self.Label(options.Label); err != nil {
+			return nil, err
+		}
+		args = append(args, "-L", options.Label)
+	}
+	return append(args, device), nil
+}
+
+type btrfsFormatter struct{}
+
+func (btrfsFormatter) Tool() string {
+	return "mkfs.btrfs"
+}
+
+func (btrfsFormatter) MountType() string {
+	return FS_BTRFS
+}
+
+func (btrfsFormatter) Label(label string) (string, error) {
+	return label, checkLabel(FS_BTRFS, label, 255, byteCount)
+}
+
+func (self btrfsFormatter) Args(device string, options FormatOptions) ([]string, error) {
+	if err := rejectOptions(FS_BTRFS, options, true, false, false); err != nil {
+		return nil, err
+	}
+	args := []string{"-f"}
+	if options.Label != "" {
+		if _, err := self.Label(options.Label); err != nil {
+			return nil, err
+		}
+		args = append(args, "-L", options.Label)
+	}
+	if options.UUID != "" {
+		if err := checkUUID(FS_BTRFS, options.UUID); err != nil {
+			return nil, err
+		}
+		args = append(args, "-U", options.UUID)
+	}
+	return append(args, device), nil
+}
+
+type swapFormatter struct{}
+
+func (swapFormatter) Tool() string {
+	return "mkswap"
+}
+
+func (swapFormatter) MountType() string {
+	return ""
+}
+
+func (swapFormatter) Label(label string) (string, error) {
+	return label, checkLabel(FS_SWAP, label, 16, byteCount)
+}
+
+func (self swapFormatter) Args(device string, options FormatOptions) ([]string, error) {
+	if err := rejectOptions(FS_SWAP, options, true, false, false); err != nil {
+		return nil, err
+	}
+	var args []string
+	if options.Label != "" {
+		if _, err := self.Label(options.Label); err != nil {
+			return nil, err
+		}
+		args = append(args, "-L", options.Label)
+	}
+	if options.UUID != "" {
+		if err := checkUUID(FS_SWAP, options.UUID); err != nil {
+			return nil, err
+		}
+		args = append(args, "-U", options.UUID)
+	}
+	return append(args, device), nil
+}
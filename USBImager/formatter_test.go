@@ -0,0 +1,99 @@
+package usbimager
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestFormatterArgsKeepLabelsWhole(t *testing.T) {
+	reserved := 0
+	definition := NewPartitionBuilder("/dev/sdb2").
+		WithName("Live System").
+		OfType(Linux).
+		WithFormatOptions(FormatOptions{UUID: "2f6a4b3c-1d2e-4f50-8a9b-0c1d2e3f4a5b", ReservedPercent: &reserved})
+	formatter, err := GetFormatter(definition.Filesystem())
+	if err != nil {
+		t.Fatal(err)
+	}
+	args, err := formatter.Args("/dev/sdb2", definition.FormatOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"-F", "-L", "Live System", "-U", "2f6a4b3c-1d2e-4f50-8a9b-0c1d2e3f4a5b", "-m", "0", "/dev/sdb2"}
+	if strings.Join(args, "|") != strings.Join(expected, "|") {
+		t.Fatalf("expected %q, got %q", expected, args)
+	}
+
+	fat := NewPartitionBuilder("/dev/sdb1").WithName("boot").OfType(FAT16)
+	args, err = vfatFormatter{}.Args("/dev/sdb1", fat.FormatOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(args, " ") != "-F 16 -n BOOT /dev/sdb1" {
+		t.Fatalf("unexpected vfat args %q", args)
+	}
+	if fat.FilesystemLabel() != "BOOT" {
+		t.Fatalf("expected the stored label to be uppercase, got %s", fat.FilesystemLabel())
+	}
+}
+
+func TestFormatterRejectsBadLabelsAndOptions(t *testing.T) {
+	var labelErr *LabelError
+	if _, err := (vfatFormatter{}).Args("/dev/sdb1", FormatOptions{Label: "LIVEBUILDER1"}); !errors.As(err, &labelErr) {
+		t.Errorf("expected a 12 character FAT label to fail with a LabelError, got %v", err)
+	}
+	if _, err := (vfatFormatter{}).Args("/dev/sdb1", FormatOptions{Label: "A/B"}); !errors.As(err, &labelErr) {
+		t.Errorf("expected a FAT label with a slash to fail with a LabelError, got %v", err)
+	}
+	if _, err := (ext4Formatter{}).Args("/dev/sdb2", FormatOptions{Label: "a label over sixteen"}); !errors.As(err, &labelErr) {
+		t.Errorf("expected a 20 byte ext4 label to fail with a LabelError, got %v", err)
+	}
+
+	var optionErr *OptionError
+	reserved := 60
+	if _, err := (ext4Formatter{}).Args("/dev/sdb2", FormatOptions{ReservedPercent: &reserved}); !errors.As(err, &optionErr) {
+		t.Errorf("expected 60%% reserved blocks to fail with an OptionError, got %v", err)
+	}
+	if _, err := (ntfsFormatter{}).Args("/dev/sdb2", FormatOptions{UUID: "2f6a4b3c-1d2e-4f50-8a9b-0c1d2e3f4a5b"}); !errors.As(err, &optionErr) {
+		t.Errorf("expected a uuid for ntfs to fail with an OptionError, got %v", err)
+	}
+
+	for _, partType := range []PartitionType{Extended, LinuxLVM, LinuxRAID, GPT_BIOSBoot, FreeBSD} {
+		definition := NewPartitionBuilder("/dev/sdb1").OfType(partType)
+		if definition.Filesystem() != "" || definition.CheckFormat() != nil {
+			t.Errorf("expected %s to be left unformatted", PartitionsCodeToName[partType])
+		}
+	}
+}
+
+func TestMissingToolIsReportedBeforeFormatting(t *testing.T) {
+	FORMATTERS["test"] = fakeToolFormatter{"livebuilder-no-such-mkfs"}
+	defer delete(FORMATTERS, "test")
+
+	definition := NewPartitionBuilder("/dev/sdb1").WithFilesystem("test")
+	var missing *MissingToolError
+	if err := definition.CheckFormat(); !errors.As(err, &missing) || missing.Tool != "livebuilder-no-such-mkfs" {
+		t.Fatalf("expected a MissingToolError, got %v", err)
+	}
+}
+
+type fakeToolFormatter struct {
+	tool string
+}
+
+func (self fakeToolFormatter) Tool() string {
+	return self.tool
+}
+
+func (fakeToolFormatter) MountType() string {
+	return ""
+}
+
+func (fakeToolFormatter) Label(label string) (string, error) {
+	return swapFormatter{}.Label(label)
+}
+
+func (fakeToolFormatter) Args(device string, options FormatOptions) ([]string, error) {
+	return swapFormatter{}.Args(device, options)
+}
@@ -0,0 +1,283 @@
+package usbimager
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strings"
+	"unicode/utf16"
+)
+
+const (
+	GPT_ENTRY_COUNT   = 128
+	GPT_ENTRY_SIZE    = 128
+	GPT_TABLE_SECTORS = GPT_ENTRY_COUNT * GPT_ENTRY_SIZE / SECTOR_SIZE
+
+	gptSignature      = "EFI PART"
+	gptRevision       = 0x00010000
+	gptHeaderSize     = 92
+	gptNameUnits      = 36 // utf-16 code units in a partition name
+	gptLegacyBootable = 1 << 2
+)
+
+// gptUsableRange leaves room for the protective mbr, both headers and both entry arrays
+func gptUsableRange(diskSectors uint64) (uint64, uint64) {
+	return 2 + GPT_TABLE_SECTORS, diskSectors - GPT_TABLE_SECTORS - 2
+}
+
+type gptHeader struct {
+	currentLBA  uint64
+	backupLBA   uint64
+	firstUsable uint64
+	lastUsable  uint64
+	diskGUID    string
+	entriesLBA  uint64
+	entryCount  uint32
+	entrySize   uint32
+	entriesCRC  uint32
+}
+
+func encodeGPTLabel(label *DiskLabel) ([]diskRegion, error) {
+	if label.Sectors < 2*(GPT_TABLE_SECTORS+2)+1 {
+		return nil, fmt.Errorf("disk of %d sectors is too small for a gpt", label.Sectors)
+	}
+	entries := make([]byte, GPT_TABLE_SECTORS*SECTOR_SIZE)
+	for _, partition := range label.Partitions {
+		if partition.Number < 1 || partition.Number > GPT_ENTRY_COUNT {
+			return nil, fmt.Errorf("gpt partition number %d out of range", partition.Number)
+		}
+		if err := encodeGPTEntry(entries[(partition.Number-1)*GPT_ENTRY_SIZE:][:GPT_ENTRY_SIZE], partition); err != nil {
+			return nil, fmt.Errorf("partition %d: %w", partition.Number, err)
+		}
+	}
+
+	firstUsable, lastUsable := gptUsableRange(label.Sectors)
+	lastLBA := label.Sectors - 1
+	primary := gptHeader{
+		currentLBA:  1,
+		backupLBA:   lastLBA,
+		firstUsable: firstUsable,
+		lastUsable:  lastUsable,
+		diskGUID:    label.DiskID,
+		entriesLBA:  2,
+		entryCount:  GPT_ENTRY_COUNT,
+		entrySize:   GPT_ENTRY_SIZE,
+		entriesCRC:  crc32.ChecksumIEEE(entries),
+	}
+	backup := primary
+	backup.currentLBA, backup.backupLBA = lastLBA, 1
+	backup.entriesLBA = lastLBA - GPT_TABLE_SECTORS
+
+	primarySector, err := encodeGPTHeader(primary)
+	if err != nil {
+		return nil, err
+	}
+	backupSector, err := encodeGPTHeader(backup)
+	if err != nil {
+		return nil, err
+	}
+
+	//protective mbr covering the whole disk, capped at what 32 bits can express
+	protectiveSectors := lastLBA
+	if protectiveSectors > 0xFFFFFFFF {
+		protectiveSectors = 0xFFFFFFFF
+	}
+	protective, err := encodeMBR(0, []LabelPartition{{
+		Number:  1,
+		Start:   1,
+		Sectors: protectiveSectors,
+		Type:    MBR_TYPE_GPT_PROTECTIVE,
+	}})
+	if err != nil {
+		return nil, err
+	}
+
+	return []diskRegion{
+		{offset: 0, data: protective},
+		{offset: SECTOR_SIZE, data: primarySector},
+		{offset: int64(primary.entriesLBA) * SECTOR_SIZE, data: entries},
+		{offset: int64(backup.entriesLBA) * SECTOR_SIZE, data: entries},
+		{offset: int64(backup.currentLBA) * SECTOR_SIZE, data: backupSector},
+	}, nil
+}
+
+func encodeGPTHeader(header gptHeader) ([]byte, error) {
+	guid, err := encodeGUID(header.diskGUID)
+	if err != nil {
+		return nil, fmt.Errorf("disk guid: %w", err)
+	}
+	sector := make([]byte, SECTOR_SIZE)
+	copy(sector[0:8], gptSignature)
+	binary.LittleEndian.PutUint32(sector[8:], gptRevision)
+	binary.LittleEndian.PutUint32(sector[12:], gptHeaderSize)
+	binary.LittleEndian.PutUint64(sector[24:], header.currentLBA)
+	binary.LittleEndian.PutUint64(sector[32:], header.backupLBA)
+	binary.LittleEndian.PutUint64(sector[40:], header.firstUsable)
+	binary.LittleEndian.PutUint64(sector[48:], header.lastUsable)
+	copy(sector[56:72], guid)
+	binary.LittleEndian.PutUint64(sector[72:], header.entriesLBA)
+	binary.LittleEndian.PutUint32(sector[80:], header.entryCount)
+	binary.LittleEndian.PutUint32(sector[84:], header.entrySize)
+	binary.LittleEndian.PutUint32(sector[88:], header.entriesCRC)
+	//the header crc is computed with its own field zeroed
+	binary.LittleEndian.PutUint32(sector[16:], crc32.ChecksumIEEE(sector[:gptHeaderSize]))
+	return sector, nil
+}
+
+func encodeGPTEntry(entry []byte, partition LabelPartition) error {
+	typeGUID, err := encodeGUID(string(partition.Type))
+	if err != nil {
+		return fmt.Errorf("type guid: %w", err)
+	}
+	uniqueGUID, err := encodeGUID(partition.GUID)
+	if err != nil {
+		return fmt.Errorf("partition guid: %w", err)
+	}
+	name := utf16.Encode([]rune(partition.Name))
+	if len(name) > gptNameUnits {
+		return fmt.Errorf("name %q is longer than %d characters", partition.Name, gptNameUnits)
+	}
+	copy(entry[0:16], typeGUID)
+	copy(entry[16:32], uniqueGUID)
+	binary.LittleEndian.PutUint64(entry[32:], partition.Start)
+	binary.LittleEndian.PutUint64(entry[40:], partition.End())
+	if partition.Bootable {
+		binary.LittleEndian.PutUint64(entry[48:], gptLegacyBootable)
+	}
+	for i, unit := range name {
+		binary.LittleEndian.PutUint16(entry[56+i*2:], unit)
+	}
+	return nil
+}
+
+// decodeGPTLabel reads the primary gpt, falling back to the backup when the primary
+// header or its entries fail their crc
+func decodeGPTLabel(r io.ReaderAt, diskSectors uint64) (*DiskLabel, error) {
+	label, primaryErr := readGPT(r, 1)
+	if primaryErr == nil {
+		label.Sectors = diskSectors
+		return label, nil
+	}
+	label, backupErr := readGPT(r, diskSectors-1)
+	if backupErr != nil {
+		return nil, fmt.Errorf("primary gpt: %v, backup gpt: %v", primaryErr, backupErr)
+	}
+	label.Sectors = diskSectors
+	return label, nil
+}
+
+func readGPT(r io.ReaderAt, headerLBA uint64) (*DiskLabel, error) {
+	sector := make([]byte, SECTOR_SIZE)
+	if _, err := r.ReadAt(sector, int64(headerLBA)*SECTOR_SIZE); err != nil {
+		return nil, err
+	}
+	if string(sector[0:8]) != gptSignature {
+		return nil, fmt.Errorf("no gpt header at sector %d", headerLBA)
+	}
+	headerSize := binary.LittleEndian.Uint32(sector[12:])
+	if headerSize < gptHeaderSize || headerSize > SECTOR_SIZE {
+		return nil, fmt.Errorf("invalid gpt header size %d", headerSize)
+	}
+	expectedCRC := binary.LittleEndian.Uint32(sector[16:])
+	header := make([]byte, headerSize)
+	copy(header, sector)
+	binary.LittleEndian.PutUint32(header[16:], 0)
+	if crc32.ChecksumIEEE(header) != expectedCRC {
+		return nil, fmt.Errorf("gpt header crc mismatch at sector %d", headerLBA)
+	}
+
+	entriesLBA := binary.LittleEndian.Uint64(sector[72:])
+	entryCount := binary.LittleEndian.Uint32(sector[80:])
+	entrySize := binary.LittleEndian.Uint32(sector[84:])
+	if entrySize < GPT_ENTRY_SIZE || entryCount > 1024 {
+		return nil, fmt.Errorf("unsupported gpt entry layout %d x %d", entryCount, entrySize)
+	}
+	entries := make([]byte, int(entryCount)*int(entrySize))
+	if _, err := r.ReadAt(entries, int64(entriesLBA)*SECTOR_SIZE); err != nil {
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(entries) != binary.LittleEndian.Uint32(sector[88:]) {
+		return nil, fmt.Errorf("gpt entries crc mismatch for header at sector %d", headerLBA)
+	}
+
+	label := &DiskLabel{
+		Type:   TABLETYPE_GPT,
+		DiskID: decodeGUID(sector[56:72]),
+	}
+	for i := 0; i < int(entryCount); i++ {
+		entry := entries[i*int(entrySize):][:entrySize]
+		if isZero(entry[0:16]) {
+			continue
+		}
+		first := binary.LittleEndian.Uint64(entry[32:])
+		last := binary.LittleEndian.Uint64(entry[40:])
+		var name []uint16
+		for offset := 56; offset+1 < GPT_ENTRY_SIZE; offset += 2 {
+			unit := binary.LittleEndian.Uint16(entry[offset:])
+			if unit == 0 {
+				break
+			}
+			name = append(name, unit)
+		}
+		label.Partitions = append(label.Partitions, LabelPartition{
+			Number:   i + 1,
+			Start:    first,
+			Sectors:  last - first + 1,
+			Type:     PartitionType(decodeGUID(entry[0:16])),
+			GUID:     decodeGUID(entry[16:32]),
+			Name:     string(utf16.Decode(name)),
+			Bootable: binary.LittleEndian.Uint64(entry[48:])&gptLegacyBootable != 0,
+		})
+	}
+	return label, nil
+}
+
+// encodeGUID converts the textual form to the on disk layout, the first three fields are little endian
+func encodeGUID(guid string) ([]byte, error) {
+	raw, err := hex.DecodeString(strings.ReplaceAll(guid, "-", ""))
+	if err != nil || len(raw) != 16 || len(guid) != 36 {
+		return nil, fmt.Errorf("invalid guid %q", guid)
+	}
+	out := make([]byte, 16)
+	for i := 0; i < 4; i++ {
+		out[i] = raw[3-i]
+	}
+	out[4], out[5] = raw[5], raw[4]
+	out[6], out[7] = raw[7], raw[6]
+	copy(out[8:], raw[8:])
+	return out, nil
+}
+
+func decodeGUID(data []byte) string {
+	raw := make([]byte, 16)
+	for i := 0; i < 4; i++ {
+		raw[i] = data[3-i]
+	}
+	raw[4], raw[5] = data[5], data[4]
+	raw[6], raw[7] = data[7], data[6]
+	copy(raw[8:], data[8:16])
+	text := strings.ToUpper(hex.EncodeToString(raw))
+	return text[0:8] + "-" + text[8:12] + "-" + text[12:16] + "-" + text[16:20] + "-" + text[20:32]
+}
+
+// randomGUID returns a version 4 guid
+func randomGUID() string {
+	raw := make([]byte, 16)
+	rand.Read(raw)
+	raw[6] = raw[6]&0x0F | 0x40
+	raw[8] = raw[8]&0x3F | 0x80
+	text := strings.ToUpper(hex.EncodeToString(raw))
+	return text[0:8] + "-" + text[8:12] + "-" + text[12:16] + "-" + text[16:20] + "-" + text[20:32]
+}
+
+func isZero(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,170 @@
+package usbimager
+
+/*
+Image file output. When the target is a regular file ImageUSB produces a disk image that
+people can flash with their own tools: the file is allocated sparse at the size the layout
+needs (or ImageFileOptions.Size), and once written and verified it can be compressed and
+gets a sha256sum style checksum file next to it
+*/
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+)
+
+const (
+	COMPRESSION_NONE = "none"
+	COMPRESSION_XZ   = "xz"
+	COMPRESSION_ZSTD = "zstd"
+
+	CHECKSUM_EXTENSION = ".sha256"
+)
+
+type compressor struct {
+	command   []string // reads the image on stdin and writes the compressed stream to stdout
+	extension string
+}
+
+var COMPRESSORS = map[string]compressor{
+	COMPRESSION_XZ:   {command: []string{"xz", "-T0", "-c", "-q"}, extension: ".xz"},
+	COMPRESSION_ZSTD: {command: []string{"zstd", "-T0", "-c", "-q"}, extension: ".zst"},
+}
+
+func CompressionNames() []string {
+	names := []string{COMPRESSION_NONE}
+	for name := range COMPRESSORS {
+		names = append(names, name)
+	}
+	sort.Strings(names[1:])
+	return names
+}
+
+type ImageFileOptions struct {
+	Size        string // size of the image eg "8G", empty sizes it from the iso, headroom and persistence
+	Compression string // COMPRESSION_XZ or COMPRESSION_ZSTD, the uncompressed image is replaced
+	Checksum    bool   // write <image>.sha256 for the final file
+}
+
+func (self *ImageFileOptions) validate() error {
+	if self.Size != "" {
+		if _, err := parseSize(self.Size); err != nil {
+			return fmt.Errorf("image size: %w", err)
+		}
+	}
+	if self.Compression == "" || self.Compression == COMPRESSION_NONE {
+		return nil
+	}
+	compressor, exists := COMPRESSORS[self.Compression]
+	if !exists {
+		return fmt.Errorf("unknown compression %s, expected one of %v", self.Compression, CompressionNames())
+	}
+	if _, err := exec.LookPath(compressor.command[0]); err != nil {
+		return fmt.Errorf("%s compression needs %s installed", self.Compression, compressor.command[0])
+	}
+	return nil
+}
+
+// imageFileSize is ImageFileOptions.Size when set, otherwise what the layout needs
+func (self *imageJob) imageFileSize() (int64, error) {
+	required, err := self.requiredSize()
+	if err != nil {
+		return 0, err
+	}
+	options := self.imager.ImageFile
+	if options == nil || options.Size == "" {
+		return required, nil
+	}
+	size, err := parseSize(options.Size)
+	if err != nil {
+		return 0, err
+	}
+	if size < required {
+		return 0, fmt.Errorf("image size %s is smaller than the %s the layout needs", formatBytes(size), formatBytes(required))
+	}
+	return size, nil
+}
+
+func (self *imageJob) compressImage() error {
+	options := self.imager.ImageFile
+	if self.target.Type != TypeRegularFile || options == nil {
+		return nil
+	}
+	compressor, exists := COMPRESSORS[options.Compression]
+	if !exists {
+		return nil
+	}
+	compressed := self.artifact + compressor.extension
+	if err := compressFile(self.artifact, compressed, compressor.command, self.progress()); err != nil {
+		os.Remove(compressed)
+		return err
+	}
+	log.Printf("Compressed %s to %s\n", self.artifact, compressed)
+	if err := os.Remove(self.artifact); err != nil {
+		return err
+	}
+	self.artifact = compressed
+	return nil
+}
+
+func compressFile(source, destination string, command []string, progress *progressTracker) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	progress.setTotal(info.Size())
+
+	out, err := os.Create(destination)
+	if err != nil {
+		return err
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stdin = io.TeeReader(in, progress)
+	cmd.Stdout = out
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		out.Close()
+		return fmt.Errorf("%s: %v %s", command[0], err, stderr.String())
+	}
+	return (&syncingFile{out}).Close()
+}
+
+// writeChecksum writes the final images sha256 in the format sha256sum -c reads
+func (self *imageJob) writeChecksum() error {
+	options := self.imager.ImageFile
+	if self.target.Type != TypeRegularFile || options == nil || !options.Checksum {
+		return nil
+	}
+	sum, err := hashFile(self.artifact, sha256.New, self.progress().sized(self.artifact))
+	if err != nil {
+		return err
+	}
+	checksumFile := self.artifact + CHECKSUM_EXTENSION
+	line := fmt.Sprintf("%s  %s\n", sum, filepath.Base(self.artifact))
+	if err := os.WriteFile(checksumFile, []byte(line), 0644); err != nil {
+		return err
+	}
+	log.Printf("Wrote %s\n", checksumFile)
+	self.checksum = checksumFile
+	return nil
+}
+
+// sized sets the trackers total to the size of path
+func (self *progressTracker) sized(path string) *progressTracker {
+	if info, err := os.Stat(path); err == nil {
+		self.setTotal(info.Size())
+	}
+	return self
+}
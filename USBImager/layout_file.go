@@ -0,0 +1,297 @@
+package usbimager
+
+/*
+Disk layouts are json or yaml files describing the partition table the partition strategy
+writes. The shipped ones are embedded from staticfiles/DiskLayouts and extracted to the app
+data dir, where they can be edited or joined by new ones. A layout is validated when it is
+loaded and planned against the real target size before anything is written to it
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	FLAG_BOOTABLE = "bootable"
+
+	// layouts are planned on a disk this large when loaded so overlaps show up without a target
+	LAYOUT_CHECK_SECTORS = 1 << 40 / SECTOR_SIZE
+)
+
+// LAYOUT_EXTENSIONS are the file types LoadLayouts picks up, the name is the file name without them
+var LAYOUT_EXTENSIONS = []string{".json", ".yaml", ".yml"}
+
+// LAYOUT_TABLES maps the table names a layout may use to table types
+var LAYOUT_TABLES = map[string]TableType{
+	"mbr": TABLETYPE_MBR,
+	"dos": TABLETYPE_MBR,
+	"gpt": TABLETYPE_GPT,
+}
+
+type LayoutFile struct {
+	Name        string            `json:"-" yaml:"-"`
+	Description string            `json:"description,omitempty" yaml:"description,omitempty"`
+	Table       string            `json:"table" yaml:"table"` // mbr or gpt
+	Partitions  []LayoutPartition `json:"partitions" yaml:"partitions"`
+}
+
+type LayoutPartition struct {
+	Name            string        `json:"name" yaml:"name"`
+	Start           string        `json:"start,omitempty" yaml:"start,omitempty"` // sector, default follows the previous partition
+	Size            string        `json:"size,omitempty" yaml:"size,omitempty"`   // eg 512M or 25% of the disk, empty for the rest
+	Type            string        `json:"type" yaml:"type"`                       // code, gpt guid or name eg "EFI System"
+	Filesystem      string        `json:"filesystem,omitempty" yaml:"filesystem,omitempty"`
+	Label           string        `json:"label,omitempty" yaml:"label,omitempty"` // defaults to the name
+	UUID            string        `json:"uuid,omitempty" yaml:"uuid,omitempty"`
+	ReservedPercent *int          `json:"reserved_percent,omitempty" yaml:"reserved_percent,omitempty"`
+	FATSize         int           `json:"fat_size,omitempty" yaml:"fat_size,omitempty"`
+	Flags           []string      `json:"flags,omitempty" yaml:"flags,omitempty"`
+	Role            PartitionRole `json:"role,omitempty" yaml:"role,omitempty"`
+}
+
+// layoutName is the layout name of a file, empty when the file is not a layout
+func layoutName(fileName string) string {
+	for _, extension := range LAYOUT_EXTENSIONS {
+		if strings.HasSuffix(fileName, extension) {
+			return strings.TrimSuffix(fileName, extension)
+		}
+	}
+	return ""
+}
+
+// ParseLayout decodes and validates a layout file, yaml is picked by the file extension
+func ParseLayout(fileName string, data []byte) (*LayoutFile, error) {
+	name := layoutName(filepath.Base(fileName))
+	if name == "" {
+		return nil, fmt.Errorf("%s is not a layout file, expected one of %v", fileName, LAYOUT_EXTENSIONS)
+	}
+	layout := &LayoutFile{}
+	if filepath.Ext(fileName) == ".json" {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(layout); err != nil {
+			return nil, fmt.Errorf("layout %s: %v", name, err)
+		}
+	} else {
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(layout); err != nil {
+			return nil, fmt.Errorf("layout %s: %v", name, err)
+		}
+	}
+	layout.Name = name
+	if err := layout.Validate(); err != nil {
+		return nil, err
+	}
+	return layout, nil
+}
+
+// Validate reports every problem with the layout at once, the imager needs exactly one esp
+// and one live partition and the table has to plan without overlaps
+func (self *LayoutFile) Validate() error {
+	var errs []error
+	tableType, exists := LAYOUT_TABLES[self.Table]
+	if !exists {
+		errs = append(errs, fmt.Errorf("unknown table %q, expected mbr or gpt", self.Table))
+	}
+	if len(self.Partitions) == 0 {
+		errs = append(errs, fmt.Errorf("no partitions"))
+	}
+
+	roles := make(map[PartitionRole]int)
+	percentTotal := 0
+	percentSeen := false
+	for i, partition := range self.Partitions {
+		number := i + 1
+		if err := partition.validate(tableType); err != nil {
+			errs = append(errs, fmt.Errorf("partition %d (%s): %w", number, partition.Name, err))
+		}
+		if partition.Role != ROLE_NONE {
+			roles[partition.Role]++
+		}
+		if partition.Size == "" && number != len(self.Partitions) {
+			errs = append(errs, fmt.Errorf("partition %d (%s) has no size, only the last partition can take the rest of the disk", number, partition.Name))
+		}
+		if partition.Start != "" && percentSeen {
+			errs = append(errs, fmt.Errorf("partition %d (%s) has a fixed start after a percentage sized partition", number, partition.Name))
+		}
+		if percent, ok, err := parsePercentSize(partition.Size); ok && err == nil {
+			percentTotal += percent
+			percentSeen = true
+		}
+	}
+	if percentTotal > 100 || (percentTotal == 100 && len(self.Partitions) > 1) {
+		errs = append(errs, fmt.Errorf("percentage sizes add up to %d%%, leaving no room for the other partitions", percentTotal))
+	}
+	for _, role := range []PartitionRole{ROLE_ESP, ROLE_LIVE} {
+		if roles[role] == 0 {
+			errs = append(errs, fmt.Errorf("no partition with role %s", role))
+		}
+	}
+	for role, count := range roles {
+		if count > 1 {
+			errs = append(errs, fmt.Errorf("%d partitions with role %s, only one is allowed", count, role))
+		}
+	}
+
+	if len(errs) == 0 {
+		if _, err := self.Build(FileObject{Path: "/dev/sdx"}).PartitionTable.Plan(LAYOUT_CHECK_SECTORS); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("layout %s: %w", self.Name, err)
+	}
+	return nil
+}
+
+func (self LayoutPartition) validate(tableType TableType) error {
+	partType, err := self.partitionType()
+	if err != nil {
+		return err
+	}
+	if tableType != "" {
+		if _, err := partType.ForTable(tableType); err != nil {
+			return err
+		}
+	}
+	if percent, ok, err := parsePercentSize(self.Size); ok {
+		if err != nil {
+			return err
+		}
+		if percent < 1 || percent > 100 {
+			return fmt.Errorf("size %s is not between 1%% and 100%%", self.Size)
+		}
+	} else if self.Size != "" {
+		if _, err := parseSize(self.Size); err != nil {
+			return err
+		}
+	}
+	if self.Start != "" {
+		if _, err := strconv.ParseUint(self.Start, 10, 64); err != nil {
+			return fmt.Errorf("invalid start %q, expected a sector", self.Start)
+		}
+	}
+	for _, flag := range self.Flags {
+		if flag != FLAG_BOOTABLE {
+			return fmt.Errorf("unknown flag %q", flag)
+		}
+	}
+
+	definition := self.definition("/dev/sdx1", partType)
+	filesystem := definition.Filesystem()
+	switch self.Role {
+	case ROLE_NONE:
+	case ROLE_ESP:
+		if filesystem != FS_VFAT {
+			return fmt.Errorf("the esp has to be vfat, got %q", filesystem)
+		}
+	case ROLE_LIVE, ROLE_PERSISTENCE:
+		if filesystem == "" || filesystem == FS_SWAP {
+			return fmt.Errorf("a %s partition needs a mountable filesystem, got %q", self.Role, filesystem)
+		}
+	default:
+		return fmt.Errorf("unknown role %q, expected %s, %s or %s", self.Role, ROLE_ESP, ROLE_LIVE, ROLE_PERSISTENCE)
+	}
+	if self.Role == ROLE_PERSISTENCE && definition.FormatOptions().Label != PERSISTENCE_LABEL {
+		return fmt.Errorf("live-boot only finds persistence partitions labelled %s", PERSISTENCE_LABEL)
+	}
+	if filesystem == "" {
+		return nil
+	}
+	formatter, err := GetFormatter(filesystem)
+	if err != nil {
+		return err
+	}
+	_, err = formatter.Args("/dev/sdx1", definition.FormatOptions())
+	return err
+}
+
+// partitionType accepts a type name from PartitionNameToCode, an mbr code or a gpt guid
+func (self LayoutPartition) partitionType() (PartitionType, error) {
+	if partType, exists := PartitionNameToCode[self.Type]; exists {
+		return partType, nil
+	}
+	partType := PartitionType(strings.ToUpper(self.Type))
+	if _, exists := PartitionsCodeToName[partType]; exists {
+		return partType, nil
+	}
+	if partType.IsGPT() && strings.Count(string(partType), "-") == 4 {
+		return partType, nil
+	}
+	return "", fmt.Errorf("unknown partition type %q", self.Type)
+}
+
+func (self LayoutPartition) definition(device string, partType PartitionType) *PartitionDefinitionBuilder {
+	definition := NewPartitionBuilder(device).
+		WithName(self.Name).
+		OfType(partType).
+		WithRole(self.Role).
+		WithFilesystem(self.Filesystem)
+	if self.Start != "" {
+		definition.StartAt(self.Start)
+	}
+	if self.Size != "" {
+		definition.WithSize(self.Size)
+	}
+	options := FormatOptions{
+		Label:           self.Label,
+		UUID:            self.UUID,
+		ReservedPercent: self.ReservedPercent,
+		FATSize:         self.FATSize,
+	}
+	//live-boot looks the partition up by label, not by its partition name
+	if self.Role == ROLE_PERSISTENCE && options.Label == "" {
+		options.Label = PERSISTENCE_LABEL
+	}
+	definition.WithFormatOptions(options)
+	for _, flag := range self.Flags {
+		if flag == FLAG_BOOTABLE {
+			definition.SetBootable(true)
+		}
+	}
+	return definition
+}
+
+// Build turns a validated layout into a partitioner for target
+func (self *LayoutFile) Build(target FileObject) *DiskPartitionare {
+	table := NewPartitionTable(LAYOUT_TABLES[self.Table])
+	for i, partition := range self.Partitions {
+		partType, _ := partition.partitionType()
+		table.WithPartitionDefinition(partition.definition(partitionDevicePath(target.Path, i+1), partType))
+	}
+	diskpart := NewDiskPartionare(target)
+	diskpart.SetPartitionTable(table)
+	return diskpart
+}
+
+// HasRole reports whether the layout brings its own partition for role
+func (self *LayoutFile) HasRole(role PartitionRole) bool {
+	for _, partition := range self.Partitions {
+		if partition.Role == role {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePercentSize reads sizes like "25%", ok is false for sizes that are not percentages
+func parsePercentSize(value string) (percent int, ok bool, err error) {
+	trimmed := strings.TrimSpace(value)
+	if !strings.HasSuffix(trimmed, "%") {
+		return 0, false, nil
+	}
+	percent, err = strconv.Atoi(strings.TrimSpace(strings.TrimSuffix(trimmed, "%")))
+	if err != nil || percent <= 0 {
+		return 0, true, fmt.Errorf("invalid size %q", value)
+	}
+	return percent, true, nil
+}
@@ -0,0 +1,153 @@
+package usbimager
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func buildLayout(t *testing.T, name string, target FileObject) *DiskPartitionare {
+	t.Helper()
+	layout, err := GetLayout(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return layout.Build(target)
+}
+
+const percentLayout = `
+description: live system on a third of the disk, persistence on the rest
+table: gpt
+partitions:
+  - name: BOOT
+    size: 256M
+    type: EFI System
+    role: esp
+  - name: SYSTEM
+    size: 30%
+    type: Linux filesystem
+    filesystem: ext4
+    role: live
+  - name: data
+    type: 0FC63DAF-8483-4772-8E79-3D69D8477DE4
+    role: persistence
+`
+
+func TestLayoutFilesPlanPercentages(t *testing.T) {
+	for _, name := range []string{LAYOUT_MBR, LAYOUT_HYBRID_GPT} {
+		if _, err := GetLayout(name); err != nil {
+			t.Fatalf("shipped layout %s did not load: %v", name, err)
+		}
+	}
+
+	layout, err := ParseLayout("persistent.yaml", []byte(percentLayout))
+	if err != nil {
+		t.Fatal(err)
+	}
+	diskpart := layout.Build(FileObject{Path: "/dev/nvme0n1"})
+	if diskpart.PartitionPath(3) != "/dev/nvme0n1p3" {
+		t.Errorf("unexpected partition path %s", diskpart.PartitionPath(3))
+	}
+	_, persistence, err := diskpart.PartitionWithRole(ROLE_PERSISTENCE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if persistence.FilesystemLabel() != PERSISTENCE_LABEL {
+		t.Errorf("expected the persistence partition to be labelled %s, got %s", PERSISTENCE_LABEL, persistence.FilesystemLabel())
+	}
+
+	const diskSectors = 16 * 1024 * 1024 * 1024 / SECTOR_SIZE
+	label, err := diskpart.PartitionTable.Plan(diskSectors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, last := gptUsableRange(diskSectors)
+	expected := (last - alignUp(first) + 1) * 30 / 100 / ALIGNMENT_SECTORS * ALIGNMENT_SECTORS
+	if label.Partitions[1].Sectors != expected || label.Partitions[1].Start%ALIGNMENT_SECTORS != 0 {
+		t.Errorf("expected the live partition to take %d aligned sectors, got %+v", expected, label.Partitions[1])
+	}
+	if label.Partitions[2].End() != last {
+		t.Errorf("expected persistence to end on the last usable sector %d, got %d", last, label.Partitions[2].End())
+	}
+}
+
+func TestLayoutValidationReportsProblems(t *testing.T) {
+	broken := map[string]string{
+		"no live partition":   `{"table": "mbr", "partitions": [{"name": "BOOT", "size": "512M", "type": "0C", "role": "esp"}]}`,
+		"unknown table":       `{"table": "apm", "partitions": [{"name": "BOOT", "size": "512M", "type": "0C", "role": "esp"}, {"name": "SYSTEM", "type": "83", "role": "live"}]}`,
+		"gpt type in mbr":     `{"table": "mbr", "partitions": [{"name": "BOOT", "size": "512M", "type": "EFI System", "role": "esp"}, {"name": "SYSTEM", "type": "83", "role": "live"}]}`,
+		"overlap":             `{"table": "mbr", "partitions": [{"name": "BOOT", "start": "2048", "size": "512M", "type": "0C", "role": "esp"}, {"name": "SYSTEM", "start": "4096", "type": "83", "role": "live"}]}`,
+		"size-less middle":    `{"table": "mbr", "partitions": [{"name": "BOOT", "type": "0C", "role": "esp"}, {"name": "SYSTEM", "type": "83", "role": "live"}]}`,
+		"over 100 percent":    `{"table": "mbr", "partitions": [{"name": "BOOT", "size": "60%", "type": "0C", "role": "esp"}, {"name": "SYSTEM", "size": "60%", "type": "83", "role": "live"}]}`,
+		"esp not vfat":        `{"table": "mbr", "partitions": [{"name": "BOOT", "size": "512M", "type": "83", "role": "esp"}, {"name": "SYSTEM", "type": "83", "role": "live"}]}`,
+		"fat label too long":  `{"table": "mbr", "partitions": [{"name": "BOOT", "label": "LIVEBUILDER1", "size": "512M", "type": "0C", "role": "esp"}, {"name": "SYSTEM", "type": "83", "role": "live"}]}`,
+		"unknown flag":        `{"table": "mbr", "partitions": [{"name": "BOOT", "size": "512M", "type": "0C", "flags": ["hidden"], "role": "esp"}, {"name": "SYSTEM", "type": "83", "role": "live"}]}`,
+		"misspelt field":      `{"table": "mbr", "partitons": []}`,
+		"persistence label":   `{"table": "mbr", "partitions": [{"name": "BOOT", "size": "512M", "type": "0C", "role": "esp"}, {"name": "SYSTEM", "size": "4G", "type": "83", "role": "live"}, {"name": "data", "label": "data", "type": "83", "role": "persistence"}]}`,
+		"two live partitions": `{"table": "gpt", "partitions": [{"name": "BOOT", "size": "512M", "type": "EF", "role": "esp"}, {"name": "A", "size": "4G", "type": "83", "role": "live"}, {"name": "B", "type": "83", "role": "live"}]}`,
+	}
+	for problem, data := range broken {
+		if _, err := ParseLayout("broken.json", []byte(data)); err == nil {
+			t.Errorf("expected a layout with %s to be rejected", problem)
+		}
+	}
+
+	job := &imageJob{
+		imager:   NewUSBImager(),
+		iso:      FileObject{Info: &SystemFileInfo{Size: 3 * 1024 * 1024 * 1024}},
+		target:   FileObject{Path: "/dev/sdb", Type: TypeBlockDevice},
+		diskpart: buildLayout(t, LAYOUT_MBR, FileObject{Path: "/dev/sdb"}),
+	}
+	if err := job.checkLayoutFits(2 * 1024 * 1024 * 1024 / SECTOR_SIZE); err == nil || !strings.Contains(err.Error(), "can not hold") {
+		t.Errorf("expected a 3G iso not to fit a 2G stick, got %v", err)
+	}
+	if err := job.checkLayoutFits(256 * 1024 * 1024 / SECTOR_SIZE); err == nil || !strings.Contains(err.Error(), "does not fit") {
+		t.Errorf("expected the 512M boot partition not to fit a 256M stick, got %v", err)
+	}
+	if err := job.checkLayoutFits(8 * 1024 * 1024 * 1024 / SECTOR_SIZE); err != nil {
+		t.Errorf("expected the layout to fit an 8G stick: %v", err)
+	}
+}
+
+func TestLoadLayoutsFromDirectory(t *testing.T) {
+	saved := LAYOUTS
+	LAYOUTS = embeddedLayouts()
+	defer func() { LAYOUTS = saved }()
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"persistent.yml": percentLayout,
+		"broken.json":    `{"table": "mbr"}`,
+		"notes.txt":      "not a layout",
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := LoadLayouts(dir)
+	if err == nil || !strings.Contains(err.Error(), "broken.json") {
+		t.Errorf("expected broken.json to be reported, got %v", err)
+	}
+	if strings.Join(LayoutNames(), ",") != "gpt-hybrid,mbr,persistent" {
+		t.Errorf("expected the valid layout to be added to the shipped ones, got %v", LayoutNames())
+	}
+
+	layout, err := GetLayout("persistent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := &imageJob{
+		iso:      FileObject{Info: &SystemFileInfo{Size: 700 * 1024 * 1024}},
+		diskpart: layout.Build(FileObject{Path: "/tmp/live.img", Type: TypeRegularFile}),
+	}
+	size, err := job.requiredSize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	//the live partition needs 1G at 30% of the file, so the file has to be at least 1G/0.3
+	if size < 1024*1024*1024*100/30 || size%(1024*1024) != 0 {
+		t.Errorf("unexpected image size %d for a 30%% live partition", size)
+	}
+}
@@ -0,0 +1,291 @@
+package usbimager
+
+/*
+Loop devices for image file targets. AttachLoop asks losetup for a free device with partition
+scanning and takes the device name from --show, WaitForPartitions blocks until udev created
+the partition nodes. Every attached device is tracked so DetachAllLoops can release them on
+shutdown, and the backing file is recorded in LOOP_REGISTRY with the pid of the process that
+attached it so a later start can sweep up loops a crashed run left behind
+*/
+
+import (
+	privileged "LiveBuilder/Privileged"
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// how long partition nodes of a fresh loop device may take to show up
+const LOOP_PARTITION_TIMEOUT = 10 * time.Second
+
+// LOOP_REGISTRY is the file recording backing files of loops this app attached, set at
+// startup. Empty disables recording and SweepLoops
+var LOOP_REGISTRY = ""
+
+type LoopDevice struct {
+	Path        string // /dev/loopN
+	BackingFile string
+	detached    bool
+	mutex       sync.Mutex
+}
+
+var attachedLoops = struct {
+	devices map[*LoopDevice]bool
+	mutex   sync.Mutex
+}{devices: make(map[*LoopDevice]bool)}
+
+// AttachLoop attaches file to the first free loop device with partition scanning enabled
+func AttachLoop(file string) (*LoopDevice, error) {
+	absolute, err := filepath.Abs(file)
+	if err != nil {
+		return nil, err
+	}
+	stdout, stderr, err := privileged.Run("losetup", "--find", "--show", "--partscan", absolute)
+	if err != nil {
+		return nil, fmt.Errorf("attaching %s to a loop device: %v %s", absolute, err, stderr)
+	}
+	path := strings.TrimSpace(stdout)
+	if !strings.HasPrefix(path, "/dev/loop") {
+		return nil, fmt.Errorf("losetup returned %q for %s", path, absolute)
+	}
+	loop := &LoopDevice{Path: path, BackingFile: absolute}
+	log.Printf("Attached %s to %s\n", absolute, path)
+
+	attachedLoops.mutex.Lock()
+	attachedLoops.devices[loop] = true
+	attachedLoops.mutex.Unlock()
+	if err := registerLoopFile(absolute, true); err != nil {
+		log.Printf("Recording loop backing file failed: %v\n", err)
+	}
+	return loop, nil
+}
+
+// WaitForPartitions waits until the nodes of partitions 1 to count exist
+func (self *LoopDevice) WaitForPartitions(count int, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for number := 1; number <= count; number++ {
+		node := partitionDevicePath(self.Path, number)
+		for {
+			if _, err := os.Stat(node); err == nil {
+				break
+			}
+			if time.Now().After(deadline) {
+				return fmt.Errorf("partition %s did not appear within %s", node, timeout)
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+	return nil
+}
+
+// Detach unmounts anything still mounted from the device and detaches it, calling it again is a no-op
+func (self *LoopDevice) Detach() error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	if self.detached {
+		return nil
+	}
+	unmountPartitions(self.Path)
+	if _, stderr, err := privileged.Run("losetup", "--detach", self.Path); err != nil {
+		return fmt.Errorf("detaching %s: %v %s", self.Path, err, stderr)
+	}
+	log.Printf("Detached %s from %s\n", self.Path, self.BackingFile)
+	self.detached = true
+
+	attachedLoops.mutex.Lock()
+	delete(attachedLoops.devices, self)
+	attachedLoops.mutex.Unlock()
+	if err := registerLoopFile(self.BackingFile, false); err != nil {
+		log.Printf("Updating loop registry failed: %v\n", err)
+	}
+	return nil
+}
+
+// DetachAllLoops detaches every loop device still attached by this process, the last resort
+// on shutdown when a run could not clean up after itself
+func DetachAllLoops() {
+	attachedLoops.mutex.Lock()
+	var loops []*LoopDevice
+	for loop := range attachedLoops.devices {
+		loops = append(loops, loop)
+	}
+	attachedLoops.mutex.Unlock()
+	for _, loop := range loops {
+		if err := loop.Detach(); err != nil {
+			log.Printf("Detaching %s on shutdown failed: %v\n", loop.Path, err)
+		}
+	}
+}
+
+// SweepLoops detaches loops whose backing file is in LOOP_REGISTRY and whose owner is no longer
+// running, left over from runs that crashed. Loops of other instances that are still running
+// are left alone. Only call it at startup, before this process attaches anything
+func SweepLoops() (int, error) {
+	registered, err := lockedReadLoopRegistry()
+	if err != nil || len(registered) == 0 {
+		return 0, err
+	}
+	stale := make(map[string]bool)
+	live := make(map[string]bool)
+	for entry := range registered {
+		if processAlive(entry.Pid) {
+			live[entry.File] = true
+		} else {
+			stale[entry.File] = true
+		}
+	}
+	stdout, stderr, err := run("losetup", "--list", "--noheadings", "--raw", "--output", "NAME,BACK-FILE")
+	if err != nil {
+		return 0, fmt.Errorf("listing loop devices: %v %s", err, stderr)
+	}
+	swept := 0
+	for _, loop := range parseLoopList(stdout) {
+		//a file a running instance attached as well can not be told apart, it is kept
+		if !stale[loop.BackingFile] || live[loop.BackingFile] {
+			continue
+		}
+		log.Printf("Sweeping leftover loop device %s of %s\n", loop.Path, loop.BackingFile)
+		if err := loop.Detach(); err != nil {
+			log.Printf("Sweeping %s failed: %v\n", loop.Path, err)
+			continue
+		}
+		swept++
+	}
+	//entries of dead owners are stale even without a loop, keep the registry from growing
+	return swept, dropDeadLoopOwners()
+}
+
+// parseLoopList reads `losetup --list --raw --output NAME,BACK-FILE`, raw output escapes
+// spaces in the file name as \x20
+func parseLoopList(output string) []*LoopDevice {
+	var loops []*LoopDevice
+	for _, line := range strings.Split(output, "\n") {
+		name, backing, found := strings.Cut(strings.TrimSpace(line), " ")
+		if !found || backing == "" {
+			continue
+		}
+		backing = strings.TrimSuffix(strings.ReplaceAll(backing, `\x20`, " "), " (deleted)")
+		loops = append(loops, &LoopDevice{Path: name, BackingFile: backing})
+	}
+	return loops
+}
+
+var loopRegistryMutex sync.Mutex
+
+// loopRegistryEntry is one line of LOOP_REGISTRY, the pid of the process that attached the file
+// and the file itself separated by a space
+type loopRegistryEntry struct {
+	Pid  int // 0 for lines written before owners were recorded, those count as dead
+	File string
+}
+
+func parseLoopRegistryEntry(line string) loopRegistryEntry {
+	field, file, found := strings.Cut(line, " ")
+	pid, err := strconv.Atoi(field)
+	if !found || err != nil || !filepath.IsAbs(file) {
+		return loopRegistryEntry{File: line}
+	}
+	return loopRegistryEntry{Pid: pid, File: file}
+}
+
+// processAlive tells whether pid is running, a process we may not signal is still running
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
+
+func lockedReadLoopRegistry() (map[loopRegistryEntry]bool, error) {
+	loopRegistryMutex.Lock()
+	defer loopRegistryMutex.Unlock()
+	return readLoopRegistry()
+}
+
+func readLoopRegistry() (map[loopRegistryEntry]bool, error) {
+	entries := make(map[loopRegistryEntry]bool)
+	if LOOP_REGISTRY == "" {
+		return entries, nil
+	}
+	file, err := os.Open(LOOP_REGISTRY)
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			entries[parseLoopRegistryEntry(line)] = true
+		}
+	}
+	return entries, scanner.Err()
+}
+
+func writeLoopRegistry(entries map[loopRegistryEntry]bool) error {
+	if LOOP_REGISTRY == "" {
+		return nil
+	}
+	var content strings.Builder
+	for entry := range entries {
+		fmt.Fprintf(&content, "%d %s\n", entry.Pid, entry.File)
+	}
+	return os.WriteFile(LOOP_REGISTRY, []byte(content.String()), 0644)
+}
+
+// dropDeadLoopOwners rewrites the registry without the entries of processes that are gone,
+// it reads the file again since other instances may have registered loops during the sweep
+func dropDeadLoopOwners() error {
+	loopRegistryMutex.Lock()
+	defer loopRegistryMutex.Unlock()
+	entries, err := readLoopRegistry()
+	if err != nil {
+		return err
+	}
+	for entry := range entries {
+		if !processAlive(entry.Pid) {
+			delete(entries, entry)
+		}
+	}
+	return writeLoopRegistry(entries)
+}
+
+// registerLoopFile adds or removes a backing file of this process, a file attached twice is
+// kept until both detach
+func registerLoopFile(file string, attached bool) error {
+	loopRegistryMutex.Lock()
+	defer loopRegistryMutex.Unlock()
+	if LOOP_REGISTRY == "" {
+		return nil
+	}
+	entries, err := readLoopRegistry()
+	if err != nil {
+		return err
+	}
+	entry := loopRegistryEntry{Pid: os.Getpid(), File: file}
+	if attached {
+		entries[entry] = true
+	} else {
+		attachedLoops.mutex.Lock()
+		stillAttached := false
+		for loop := range attachedLoops.devices {
+			stillAttached = stillAttached || loop.BackingFile == file
+		}
+		attachedLoops.mutex.Unlock()
+		if stillAttached {
+			return nil
+		}
+		delete(entries, entry)
+	}
+	return writeLoopRegistry(entries)
+}
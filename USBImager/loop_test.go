@@ -0,0 +1,84 @@
+package usbimager
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseLoopList(t *testing.T) {
+	output := "/dev/loop0 /var/lib/snapd/snaps/core_1.snap\n" +
+		`/dev/loop3 /home/user/My\x20Images/live.img (deleted)` + "\n" +
+		"/dev/loop7 \n"
+	loops := parseLoopList(output)
+	if len(loops) != 2 {
+		t.Fatalf("expected 2 loops, got %d", len(loops))
+	}
+	if loops[1].Path != "/dev/loop3" || loops[1].BackingFile != "/home/user/My Images/live.img" {
+		t.Fatalf("unexpected loop %+v", loops[1])
+	}
+}
+
+func TestLoopRegistryKeepsFilesUntilDetached(t *testing.T) {
+	LOOP_REGISTRY = filepath.Join(t.TempDir(), "loops")
+	defer func() { LOOP_REGISTRY = "" }()
+
+	for _, file := range []string{"/images/a.img", "/images/b.img"} {
+		if err := registerLoopFile(file, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := registerLoopFile("/images/a.img", false); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := readLoopRegistry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || !entries[loopRegistryEntry{Pid: os.Getpid(), File: "/images/b.img"}] {
+		t.Fatalf("unexpected registry %v", entries)
+	}
+}
+
+func TestSweepKeepsLoopsOfRunningInstances(t *testing.T) {
+	LOOP_REGISTRY = filepath.Join(t.TempDir(), "loops")
+	defer func() { LOOP_REGISTRY = "" }()
+
+	//pid 1 is always running, the huge pid and the line without one stand for crashed runs
+	content := "1 /images/running.img\n999999999 /images/crashed.img\n/images/old format.img\n"
+	if err := os.WriteFile(LOOP_REGISTRY, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := dropDeadLoopOwners(); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := readLoopRegistry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || !entries[loopRegistryEntry{Pid: 1, File: "/images/running.img"}] {
+		t.Fatalf("expected only the running owner to be kept, got %v", entries)
+	}
+}
+
+func TestCancelledImagerStopsBeforeTouchingTarget(t *testing.T) {
+	dir := t.TempDir()
+	iso := filepath.Join(dir, "live.iso")
+	if err := os.WriteFile(iso, make([]byte, SECTOR_SIZE), 0644); err != nil {
+		t.Fatal(err)
+	}
+	imager := NewUSBImager()
+	imager.Strategy = STRATEGY_RAW
+	imager.Cancel()
+	err := imager.ImageUSB(iso, filepath.Join(dir, "out.img"))
+	if err == nil || !strings.Contains(err.Error(), "cancelled") {
+		t.Fatalf("expected the run to be cancelled, got %v", err)
+	}
+
+	tracker := newProgressTracker("copy payload", func(ImageEvent) {})
+	tracker.ctx = imager.ctx
+	if _, err := tracker.Write([]byte("data")); err == nil || !strings.Contains(err.Error(), "canceled") {
+		t.Fatalf("expected writes through a cancelled tracker to fail, got %v", err)
+	}
+}
@@ -0,0 +1,116 @@
+package usbimager
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	MBR_MAX_PARTITIONS      = 4
+	MBR_TYPE_GPT_PROTECTIVE = PartitionType("EE")
+
+	mbrDiskSignatureOffset = 440
+	mbrEntriesOffset       = 446
+	mbrEntrySize           = 16
+	mbrBootSignatureOffset = 510
+	mbrActiveFlag          = 0x80
+)
+
+// encodeMBRLabel writes the mbr and zeroes both gpt header areas so a stale gpt from an
+// earlier imaging run is not picked up by tools that look for one
+func encodeMBRLabel(label *DiskLabel) ([]diskRegion, error) {
+	signature, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(label.DiskID), "0x"), 16, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mbr disk id %q", label.DiskID)
+	}
+	for _, partition := range label.Partitions {
+		if partition.End() > math.MaxUint32 {
+			return nil, fmt.Errorf("partition %d ends past the 2TiB mbr limit", partition.Number)
+		}
+	}
+	sector, err := encodeMBR(uint32(signature), label.Partitions)
+	if err != nil {
+		return nil, err
+	}
+	regions := []diskRegion{
+		{offset: 0, data: sector},
+		{offset: SECTOR_SIZE, data: make([]byte, GPT_TABLE_SECTORS*SECTOR_SIZE)},
+	}
+	if label.Sectors > 2*(GPT_TABLE_SECTORS+1) {
+		regions = append(regions, diskRegion{
+			offset: int64(label.Sectors-GPT_TABLE_SECTORS) * SECTOR_SIZE,
+			data:   make([]byte, GPT_TABLE_SECTORS*SECTOR_SIZE),
+		})
+	}
+	return regions, nil
+}
+
+func encodeMBR(signature uint32, partitions []LabelPartition) ([]byte, error) {
+	sector := make([]byte, SECTOR_SIZE)
+	binary.LittleEndian.PutUint32(sector[mbrDiskSignatureOffset:], signature)
+	for _, partition := range partitions {
+		if partition.Number < 1 || partition.Number > MBR_MAX_PARTITIONS {
+			return nil, fmt.Errorf("mbr partition number %d out of range", partition.Number)
+		}
+		code, err := strconv.ParseUint(string(partition.Type), 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("partition %d: invalid mbr type %q", partition.Number, partition.Type)
+		}
+		entry := sector[mbrEntriesOffset+(partition.Number-1)*mbrEntrySize:][:mbrEntrySize]
+		if partition.Bootable {
+			entry[0] = mbrActiveFlag
+		}
+		copy(entry[1:4], lbaToCHS(partition.Start))
+		entry[4] = byte(code)
+		copy(entry[5:8], lbaToCHS(partition.End()))
+		binary.LittleEndian.PutUint32(entry[8:], uint32(partition.Start))
+		binary.LittleEndian.PutUint32(entry[12:], uint32(partition.Sectors))
+	}
+	sector[mbrBootSignatureOffset] = 0x55
+	sector[mbrBootSignatureOffset+1] = 0xAA
+	return sector, nil
+}
+
+func decodeMBR(sector []byte) (*DiskLabel, error) {
+	if len(sector) < SECTOR_SIZE || sector[mbrBootSignatureOffset] != 0x55 || sector[mbrBootSignatureOffset+1] != 0xAA {
+		return nil, fmt.Errorf("no partition table found, missing mbr boot signature")
+	}
+	label := &DiskLabel{
+		Type:   TABLETYPE_MBR,
+		DiskID: fmt.Sprintf("0x%08x", binary.LittleEndian.Uint32(sector[mbrDiskSignatureOffset:])),
+	}
+	for i := 0; i < MBR_MAX_PARTITIONS; i++ {
+		entry := sector[mbrEntriesOffset+i*mbrEntrySize:][:mbrEntrySize]
+		if entry[4] == 0 {
+			continue
+		}
+		label.Partitions = append(label.Partitions, LabelPartition{
+			Number:   i + 1,
+			Bootable: entry[0] == mbrActiveFlag,
+			Type:     PartitionType(fmt.Sprintf("%02X", entry[4])),
+			Start:    uint64(binary.LittleEndian.Uint32(entry[8:])),
+			Sectors:  uint64(binary.LittleEndian.Uint32(entry[12:])),
+		})
+	}
+	return label, nil
+}
+
+// lbaToCHS uses the conventional 255 head 63 sector geometry, addresses past what CHS can
+// hold get the 1023/254/63 marker firmware expects
+func lbaToCHS(lba uint64) []byte {
+	const heads, sectorsPerTrack = 255, 63
+	cylinder := lba / (heads * sectorsPerTrack)
+	if cylinder > 1023 {
+		return []byte{0xFE, 0xFF, 0xFF}
+	}
+	head := (lba / sectorsPerTrack) % heads
+	sector := lba%sectorsPerTrack + 1
+	return []byte{
+		byte(head),
+		byte(sector) | byte((cylinder>>2)&0xC0),
+		byte(cylinder),
+	}
+}
@@ -0,0 +1,94 @@
+package usbimager
+
+import (
+	privileged "LiveBuilder/Privileged"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// MountPoint is a device mounted on a temporary directory for the length of an imaging run
+type MountPoint struct {
+	Device    string
+	Dir       string
+	loop      *LoopDevice // set for isos, detached after unmounting
+	unmounted bool
+}
+
+// mountDevice mounts device on a new temp directory. Writable mounts are handed to the
+// current user (vfat via uid/gid options, everything else via chown) so the payload can be
+// copied without root
+func mountDevice(device string, fsType string, readOnly bool) (*MountPoint, error) {
+	dir, err := os.MkdirTemp("", privileged.MOUNT_DIR_PREFIX+"*")
+	if err != nil {
+		return nil, err
+	}
+
+	var options []string
+	if readOnly {
+		options = append(options, "ro")
+	}
+	if fsType == "vfat" && !readOnly {
+		options = append(options, fmt.Sprintf("uid=%d,gid=%d", os.Getuid(), os.Getgid()))
+	}
+	var args []string
+	if fsType != "" {
+		args = append(args, "-t", fsType)
+	}
+	if len(options) > 0 {
+		args = append(args, "-o", strings.Join(options, ","))
+	}
+	args = append(args, device, dir)
+
+	if _, stderr, err := privileged.Run("mount", args...); err != nil {
+		os.Remove(dir)
+		return nil, fmt.Errorf("mounting %s: %v %s", device, err, stderr)
+	}
+	mountPoint := &MountPoint{Device: device, Dir: dir}
+
+	if !readOnly && fsType != "vfat" {
+		owner := fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid())
+		if _, stderr, err := privileged.Run("chown", owner, dir); err != nil {
+			mountPoint.Unmount()
+			return nil, fmt.Errorf("taking ownership of %s: %v %s", dir, err, stderr)
+		}
+	}
+	log.Printf("Mounted %s on %s\n", device, dir)
+	return mountPoint, nil
+}
+
+// mountISO mounts an iso read only through a loop device, the helper does not mount files
+func mountISO(iso string) (*MountPoint, error) {
+	loop, err := AttachLoop(iso)
+	if err != nil {
+		return nil, err
+	}
+	mountPoint, err := mountDevice(loop.Path, "iso9660", true)
+	if err != nil {
+		if detachErr := loop.Detach(); detachErr != nil {
+			log.Printf("Detaching %s failed: %v\n", loop.Path, detachErr)
+		}
+		return nil, err
+	}
+	mountPoint.loop = loop
+	return mountPoint, nil
+}
+
+// Unmount unmounts and removes the directory, calling it again is a no-op
+func (self *MountPoint) Unmount() error {
+	if self.unmounted {
+		return nil
+	}
+	if _, stderr, err := privileged.Run("umount", self.Dir); err != nil {
+		return fmt.Errorf("unmounting %s: %v %s", self.Dir, err, stderr)
+	}
+	log.Printf("Unmounted %s from %s\n", self.Device, self.Dir)
+	self.unmounted = true
+	if self.loop != nil {
+		if err := self.loop.Detach(); err != nil {
+			return err
+		}
+	}
+	return os.Remove(self.Dir)
+}
@@ -2,15 +2,30 @@ package usbimager
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 )
 
+// PartitionRole tells the imager what a partition is used for once it is formatted
+type PartitionRole string
+
+const (
+	ROLE_NONE PartitionRole = ""
+	ROLE_ESP  PartitionRole = "esp"  // EFI system partition, gets the efi bootloader and stub grub.cfg
+	ROLE_LIVE PartitionRole = "live" // gets the /live payload, /boot/grub and the real grub.cfg
+
+	ROLE_PERSISTENCE PartitionRole = "persistence" // live-boot persistence, gets persistence.conf
+)
+
 type PartitionDefinitionBuilder struct {
 	volumeName       string
 	label            string
 	stringAttributes map[string]string
 	bootable         bool
 	partType         PartitionType
+	role             PartitionRole
+	filesystem       string        // overrides PARTITION_FILESYSTEMS for partType
+	format           FormatOptions // label defaults to the volume name
 }
 
 //end result
@@ -42,29 +57,116 @@ func (pb *PartitionDefinitionBuilder) WithSize(size string) *PartitionDefinition
 	pb.stringAttributes["size"] = size
 	return pb
 }
+
+// Size is the sfdisk size of the partition, empty when it takes the rest of the disk
+func (pb *PartitionDefinitionBuilder) Size() string {
+	return pb.stringAttributes["size"]
+}
 func (pb *PartitionDefinitionBuilder) WithUndefinedOption(key, value string) *PartitionDefinitionBuilder {
 	pb.stringAttributes[key] = value
 	return pb
 }
+func (pb *PartitionDefinitionBuilder) WithRole(role PartitionRole) *PartitionDefinitionBuilder {
+	pb.role = role
+	return pb
+}
 func (pb *PartitionDefinitionBuilder) SetBootable(bootable bool) *PartitionDefinitionBuilder {
 	pb.bootable = bootable
 	return pb
 }
-func (pb *PartitionDefinitionBuilder) ToSfdisk() string {
+func (pb *PartitionDefinitionBuilder) WithFilesystem(filesystem string) *PartitionDefinitionBuilder {
+	pb.filesystem = filesystem
+	return pb
+}
+func (pb *PartitionDefinitionBuilder) WithFormatOptions(options FormatOptions) *PartitionDefinitionBuilder {
+	pb.format = options
+	return pb
+}
+
+// Filesystem is what the partition gets formatted with, empty when it is left unformatted
+func (pb *PartitionDefinitionBuilder) Filesystem() string {
+	if pb.filesystem != "" {
+		return pb.filesystem
+	}
+	return PARTITION_FILESYSTEMS[pb.partType]
+}
+
+// FormatOptions fills in the volume name as label and the fat width the partition type implies
+func (pb *PartitionDefinitionBuilder) FormatOptions() FormatOptions {
+	options := pb.format
+	if options.Label == "" {
+		options.Label = pb.volumeName
+	}
+	if options.FATSize == 0 && pb.Filesystem() == FS_VFAT {
+		options.FATSize = FAT_SIZES[pb.partType]
+	}
+	return options
+}
+
+// CheckFormat validates the label and options against the filesystem and checks its mkfs is installed
+func (pb *PartitionDefinitionBuilder) CheckFormat() error {
+	filesystem := pb.Filesystem()
+	if filesystem == "" {
+		return nil
+	}
+	formatter, err := GetFormatter(filesystem)
+	if err != nil {
+		return fmt.Errorf("partition %s: %w", pb.label, err)
+	}
+	if _, err := formatter.Args(pb.label, pb.FormatOptions()); err != nil {
+		return fmt.Errorf("partition %s: %w", pb.label, err)
+	}
+	if !ToolAvailable(filesystem) {
+		return fmt.Errorf("partition %s: %w", pb.label, &MissingToolError{Filesystem: filesystem, Tool: formatter.Tool()})
+	}
+	return nil
+}
+
+// MountType is the mount -t type of the filesystem, empty lets mount probe
+func (pb *PartitionDefinitionBuilder) MountType() string {
+	if formatter, err := GetFormatter(pb.Filesystem()); err == nil {
+		return formatter.MountType()
+	}
+	return ""
+}
+
+// FilesystemLabel is the label as the filesystem stores it, vfat uppercases
+func (pb *PartitionDefinitionBuilder) FilesystemLabel() string {
+	label := pb.FormatOptions().Label
+	if formatter, err := GetFormatter(pb.Filesystem()); err == nil {
+		if stored, err := formatter.Label(label); err == nil {
+			return stored
+		}
+	}
+	return label
+}
+
+// ToSfdisk renders the definition as an sfdisk script line, types are written as GUIDs in
+// GPT tables and the bootable flag becomes the legacy bios bootable attribute
+func (pb *PartitionDefinitionBuilder) ToSfdisk(tableType TableType) (string, error) {
 	var definition []string
 	for key, value := range pb.stringAttributes {
 		definition = append(definition, fmt.Sprintf("%s=%s", key, value))
 	}
+	sort.Strings(definition)
 
 	if pb.partType != "" {
-		definition = append(definition, fmt.Sprintf("type=%s", pb.partType))
+		partType, err := pb.partType.ForTable(tableType)
+		if err != nil {
+			return "", fmt.Errorf("partition %s: %w", pb.label, err)
+		}
+		definition = append(definition, fmt.Sprintf("type=%s", partType))
 	}
 
 	if pb.bootable {
-		definition = append(definition, "bootable")
+		if tableType == TABLETYPE_GPT {
+			definition = append(definition, "attrs=\"LegacyBIOSBootable\"")
+		} else {
+			definition = append(definition, "bootable")
+		}
 	}
 
 	definitions := strings.Join(definition, ", ")
 	sfdisk_partition_label := fmt.Sprintf("%s : ", pb.label) + definitions
-	return sfdisk_partition_label
+	return sfdisk_partition_label, nil
 }
@@ -19,10 +19,11 @@ type PartitionTabelBuilder struct {
 }
 
 func NewPartitionTable(typ TableType) *PartitionTabelBuilder {
+	//the label id is left empty so Plan gives every disk its own, a fixed one collides
+	//as soon as two sticks are plugged in
 	return &PartitionTabelBuilder{
-		label:    typ,
-		units:    "sectors",
-		label_id: "0x12345678",
+		label: typ,
+		units: "sectors",
 	}
 }
 func (table *PartitionTabelBuilder) WithUnitSize(unit string) *PartitionTabelBuilder {
@@ -39,17 +40,26 @@ func (table *PartitionTabelBuilder) WithPartitionDefinition(definition *Partitio
 	return table
 }
 
-func (table *PartitionTabelBuilder) ToSfdisk() string {
+func (table *PartitionTabelBuilder) TableType() TableType {
+	return table.label
+}
+
+func (table *PartitionTabelBuilder) ToSfdisk() (string, error) {
 
-	partitionTable := fmt.Sprintf(
-		"label: %s\nlabel-id: %s\nunit: %s\n",
-		table.label, table.label_id, table.units,
-	)
+	partitionTable := fmt.Sprintf("label: %s\n", table.label)
+	if table.label_id != "" {
+		partitionTable += fmt.Sprintf("label-id: %s\n", table.label_id)
+	}
+	partitionTable += fmt.Sprintf("unit: %s\n", table.units)
 
 	for _, definition := range table.partitions {
-		partitionTable += "\t" + definition.ToSfdisk()
+		line, err := definition.ToSfdisk(table.label)
+		if err != nil {
+			return "", err
+		}
+		partitionTable += "\t" + line
 		partitionTable += "\n"
 	}
 
-	return partitionTable
+	return partitionTable, nil
 }
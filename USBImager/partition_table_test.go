@@ -0,0 +1,41 @@
+package usbimager
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHybridGPTSfdiskScript(t *testing.T) {
+	diskpart := buildLayout(t, LAYOUT_HYBRID_GPT, FileObject{Path: "/dev/sdb"})
+	script, err := diskpart.PartitionTable.ToSfdisk()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"label: gpt\n",
+		"/dev/sdb1 : name=\"BIOSBOOT\", size=1M, start=2048, type=" + string(GPT_BIOSBoot),
+		"type=" + string(GPT_EFISystem),
+		"type=" + string(GPT_LinuxFilesystem),
+	} {
+		if !strings.Contains(script, expected) {
+			t.Errorf("sfdisk script missing %q:\n%s", expected, script)
+		}
+	}
+	if strings.Contains(script, "label-id") {
+		t.Errorf("gpt script should let sfdisk generate the label id:\n%s", script)
+	}
+}
+
+func TestMBRTypesConvertForGPT(t *testing.T) {
+	definition := NewPartitionBuilder("/dev/sdb1").OfType(Linux).SetBootable(true)
+	line, err := definition.ToSfdisk(TABLETYPE_GPT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(line, "type="+string(GPT_LinuxFilesystem)) || !strings.Contains(line, "LegacyBIOSBootable") {
+		t.Fatalf("unexpected gpt definition %s", line)
+	}
+	if _, err := NewPartitionBuilder("/dev/sdb1").OfType(GPT_EFISystem).ToSfdisk(TABLETYPE_MBR); err == nil {
+		t.Fatal("expected an error for a GPT type in an MBR table")
+	}
+}
@@ -2,8 +2,6 @@ package usbimager
 
 import (
 	"fmt"
-	"strings"
-	"text/template"
 )
 
 // PartitionType represents a partition type code
@@ -35,6 +33,27 @@ const (
 	LinuxRAID         PartitionType = "FD"
 )
 
+// GPT partition type GUIDs
+const (
+	GPT_EFISystem          PartitionType = "C12A7328-F81F-11D2-BA4B-00A0C93EC93B"
+	GPT_LinuxFilesystem    PartitionType = "0FC63DAF-8483-4772-8E79-3D69D8477DE4"
+	GPT_BIOSBoot           PartitionType = "21686148-6449-6E6F-744E-656564454649"
+	GPT_MicrosoftBasicData PartitionType = "EBD0A0A2-B9E5-4433-87C0-68B6B72699C7"
+)
+
+// MBRToGPT maps MBR codes to the GPT type with the same meaning, used when a
+// definition written with MBR codes is placed in a GPT table
+var MBRToGPT = map[PartitionType]PartitionType{
+	FAT12:           GPT_MicrosoftBasicData,
+	FAT16Small:      GPT_MicrosoftBasicData,
+	FAT16:           GPT_MicrosoftBasicData,
+	HPFS_NTFS_exFAT: GPT_MicrosoftBasicData,
+	W95_FAT32:       GPT_MicrosoftBasicData,
+	W95_FAT32_LBA:   GPT_MicrosoftBasicData,
+	Linux:           GPT_LinuxFilesystem,
+	EFI_FAT:         GPT_EFISystem,
+}
+
 // PartitionNameToCode maps partition type names to their codes
 var PartitionNameToCode = map[string]PartitionType{
 	"FAT12":                 FAT12,
@@ -59,6 +78,11 @@ var PartitionNameToCode = map[string]PartitionType{
 	"Darwin boot":           DarwinBoot,
 	"EFI (FAT-12/16/32)":    EFI_FAT,
 	"Linux raid autodetect": LinuxRAID,
+
+	"EFI System":           GPT_EFISystem,
+	"Linux filesystem":     GPT_LinuxFilesystem,
+	"BIOS boot":            GPT_BIOSBoot,
+	"Microsoft basic data": GPT_MicrosoftBasicData,
 }
 
 // PartitionsCodeToName maps codes back to their descriptive names
@@ -85,68 +109,32 @@ var PartitionsCodeToName = map[PartitionType]string{
 	DarwinBoot:        "Darwin boot",
 	EFI_FAT:           "EFI (FAT-12/16/32)",
 	LinuxRAID:         "Linux raid autodetect",
-}
-
-var MkfsCommands = map[PartitionType]string{
-	// FAT filesystems
-	FAT12:         "mkfs.vfat -F 12 -n {{.Label}} {{.Device}}",
-	FAT16Small:    "mkfs.vfat -F 16 -n {{.Label}} {{.Device}}",
-	FAT16:         "mkfs.vfat -F 16 -n {{.Label}} {{.Device}}",
-	W95_FAT32:     "mkfs.vfat -F 32 -n {{.Label}} {{.Device}}",
-	W95_FAT32_LBA: "mkfs.vfat -F 32 -n {{.Label}} {{.Device}}",
-	EFI_FAT:       "mkfs.vfat -F 32 -n {{.Label}} {{.Device}}",
-
-	// Hidden FAT filesystems
-	HiddenFAT12: "mkfs.vfat -F 12 -n {{.Label}} {{.Device}}",
-	HiddenFAT16: "mkfs.vfat -F 16 -n {{.Label}} {{.Device}}",
-
-	// NTFS/exFAT
-	HPFS_NTFS_exFAT: "mkfs.ntfs -F -L {{.Label}} {{.Device}}",
-	HiddenHPFS_NTFS: "mkfs.ntfs -F -L {{.Label}} {{.Device}}",
 
-	// Linux filesystems
-	Linux:             "mkfs.ext4 -F -L {{.Label}} {{.Device}}",
-	LinuxSwap_Solaris: "mkswap -L {{.Label}} {{.Device}}",
-	LinuxLVM:          "", // LVM partitions don't get formatted directly
-	LinuxRAID:         "", // RAID partitions don't get formatted directly
-
-	// BSD filesystems
-	FreeBSD:    "newfs -L {{.Label}} {{.Device}}",
-	OpenBSD:    "newfs -L {{.Label}} {{.Device}}",
-	UFS_Darwin: "newfs -L {{.Label}} {{.Device}}",
-	NeXTSTEP:   "newfs -L {{.Label}} {{.Device}}",
-	DarwinBoot: "newfs_hfs -v {{.Label}} {{.Device}}",
+	GPT_EFISystem:          "EFI System",
+	GPT_LinuxFilesystem:    "Linux filesystem",
+	GPT_BIOSBoot:           "BIOS boot",
+	GPT_MicrosoftBasicData: "Microsoft basic data",
+}
 
-	// Extended partitions don't get formatted
-	Extended:         "",
-	W95_Extended_LBA: "",
-	LinuxExtended:    "",
+// IsGPT reports whether the type is a GPT GUID rather than an MBR code
+func (partType PartitionType) IsGPT() bool {
+	return len(partType) == 36
 }
 
-func getFormatCommandForDeivce(partType PartitionType, device string, label string) (string, error) {
-	cmdTemplate, exists := MkfsCommands[partType]
-	if !exists {
-		return "", fmt.Errorf("no mkfs command available for partition type %s", partType)
+// ForTable returns the type code to write into a table of tableType
+func (partType PartitionType) ForTable(tableType TableType) (PartitionType, error) {
+	if tableType != TABLETYPE_GPT {
+		if partType.IsGPT() {
+			return "", fmt.Errorf("GPT partition type %s can not be used in a %s table", partType, tableType)
+		}
+		return partType, nil
 	}
-
-	// Parse and execute the template
-	tmpl, err := template.New("mkfs").Parse(cmdTemplate)
-	if err != nil {
-		return "", fmt.Errorf("failed to parse command template: %v", err)
+	if partType.IsGPT() {
+		return partType, nil
 	}
-
-	var cmdBuf strings.Builder
-	data := struct {
-		Device string
-		Label  string
-	}{
-		Device: device,
-		Label:  label,
-	}
-
-	if err := tmpl.Execute(&cmdBuf, data); err != nil {
-		return "", fmt.Errorf("failed to execute command template: %v", err)
+	guid, exists := MBRToGPT[partType]
+	if !exists {
+		return "", fmt.Errorf("no GPT equivalent for partition type %s", partType)
 	}
-
-	return cmdBuf.String(), nil
+	return guid, nil
 }
@@ -0,0 +1,119 @@
+package usbimager
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// directories copied from the iso onto the live partition
+var PAYLOAD_DIRS = []string{
+	"live",
+	filepath.Join("boot", "grub"),
+}
+
+// copyPayload copies the live system and the isos grub files from the mounted iso onto the live partition
+func copyPayload(isoRoot, liveRoot string, progress *progressTracker) error {
+	var total int64
+	for _, dir := range PAYLOAD_DIRS {
+		size, err := treeSize(filepath.Join(isoRoot, dir))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		total += size
+	}
+	progress.setTotal(total)
+
+	for _, dir := range PAYLOAD_DIRS {
+		source := filepath.Join(isoRoot, dir)
+		if _, err := os.Stat(source); err != nil {
+			if os.IsNotExist(err) && dir != "live" {
+				log.Printf("ISO has no %s, skipping\n", dir)
+				continue
+			}
+			return fmt.Errorf("iso payload %s: %w", dir, err)
+		}
+		log.Printf("Copying %s to %s\n", source, liveRoot)
+		if err := copyTree(source, filepath.Join(liveRoot, dir), progress); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyTree recursively copies source to destination keeping file modes and symlinks
+func copyTree(source, destination string, progress *progressTracker) error {
+	return filepath.WalkDir(source, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(source, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(destination, rel)
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case entry.IsDir():
+			//iso9660 directories are read only, keep them writable so the tree can be replaced later
+			return os.MkdirAll(target, info.Mode().Perm()|0200)
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case info.Mode().IsRegular():
+			return copyFile(path, target, info.Mode().Perm(), progress)
+		default:
+			log.Printf("Skipping special file %s\n", path)
+			return nil
+		}
+	})
+}
+
+func copyFile(source, destination string, mode os.FileMode, progress *progressTracker) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(destination, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode|0200)
+	if err != nil {
+		return err
+	}
+	var writer io.Writer = out
+	if progress != nil {
+		writer = io.MultiWriter(out, progress)
+	}
+	if _, err := io.Copy(writer, in); err != nil {
+		out.Close()
+		return fmt.Errorf("copying %s: %w", source, err)
+	}
+	return out.Close()
+}
+
+// treeSize sums the size of every regular file below root
+func treeSize(root string) (int64, error) {
+	var total int64
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		total += info.Size()
+		return nil
+	})
+	return total, err
+}
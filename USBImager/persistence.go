@@ -0,0 +1,103 @@
+package usbimager
+
+/*
+live-boot persistence, an ext4 partition labelled "persistence" holding a persistence.conf
+that lists which paths get overlaid. The partition goes after the live partition so it
+can take the rest of the disk
+*/
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	PERSISTENCE_LABEL       = "persistence"
+	PERSISTENCE_CONF        = "persistence.conf"
+	PERSISTENCE_KERNEL_FLAG = "persistence"
+
+	// headroom on the live partition for grub modules and the configs written after the payload
+	LIVE_PARTITION_HEADROOM = 256 * 1024 * 1024
+	// size given to a rest of disk persistence partition when the target is an image file
+	PERSISTENCE_MIN_FILE_SIZE = 1024 * 1024 * 1024
+)
+
+// DEFAULT_UNION_PATHS makes the whole root filesystem persistent
+var DEFAULT_UNION_PATHS = []string{"/ union"}
+
+type PersistenceOptions struct {
+	Size       string   // sfdisk size eg "4G", empty for the rest of the disk
+	UnionPaths []string // persistence.conf lines, DEFAULT_UNION_PATHS when empty
+}
+
+func (self *PersistenceOptions) validate() error {
+	if self.Size == "" {
+		return nil
+	}
+	if _, err := parseSize(self.Size); err != nil {
+		return fmt.Errorf("persistence size: %w", err)
+	}
+	return nil
+}
+
+func (self *PersistenceOptions) conf() string {
+	paths := self.UnionPaths
+	if len(paths) == 0 {
+		paths = DEFAULT_UNION_PATHS
+	}
+	return strings.Join(paths, "\n") + "\n"
+}
+
+// addPersistencePartition sizes the live partition to fit the iso and appends the
+// persistence partition behind it
+func addPersistencePartition(diskpart *DiskPartitionare, options *PersistenceOptions, isoSize int64) error {
+	_, live, err := diskpart.PartitionWithRole(ROLE_LIVE)
+	if err != nil {
+		return err
+	}
+	if live.Size() == "" {
+		live.WithSize(fmt.Sprintf("%dM", livePartitionSize(isoSize)/(1024*1024)))
+	}
+
+	number := len(diskpart.PartitionTable.partitions) + 1
+	persistence := NewPartitionBuilder(partitionDevicePath(diskpart.Device.Path, number)).
+		WithName(PERSISTENCE_LABEL).
+		OfType(Linux).
+		WithRole(ROLE_PERSISTENCE)
+	if options.Size != "" {
+		persistence.WithSize(options.Size)
+	}
+	diskpart.PartitionTable.WithPartitionDefinition(persistence)
+	return nil
+}
+
+// livePartitionSize fits the iso payload plus headroom, rounded up to whole gigabytes
+func livePartitionSize(isoSize int64) int64 {
+	return roundUpToGB(isoSize + LIVE_PARTITION_HEADROOM)
+}
+
+func writePersistenceConf(root string, options *PersistenceOptions) error {
+	return os.WriteFile(filepath.Join(root, PERSISTENCE_CONF), []byte(options.conf()), 0644)
+}
+
+// parseSize converts an sfdisk size to bytes, K/M/G/T suffixes are binary and a bare number is sectors
+func parseSize(value string) (int64, error) {
+	size := strings.ToUpper(strings.TrimSpace(value))
+	multiplier := int64(512)
+	suffixes := map[string]int64{"K": 1 << 10, "M": 1 << 20, "G": 1 << 30, "T": 1 << 40}
+	for suffix, unit := range suffixes {
+		if strings.HasSuffix(size, suffix) || strings.HasSuffix(size, suffix+"IB") {
+			size = strings.TrimSuffix(strings.TrimSuffix(size, "IB"), suffix)
+			multiplier = unit
+			break
+		}
+	}
+	number, err := strconv.ParseInt(size, 10, 64)
+	if err != nil || number <= 0 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return number * multiplier, nil
+}
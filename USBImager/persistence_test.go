@@ -0,0 +1,48 @@
+package usbimager
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	cases := map[string]int64{
+		"512M": 512 << 20,
+		"4G":   4 << 30,
+		"2GiB": 2 << 30,
+		"2048": 2048 * 512,
+		" 1k ": 1 << 10,
+	}
+	for size, expected := range cases {
+		got, err := parseSize(size)
+		if err != nil || got != expected {
+			t.Errorf("parseSize(%q) = %d, %v expected %d", size, got, err, expected)
+		}
+	}
+	for _, invalid := range []string{"", "G", "-1G", "lots"} {
+		if _, err := parseSize(invalid); err == nil {
+			t.Errorf("parseSize(%q) should fail", invalid)
+		}
+	}
+}
+
+func TestAddPersistencePartition(t *testing.T) {
+	diskpart := buildLayout(t, LAYOUT_MBR, FileObject{Path: "/dev/sdb"})
+	options := &PersistenceOptions{Size: "4G"}
+	if err := addPersistencePartition(diskpart, options, 900<<20); err != nil {
+		t.Fatal(err)
+	}
+	script, err := diskpart.PartitionTable.ToSfdisk()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(script, "/dev/sdb2 : name=\"SYSTEM\", size=2048M, type=83") {
+		t.Errorf("live partition was not sized to the iso:\n%s", script)
+	}
+	if !strings.Contains(script, "/dev/sdb3 : name=\"persistence\", size=4G, type=83") {
+		t.Errorf("persistence partition missing:\n%s", script)
+	}
+	if options.conf() != "/ union\n" {
+		t.Errorf("unexpected default persistence.conf %q", options.conf())
+	}
+}
@@ -0,0 +1,284 @@
+package usbimager
+
+/*
+Raw imaging. live-build's iso-hybrid images carry their own mbr and boot code, so the iso is
+copied byte for byte onto the target in RAW_BLOCK_SIZE blocks and hashed on the way. The
+result is an iso9660 stick that cannot be written to, which is what read only kiosk sticks
+want. Verification reads the written bytes back past the page cache and compares the hash
+*/
+
+import (
+	privileged "LiveBuilder/Privileged"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// large enough to keep usb sticks streaming, a multiple of every sector size in use
+const RAW_BLOCK_SIZE = 4 * 1024 * 1024
+
+// rawStrategy writes the iso onto the target as is, the iso decides how it boots
+type rawStrategy struct{}
+
+func (rawStrategy) Validate(imager *USBImager) error {
+	if imager.Persistence != nil {
+		return fmt.Errorf("raw images are read only and cannot have a persistence partition")
+	}
+	if imager.ImageFile != nil && imager.ImageFile.Size != "" {
+		return fmt.Errorf("raw images are exactly as large as the iso, an image size cannot be set")
+	}
+	return nil
+}
+
+func (rawStrategy) Stages() []imageStage {
+	return []imageStage{
+		{"check image", (*imageJob).checkHybrid},
+		{"prepare target", (*imageJob).prepareRaw},
+		{"write image", (*imageJob).writeRaw},
+		{"sync", (*imageJob).sync},
+		{"verify", (*imageJob).verifyRaw},
+		{"compress image", (*imageJob).compressImage},
+		{"write checksum", (*imageJob).writeChecksum},
+	}
+}
+
+// checkHybrid refuses isos without an mbr, written raw they would not boot from a stick
+func (self *imageJob) checkHybrid() error {
+	file, err := os.Open(self.iso.Path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	sector := make([]byte, SECTOR_SIZE)
+	if _, err := io.ReadFull(file, sector); err != nil {
+		return fmt.Errorf("reading %s: %w", self.iso.Path, err)
+	}
+	if _, err := decodeMBR(sector); err != nil {
+		return fmt.Errorf("%s is not an iso-hybrid image and would not boot when written raw: %w", self.iso.Path, err)
+	}
+	return nil
+}
+
+func (self *imageJob) prepareRaw() error {
+	if self.target.Type != TypeBlockDevice {
+		return nil
+	}
+	writer, err := openDiskWriter(self.target.Path)
+	if err != nil {
+		return err
+	}
+	sectors, err := writer.sectors()
+	writer.Close()
+	if err != nil {
+		return err
+	}
+	if size := int64(sectors) * SECTOR_SIZE; size < self.iso.Info.Size {
+		return fmt.Errorf("%s holds %s, the image needs %s", self.target.Path, formatBytes(size), formatBytes(self.iso.Info.Size))
+	}
+	if err := self.target.umountPartitions(); err != nil {
+		return err
+	}
+	//clears signatures the image does not overwrite, like a gpt backup header at the end of the disk
+	return self.target.wipeFS()
+}
+
+func (self *imageJob) writeRaw() error {
+	in, err := os.Open(self.iso.Path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := openRawTarget(self.target)
+	if err != nil {
+		return err
+	}
+	progress := self.progress()
+	progress.setTotal(self.iso.Info.Size)
+	hasher := sha256.New()
+	padded := self.target.Type == TypeBlockDevice
+	if err := copyBlocks(out, io.TeeReader(in, io.MultiWriter(hasher, progress)), padded); err != nil {
+		out.Close()
+		return fmt.Errorf("writing %s: %w", self.target.Path, err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("writing %s: %w", self.target.Path, err)
+	}
+	self.rawSum = hex.EncodeToString(hasher.Sum(nil))
+	log.Printf("Wrote %s onto %s, sha256 %s\n", self.iso.Path, self.target.Path, self.rawSum)
+	return nil
+}
+
+// copyBlocks writes whole RAW_BLOCK_SIZE blocks, with pad a short last block is filled up to
+// a full sector since block devices only take sector sized writes
+func copyBlocks(out io.Writer, in io.Reader, pad bool) error {
+	block := make([]byte, RAW_BLOCK_SIZE)
+	for {
+		n, err := io.ReadFull(in, block)
+		if n > 0 {
+			length := n
+			if pad {
+				length = (n + SECTOR_SIZE - 1) / SECTOR_SIZE * SECTOR_SIZE
+				clear(block[n:length])
+			}
+			if _, writeErr := out.Write(block[:length]); writeErr != nil {
+				return writeErr
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (self *imageJob) verifyRaw() error {
+	if !self.imager.Verify {
+		log.Println("Skipping verification")
+		return nil
+	}
+	if self.target.Type == TypeBlockDevice {
+		if _, stderr, err := privileged.Run("blockdev", "--flushbufs", self.target.Path); err != nil {
+			return fmt.Errorf("flushing %s: %v %s", self.target.Path, err, stderr)
+		}
+	}
+	progress := self.progress()
+	progress.setTotal(self.iso.Info.Size)
+	actual, err := hashRawTarget(self.target, self.iso.Info.Size, sha256.New, progress)
+	if err != nil {
+		return err
+	}
+	if actual != self.rawSum {
+		return &VerificationError{Mismatches: []FileMismatch{{
+			Path:     filepath.Base(self.iso.Path),
+			Expected: self.rawSum,
+			Actual:   actual,
+		}}}
+	}
+	log.Printf("Verified %s\n", self.target.Path)
+	return nil
+}
+
+// openRawTarget opens the target for a sequential write, through dd run by the privileged helper when only root may write it
+func openRawTarget(target FileObject) (io.WriteCloser, error) {
+	flags := os.O_WRONLY
+	if target.Type == TypeRegularFile {
+		flags |= os.O_TRUNC
+	}
+	file, err := os.OpenFile(target.Path, flags, 0)
+	if err == nil {
+		return &syncingFile{file}, nil
+	}
+	if !os.IsPermission(err) {
+		return nil, err
+	}
+	log.Printf("No write access to %s, writing as root\n", target.Path)
+	cmd := privileged.Command("dd", "of="+target.Path, fmt.Sprintf("bs=%d", RAW_BLOCK_SIZE),
+		"iflag=fullblock", "oflag=direct", "conv=fsync,notrunc", "status=none")
+	return startPipe(cmd, true)
+}
+
+// hashRawTarget hashes the first size bytes of the target, through dd run by the privileged helper when only root may read it
+func hashRawTarget(target FileObject, size int64, newHasher func() hash.Hash, progress *progressTracker) (string, error) {
+	var in io.ReadCloser
+	file, err := os.Open(target.Path)
+	switch {
+	case err == nil:
+		in = file
+	case os.IsPermission(err):
+		cmd := privileged.Command("dd", "if="+target.Path, fmt.Sprintf("bs=%d", RAW_BLOCK_SIZE),
+			fmt.Sprintf("count=%d", size), "iflag=count_bytes,direct", "status=none")
+		pipe, err := startPipe(cmd, false)
+		if err != nil {
+			return "", err
+		}
+		in = pipe
+	default:
+		return "", err
+	}
+
+	hasher := newHasher()
+	copied, err := io.Copy(io.MultiWriter(hasher, progress), io.LimitReader(in, size))
+	closeErr := in.Close()
+	if err != nil {
+		return "", fmt.Errorf("reading back %s: %w", target.Path, err)
+	}
+	if closeErr != nil {
+		return "", fmt.Errorf("reading back %s: %w", target.Path, closeErr)
+	}
+	if copied != size {
+		return "", fmt.Errorf("reading back %s: got %d of %d bytes", target.Path, copied, size)
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// syncingFile flushes the target to disk before closing
+type syncingFile struct {
+	*os.File
+}
+
+func (self *syncingFile) Close() error {
+	if err := self.File.Sync(); err != nil {
+		self.File.Close()
+		return err
+	}
+	return self.File.Close()
+}
+
+// commandPipe streams into or out of a running command, Close waits for it to exit
+type commandPipe struct {
+	cmd    *privileged.Cmd
+	pipe   io.Closer
+	reader io.Reader
+	writer io.Writer
+	stderr *bytes.Buffer
+}
+
+func startPipe(cmd *privileged.Cmd, write bool) (*commandPipe, error) {
+	stream := &commandPipe{cmd: cmd, stderr: &bytes.Buffer{}}
+	cmd.Stderr = stream.stderr
+	if write {
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			return nil, err
+		}
+		stream.pipe, stream.writer = stdin, stdin
+	} else {
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, err
+		}
+		stream.pipe, stream.reader = stdout, stdout
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+func (self *commandPipe) Write(data []byte) (int, error) {
+	return self.writer.Write(data)
+}
+
+func (self *commandPipe) Read(data []byte) (int, error) {
+	return self.reader.Read(data)
+}
+
+func (self *commandPipe) Close() error {
+	//closing stdin is what tells the command the input has ended
+	if self.writer != nil {
+		self.pipe.Close()
+	}
+	if err := self.cmd.Wait(); err != nil {
+		return fmt.Errorf("%s: %v %s", self.cmd.Args[0], err, self.stderr.String())
+	}
+	return nil
+}
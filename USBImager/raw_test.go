@@ -0,0 +1,112 @@
+package usbimager
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRawStrategyWritesAndVerifiesImageFile(t *testing.T) {
+	dir := t.TempDir()
+	iso := filepath.Join(dir, "live.iso")
+	target := filepath.Join(dir, "stick.img")
+
+	//a short last block exercises the partial write path
+	payload := make([]byte, RAW_BLOCK_SIZE+3*SECTOR_SIZE)
+	rand.New(rand.NewSource(1)).Read(payload)
+	payload[510], payload[511] = 0x55, 0xAA
+	if err := os.WriteFile(iso, payload, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(target, bytes.Repeat([]byte{0xFF}, 2*RAW_BLOCK_SIZE), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	imager := NewUSBImager()
+	imager.Strategy = STRATEGY_RAW
+	if err := imager.ImageUSB(iso, target); err != nil {
+		t.Fatal(err)
+	}
+	written, err := os.ReadFile(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(written, payload) {
+		t.Fatalf("target differs from the iso, %d bytes written for a %d byte iso", len(written), len(payload))
+	}
+}
+
+func TestRawStrategyRefusesNonHybridISO(t *testing.T) {
+	dir := t.TempDir()
+	iso := filepath.Join(dir, "plain.iso")
+	target := filepath.Join(dir, "stick.img")
+	if err := os.WriteFile(iso, make([]byte, 4*SECTOR_SIZE), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(target, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	imager := NewUSBImager()
+	imager.Strategy = STRATEGY_RAW
+	err := imager.ImageUSB(iso, target)
+	if err == nil || !strings.Contains(err.Error(), "iso-hybrid") {
+		t.Fatalf("expected a non hybrid iso to be refused, got %v", err)
+	}
+	imager.Persistence = &PersistenceOptions{}
+	if err := imager.ImageUSB(iso, target); err == nil {
+		t.Fatal("expected raw imaging with persistence to be refused")
+	}
+}
+
+func TestRawImageFileIsCompressedWithChecksum(t *testing.T) {
+	if _, err := exec.LookPath("xz"); err != nil {
+		t.Skip("xz not installed")
+	}
+	dir := t.TempDir()
+	iso := filepath.Join(dir, "live.iso")
+	target := filepath.Join(dir, "live.img")
+	payload := make([]byte, 8*SECTOR_SIZE)
+	payload[510], payload[511] = 0x55, 0xAA
+	if err := os.WriteFile(iso, payload, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	imager := NewUSBImager()
+	imager.Strategy = STRATEGY_RAW
+	imager.ImageFile = &ImageFileOptions{Compression: COMPRESSION_XZ, Checksum: true}
+	subscriber := imager.GetSubscriber()
+	if err := imager.ImageUSB(iso, target); err != nil {
+		t.Fatal(err)
+	}
+	for event := range subscriber {
+		if event.Type != IMAGE_FINISHED {
+			continue
+		}
+		expected := []string{target + ".xz", target + ".xz" + CHECKSUM_EXTENSION}
+		if strings.Join(event.Artifacts, " ") != strings.Join(expected, " ") {
+			t.Fatalf("expected artifacts %v, got %v", expected, event.Artifacts)
+		}
+		break
+	}
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Errorf("uncompressed image should have been replaced, stat returned %v", err)
+	}
+
+	cmd := exec.Command("sha256sum", "-c", filepath.Base(target)+".xz"+CHECKSUM_EXTENSION)
+	cmd.Dir = dir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("checksum file does not verify: %v %s", err, output)
+	}
+	decompressed, err := exec.Command("xz", "-dc", target+".xz").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decompressed, payload) {
+		t.Fatal("compressed image does not decompress to the iso")
+	}
+}
@@ -0,0 +1,83 @@
+package usbimager
+
+import (
+	"fmt"
+	"sort"
+)
+
+// ImagingStrategy is how an iso gets onto the target, a fixed list of stages run in order
+// against the same job
+type ImagingStrategy interface {
+	// Validate rejects imager options the strategy cannot honour before anything is touched
+	Validate(imager *USBImager) error
+	Stages() []imageStage
+}
+
+type imageStage struct {
+	name string
+	run  func(*imageJob) error
+}
+
+// STRATEGIES are the imaging strategies ImageUSB can use, keyed by the name used on the command line
+var STRATEGIES = map[string]ImagingStrategy{
+	STRATEGY_PARTITION: partitionStrategy{},
+	STRATEGY_RAW:       rawStrategy{},
+}
+
+const (
+	STRATEGY_PARTITION = "partition"
+	STRATEGY_RAW       = "raw"
+	DEFAULT_STRATEGY   = STRATEGY_PARTITION
+)
+
+func StrategyNames() []string {
+	var names []string
+	for name := range STRATEGIES {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func GetStrategy(name string) (ImagingStrategy, error) {
+	strategy, exists := STRATEGIES[name]
+	if !exists {
+		return nil, fmt.Errorf("unknown imaging strategy %s, expected one of %v", name, StrategyNames())
+	}
+	return strategy, nil
+}
+
+// partitionStrategy lays out its own partitions, copies the live system and installs grub,
+// the target stays writable and can carry a persistence partition
+type partitionStrategy struct{}
+
+func (partitionStrategy) Validate(imager *USBImager) error {
+	if imager.Layout != "" {
+		if _, err := GetLayout(imager.Layout); err != nil {
+			return err
+		}
+	}
+	if imager.Persistence != nil {
+		return imager.Persistence.validate()
+	}
+	return nil
+}
+
+func (partitionStrategy) Stages() []imageStage {
+	return []imageStage{
+		{"plan layout", (*imageJob).planLayout},
+		{"prepare target", (*imageJob).prepare},
+		{"partition", (*imageJob).partition},
+		{"format", (*imageJob).format},
+		{"mount", (*imageJob).mount},
+		{"copy payload", (*imageJob).copyPayload},
+		{"install bootloaders", (*imageJob).installBootloaders},
+		{"write grub config", (*imageJob).writeGrubConfig},
+		{"write persistence config", (*imageJob).writePersistenceConf},
+		{"sync", (*imageJob).sync},
+		{"verify", (*imageJob).verify},
+		{"release target", (*imageJob).cleanup},
+		{"compress image", (*imageJob).compressImage},
+		{"write checksum", (*imageJob).writeChecksum},
+	}
+}
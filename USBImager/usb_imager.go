@@ -1,27 +1,167 @@
 package usbimager
 
 import (
+	privileged "LiveBuilder/Privileged"
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"runtime/debug"
+	"sync"
+	"time"
 )
 
 type USBImager struct {
+	Strategy     string // one of STRATEGIES, DEFAULT_STRATEGY when empty
+	KernelParams string // kernel command line of the boot entry, DEFAULT_KERNEL_PARAMS when empty
+	Layout       string // one of LAYOUTS, DEFAULT_LAYOUT when empty
+
+	Persistence *PersistenceOptions // adds a live-boot persistence partition when set
+	Force       bool                // image block devices CheckTarget would refuse, short of what the helper refuses
+	Verify      bool                // read the target back and check it against the iso
+	ImageFile   *ImageFileOptions   // size, compression and checksum when the target is a regular file
+
+	eventChannel chan ImageEvent
+	subscribers  []chan ImageEvent
+	subMutex     sync.RWMutex
+	closed       bool // set once Close drained the events, later subscribers get a closed channel
+	closeOnce    sync.Once
+
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 func NewUSBImager() *USBImager {
-	return &USBImager{}
+	imager := &USBImager{
+		Strategy:     DEFAULT_STRATEGY,
+		KernelParams: DEFAULT_KERNEL_PARAMS,
+		Layout:       DEFAULT_LAYOUT,
+		Verify:       true,
+		eventChannel: make(chan ImageEvent, 100),
+	}
+	imager.ctx, imager.cancel = context.WithCancel(context.Background())
+	go imager.listenForUpdates()
+	return imager
 }
 
-func (self *USBImager) ImageUSB(iso_file, out_file string) error {
-	inFile, outFile, err := self.initalizeFileInfos(iso_file, out_file)
+// imageJob is the state of a single ImageUSB run, cleanups run in reverse on every exit path
+type imageJob struct {
+	imager      *USBImager
+	emit        func(ImageEvent) // stamps events with the target so batch subscribers can tell devices apart
+	stage       string           // name of the running stage, for progress events
+	iso         FileObject
+	target      FileObject
+	diskpart    *DiskPartitionare
+	isoMount    *MountPoint
+	espMount    *MountPoint
+	liveMnt     *MountPoint
+	persMnt     *MountPoint
+	persistence *PersistenceOptions // the imagers options, or defaults when the layout brings a persistence partition
+	rawSum      string              // raw strategy, hash of the iso taken while it was written
+	artifact    string              // image file targets, the final file after compression
+	checksum    string              // image file targets, the checksum file when one was written
+	cleanups    []func() error
+}
+
+// ImageUSB writes a bootable (bios + uefi) live stick from iso_file onto out_file, which
+// is either a block device or a regular file, using the imagers Strategy.
+// IMAGE_FINISHED is always the last event sent before returning
+func (self *USBImager) ImageUSB(iso_file, out_file string) (err error) {
+	emit := self.emitFor(out_file)
+	started := time.Now()
+	emit(ImageEvent{Type: IMAGE_STARTED, Source: iso_file})
+	var artifacts []string
+	defer func() {
+		//a panicking stage has already been cleaned up by imageUSB, report it like any failure
+		if recovered := recover(); recovered != nil {
+			log.Printf("Imaging panicked: %v\n%s\n", recovered, debug.Stack())
+			err = fmt.Errorf("imaging panicked: %v", recovered)
+		}
+		emit(ImageEvent{Type: IMAGE_FINISHED, Duration: time.Since(started), Err: err, Artifacts: artifacts})
+	}()
+	artifacts, err = self.imageUSB(iso_file, out_file, emit)
+	return err
+}
+
+// imageUSB returns the files produced for image file targets
+func (self *USBImager) imageUSB(iso_file, out_file string, emit func(ImageEvent)) (artifacts []string, err error) {
+	strategyName := self.Strategy
+	if strategyName == "" {
+		strategyName = DEFAULT_STRATEGY
+	}
+	strategy, err := GetStrategy(strategyName)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if err := strategy.Validate(self); err != nil {
+		return nil, err
+	}
+	if self.ImageFile != nil {
+		if err := self.ImageFile.validate(); err != nil {
+			return nil, err
+		}
 	}
-	err = self.prepOutFile(outFile, calculateNeededSizeForISO(inFile))
+	inFile, outFile, err := self.initalizeFileInfos(iso_file, out_file)
 	if err != nil {
-		log.Println(err)
+		return nil, err
 	}
-	return nil
+	if outFile.Type == TypeBlockDevice {
+		if self.ImageFile != nil {
+			return nil, fmt.Errorf("%s is a block device, image file options only apply to regular files", outFile.Path)
+		}
+		if err := CheckTarget(outFile.Path, self.Force); err != nil {
+			return nil, err
+		}
+	}
+	job := &imageJob{
+		imager:   self,
+		emit:     emit,
+		iso:      inFile,
+		target:   outFile,
+		artifact: outFile.Path,
+	}
+	defer func() {
+		if cleanupErr := job.cleanup(); cleanupErr != nil {
+			log.Printf("Imaging cleanup failed: %v\n", cleanupErr)
+			err = errors.Join(err, cleanupErr)
+		}
+	}()
+
+	for _, stage := range strategy.Stages() {
+		if self.ctx.Err() != nil {
+			return nil, fmt.Errorf("imaging cancelled before %s", stage.name)
+		}
+		log.Printf("Imaging stage: %s\n", stage.name)
+		stageStarted := time.Now()
+		emit(ImageEvent{Type: STAGE_STARTED, Stage: stage.name})
+		job.stage = stage.name
+		err := stage.run(job)
+		emit(ImageEvent{Type: STAGE_FINISHED, Stage: stage.name, Duration: time.Since(stageStarted), Err: err})
+		if err != nil {
+			log.Printf("Imaging stage %s failed: %v\n", stage.name, err)
+			return nil, fmt.Errorf("%s: %w", stage.name, err)
+		}
+	}
+	log.Printf("Imaged %s onto %s\n", iso_file, out_file)
+	return job.artifacts(), nil
+}
+
+func (self *imageJob) artifacts() []string {
+	if self.target.Type != TypeRegularFile {
+		return nil
+	}
+	artifacts := []string{self.artifact}
+	if self.checksum != "" {
+		artifacts = append(artifacts, self.checksum)
+	}
+	return artifacts
+}
+
+// Cancel stops every run of this imager, running stages stop at their next block of data
+// and the targets are unmounted and detached as on any failure. The imager cannot be reused
+func (self *USBImager) Cancel() {
+	log.Println("Imaging cancelled")
+	self.cancel()
 }
 
 func (self *USBImager) initalizeFileInfos(iso_file, out_file string) (FileObject, FileObject, error) {
@@ -42,32 +182,283 @@ func (self *USBImager) initalizeFileInfos(iso_file, out_file string) (FileObject
 	return isoFileInfo, outFileInfo, nil
 }
 
-func (self *USBImager) prepOutFile(outFile FileObject, outFileSize int64) error {
-	if outFileSize == 0 {
-		outFileSize = 4 * 1024 * 1024 * 1024 //4gb default
+// progress returns a tracker for the running stage that stops copies once the imager is cancelled
+func (self *imageJob) progress() *progressTracker {
+	tracker := newProgressTracker(self.stage, self.emit)
+	tracker.ctx = self.imager.ctx
+	return tracker
+}
+
+func (self *imageJob) addCleanup(cleanup func() error) {
+	self.cleanups = append(self.cleanups, cleanup)
+}
+
+// cleanup unmounts and detaches in reverse order, every step is attempted even if one fails.
+// It also runs as a stage so image files are complete before they are compressed
+func (self *imageJob) cleanup() error {
+	var errs []error
+	for i := len(self.cleanups) - 1; i >= 0; i-- {
+		errs = append(errs, self.cleanups[i]())
+	}
+	self.cleanups = nil
+	return errors.Join(errs...)
+}
+
+func (self *imageJob) planLayout() error {
+	layoutName := self.imager.Layout
+	if layoutName == "" {
+		layoutName = DEFAULT_LAYOUT
+	}
+	layout, err := GetLayout(layoutName)
+	if err != nil {
+		return err
+	}
+	self.diskpart = layout.Build(self.target)
+	self.persistence = self.imager.Persistence
+	if layout.HasRole(ROLE_PERSISTENCE) {
+		if self.persistence == nil {
+			self.persistence = &PersistenceOptions{}
+		} else if self.persistence.Size != "" {
+			return fmt.Errorf("layout %s sizes its own persistence partition, drop the persistence size", layout.Name)
+		}
+	} else if self.persistence != nil {
+		if err := addPersistencePartition(self.diskpart, self.persistence, self.iso.Info.Size); err != nil {
+			return err
+		}
+	}
+	//labels and mkfs tools are checked before the target is touched
+	for _, partition := range self.diskpart.PartitionTable.partitions {
+		if err := partition.CheckFormat(); err != nil {
+			return err
+		}
+	}
+	if self.target.Type == TypeBlockDevice {
+		return self.checkLayoutFits(deviceSectors(self.target.Path))
+	}
+	return nil
+}
+
+// checkLayoutFits plans the table on a disk of sectors so overlaps, partitions past the end
+// of the device and a live partition too small for the iso fail before anything is wiped
+func (self *imageJob) checkLayoutFits(sectors uint64) error {
+	if sectors == 0 {
+		log.Printf("Size of %s unknown, the layout is checked when partitioning\n", self.target.Path)
+		return nil
+	}
+	label, err := self.diskpart.PartitionTable.Plan(sectors)
+	if err != nil {
+		return fmt.Errorf("layout does not fit %s: %w", self.target.Path, err)
+	}
+	liveNumber, _, err := self.diskpart.PartitionWithRole(ROLE_LIVE)
+	if err != nil {
+		return err
+	}
+	if live := label.Partitions[liveNumber-1]; live.Size() < self.iso.Info.Size {
+		return fmt.Errorf("live partition of %s on %s can not hold the %s iso", formatBytes(live.Size()), self.target.Path, formatBytes(self.iso.Info.Size))
+	}
+	return nil
+}
+
+// requiredSize is how large an image file has to be to hold every partition of the layout,
+// percentage sized partitions grow the file until each of them reaches its minimum
+func (self *imageJob) requiredSize() (int64, error) {
+	fixed := int64(2 * 1024 * 1024) //alignment before the first partition and the gpt backup header
+	percentTotal := 0
+	var size int64
+	for _, partition := range self.diskpart.PartitionTable.partitions {
+		var minimum int64 = ALIGNMENT_SECTORS * SECTOR_SIZE
+		switch partition.role {
+		case ROLE_LIVE:
+			minimum = livePartitionSize(self.iso.Info.Size)
+		case ROLE_PERSISTENCE:
+			minimum = PERSISTENCE_MIN_FILE_SIZE
+		}
+		percent, isPercent, err := parsePercentSize(partition.Size())
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case isPercent:
+			percentTotal += percent
+			fixed += ALIGNMENT_SECTORS * SECTOR_SIZE //each one is rounded down to the alignment
+			size = max(size, (minimum*100+int64(percent)-1)/int64(percent))
+		case partition.Size() != "":
+			partSize, err := parseSize(partition.Size())
+			if err != nil {
+				return 0, err
+			}
+			fixed += partSize
+		default:
+			fixed += minimum
+		}
+	}
+	if percentTotal >= 100 {
+		return 0, fmt.Errorf("percentage sizes add up to %d%%, an image file can not be sized for them", percentTotal)
 	}
+	size = max(size, fixed*100/int64(100-percentTotal))
+	return roundUpToMB(size), nil
+}
 
-	if outFile.Info.Size < outFileSize {
-		log.Println("Out file to small, resizing")
-		err := outFile.resize(outFileSize)
+func (self *imageJob) prepare() error {
+	if self.target.Type == TypeRegularFile {
+		size, err := self.imageFileSize()
 		if err != nil {
 			return err
 		}
+		log.Printf("Allocating %s image file %s\n", formatBytes(size), self.target.Path)
+		return self.target.allocate(size)
 	}
 	//if its a storage device unmount it and wipe it
-	if outFile.Type == TypeBlockDevice {
-		err := outFile.umountPartitions()
+	if self.target.Type == TypeBlockDevice {
+		err := self.target.umountPartitions()
 		if err != nil {
 			return err
 		}
-		err = outFile.wipeFS()
+		err = self.target.wipeFS()
 		if err != nil {
 			return err
 		}
 	}
-	diskpart := StandardLinuxMBRBootPart(outFile)
-	diskpart.PartitionDisk()
-	diskpart.WriteFileSystems()
+	return nil
+}
+
+func (self *imageJob) partition() error {
+	self.addCleanup(self.diskpart.Close)
+	return self.diskpart.PartitionDisk()
+}
+
+func (self *imageJob) format() error {
+	return self.diskpart.WriteFileSystems()
+}
+
+func (self *imageJob) mount() error {
+	var err error
+	self.isoMount, err = mountISO(self.iso.Path)
+	if err != nil {
+		return err
+	}
+	self.addCleanup(self.isoMount.Unmount)
 
+	espNumber, esp, err := self.diskpart.PartitionWithRole(ROLE_ESP)
+	if err != nil {
+		return err
+	}
+	self.espMount, err = mountDevice(self.diskpart.PartitionPath(espNumber), esp.MountType(), false)
+	if err != nil {
+		return err
+	}
+	self.addCleanup(self.espMount.Unmount)
+
+	liveNumber, live, err := self.diskpart.PartitionWithRole(ROLE_LIVE)
+	if err != nil {
+		return err
+	}
+	self.liveMnt, err = mountDevice(self.diskpart.PartitionPath(liveNumber), live.MountType(), false)
+	if err != nil {
+		return err
+	}
+	self.addCleanup(self.liveMnt.Unmount)
+
+	if self.persistence == nil {
+		return nil
+	}
+	persNumber, pers, err := self.diskpart.PartitionWithRole(ROLE_PERSISTENCE)
+	if err != nil {
+		return err
+	}
+	self.persMnt, err = mountDevice(self.diskpart.PartitionPath(persNumber), pers.MountType(), false)
+	if err != nil {
+		return err
+	}
+	self.addCleanup(self.persMnt.Unmount)
+	return nil
+}
+
+func (self *imageJob) copyPayload() error {
+	return copyPayload(self.isoMount.Dir, self.liveMnt.Dir, self.progress())
+}
+
+func (self *imageJob) installBootloaders() error {
+	return installBootloaders(self.diskpart.DiskDevice(), self.espMount.Dir, self.liveMnt.Dir)
+}
+
+func (self *imageJob) writeGrubConfig() error {
+	_, live, err := self.diskpart.PartitionWithRole(ROLE_LIVE)
+	if err != nil {
+		return err
+	}
+	kernel, initrd, err := findBootFiles(self.liveMnt.Dir)
+	if err != nil {
+		return err
+	}
+	params := self.imager.KernelParams
+	if params == "" {
+		params = DEFAULT_KERNEL_PARAMS
+	}
+	if self.persistence != nil {
+		params += " " + PERSISTENCE_KERNEL_FLAG
+	}
+	return writeGrubConfigs(self.espMount.Dir, self.liveMnt.Dir, GrubConfig{
+		Label:        live.FilesystemLabel(),
+		Title:        "Live system",
+		Kernel:       kernel,
+		Initrd:       initrd,
+		KernelParams: params,
+	})
+}
+
+func (self *imageJob) writePersistenceConf() error {
+	if self.persMnt == nil {
+		return nil
+	}
+	return writePersistenceConf(self.persMnt.Dir, self.persistence)
+}
+
+func (self *imageJob) sync() error {
+	if _, stderr, err := run("sync"); err != nil {
+		return fmt.Errorf("sync: %v %s", err, stderr)
+	}
+	return nil
+}
+
+// verify reads the partition table and the live payload back from the target, the live
+// partition is remounted read only after a buffer flush so nothing comes from the page cache
+func (self *imageJob) verify() error {
+	if !self.imager.Verify {
+		log.Println("Skipping verification")
+		return nil
+	}
+	result := &VerificationError{}
+	label, err := readDiskLabel(self.diskpart.DiskDevice())
+	if err != nil {
+		return fmt.Errorf("reading back the partition table: %w", err)
+	}
+	result.TableErrors = compareTable(self.diskpart.PartitionTable, label)
+
+	liveNumber, live, err := self.diskpart.PartitionWithRole(ROLE_LIVE)
+	if err != nil {
+		return err
+	}
+	if err := self.liveMnt.Unmount(); err != nil {
+		return err
+	}
+	liveDevice := self.diskpart.PartitionPath(liveNumber)
+	if _, stderr, err := privileged.Run("blockdev", "--flushbufs", liveDevice); err != nil {
+		return fmt.Errorf("flushing %s: %v %s", liveDevice, err, stderr)
+	}
+	self.liveMnt, err = mountDevice(liveDevice, live.MountType(), true)
+	if err != nil {
+		return err
+	}
+	self.addCleanup(self.liveMnt.Unmount)
+
+	result.Mismatches, err = verifyPayload(self.isoMount.Dir, self.liveMnt.Dir, self.progress())
+	if err != nil {
+		return err
+	}
+	if !result.empty() {
+		return result
+	}
+	log.Printf("Verified %s\n", self.target.Path)
 	return nil
 }
@@ -183,7 +183,10 @@ func getUsernameForUID(uid string) string {
 }
 
 func calculateNeededSizeForISO(iso FileObject) int64 {
-	bytes := iso.Info.Size
+	return roundUpToGB(iso.Info.Size)
+}
+
+func roundUpToGB(bytes int64) int64 {
 	const GB = 1024 * 1024 * 1024
 	if bytes <= 0 {
 		return 0
@@ -192,14 +195,21 @@ func calculateNeededSizeForISO(iso FileObject) int64 {
 	return gbNeeded * GB
 }
 
+func roundUpToMB(bytes int64) int64 {
+	const MB = 1024 * 1024
+	if bytes <= 0 {
+		return 0
+	}
+	return (bytes + MB - 1) / MB * MB
+}
+
 func run(cmd string, args ...string) (string, string, error) {
 	var out bytes.Buffer
 	var err bytes.Buffer
 	c := exec.Command(cmd, args...)
 	c.Stdout = &out
 	c.Stderr = &err
-	if err := c.Run(); err != nil {
-		return "", "", err
-	}
-	return out.String(), err.String(), nil
+	//output is returned on failure too, stderr is usually the only useful part of the error
+	runErr := c.Run()
+	return out.String(), err.String(), runErr
 }
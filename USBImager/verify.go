@@ -0,0 +1,222 @@
+package usbimager
+
+/*
+Post write verification. The live partition is remounted read only after its buffers
+are flushed so files are read back from the target rather than the page cache, then
+every /live file is hashed against the isos sha256sum.txt (md5sum.txt on older builds).
+The partition table is decoded straight from the target and compared to the requested one
+*/
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// checksum files live-build writes into the iso root, in order of preference
+var ISO_CHECKSUM_FILES = []struct {
+	name   string
+	hasher func() hash.Hash
+}{
+	{"sha256sum.txt", sha256.New},
+	{"md5sum.txt", md5.New},
+}
+
+type FileMismatch struct {
+	Path     string // relative to the live partition root
+	Expected string
+	Actual   string // empty when the file could not be read
+	Err      error
+}
+
+func (self FileMismatch) String() string {
+	if self.Err != nil {
+		return fmt.Sprintf("%s: %v", self.Path, self.Err)
+	}
+	return fmt.Sprintf("%s: expected %s got %s", self.Path, self.Expected, self.Actual)
+}
+
+// VerificationError lists everything that did not match what was requested
+type VerificationError struct {
+	Mismatches  []FileMismatch
+	TableErrors []string
+}
+
+func (self *VerificationError) Error() string {
+	var lines []string
+	for _, tableErr := range self.TableErrors {
+		lines = append(lines, "partition table: "+tableErr)
+	}
+	for _, mismatch := range self.Mismatches {
+		lines = append(lines, "file "+mismatch.String())
+	}
+	return fmt.Sprintf("verification failed with %d problem(s):\n\t%s", len(lines), strings.Join(lines, "\n\t"))
+}
+
+func (self *VerificationError) empty() bool {
+	return len(self.Mismatches) == 0 && len(self.TableErrors) == 0
+}
+
+type checksumEntry struct {
+	path string // relative, slash separated, without a leading ./
+	sum  string
+}
+
+// readISOChecksums returns the isos checksums for files under live/ and the matching hasher,
+// nil entries when the iso ships no checksum file
+func readISOChecksums(isoRoot string) ([]checksumEntry, func() hash.Hash, error) {
+	for _, checksumFile := range ISO_CHECKSUM_FILES {
+		file, err := os.Open(filepath.Join(isoRoot, checksumFile.name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		defer file.Close()
+
+		var entries []checksumEntry
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) != 2 {
+				continue
+			}
+			path := strings.TrimPrefix(strings.TrimPrefix(fields[1], "*"), "./")
+			if strings.HasPrefix(path, "live/") {
+				entries = append(entries, checksumEntry{path: path, sum: strings.ToLower(fields[0])})
+			}
+		}
+		return entries, checksumFile.hasher, scanner.Err()
+	}
+	return nil, sha256.New, nil
+}
+
+func hashFile(path string, newHasher func() hash.Hash, progress *progressTracker) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hasher := newHasher()
+	var writer io.Writer = hasher
+	if progress != nil {
+		writer = io.MultiWriter(hasher, progress)
+	}
+	if _, err := io.Copy(writer, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// verifyPayload compares the live partitions files to the isos checksums. The kernel and
+// initrd, and every file when the iso has no checksum file, are compared to the isos copy directly
+func verifyPayload(isoRoot, liveRoot string, progress *progressTracker) ([]FileMismatch, error) {
+	entries, newHasher, err := readISOChecksums(isoRoot)
+	if err != nil {
+		return nil, err
+	}
+	listed := make(map[string]bool)
+	for _, entry := range entries {
+		listed[entry.path] = true
+	}
+
+	var unlisted []string
+	if len(entries) == 0 {
+		err := filepath.WalkDir(filepath.Join(isoRoot, "live"), func(path string, entry os.DirEntry, err error) error {
+			if err != nil || !entry.Type().IsRegular() {
+				return err
+			}
+			rel, err := filepath.Rel(isoRoot, path)
+			unlisted = append(unlisted, filepath.ToSlash(rel))
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	} else if kernel, initrd, err := findBootFiles(isoRoot); err == nil {
+		for _, bootFile := range []string{kernel, initrd} {
+			if rel := strings.TrimPrefix(bootFile, "/"); !listed[rel] {
+				unlisted = append(unlisted, rel)
+			}
+		}
+	}
+	for _, path := range unlisted {
+		sum, err := hashFile(filepath.Join(isoRoot, filepath.FromSlash(path)), newHasher, nil)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, checksumEntry{path: path, sum: sum})
+	}
+
+	var total int64
+	for _, entry := range entries {
+		if info, err := os.Stat(filepath.Join(liveRoot, filepath.FromSlash(entry.path))); err == nil {
+			total += info.Size()
+		}
+	}
+	progress.setTotal(total)
+
+	var mismatches []FileMismatch
+	for _, entry := range entries {
+		actual, err := hashFile(filepath.Join(liveRoot, filepath.FromSlash(entry.path)), newHasher, progress)
+		if err != nil {
+			mismatches = append(mismatches, FileMismatch{Path: entry.path, Expected: entry.sum, Err: err})
+			continue
+		}
+		if actual != entry.sum {
+			mismatches = append(mismatches, FileMismatch{Path: entry.path, Expected: entry.sum, Actual: actual})
+		}
+	}
+	return mismatches, nil
+}
+
+// compareTable checks the table read back from the target against the requested one, only
+// what the request pinned down is compared since Plan fills in and aligns the rest
+func compareTable(requested *PartitionTabelBuilder, label *DiskLabel) []string {
+	var problems []string
+	if label.Type != requested.label {
+		problems = append(problems, fmt.Sprintf("label is %q, requested %q", label.Type, requested.label))
+	}
+	if len(label.Partitions) != len(requested.partitions) {
+		problems = append(problems, fmt.Sprintf("%d partitions, requested %d", len(label.Partitions), len(requested.partitions)))
+		return problems
+	}
+	for i, definition := range requested.partitions {
+		written := label.Partitions[i]
+		number := i + 1
+		if definition.partType != "" {
+			expected, err := definition.partType.ForTable(requested.label)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("partition %d: %v", number, err))
+			} else if !strings.EqualFold(strings.TrimLeft(string(written.Type), "0"), strings.TrimLeft(string(expected), "0")) {
+				problems = append(problems, fmt.Sprintf("partition %d type is %s, requested %s", number, written.Type, expected))
+			}
+		}
+		if start := definition.stringAttributes["start"]; start != "" && strconv.FormatUint(written.Start, 10) != start {
+			problems = append(problems, fmt.Sprintf("partition %d starts at %d, requested %s", number, written.Start, start))
+		}
+		//percentage sizes depend on the disk, the table plan already placed them
+		if size := definition.Size(); size != "" && !strings.HasSuffix(size, "%") {
+			requestedBytes, err := parseSize(size)
+			if err != nil || written.Size() != requestedBytes {
+				problems = append(problems, fmt.Sprintf("partition %d size is %d sectors, requested %s", number, written.Sectors, size))
+			}
+		}
+		if requested.label == TABLETYPE_GPT && definition.volumeName != "" && written.Name != definition.volumeName {
+			problems = append(problems, fmt.Sprintf("partition %d name is %q, requested %q", number, written.Name, definition.volumeName))
+		}
+		if requested.label == TABLETYPE_MBR && definition.bootable != written.Bootable {
+			problems = append(problems, fmt.Sprintf("partition %d bootable flag is %t, requested %t", number, written.Bootable, definition.bootable))
+		}
+	}
+	return problems
+}
@@ -0,0 +1,52 @@
+package usbimager
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVerifyPayloadReportsMismatches(t *testing.T) {
+	isoRoot := t.TempDir()
+	liveRoot := t.TempDir()
+	for _, root := range []string{isoRoot, liveRoot} {
+		os.MkdirAll(filepath.Join(root, "live"), 0755)
+		os.WriteFile(filepath.Join(root, "live", "vmlinuz"), []byte("kernel"), 0644)
+		os.WriteFile(filepath.Join(root, "live", "initrd.img"), []byte("initrd"), 0644)
+	}
+	os.WriteFile(filepath.Join(isoRoot, "live", "filesystem.squashfs"), []byte("squashfs"), 0644)
+	os.WriteFile(filepath.Join(liveRoot, "live", "filesystem.squashfs"), []byte("corrupt"), 0644)
+
+	squashfsSum, _ := hashFile(filepath.Join(isoRoot, "live", "filesystem.squashfs"), ISO_CHECKSUM_FILES[0].hasher, nil)
+	os.WriteFile(filepath.Join(isoRoot, "sha256sum.txt"), []byte(squashfsSum+"  ./live/filesystem.squashfs\n"+
+		"0000  ./live/missing.txt\n"+squashfsSum+"  ./isolinux/isolinux.bin\n"), 0644)
+
+	mismatches, err := verifyPayload(isoRoot, liveRoot, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, mismatch := range mismatches {
+		paths = append(paths, mismatch.Path)
+	}
+	if strings.Join(paths, ",") != "live/filesystem.squashfs,live/missing.txt" {
+		t.Fatalf("unexpected mismatches %v", mismatches)
+	}
+}
+
+func TestCompareTable(t *testing.T) {
+	requested := buildLayout(t, LAYOUT_MBR, FileObject{Path: "/dev/sdb"}).PartitionTable
+	label, err := requested.Plan(2048 + 1048576 + 29212672)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if problems := compareTable(requested, label); len(problems) != 0 {
+		t.Fatalf("expected a matching table, got %v", problems)
+	}
+	label.Partitions[0].Type = Linux
+	label.Partitions[0].Bootable = false
+	if problems := compareTable(requested, label); len(problems) != 2 {
+		t.Fatalf("expected type and bootable problems, got %v", problems)
+	}
+}
@@ -3,6 +3,8 @@ package buildwindow
 import (
 	buildmanager "LiveBuilder/BuildManager"
 	logger "LiveBuilder/BuildManager/Logger"
+	variableswindow "LiveBuilder/frontend/VariablesWindow"
+	"context"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/container"
@@ -23,18 +25,24 @@ type BuildWindow struct {
 	logContent        strings.Builder
 	buildManager      *buildmanager.BuildManager
 	logScroll         *container.Scroll
+	buildButton       *widget.Button
+	cancelButton      *widget.Button
+	cancelBuild       context.CancelFunc
+	stageList         *StageList
+	content           *fyne.Container
 	//buildLogText      *widget.RichText
 	//livebuilder       *execution.LiveBuilder
 }
 
-func NewBuildWindow(window fyne.Window) *fyne.Container {
-	build_window := BuildWindow{
+func NewBuildWindow(window fyne.Window) *BuildWindow {
+	build_window := &BuildWindow{
 		window:            window,
 		selectedPathLabel: widget.NewLabel("Select folder"),
 		buildStatusLabel:  widget.NewLabel("Statuses"),
 	}
 
 	build_window.logWidget = logger.NewLogView(500)
+	build_window.stageList = NewStageList()
 
 	build_window.logScroll = container.NewScroll(build_window.logWidget)
 	build_window.logScroll.SetMinSize(fyne.NewSize(600, 500))
@@ -45,7 +53,12 @@ func NewBuildWindow(window fyne.Window) *fyne.Container {
 	//go build_window.startLogSubscriberWithBatching()
 	filesectionHeader := build_window.buildFolderSelectionHeader()
 	buildArea := build_window.buildMainBuildArea()
-	return container.NewBorder(filesectionHeader, nil, nil, nil, buildArea)
+	build_window.content = container.NewBorder(filesectionHeader, nil, nil, nil, buildArea)
+	return build_window
+}
+
+func (self *BuildWindow) GetContainer() *fyne.Container {
+	return self.content
 }
 
 func (self *BuildWindow) buildFolderSelectionHeader() *fyne.Container {
@@ -81,7 +94,7 @@ func (self *BuildWindow) startLogSubscriberWithBatching() {
 		flushInterval = 3 * time.Second
 	)
 
-	var pendingUpdates []buildmanager.LogUpdate
+	var pendingUpdates []buildmanager.BuildEvent
 	var mu sync.Mutex
 	var lastFlush time.Time = time.Now()
 
@@ -93,7 +106,7 @@ func (self *BuildWindow) startLogSubscriberWithBatching() {
 			return
 		}
 
-		updates := make([]buildmanager.LogUpdate, len(pendingUpdates))
+		updates := make([]buildmanager.BuildEvent, len(pendingUpdates))
 		copy(updates, pendingUpdates)
 		pendingUpdates = pendingUpdates[:0]
 		lastFlush = time.Now()
@@ -102,10 +115,7 @@ func (self *BuildWindow) startLogSubscriberWithBatching() {
 			fyne.Do(func() {
 
 			})
-			if !update.Append {
-				self.logWidget.Clear()
-			}
-			self.logWidget.AppendLine(update.Message)
+			self.logWidget.AppendLine(update.String())
 		}
 	}
 
@@ -128,10 +138,7 @@ func (self *BuildWindow) startLogSubscriberWithBatching() {
 	// Collect updates
 	for update := range subscriber {
 		mu.Lock()
-		pendingUpdates = append(pendingUpdates, buildmanager.LogUpdate{
-			Message: update.Message,
-			Append:  update.Append,
-		})
+		pendingUpdates = append(pendingUpdates, update)
 		shouldFlush := len(pendingUpdates) >= maxBatchSize
 		mu.Unlock()
 
@@ -146,12 +153,10 @@ func (self *BuildWindow) startLogSubscriberWithBatching() {
 
 func (self *BuildWindow) startLogSubscriber() {
 	subscriber := self.buildManager.GetSubscriber()
-	for update := range subscriber {
+	for event := range subscriber {
 		fyne.Do(func() {
-			if !update.Append {
-				self.logWidget.Clear()
-			}
-			self.logWidget.AppendLine(update.Message)
+			self.stageList.Update(event)
+			self.logWidget.AppendLine(event.String())
 			self.logScroll.ScrollToBottom()
 		})
 
@@ -159,19 +164,61 @@ func (self *BuildWindow) startLogSubscriber() {
 }
 
 func (self *BuildWindow) buildMainBuildArea() *fyne.Container {
-	buildButton := widget.NewButton("Execute Live Build", func() {
-		self.logContent.Reset()
-		self.buildStatusLabel.SetText("Building...")
+	self.buildButton = widget.NewButton("Execute Live Build", self.StartBuild)
+	self.cancelButton = widget.NewButton("Cancel Build", func() {
+		if self.cancelBuild != nil {
+			self.buildStatusLabel.SetText("Cancelling...")
+			self.cancelButton.Disable()
+			self.cancelBuild()
+		}
+	})
+	self.cancelButton.Disable()
+
+	buttons := container.NewGridWithColumns(2, self.buildButton, self.cancelButton)
+	hbox := container.NewBorder(buttons, self.buildStatusLabel, self.stageList.GetContainer(), nil, self.logScroll)
+	return hbox
+}
 
-		go func() {
-			self.buildManager.Build(self.buildPath)
-			log.Println("all building done, display final message")
+// StartBuild builds the current selection, does nothing if a build is already running.
+// Required variables without a value are asked for first
+func (self *BuildWindow) StartBuild() {
+	if self.cancelBuild != nil {
+		return
+	}
+	variableswindow.PromptForMissing(self.window, self.startBuild)
+}
 
-			self.buildStatusLabel.SetText("Building Finished!")
+func (self *BuildWindow) startBuild() {
+	if self.cancelBuild != nil {
+		return
+	}
+	self.logContent.Reset()
+	self.logWidget.Clear()
+	self.stageList.Reset()
+	self.buildStatusLabel.SetText("Building...")
 
-		}()
-	})
+	ctx, cancel := context.WithCancel(context.Background())
+	self.cancelBuild = cancel
+	self.buildButton.Disable()
+	self.cancelButton.Enable()
 
-	hbox := container.NewBorder(buildButton, self.buildStatusLabel, nil, nil, self.logScroll)
-	return hbox
+	go func() {
+		defer cancel()
+		err := self.buildManager.Build(ctx, self.buildPath)
+		log.Println("all building done, display final message")
+
+		fyne.Do(func() {
+			switch {
+			case ctx.Err() != nil:
+				self.buildStatusLabel.SetText("Build Cancelled")
+			case err != nil:
+				self.buildStatusLabel.SetText("Build Failed: " + err.Error())
+			default:
+				self.buildStatusLabel.SetText("Building Finished!")
+			}
+			self.cancelBuild = nil
+			self.buildButton.Enable()
+			self.cancelButton.Disable()
+		})
+	}()
 }
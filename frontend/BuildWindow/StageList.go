@@ -0,0 +1,69 @@
+package buildwindow
+
+import (
+	buildmanager "LiveBuilder/BuildManager"
+	"fmt"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+)
+
+// StageList shows every build stage with its state and duration plus an overall progress bar
+type StageList struct {
+	labels   map[string]*widget.Label
+	finished int
+	progress *widget.ProgressBar
+	content  *fyne.Container
+}
+
+func NewStageList() *StageList {
+	stageList := &StageList{
+		labels:   make(map[string]*widget.Label),
+		progress: widget.NewProgressBar(),
+	}
+	stageList.progress.Max = float64(len(buildmanager.BuildStages))
+
+	stageList.content = container.NewVBox(widget.NewLabelWithStyle("Stages", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}))
+	for _, stage := range buildmanager.BuildStages {
+		label := widget.NewLabel("")
+		stageList.labels[stage] = label
+		stageList.content.Add(label)
+	}
+	stageList.content.Add(stageList.progress)
+	stageList.Reset()
+	return stageList
+}
+
+func (self *StageList) Reset() {
+	self.finished = 0
+	self.progress.SetValue(0)
+	for stage, label := range self.labels {
+		label.SetText(fmt.Sprintf("○ %s", stage))
+	}
+}
+
+// Update applies a build event, events for stages not in the list are ignored
+func (self *StageList) Update(event buildmanager.BuildEvent) {
+	label, ok := self.labels[event.Stage]
+	if !ok {
+		return
+	}
+	switch event.Type {
+	case buildmanager.STAGE_STARTED:
+		label.SetText(fmt.Sprintf("▶ %s", event.Stage))
+	case buildmanager.STAGE_FINISHED:
+		if event.Err != nil {
+			label.SetText(fmt.Sprintf("✗ %s (%s)", event.Stage, event.Duration.Round(time.Second)))
+			return
+		}
+		label.SetText(fmt.Sprintf("✓ %s (%s)", event.Stage, event.Duration.Round(time.Second)))
+		self.finished++
+		self.progress.SetValue(float64(self.finished))
+	}
+}
+
+func (self *StageList) GetContainer() *fyne.Container {
+	return self.content
+}
@@ -186,6 +186,9 @@ func (self *FileListContainer) buildFileList() *widget.List {
 	}
 
 	self.list = list
+	appstate.GetGlobalState().OnChange(func() {
+		fyne.Do(list.Refresh)
+	})
 	return list
 }
 
@@ -1,6 +1,7 @@
 package filelistwidgets
 
 import (
+	buildmanager "LiveBuilder/BuildManager"
 	filesystem "LiveBuilder/Filesystem"
 	"fmt"
 	"os"
@@ -113,7 +114,15 @@ func (self *FileListItem) getFileContents() string {
 	if err != nil {
 		return fmt.Sprintf("Error reading file: %v", err)
 	}
-	return string(bytes)
+	if !self.fileEntry.MetaData.Template {
+		return string(bytes)
+	}
+	//templates are previewed the way the import will write them
+	rendered, err := filesystem.RenderTemplate(self.fileEntry.Name(), bytes, buildmanager.PreviewVariables())
+	if err != nil {
+		return fmt.Sprintf("%v\n\nTemplate source:\n%s", err, bytes)
+	}
+	return string(rendered)
 }
 
 func (self *FileListItem) Tapped(_ *fyne.PointEvent) {
@@ -135,6 +144,19 @@ func (self *FileListItem) Tapped(_ *fyne.PointEvent) {
 		if self.fileEntry.MetaData.FileType != "" {
 			header += fmt.Sprintf("Type: %s\n", self.fileEntry.MetaData.FileType)
 		}
+		if mode, err := self.fileEntry.MetaData.FileMode(); err != nil {
+			header += fmt.Sprintf("Mode: %v\n", err)
+		} else {
+			header += fmt.Sprintf("Mode: %v\n", mode)
+		}
+		if ownership, err := self.fileEntry.MetaData.Ownership(); err != nil {
+			header += fmt.Sprintf("Owner: %v\n", err)
+		} else if ownership != "" {
+			header += fmt.Sprintf("Owner: %s\n", ownership)
+		}
+		if self.fileEntry.MetaData.Template {
+			header += "Template: rendered with the current variables\n"
+		}
 		header += strings.Repeat("-", 50)
 
 		self.fileListContainer.fileViewHeader.SetText(header)
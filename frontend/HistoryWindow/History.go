@@ -0,0 +1,227 @@
+package historywindow
+
+import (
+	buildmanager "LiveBuilder/BuildManager"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+const ALL_STATUSES = "All"
+
+type HistoryWindow struct {
+	window       fyne.Window
+	onRerun      func()
+	entries      []buildmanager.HistoryEntry
+	visible      []buildmanager.HistoryEntry
+	selected     *buildmanager.HistoryEntry
+	searchEntry  *widget.Entry
+	statusSelect *widget.Select
+	list         *widget.List
+	details      *widget.Label
+	actions      *fyne.Container
+	content      *fyne.Container
+}
+
+// NewHistoryWindow builds the history tab, onRerun is called after a past builds inputs
+// have been applied to the global state so the caller can start the build
+func NewHistoryWindow(window fyne.Window, onRerun func()) *HistoryWindow {
+	history := &HistoryWindow{
+		window:  window,
+		onRerun: onRerun,
+		details: widget.NewLabel("Select a build from the list"),
+	}
+	history.details.Wrapping = fyne.TextWrapWord
+
+	history.searchEntry = widget.NewEntry()
+	history.searchEntry.SetPlaceHolder("Search id, profile, error or files")
+	history.searchEntry.OnChanged = func(string) { history.applyFilter() }
+	//SetSelected runs applyFilter, which needs the list and actions built
+	history.list = history.buildList()
+	history.actions = history.buildActions()
+	history.actions.Hide()
+
+	history.statusSelect = widget.NewSelect(append([]string{ALL_STATUSES}, buildmanager.HistoryStatuses...), func(string) {
+		history.applyFilter()
+	})
+	history.statusSelect.SetSelected(ALL_STATUSES)
+	refreshButton := widget.NewButton("Refresh", history.Refresh)
+
+	filterBar := container.NewBorder(nil, nil, nil, container.NewHBox(history.statusSelect, refreshButton), history.searchEntry)
+	detailPane := container.NewBorder(nil, history.actions, nil, nil, container.NewScroll(history.details))
+	split := container.NewHSplit(history.list, detailPane)
+	split.SetOffset(0.45)
+
+	history.Refresh()
+	history.content = container.NewBorder(filterBar, nil, nil, nil, split)
+	return history
+}
+
+func (self *HistoryWindow) GetContainer() *fyne.Container {
+	return self.content
+}
+
+func (self *HistoryWindow) Refresh() {
+	entries, err := buildmanager.ListHistory()
+	if err != nil {
+		log.Printf("Error listing build history: %v\n", err)
+		dialog.ShowError(err, self.window)
+		return
+	}
+	self.entries = entries
+	self.applyFilter()
+}
+
+func (self *HistoryWindow) applyFilter() {
+	filter := buildmanager.HistoryFilter{Search: self.searchEntry.Text}
+	if self.statusSelect.Selected != ALL_STATUSES {
+		filter.Status = self.statusSelect.Selected
+	}
+	self.visible = buildmanager.FilterHistory(self.entries, filter)
+	self.selected = nil
+	self.list.UnselectAll()
+	self.list.Refresh()
+	self.details.SetText("Select a build from the list")
+	self.actions.Hide()
+}
+
+func (self *HistoryWindow) buildList() *widget.List {
+	list := widget.NewList(
+		func() int {
+			return len(self.visible)
+		},
+		func() fyne.CanvasObject {
+			label := widget.NewLabel("")
+			label.TextStyle = fyne.TextStyle{Monospace: true}
+			return label
+		},
+		func(id widget.ListItemID, item fyne.CanvasObject) {
+			if id >= len(self.visible) {
+				return
+			}
+			item.(*widget.Label).SetText(self.visible[id].Summary())
+		},
+	)
+	list.OnSelected = func(id widget.ListItemID) {
+		if id >= len(self.visible) {
+			return
+		}
+		entry := self.visible[id]
+		self.selected = &entry
+		self.details.SetText(describeEntry(entry))
+		self.actions.Show()
+	}
+	return list
+}
+
+func (self *HistoryWindow) buildActions() *fyne.Container {
+	return container.NewGridWithColumns(4,
+		widget.NewButton("Open Log", self.openLog),
+		widget.NewButton("Re-run", self.rerun),
+		widget.NewButton("Delete ISOs", self.deleteArtifacts),
+		widget.NewButton("Delete Build", self.deleteRecord),
+	)
+}
+
+func describeEntry(entry buildmanager.HistoryEntry) string {
+	manifest := entry.Manifest
+	var text strings.Builder
+	fmt.Fprintf(&text, "Build: %s\n", manifest.ID)
+	fmt.Fprintf(&text, "Status: %s\n", manifest.Status)
+	if manifest.Error != "" {
+		fmt.Fprintf(&text, "Error: %s\n", manifest.Error)
+	}
+	fmt.Fprintf(&text, "Profile: %s\n", manifest.Inputs.Name)
+	fmt.Fprintf(&text, "Started: %s\n", manifest.StartTime.Format(time.RFC1123))
+	fmt.Fprintf(&text, "Duration: %s\n", manifest.Duration.Round(time.Second))
+	fmt.Fprintf(&text, "App version: %s, lb version: %s\n", manifest.AppVersion, manifest.LBVersion)
+	for identifier, names := range manifest.Inputs.Selections {
+		if len(names) > 0 {
+			fmt.Fprintf(&text, "%s: %s\n", identifier, strings.Join(names, ", "))
+		}
+	}
+	artifacts := entry.ExistingArtifacts()
+	if len(artifacts) == 0 {
+		text.WriteString("ISO: none on disk\n")
+	}
+	for _, artifact := range artifacts {
+		fmt.Fprintf(&text, "ISO: %s\n", artifact)
+	}
+	return text.String()
+}
+
+func (self *HistoryWindow) openLog() {
+	if self.selected == nil {
+		return
+	}
+	data, err := os.ReadFile(self.selected.LogPath())
+	if err != nil {
+		dialog.ShowError(err, self.window)
+		return
+	}
+	logLabel := widget.NewLabel(string(data))
+	logLabel.TextStyle = fyne.TextStyle{Monospace: true}
+	scroll := container.NewScroll(logLabel)
+	scroll.SetMinSize(fyne.NewSize(800, 500))
+	dialog.ShowCustom(self.selected.ID(), "Close", scroll, self.window)
+}
+
+func (self *HistoryWindow) rerun() {
+	if self.selected == nil {
+		return
+	}
+	entry := *self.selected
+	message := fmt.Sprintf("Re-run build %s with the same selections?", entry.ID())
+	if changed := entry.ChangedInputs(); len(changed) > 0 {
+		message += "\n\nThese files have changed since:\n" + strings.Join(changed, "\n")
+	}
+	dialog.ShowConfirm("Re-run Build", message, func(confirmed bool) {
+		if !confirmed {
+			return
+		}
+		if err := entry.ApplyInputs(); err != nil {
+			dialog.ShowError(err, self.window)
+			return
+		}
+		self.onRerun()
+	}, self.window)
+}
+
+func (self *HistoryWindow) deleteArtifacts() {
+	if self.selected == nil {
+		return
+	}
+	entry := *self.selected
+	dialog.ShowConfirm("Delete ISOs", fmt.Sprintf("Delete the ISOs of build %s? The log is kept.", entry.ID()), func(confirmed bool) {
+		if !confirmed {
+			return
+		}
+		if err := entry.DeleteArtifacts(); err != nil {
+			dialog.ShowError(err, self.window)
+		}
+		self.Refresh()
+	}, self.window)
+}
+
+func (self *HistoryWindow) deleteRecord() {
+	if self.selected == nil {
+		return
+	}
+	entry := *self.selected
+	dialog.ShowConfirm("Delete Build", fmt.Sprintf("Delete build %s including its ISOs and log?", entry.ID()), func(confirmed bool) {
+		if !confirmed {
+			return
+		}
+		if err := entry.Delete(); err != nil {
+			dialog.ShowError(err, self.window)
+		}
+		self.Refresh()
+	}, self.window)
+}
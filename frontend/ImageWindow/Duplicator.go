@@ -0,0 +1,288 @@
+package imagewindow
+
+import (
+	usbimager "LiveBuilder/USBImager"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+// deviceRow is one inserted stick in the duplicator
+type deviceRow struct {
+	device   usbimager.BlockDevice
+	check    *widget.Check
+	stage    *widget.Label
+	progress *widget.ProgressBar
+	status   *widget.Label
+	content  *fyne.Container
+}
+
+func newDeviceRow(device usbimager.BlockDevice) *deviceRow {
+	row := &deviceRow{
+		device:   device,
+		check:    widget.NewCheck(device.Description(), nil),
+		stage:    widget.NewLabel(""),
+		progress: widget.NewProgressBar(),
+		status:   widget.NewLabel(""),
+	}
+	row.check.SetChecked(true)
+	row.content = container.NewVBox(
+		container.NewBorder(nil, nil, nil, row.status, row.check),
+		row.stage,
+		row.progress,
+		widget.NewSeparator(),
+	)
+	return row
+}
+
+type DuplicatorWindow struct {
+	window         fyne.Window
+	isoSelect      *widget.Select
+	strategySelect *widget.Select
+	layoutSelect   *widget.Select
+	parallelEntry  *widget.Entry
+	verifyCheck    *widget.Check
+	startButton    *widget.Button
+	refreshButton  *widget.Button
+	cancelButton   *widget.Button
+	imager         *usbimager.USBImager // the running imager, nil when idle
+	statusLabel    *widget.Label
+	rowsContainer  *fyne.Container
+	rows           []*deviceRow
+	rowByPath      map[string]*deviceRow
+	content        *fyne.Container
+	running        bool
+}
+
+// NewDuplicatorWindow builds the duplicator tab, every eligible stick gets a row with its own progress
+func NewDuplicatorWindow(window fyne.Window) *DuplicatorWindow {
+	duplicator := &DuplicatorWindow{
+		window:        window,
+		statusLabel:   widget.NewLabel(""),
+		rowsContainer: container.NewVBox(),
+		rowByPath:     make(map[string]*deviceRow),
+	}
+	duplicator.statusLabel.Wrapping = fyne.TextWrapWord
+
+	duplicator.isoSelect = widget.NewSelect(nil, nil)
+	duplicator.isoSelect.PlaceHolder = "Select a built ISO"
+	browseButton := widget.NewButton("Browse", func() {
+		browseISO(duplicator.isoSelect, duplicator.window)
+	})
+
+	duplicator.layoutSelect = widget.NewSelect(usbimager.LayoutNames(), nil)
+	duplicator.layoutSelect.SetSelected(usbimager.DEFAULT_LAYOUT)
+	duplicator.strategySelect = widget.NewSelect(usbimager.StrategyNames(), func(strategy string) {
+		if strategy == usbimager.STRATEGY_RAW {
+			duplicator.layoutSelect.Disable()
+		} else {
+			duplicator.layoutSelect.Enable()
+		}
+	})
+	duplicator.strategySelect.SetSelected(usbimager.DEFAULT_STRATEGY)
+
+	duplicator.parallelEntry = widget.NewEntry()
+	duplicator.parallelEntry.SetText(strconv.Itoa(usbimager.DEFAULT_PARALLELISM))
+
+	duplicator.verifyCheck = widget.NewCheck("Verify after writing", nil)
+	duplicator.verifyCheck.SetChecked(true)
+
+	duplicator.refreshButton = widget.NewButton("Refresh devices", duplicator.Refresh)
+	duplicator.startButton = widget.NewButton("Write to selected devices", duplicator.confirmStart)
+	duplicator.startButton.Importance = widget.DangerImportance
+	duplicator.cancelButton = widget.NewButton("Cancel", duplicator.cancel)
+	duplicator.cancelButton.Disable()
+
+	form := widget.NewForm(
+		widget.NewFormItem("ISO", container.NewBorder(nil, nil, nil, browseButton, duplicator.isoSelect)),
+		widget.NewFormItem("Mode", duplicator.strategySelect),
+		widget.NewFormItem("Layout", duplicator.layoutSelect),
+		widget.NewFormItem("Parallel writes", duplicator.parallelEntry),
+	)
+	duplicator.content = container.NewBorder(
+		container.NewVBox(
+			form,
+			duplicator.verifyCheck,
+			container.NewHBox(duplicator.refreshButton, duplicator.startButton, duplicator.cancelButton),
+			duplicator.statusLabel,
+		),
+		nil, nil, nil,
+		container.NewVScroll(duplicator.rowsContainer),
+	)
+	duplicator.Refresh()
+	return duplicator
+}
+
+func (self *DuplicatorWindow) GetContainer() *fyne.Container {
+	return self.content
+}
+
+// Refresh reloads the built isos and rebuilds a row per inserted stick, rows are left alone while writing
+func (self *DuplicatorWindow) Refresh() {
+	isos, err := listBuiltISOs()
+	if err != nil {
+		log.Printf("Error listing built isos: %v\n", err)
+	} else {
+		setISOOptions(self.isoSelect, isos)
+	}
+	if self.running {
+		return
+	}
+
+	devices, err := usbimager.ListEligibleDevices()
+	if err != nil {
+		log.Printf("Error listing block devices: %v\n", err)
+		dialog.ShowError(err, self.window)
+		return
+	}
+	self.rows = nil
+	self.rowByPath = make(map[string]*deviceRow)
+	self.rowsContainer.RemoveAll()
+	for _, device := range devices {
+		row := newDeviceRow(device)
+		self.rows = append(self.rows, row)
+		self.rowByPath[device.Path] = row
+		self.rowsContainer.Add(row.content)
+	}
+	if len(devices) == 0 {
+		self.rowsContainer.Add(widget.NewLabel(NO_DEVICES))
+	}
+	self.statusLabel.SetText(fmt.Sprintf("%d removable device(s) found", len(devices)))
+}
+
+func (self *DuplicatorWindow) selectedRows() []*deviceRow {
+	var selected []*deviceRow
+	for _, row := range self.rows {
+		if row.check.Checked {
+			selected = append(selected, row)
+		}
+	}
+	return selected
+}
+
+func (self *DuplicatorWindow) confirmStart() {
+	iso := self.isoSelect.Selected
+	selected := self.selectedRows()
+	if iso == "" || len(selected) == 0 {
+		dialog.ShowInformation("Duplicate", "Select an ISO and at least one device first", self.window)
+		return
+	}
+	parallelism, err := strconv.Atoi(strings.TrimSpace(self.parallelEntry.Text))
+	if err != nil || parallelism < 1 {
+		dialog.ShowError(fmt.Errorf("parallel writes must be a positive number"), self.window)
+		return
+	}
+	var descriptions []string
+	for _, row := range selected {
+		descriptions = append(descriptions, row.device.Description())
+	}
+	message := fmt.Sprintf("This will ERASE ALL DATA on\n%s\n\nContinue?", strings.Join(descriptions, "\n"))
+	dialog.ShowConfirm("Erase devices", message, func(confirmed bool) {
+		if confirmed {
+			self.start(iso, selected, parallelism)
+		}
+	}, self.window)
+}
+
+func (self *DuplicatorWindow) start(iso string, selected []*deviceRow, parallelism int) {
+	imager := usbimager.NewUSBImager()
+	imager.Strategy = self.strategySelect.Selected
+	imager.Layout = self.layoutSelect.Selected
+	imager.Verify = self.verifyCheck.Checked
+
+	var targets []string
+	for _, row := range self.rows {
+		row.check.Disable()
+		row.progress.SetValue(0)
+		row.stage.SetText("")
+		row.status.SetText("")
+	}
+	for _, row := range selected {
+		targets = append(targets, row.device.Path)
+		row.status.SetText("queued")
+	}
+
+	self.running = true
+	self.imager = imager
+	self.cancelButton.Enable()
+	self.startButton.Disable()
+	self.refreshButton.Disable()
+	self.statusLabel.SetText(fmt.Sprintf("Writing %s to %d device(s), %d at a time...", iso, len(targets), parallelism))
+	go self.followProgress(imager.GetSubscriber())
+	go func() {
+		results := imager.ImageBatch(iso, targets, parallelism)
+		imager.Close()
+		fyne.Do(func() {
+			self.finish(results)
+		})
+	}()
+}
+
+// cancel stops the running batch, sticks not started yet are skipped and running ones cleaned up
+func (self *DuplicatorWindow) cancel() {
+	if self.imager == nil {
+		return
+	}
+	self.cancelButton.Disable()
+	self.statusLabel.SetText("Cancelling...")
+	self.imager.Cancel()
+}
+
+// followProgress routes every event to the row of its target, it returns when the imager is
+// closed after the batch
+func (self *DuplicatorWindow) followProgress(subscriber <-chan usbimager.ImageEvent) {
+	for event := range subscriber {
+		fyne.Do(func() {
+			row, ok := self.rowByPath[event.Target]
+			if !ok {
+				return
+			}
+			switch event.Type {
+			case usbimager.IMAGE_STARTED:
+				row.status.SetText("writing")
+			case usbimager.STAGE_STARTED:
+				row.stage.SetText(event.String())
+				row.progress.SetValue(0)
+			case usbimager.PROGRESS:
+				row.stage.SetText(event.String())
+				row.progress.SetValue(event.Fraction())
+			case usbimager.IMAGE_FINISHED:
+				row.stage.SetText(event.String())
+			}
+		})
+	}
+}
+
+// finish shows the final status of every device, the results are authoritative since
+// subscribers may miss events when many sticks report at once
+func (self *DuplicatorWindow) finish(results []usbimager.DeviceResult) {
+	failed := 0
+	for _, result := range results {
+		row, ok := self.rowByPath[result.Target]
+		if !ok {
+			continue
+		}
+		row.status.SetText(result.Status())
+		if result.Err != nil {
+			failed++
+			row.stage.SetText(result.Err.Error())
+			continue
+		}
+		row.progress.SetValue(1)
+	}
+	for _, row := range self.rows {
+		row.check.Enable()
+	}
+	self.running = false
+	self.imager = nil
+	self.cancelButton.Disable()
+	self.startButton.Enable()
+	self.refreshButton.Enable()
+	self.statusLabel.SetText(fmt.Sprintf("Finished, %d of %d device(s) failed", failed, len(results)))
+}
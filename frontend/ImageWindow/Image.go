@@ -0,0 +1,358 @@
+package imagewindow
+
+import (
+	buildmanager "LiveBuilder/BuildManager"
+	usbimager "LiveBuilder/USBImager"
+	"fmt"
+	"log"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+const (
+	NO_DEVICES = "No removable devices found"
+
+	OUTPUT_DEVICE = "USB device"
+	OUTPUT_FILE   = "Image file"
+)
+
+type ImageWindow struct {
+	window            fyne.Window
+	isoSelect         *widget.Select
+	deviceSelect      *widget.Select
+	strategySelect    *widget.Select
+	layoutSelect      *widget.Select
+	persistenceCheck  *widget.Check
+	persistenceSize   *widget.Entry
+	statusLabel       *widget.Label
+	stageLabel        *widget.Label
+	progressBar       *widget.ProgressBar
+	writeButton       *widget.Button
+	cancelButton      *widget.Button
+	imager            *usbimager.USBImager // the running imager, nil when idle
+	deviceByLabel     map[string]usbimager.BlockDevice
+	content           *fyne.Container
+	persistenceFields *fyne.Container
+	partitionOptions  *fyne.Container
+	outputRadio       *widget.RadioGroup
+	deviceFields      *fyne.Container
+	fileFields        *fyne.Container
+	filePath          *widget.Entry
+	imageSize         *widget.Entry
+	compressionSelect *widget.Select
+	checksumCheck     *widget.Check
+}
+
+// NewImageWindow builds the imaging tab, only devices usbimager considers eligible are offered
+func NewImageWindow(window fyne.Window) *ImageWindow {
+	image := &ImageWindow{
+		window:        window,
+		statusLabel:   widget.NewLabel(""),
+		deviceByLabel: make(map[string]usbimager.BlockDevice),
+	}
+	image.statusLabel.Wrapping = fyne.TextWrapWord
+	image.stageLabel = widget.NewLabel("")
+	image.progressBar = widget.NewProgressBar()
+	image.progressBar.Hide()
+
+	image.isoSelect = widget.NewSelect(nil, nil)
+	image.isoSelect.PlaceHolder = "Select a built ISO"
+	browseButton := widget.NewButton("Browse", image.browseISO)
+
+	image.deviceSelect = widget.NewSelect(nil, nil)
+	image.deviceSelect.PlaceHolder = "Select a device"
+	refreshButton := widget.NewButton("Refresh", image.Refresh)
+
+	image.layoutSelect = widget.NewSelect(usbimager.LayoutNames(), nil)
+	image.layoutSelect.SetSelected(usbimager.DEFAULT_LAYOUT)
+	//layout and persistence only apply when the stick is partitioned by us
+	image.strategySelect = widget.NewSelect(usbimager.StrategyNames(), func(strategy string) {
+		if image.partitionOptions == nil {
+			return
+		}
+		if strategy == usbimager.STRATEGY_RAW {
+			image.partitionOptions.Hide()
+		} else {
+			image.partitionOptions.Show()
+		}
+	})
+
+	image.persistenceSize = widget.NewEntry()
+	image.persistenceSize.SetPlaceHolder("Size eg 4G, empty for rest of disk")
+	image.persistenceFields = container.NewVBox(image.persistenceSize)
+	image.persistenceFields.Hide()
+	image.persistenceCheck = widget.NewCheck("Add persistence partition", func(checked bool) {
+		if checked {
+			image.persistenceFields.Show()
+		} else {
+			image.persistenceFields.Hide()
+		}
+	})
+
+	image.writeButton = widget.NewButton("Write to device", image.confirmWrite)
+	image.writeButton.Importance = widget.DangerImportance
+	image.cancelButton = widget.NewButton("Cancel", image.cancel)
+	image.cancelButton.Hide()
+
+	//image files are written without touching hardware and can be flashed with other tools
+	image.filePath = widget.NewEntry()
+	image.filePath.SetPlaceHolder("Path of the .img file")
+	saveButton := widget.NewButton("Save as", image.browseImageFile)
+	image.imageSize = widget.NewEntry()
+	image.imageSize.SetPlaceHolder("eg 8G, empty to fit the ISO")
+	image.compressionSelect = widget.NewSelect(usbimager.CompressionNames(), nil)
+	image.compressionSelect.SetSelected(usbimager.COMPRESSION_NONE)
+	image.checksumCheck = widget.NewCheck("Write a .sha256 checksum file", nil)
+	image.fileFields = container.NewVBox(widget.NewForm(
+		widget.NewFormItem("File", container.NewBorder(nil, nil, nil, saveButton, image.filePath)),
+		widget.NewFormItem("Size", image.imageSize),
+		widget.NewFormItem("Compression", image.compressionSelect),
+	), image.checksumCheck)
+	image.fileFields.Hide()
+	image.deviceFields = container.NewVBox(widget.NewForm(
+		widget.NewFormItem("Device", container.NewBorder(nil, nil, nil, refreshButton, image.deviceSelect)),
+	))
+	image.outputRadio = widget.NewRadioGroup([]string{OUTPUT_DEVICE, OUTPUT_FILE}, func(output string) {
+		if output == OUTPUT_FILE {
+			image.deviceFields.Hide()
+			image.fileFields.Show()
+			image.writeButton.SetText("Write image file")
+		} else {
+			image.fileFields.Hide()
+			image.deviceFields.Show()
+			image.writeButton.SetText("Write to device")
+		}
+	})
+	image.outputRadio.Horizontal = true
+	image.outputRadio.SetSelected(OUTPUT_DEVICE)
+
+	form := widget.NewForm(
+		widget.NewFormItem("ISO", container.NewBorder(nil, nil, nil, browseButton, image.isoSelect)),
+		widget.NewFormItem("Write to", image.outputRadio),
+		widget.NewFormItem("Mode", image.strategySelect),
+	)
+	image.partitionOptions = container.NewVBox(
+		widget.NewForm(widget.NewFormItem("Layout", image.layoutSelect)),
+		image.persistenceCheck,
+		image.persistenceFields,
+	)
+	image.strategySelect.SetSelected(usbimager.DEFAULT_STRATEGY)
+	image.content = container.NewVBox(
+		form,
+		image.deviceFields,
+		image.fileFields,
+		image.partitionOptions,
+		image.writeButton,
+		image.cancelButton,
+		image.stageLabel,
+		image.progressBar,
+		image.statusLabel,
+	)
+	image.Refresh()
+	return image
+}
+
+func (self *ImageWindow) GetContainer() *fyne.Container {
+	return self.content
+}
+
+// Refresh reloads the built isos and the eligible devices
+func (self *ImageWindow) Refresh() {
+	self.refreshISOs()
+	self.refreshDevices()
+}
+
+func (self *ImageWindow) refreshISOs() {
+	isos, err := listBuiltISOs()
+	if err != nil {
+		log.Printf("Error listing built isos: %v\n", err)
+		return
+	}
+	setISOOptions(self.isoSelect, isos)
+}
+
+// listBuiltISOs returns the isos of past builds that are still on disk
+func listBuiltISOs() ([]string, error) {
+	entries, err := buildmanager.ListHistory()
+	if err != nil {
+		return nil, err
+	}
+	var isos []string
+	for _, entry := range entries {
+		isos = append(isos, entry.ExistingArtifacts()...)
+	}
+	return isos, nil
+}
+
+// setISOOptions replaces the options of an iso select keeping the current selection
+func setISOOptions(isoSelect *widget.Select, isos []string) {
+	selected := isoSelect.Selected
+	isoSelect.Options = isos
+	isoSelect.Refresh()
+	if selected != "" {
+		isoSelect.SetSelected(selected)
+	}
+}
+
+func (self *ImageWindow) refreshDevices() {
+	devices, err := usbimager.ListEligibleDevices()
+	if err != nil {
+		log.Printf("Error listing block devices: %v\n", err)
+		dialog.ShowError(err, self.window)
+		return
+	}
+	self.deviceByLabel = make(map[string]usbimager.BlockDevice)
+	var labels []string
+	for _, device := range devices {
+		label := device.Description()
+		labels = append(labels, label)
+		self.deviceByLabel[label] = device
+	}
+	self.deviceSelect.Options = labels
+	self.deviceSelect.ClearSelected()
+	if len(labels) == 0 {
+		self.deviceSelect.PlaceHolder = NO_DEVICES
+	} else {
+		self.deviceSelect.PlaceHolder = "Select a device"
+	}
+	self.deviceSelect.Refresh()
+}
+
+func (self *ImageWindow) browseISO() {
+	browseISO(self.isoSelect, self.window)
+}
+
+// browseISO lets the user pick an iso that is not in the build history
+func browseISO(isoSelect *widget.Select, window fyne.Window) {
+	dialog.ShowFileOpen(func(reader fyne.URIReadCloser, err error) {
+		if err != nil {
+			log.Println("Error selecting iso:", err)
+			return
+		}
+		if reader == nil {
+			return
+		}
+		path := reader.URI().Path()
+		reader.Close()
+		setISOOptions(isoSelect, append(isoSelect.Options, path))
+		isoSelect.SetSelected(path)
+	}, window)
+}
+
+func (self *ImageWindow) browseImageFile() {
+	dialog.ShowFileSave(func(writer fyne.URIWriteCloser, err error) {
+		if err != nil {
+			log.Println("Error selecting image file:", err)
+			return
+		}
+		if writer == nil {
+			return
+		}
+		self.filePath.SetText(writer.URI().Path())
+		writer.Close()
+	}, self.window)
+}
+
+func (self *ImageWindow) confirmWrite() {
+	iso := self.isoSelect.Selected
+	if self.outputRadio.Selected == OUTPUT_FILE {
+		if iso == "" || self.filePath.Text == "" {
+			dialog.ShowInformation("Image USB", "Select an ISO and an image file first", self.window)
+			return
+		}
+		self.write(iso, self.filePath.Text)
+		return
+	}
+	device, ok := self.deviceByLabel[self.deviceSelect.Selected]
+	if iso == "" || !ok {
+		dialog.ShowInformation("Image USB", "Select an ISO and a device first", self.window)
+		return
+	}
+	message := fmt.Sprintf("This will ERASE ALL DATA on\n%s\n\nContinue?", device.Description())
+	dialog.ShowConfirm("Erase device", message, func(confirmed bool) {
+		if confirmed {
+			self.write(iso, device.Path)
+		}
+	}, self.window)
+}
+
+func (self *ImageWindow) write(iso string, target string) {
+	imager := usbimager.NewUSBImager()
+	imager.Strategy = self.strategySelect.Selected
+	imager.Layout = self.layoutSelect.Selected
+	if self.persistenceCheck.Checked && imager.Strategy != usbimager.STRATEGY_RAW {
+		imager.Persistence = &usbimager.PersistenceOptions{Size: self.persistenceSize.Text}
+	}
+	if self.outputRadio.Selected == OUTPUT_FILE {
+		imager.ImageFile = &usbimager.ImageFileOptions{
+			Compression: self.compressionSelect.Selected,
+			Checksum:    self.checksumCheck.Checked,
+		}
+		if imager.Strategy != usbimager.STRATEGY_RAW {
+			imager.ImageFile.Size = self.imageSize.Text
+		}
+	}
+
+	self.imager = imager
+	self.writeButton.Disable()
+	self.cancelButton.Enable()
+	self.cancelButton.Show()
+	self.statusLabel.SetText(fmt.Sprintf("Writing %s to %s...", iso, target))
+	self.progressBar.SetValue(0)
+	self.progressBar.Show()
+	go self.followProgress(imager.GetSubscriber())
+	go func() {
+		err := imager.ImageUSB(iso, target)
+		imager.Close()
+		fyne.Do(func() {
+			self.imager = nil
+			self.writeButton.Enable()
+			self.cancelButton.Hide()
+			if err != nil {
+				log.Printf("Imaging %s failed: %v\n", target, err)
+				self.statusLabel.SetText(fmt.Sprintf("Imaging failed: %v", err))
+				dialog.ShowError(err, self.window)
+				return
+			}
+			self.statusLabel.SetText(fmt.Sprintf("Wrote %s to %s", iso, target))
+		})
+	}()
+}
+
+// cancel stops the running imager, the target is cleaned up before write returns
+func (self *ImageWindow) cancel() {
+	if self.imager == nil {
+		return
+	}
+	self.cancelButton.Disable()
+	self.statusLabel.SetText("Cancelling...")
+	self.imager.Cancel()
+}
+
+// followProgress shows the running stage and moves the progress bar for stages that report bytes,
+// it returns when the imager is closed after the run
+func (self *ImageWindow) followProgress(subscriber <-chan usbimager.ImageEvent) {
+	for event := range subscriber {
+		switch event.Type {
+		case usbimager.STAGE_STARTED:
+			fyne.Do(func() {
+				self.stageLabel.SetText(event.String())
+				self.progressBar.SetValue(0)
+			})
+		case usbimager.PROGRESS:
+			fyne.Do(func() {
+				self.stageLabel.SetText(event.String())
+				self.progressBar.SetValue(event.Fraction())
+			})
+		case usbimager.IMAGE_FINISHED:
+			fyne.Do(func() {
+				self.stageLabel.SetText(strings.Join(append([]string{event.String()}, event.Artifacts...), "\n"))
+				self.progressBar.Hide()
+			})
+		}
+	}
+}
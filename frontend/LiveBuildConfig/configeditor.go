@@ -0,0 +1,216 @@
+package livebuildconfig
+
+import (
+	lbconfig "LiveBuilder/LBConfig"
+	"strconv"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+)
+
+/*
+The config editor is generated from lbconfig.OPTIONS, every option gets a widget that
+writes straight into the edited config. Choices become dropdowns or checkboxes, free
+lists become list editors and empty values are left to the lb default
+*/
+
+const DEFAULT_CHOICE = "(lb default)"
+
+// options edited as one entry per item although the model keeps them as one string
+var LIST_STRING_OPTIONS = map[string]string{
+	"--bootappend-live": " ",
+}
+
+type configEditor struct {
+	config    *lbconfig.Config
+	reloaders []func()
+	loading   bool
+	container *fyne.Container
+	onChanged func()
+}
+
+func newConfigEditor(onChanged func()) *configEditor {
+	editor := &configEditor{
+		config:    &lbconfig.Config{},
+		onChanged: onChanged,
+	}
+	editor.container = editor.buildForm()
+	return editor
+}
+
+// Load shows config in the form, the editor keeps its own copy
+func (self *configEditor) Load(config *lbconfig.Config) {
+	self.loading = true
+	defer func() { self.loading = false }()
+	*self.config = *config
+	for _, reload := range self.reloaders {
+		reload()
+	}
+}
+
+func (self *configEditor) Config() *lbconfig.Config {
+	config := *self.config
+	return &config
+}
+
+// edit applies a change made in the form, widgets being reloaded must not write back
+func (self *configEditor) edit(apply func()) {
+	if self.loading {
+		return
+	}
+	apply()
+	if self.onChanged != nil {
+		self.onChanged()
+	}
+}
+
+func (self *configEditor) buildForm() *fyne.Container {
+	groups := container.NewVBox()
+	for _, group := range lbconfig.GROUPS {
+		form := widget.NewForm()
+		for _, option := range lbconfig.OPTIONS {
+			if option.Group != group {
+				continue
+			}
+			item := widget.NewFormItem(option.Label, self.buildField(option))
+			item.HintText = option.Flag
+			form.AppendItem(item)
+		}
+		if group == lbconfig.GROUP_ISO {
+			form.AppendItem(widget.NewFormItem("", widget.NewLabel("Empty ISO fields are filled from the values above")))
+		}
+		groups.Add(widget.NewCard(group, "", form))
+	}
+
+	extraArgs := newListEditor("--option value", func(items []string) {
+		self.edit(func() { self.config.ExtraArgs = items })
+	})
+	self.reloaders = append(self.reloaders, func() { extraArgs.SetItems(self.config.ExtraArgs) })
+	groups.Add(widget.NewCard("Other options", "passed to lb config as they are", extraArgs.GetContainer()))
+	return groups
+}
+
+// buildField picks the widget for an option and registers how to reload it
+func (self *configEditor) buildField(option lbconfig.Option) fyne.CanvasObject {
+	switch value := option.Value(self.config).(type) {
+	case *string:
+		if separator, ok := LIST_STRING_OPTIONS[option.Flag]; ok {
+			return self.stringListField(value, separator)
+		}
+		if len(option.Choices) > 0 {
+			return self.choiceField(value, option.Choices)
+		}
+		return self.stringField(value, option.Suggestions)
+	case **bool:
+		return self.boolField(value)
+	case *[]string:
+		if len(option.Choices) > 0 && option.MaxItems == 1 {
+			return self.singleItemField(value, option.Choices)
+		}
+		if len(option.Choices) > 0 {
+			return self.checkField(value, option.Choices)
+		}
+		return self.listField(value)
+	}
+	return widget.NewLabel("unsupported option")
+}
+
+func (self *configEditor) stringField(value *string, suggestions []string) fyne.CanvasObject {
+	onChanged := func(text string) {
+		self.edit(func() { *value = text })
+	}
+	if len(suggestions) > 0 {
+		selectEntry := widget.NewSelectEntry(suggestions)
+		selectEntry.SetPlaceHolder(DEFAULT_CHOICE)
+		selectEntry.OnChanged = onChanged
+		self.reloaders = append(self.reloaders, func() { selectEntry.SetText(*value) })
+		return selectEntry
+	}
+	entry := widget.NewEntry()
+	entry.SetPlaceHolder(DEFAULT_CHOICE)
+	entry.OnChanged = onChanged
+	self.reloaders = append(self.reloaders, func() { entry.SetText(*value) })
+	return entry
+}
+
+func (self *configEditor) choiceField(value *string, choices []string) fyne.CanvasObject {
+	selector := widget.NewSelect(append([]string{DEFAULT_CHOICE}, choices...), func(choice string) {
+		if choice == DEFAULT_CHOICE {
+			choice = ""
+		}
+		self.edit(func() { *value = choice })
+	})
+	self.reloaders = append(self.reloaders, func() { showChoice(selector, *value) })
+	return selector
+}
+
+func (self *configEditor) boolField(value **bool) fyne.CanvasObject {
+	selector := widget.NewSelect([]string{DEFAULT_CHOICE, "true", "false"}, func(choice string) {
+		self.edit(func() {
+			*value = nil
+			if choice != DEFAULT_CHOICE {
+				enabled := choice == "true"
+				*value = &enabled
+			}
+		})
+	})
+	self.reloaders = append(self.reloaders, func() {
+		choice := ""
+		if *value != nil {
+			choice = strconv.FormatBool(**value)
+		}
+		showChoice(selector, choice)
+	})
+	return selector
+}
+
+func (self *configEditor) singleItemField(value *[]string, choices []string) fyne.CanvasObject {
+	selector := widget.NewSelect(append([]string{DEFAULT_CHOICE}, choices...), func(choice string) {
+		self.edit(func() {
+			*value = nil
+			if choice != DEFAULT_CHOICE {
+				*value = []string{choice}
+			}
+		})
+	})
+	self.reloaders = append(self.reloaders, func() { showChoice(selector, strings.Join(*value, " ")) })
+	return selector
+}
+
+func (self *configEditor) checkField(value *[]string, choices []string) fyne.CanvasObject {
+	checks := widget.NewCheckGroup(choices, func(selected []string) {
+		self.edit(func() { *value = append([]string(nil), selected...) })
+	})
+	checks.Horizontal = true
+	self.reloaders = append(self.reloaders, func() { checks.SetSelected(append([]string(nil), *value...)) })
+	return checks
+}
+
+func (self *configEditor) listField(value *[]string) fyne.CanvasObject {
+	list := newListEditor(DEFAULT_CHOICE, func(items []string) {
+		self.edit(func() { *value = items })
+	})
+	self.reloaders = append(self.reloaders, func() { list.SetItems(*value) })
+	return list.GetContainer()
+}
+
+// stringListField splits on whitespace only, joining the items again gives back the loaded value
+func (self *configEditor) stringListField(value *string, separator string) fyne.CanvasObject {
+	list := newListEditor("", func(items []string) {
+		self.edit(func() { *value = strings.Join(items, separator) })
+	})
+	self.reloaders = append(self.reloaders, func() { list.SetItems(strings.Fields(*value)) })
+	return list.GetContainer()
+}
+
+// showChoice displays a loaded value without running OnChanged, values outside the
+// options are shown as they are so validation can point at them
+func showChoice(selector *widget.Select, value string) {
+	if value == "" {
+		value = DEFAULT_CHOICE
+	}
+	selector.Selected = value
+	selector.Refresh()
+}
@@ -0,0 +1,80 @@
+package livebuildconfig
+
+import (
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+// listEditor edits a list of strings as one entry per row, empty rows are dropped from Items
+type listEditor struct {
+	items       []string
+	placeholder string
+	rows        *fyne.Container
+	container   *fyne.Container
+	OnChanged   func(items []string)
+}
+
+func newListEditor(placeholder string, onChanged func(items []string)) *listEditor {
+	editor := &listEditor{
+		placeholder: placeholder,
+		rows:        container.NewVBox(),
+		OnChanged:   onChanged,
+	}
+	addButton := widget.NewButtonWithIcon("Add", theme.ContentAddIcon(), func() {
+		editor.items = append(editor.items, "")
+		editor.rebuild()
+	})
+	editor.container = container.NewVBox(editor.rows, container.NewHBox(addButton))
+	return editor
+}
+
+// SetItems replaces the rows without calling OnChanged
+func (self *listEditor) SetItems(items []string) {
+	self.items = append([]string(nil), items...)
+	self.rebuild()
+}
+
+func (self *listEditor) Items() []string {
+	var items []string
+	for _, item := range self.items {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func (self *listEditor) rebuild() {
+	self.rows.RemoveAll()
+	for i := range self.items {
+		index := i
+		entry := widget.NewEntry()
+		entry.SetPlaceHolder(self.placeholder)
+		entry.SetText(self.items[index])
+		entry.OnChanged = func(text string) {
+			self.items[index] = text
+			self.changed()
+		}
+		removeButton := widget.NewButtonWithIcon("", theme.DeleteIcon(), func() {
+			self.items = append(self.items[:index], self.items[index+1:]...)
+			self.rebuild()
+			self.changed()
+		})
+		self.rows.Add(container.NewBorder(nil, nil, nil, removeButton, entry))
+	}
+	self.rows.Refresh()
+}
+
+func (self *listEditor) changed() {
+	if self.OnChanged != nil {
+		self.OnChanged(self.Items())
+	}
+}
+
+func (self *listEditor) GetContainer() *fyne.Container {
+	return self.container
+}
@@ -3,10 +3,16 @@ package livebuildconfig
 import (
 	appstate "LiveBuilder/AppState"
 	filesystem "LiveBuilder/Filesystem"
-	filelistwidgets "LiveBuilder/frontend/FileListWidgets"
+	lbconfig "LiveBuilder/LBConfig"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
 )
 
@@ -17,14 +23,39 @@ type fields struct {
 }
 
 type LBConfigruationTab struct {
+	window     fyne.Window
 	headerGrid *fyne.Container
-	fileList   *filelistwidgets.FileListContainer
+	picker     *widget.Select
+	editor     *configEditor
+	preview    *widget.Label
+	problems   *widget.Label
+	status     *widget.Label
+	saveButton *widget.Button
+	current    string
+	dirty      bool
 }
 
-func NewLBConfigurationTab() *LBConfigruationTab {
-	cfg := &LBConfigruationTab{}
+func NewLBConfigurationTab(window fyne.Window) *LBConfigruationTab {
+	cfg := &LBConfigruationTab{
+		window:   window,
+		preview:  widget.NewLabel(""),
+		problems: widget.NewLabel(""),
+		status:   widget.NewLabel(""),
+	}
+	cfg.preview.TextStyle = fyne.TextStyle{Monospace: true}
+	cfg.preview.Wrapping = fyne.TextWrapWord
+	cfg.problems.Importance = widget.DangerImportance
+	cfg.problems.Wrapping = fyne.TextWrapWord
+	cfg.editor = newConfigEditor(cfg.onEdited)
+	cfg.picker = widget.NewSelect(cfg.configNames(), cfg.pick)
+	cfg.picker.PlaceHolder = "Select an lb config"
+	cfg.saveButton = widget.NewButtonWithIcon("Save", theme.DocumentSaveIcon(), cfg.save)
 	cfg.headerGrid = cfg.buildHeaderGrid()
-	cfg.fileList = cfg.buildFileList()
+
+	appstate.GetGlobalState().OnChange(func() {
+		fyne.Do(cfg.showSelectedConfig)
+	})
+	cfg.showSelectedConfig()
 	return cfg
 }
 
@@ -43,10 +74,18 @@ func (self *LBConfigruationTab) buildHeaderGrid() *fyne.Container {
 	for _, field := range fields {
 		entry := widget.NewEntry()
 		entry.SetPlaceHolder(field.getter())
-		entry.OnChanged = field.setter
+		setter := field.setter
+		entry.OnChanged = func(text string) {
+			setter(text)
+			self.refreshPreview()
+		}
 		entries = append(entries, entry)
 		headers = append(headers, widget.NewLabel(field.label))
 
+		getter := field.getter
+		appstate.OnChange(func() {
+			fyne.Do(func() { entry.SetText(getter()) })
+		})
 	}
 
 	grid := container.NewGridWithColumns(4,
@@ -55,10 +94,185 @@ func (self *LBConfigruationTab) buildHeaderGrid() *fyne.Container {
 	return grid
 }
 
-func (self *LBConfigruationTab) buildFileList() *filelistwidgets.FileListContainer {
-	return filelistwidgets.NewFileListContainer(filesystem.LBCONFIGS_DIR_ID)
+// configNames lists the config files the build can use, imported templates are not among them
+func (self *LBConfigruationTab) configNames() []string {
+	var names []string
+	for _, entry := range filesystem.GetFileManager().GetFileSystem(filesystem.LBCONFIGS_DIR_ID) {
+		if !entry.IsDir() && lbconfig.IsConfigFile(entry.Name()) {
+			names = append(names, entry.Name())
+		}
+	}
+	return names
+}
+
+// showSelectedConfig follows the selection made elsewhere, eg by loading a profile
+func (self *LBConfigruationTab) showSelectedConfig() {
+	for name := range appstate.GetGlobalState().GetDirectoryEntryMap(filesystem.LBCONFIGS_DIR_ID) {
+		if name != self.current {
+			self.open(name)
+		}
+		return
+	}
+	//the form is kept, it is only no longer the file the build uses
+	self.current = ""
+	self.picker.Selected = ""
+	self.picker.Refresh()
+	self.setDirty(self.dirty)
+}
+
+// pick asks before dropping unsaved edits for another config
+func (self *LBConfigruationTab) pick(name string) {
+	if name == self.current || !self.dirty {
+		self.open(name)
+		return
+	}
+	dialog.ShowConfirm("Unsaved changes", "Discard the unsaved changes to this config?", func(discard bool) {
+		if discard {
+			self.open(name)
+			return
+		}
+		self.picker.Selected = self.current
+		self.picker.Refresh()
+	}, self.window)
+}
+
+// open loads a config into the form and makes it the one the build uses
+func (self *LBConfigruationTab) open(name string) {
+	if name == self.current {
+		return
+	}
+	entry, err := filesystem.GetFileManager().GetEntryByName(filesystem.LBCONFIGS_DIR_ID, name)
+	if err != nil {
+		dialog.ShowError(err, self.window)
+		return
+	}
+	config, err := lbconfig.Load(entry.FullPath())
+	if err != nil {
+		dialog.ShowError(err, self.window)
+		return
+	}
+	appstate.GetGlobalState().SelectOnly(filesystem.LBCONFIGS_DIR_ID, entry)
+	self.current = name
+	self.picker.Selected = name
+	self.picker.Refresh()
+	self.editor.Load(config)
+	self.setDirty(false)
+}
+
+func (self *LBConfigruationTab) onEdited() {
+	self.setDirty(true)
+}
+
+func (self *LBConfigruationTab) setDirty(dirty bool) {
+	self.dirty = dirty
+	status := self.current
+	if status == "" {
+		status = "new config"
+	}
+	if dirty {
+		status += " (unsaved changes)"
+	}
+	self.status.SetText(status)
+	self.refreshPreview()
+}
+
+// refreshPreview validates the form and shows the command the build would run
+func (self *LBConfigruationTab) refreshPreview() {
+	if self.editor == nil {
+		return
+	}
+	config := self.editor.Config()
+	state := appstate.GetGlobalState()
+	config.FillISOFields(state.ISOVolumeName(), state.ISOPublisher(), state.ISOApplication(), state.ISOImageName())
+	args, err := config.Args()
+	if err != nil {
+		self.problems.SetText(err.Error())
+		self.problems.Show()
+		self.preview.SetText("")
+		self.saveButton.Disable()
+		return
+	}
+	self.problems.Hide()
+	self.preview.SetText(lbconfig.CommandLine(args))
+	self.saveButton.Enable()
+}
+
+func (self *LBConfigruationTab) save() {
+	if self.current == "" {
+		self.saveAs()
+		return
+	}
+	self.writeConfig(self.current)
+}
+
+func (self *LBConfigruationTab) saveAs() {
+	entry := widget.NewEntry()
+	entry.SetPlaceHolder("my-config.json")
+	items := []*widget.FormItem{widget.NewFormItem("Name", entry)}
+	dialog.ShowForm("Save lb config As", "Ok", "Cancel", items, func(confirmed bool) {
+		if !confirmed {
+			return
+		}
+		name, err := configFileName(entry.Text)
+		if err != nil {
+			dialog.ShowError(err, self.window)
+			return
+		}
+		if _, err := os.Stat(self.configPath(name)); err == nil {
+			dialog.ShowConfirm("Overwrite", fmt.Sprintf("%s already exists, overwrite it?", name), func(overwrite bool) {
+				if overwrite {
+					self.writeConfig(name)
+				}
+			}, self.window)
+			return
+		}
+		self.writeConfig(name)
+	}, self.window)
+}
+
+func (self *LBConfigruationTab) writeConfig(name string) {
+	if err := self.editor.Config().Save(self.configPath(name)); err != nil {
+		dialog.ShowError(err, self.window)
+		return
+	}
+	fileManager := filesystem.GetFileManager()
+	fileManager.RescanFileSystem(filesystem.LBCONFIGS_DIR_ID)
+	self.picker.SetOptions(self.configNames())
+
+	//the saved file is what the build reads, so it becomes the selection
+	entry, err := fileManager.GetEntryByName(filesystem.LBCONFIGS_DIR_ID, name)
+	if err != nil {
+		dialog.ShowError(err, self.window)
+		return
+	}
+	appstate.GetGlobalState().SelectOnly(filesystem.LBCONFIGS_DIR_ID, entry)
+	self.current = name
+	self.picker.Selected = name
+	self.picker.Refresh()
+	self.setDirty(false)
+}
+
+func (self *LBConfigruationTab) configPath(name string) string {
+	return filepath.Join(filesystem.GetFileManager().GetAppDataDir(), filesystem.LBCONFIGS_DIR_ID, name)
+}
+
+// configFileName checks a name typed by the user, json is used when no extension is given
+func configFileName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || strings.HasPrefix(name, ".") || strings.ContainsAny(name, `/\`) {
+		return "", fmt.Errorf("invalid config name %q", name)
+	}
+	if !lbconfig.IsConfigFile(name) {
+		name += ".json"
+	}
+	return name, nil
 }
 
 func (self *LBConfigruationTab) GetContainer() *fyne.Container {
-	return container.NewBorder(self.headerGrid, nil, nil, nil, self.fileList.GetContainer())
+	saveAsButton := widget.NewButtonWithIcon("Save As", theme.DocumentSaveIcon(), self.saveAs)
+	bar := container.NewBorder(nil, nil, widget.NewLabel("Config"), container.NewHBox(self.status, self.saveButton, saveAsButton), self.picker)
+	header := container.NewVBox(self.headerGrid, bar)
+
+	previewCard := widget.NewCard("lb config command", "", container.NewVBox(self.problems, self.preview))
+	return container.NewBorder(header, previewCard, nil, nil, container.NewVScroll(self.editor.container))
 }
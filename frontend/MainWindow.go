@@ -29,13 +29,41 @@ func NewMainWindow(title string) *MainWindow {
 }
 
 func (self *MainWindow) BuildMainContent() {
+	buildWindow := buildBuildWindow(self.window)
+	buildTab := container.NewTabItem("Build", buildWindow.GetContainer())
+	variablesWindow := buildVariablesView(self.window)
+	variablesTab := container.NewTabItem("Variables", variablesWindow.GetContainer())
 	tabs := container.NewAppTabs(
-		container.NewTabItem("lb config Editor", buildLBConfigView()),
+		container.NewTabItem("lb config Editor", buildLBConfigView(self.window)),
 		container.NewTabItem("File Selection", buildFileSelectionView()),
-		container.NewTabItem("Build", buildBuildWindow(self.window)),
-		container.NewTabItem("Export/edit", buildAppConfigView()),
+		variablesTab,
+		buildTab,
 	)
-	self.SetContent(tabs)
+	historyWindow := buildHistoryView(self.window, func() {
+		tabs.Select(buildTab)
+		buildWindow.StartBuild()
+	})
+	historyTab := container.NewTabItem("History", historyWindow.GetContainer())
+	tabs.Append(historyTab)
+	imageWindow := buildImageView(self.window)
+	imageTab := container.NewTabItem("Image USB", imageWindow.GetContainer())
+	tabs.Append(imageTab)
+	duplicatorWindow := buildDuplicatorView(self.window)
+	duplicatorTab := container.NewTabItem("Duplicator", duplicatorWindow.GetContainer())
+	tabs.Append(duplicatorTab)
+	tabs.OnSelected = func(tab *container.TabItem) {
+		switch tab {
+		case variablesTab:
+			variablesWindow.Refresh()
+		case historyTab:
+			historyWindow.Refresh()
+		case imageTab:
+			imageWindow.Refresh()
+		case duplicatorTab:
+			duplicatorWindow.Refresh()
+		}
+	}
+	self.SetContent(container.NewBorder(buildProfileBar(self.window), nil, nil, nil, tabs))
 }
 
 func (mw *MainWindow) SetContent(content fyne.CanvasObject) {
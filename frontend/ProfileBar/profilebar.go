@@ -0,0 +1,129 @@
+package profilebar
+
+import (
+	appstate "LiveBuilder/AppState"
+	"fmt"
+	"log"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+type ProfileBar struct {
+	window   fyne.Window
+	selector *widget.Select
+}
+
+func NewProfileBar(window fyne.Window) *fyne.Container {
+	bar := &ProfileBar{
+		window: window,
+	}
+	bar.selector = widget.NewSelect([]string{}, nil)
+	bar.selector.PlaceHolder = "Select a profile"
+	bar.refreshProfiles()
+
+	loadButton := widget.NewButton("Load", bar.loadSelected)
+	saveButton := widget.NewButton("Save", bar.saveSelected)
+	saveAsButton := widget.NewButton("Save As", bar.saveAs)
+	duplicateButton := widget.NewButton("Duplicate", bar.duplicateSelected)
+	deleteButton := widget.NewButton("Delete", bar.deleteSelected)
+
+	buttons := container.NewHBox(loadButton, saveButton, saveAsButton, duplicateButton, deleteButton)
+	return container.NewBorder(nil, nil, widget.NewLabel("Profile"), buttons, bar.selector)
+}
+
+func (self *ProfileBar) refreshProfiles() {
+	names, err := appstate.ListProfiles()
+	if err != nil {
+		log.Printf("Error listing profiles: %v\n", err)
+		return
+	}
+	self.selector.SetOptions(names)
+}
+
+// requireSelection returns the selected profile name, showing an error if none is selected
+func (self *ProfileBar) requireSelection() (string, bool) {
+	name := self.selector.Selected
+	if name == "" {
+		dialog.ShowError(fmt.Errorf("no profile selected"), self.window)
+		return "", false
+	}
+	return name, true
+}
+
+// askForName prompts for a profile name and runs onName with it
+func (self *ProfileBar) askForName(title string, onName func(string)) {
+	entry := widget.NewEntry()
+	items := []*widget.FormItem{widget.NewFormItem("Name", entry)}
+	dialog.ShowForm(title, "Ok", "Cancel", items, func(confirmed bool) {
+		if confirmed {
+			onName(entry.Text)
+		}
+	}, self.window)
+}
+
+func (self *ProfileBar) loadSelected() {
+	name, ok := self.requireSelection()
+	if !ok {
+		return
+	}
+	if err := appstate.GetGlobalState().LoadProfile(name); err != nil {
+		dialog.ShowError(err, self.window)
+	}
+}
+
+func (self *ProfileBar) save(name string) {
+	if err := appstate.GetGlobalState().SaveProfile(name); err != nil {
+		dialog.ShowError(err, self.window)
+		return
+	}
+	self.refreshProfiles()
+	self.selector.SetSelected(name)
+}
+
+func (self *ProfileBar) saveSelected() {
+	if self.selector.Selected == "" {
+		self.saveAs()
+		return
+	}
+	self.save(self.selector.Selected)
+}
+
+func (self *ProfileBar) saveAs() {
+	self.askForName("Save Profile As", self.save)
+}
+
+func (self *ProfileBar) duplicateSelected() {
+	source, ok := self.requireSelection()
+	if !ok {
+		return
+	}
+	self.askForName("Duplicate Profile", func(destination string) {
+		if err := appstate.DuplicateProfile(source, destination); err != nil {
+			dialog.ShowError(err, self.window)
+			return
+		}
+		self.refreshProfiles()
+		self.selector.SetSelected(destination)
+	})
+}
+
+func (self *ProfileBar) deleteSelected() {
+	name, ok := self.requireSelection()
+	if !ok {
+		return
+	}
+	dialog.ShowConfirm("Delete Profile", fmt.Sprintf("Delete profile %s?", name), func(confirmed bool) {
+		if !confirmed {
+			return
+		}
+		if err := appstate.DeleteProfile(name); err != nil {
+			dialog.ShowError(err, self.window)
+			return
+		}
+		self.selector.ClearSelected()
+		self.refreshProfiles()
+	}, self.window)
+}
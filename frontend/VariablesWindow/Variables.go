@@ -0,0 +1,165 @@
+package variableswindow
+
+import (
+	appstate "LiveBuilder/AppState"
+	filesystem "LiveBuilder/Filesystem"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+// VariablesWindow edits the template variables of the current profile, the variables the
+// selected files declare are listed first and any other variable can be added below them
+type VariablesWindow struct {
+	window   fyne.Window
+	declared *widget.Form
+	others   *fyne.Container
+	content  *fyne.Container
+}
+
+func NewVariablesWindow(window fyne.Window) *VariablesWindow {
+	variables_window := &VariablesWindow{
+		window:   window,
+		declared: widget.NewForm(),
+		others:   container.NewVBox(),
+	}
+	addButton := widget.NewButtonWithIcon("Add Variable", theme.ContentAddIcon(), variables_window.addVariable)
+	variables_window.content = container.NewVBox(
+		widget.NewCard("Declared by the selected files", "required variables are marked with *", variables_window.declared),
+		widget.NewCard("Other variables", "available to templates as {{.name}}, the build sets build_date and git_revision", container.NewVBox(variables_window.others, container.NewHBox(addButton))),
+	)
+	appstate.GetGlobalState().OnChange(func() {
+		fyne.Do(variables_window.Refresh)
+	})
+	variables_window.Refresh()
+	return variables_window
+}
+
+// Refresh rebuilds the lists, file selections do not notify so the tab calls this when shown
+func (self *VariablesWindow) Refresh() {
+	state := appstate.GetGlobalState()
+	values := state.Variables()
+	specs := state.DeclaredVariables()
+
+	self.declared.Items = nil
+	for _, spec := range specs {
+		self.declared.AppendItem(newDeclaredItem(spec, values[spec.Name]))
+	}
+	self.declared.Refresh()
+
+	var names []string
+	for name := range values {
+		if !slices.ContainsFunc(specs, func(spec filesystem.VariableSpec) bool { return spec.Name == name }) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	self.others.RemoveAll()
+	for _, name := range names {
+		self.others.Add(self.buildOtherRow(name, values[name]))
+	}
+	self.others.Refresh()
+}
+
+func newDeclaredItem(spec filesystem.VariableSpec, value string) *widget.FormItem {
+	entry := widget.NewEntry()
+	entry.SetPlaceHolder(spec.Default)
+	entry.SetText(value)
+	entry.OnChanged = func(text string) {
+		setVariable(spec.Name, text)
+	}
+	label := spec.Name
+	if spec.Required {
+		label += " *"
+	}
+	item := widget.NewFormItem(label, entry)
+	item.HintText = spec.Description
+	return item
+}
+
+func (self *VariablesWindow) buildOtherRow(name, value string) fyne.CanvasObject {
+	entry := widget.NewEntry()
+	entry.SetText(value)
+	entry.OnChanged = func(text string) {
+		setVariable(name, text)
+	}
+	removeButton := widget.NewButtonWithIcon("", theme.DeleteIcon(), func() {
+		appstate.GetGlobalState().UnsetVariable(name)
+		self.Refresh()
+	})
+	return container.NewBorder(nil, nil, widget.NewLabel(name), removeButton, entry)
+}
+
+func (self *VariablesWindow) addVariable() {
+	nameEntry := widget.NewEntry()
+	valueEntry := widget.NewEntry()
+	items := []*widget.FormItem{
+		widget.NewFormItem("Name", nameEntry),
+		widget.NewFormItem("Value", valueEntry),
+	}
+	dialog.ShowForm("Add Variable", "Ok", "Cancel", items, func(confirmed bool) {
+		if !confirmed {
+			return
+		}
+		if err := appstate.GetGlobalState().SetVariable(nameEntry.Text, valueEntry.Text); err != nil {
+			dialog.ShowError(err, self.window)
+			return
+		}
+		self.Refresh()
+	}, self.window)
+}
+
+func (self *VariablesWindow) GetContainer() *fyne.Container {
+	return container.NewBorder(nil, nil, nil, nil, container.NewVScroll(self.content))
+}
+
+// PromptForMissing asks for the required variables that have no value yet and runs onDone
+// once all of them are set, it runs onDone straight away when nothing is missing
+func PromptForMissing(window fyne.Window, onDone func()) {
+	missing := appstate.GetGlobalState().MissingVariables()
+	if len(missing) == 0 {
+		onDone()
+		return
+	}
+	var items []*widget.FormItem
+	entries := make([]*widget.Entry, len(missing))
+	for i, spec := range missing {
+		entries[i] = widget.NewEntry()
+		entries[i].Validator = func(text string) error {
+			return requireValue(spec.Name, text)
+		}
+		item := widget.NewFormItem(spec.Name, entries[i])
+		item.HintText = spec.Description
+		items = append(items, item)
+	}
+	dialog.ShowForm("Required Variables", "Build", "Cancel", items, func(confirmed bool) {
+		if !confirmed {
+			return
+		}
+		for i, spec := range missing {
+			setVariable(spec.Name, entries[i].Text)
+		}
+		PromptForMissing(window, onDone)
+	}, window)
+}
+
+func setVariable(name, value string) {
+	if err := appstate.GetGlobalState().SetVariable(name, value); err != nil {
+		log.Printf("Error setting variable %s: %v\n", name, err)
+	}
+}
+
+func requireValue(name, text string) error {
+	if strings.TrimSpace(text) == "" {
+		return fmt.Errorf("%s is required", name)
+	}
+	return nil
+}
@@ -5,7 +5,11 @@ import (
 	filesystem "LiveBuilder/Filesystem"
 	buildwindow "LiveBuilder/frontend/BuildWindow"
 	filelistwidgets "LiveBuilder/frontend/FileListWidgets"
+	historywindow "LiveBuilder/frontend/HistoryWindow"
+	imagewindow "LiveBuilder/frontend/ImageWindow"
 	livebuildconfig "LiveBuilder/frontend/LiveBuildConfig"
+	profilebar "LiveBuilder/frontend/ProfileBar"
+	variableswindow "LiveBuilder/frontend/VariablesWindow"
 
 	//"fmt"
 	"fyne.io/fyne/v2"
@@ -23,15 +27,31 @@ func buildFileSelectionView() *fyne.Container {
 	return container.NewBorder(nil, nil, nil, nil, tabs)
 }
 
-func buildLBConfigView() *fyne.Container {
-	cfgtab := livebuildconfig.NewLBConfigurationTab()
+func buildLBConfigView(window fyne.Window) *fyne.Container {
+	cfgtab := livebuildconfig.NewLBConfigurationTab(window)
 	return cfgtab.GetContainer()
 }
 
-func buildBuildWindow(window fyne.Window) *fyne.Container {
+func buildVariablesView(window fyne.Window) *variableswindow.VariablesWindow {
+	return variableswindow.NewVariablesWindow(window)
+}
+
+func buildBuildWindow(window fyne.Window) *buildwindow.BuildWindow {
 	return buildwindow.NewBuildWindow(window)
 }
 
-func buildAppConfigView() *fyne.Container {
-	return &fyne.Container{}
+func buildHistoryView(window fyne.Window, onRerun func()) *historywindow.HistoryWindow {
+	return historywindow.NewHistoryWindow(window, onRerun)
+}
+
+func buildProfileBar(window fyne.Window) *fyne.Container {
+	return profilebar.NewProfileBar(window)
+}
+
+func buildImageView(window fyne.Window) *imagewindow.ImageWindow {
+	return imagewindow.NewImageWindow(window)
+}
+
+func buildDuplicatorView(window fyne.Window) *imagewindow.DuplicatorWindow {
+	return imagewindow.NewDuplicatorWindow(window)
 }
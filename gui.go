@@ -0,0 +1,13 @@
+package main
+
+import (
+	cli "LiveBuilder/CLI"
+	frontend "LiveBuilder/frontend"
+)
+
+func init() {
+	cli.ShowGUI = func() {
+		mainWindow := frontend.NewMainWindow("Live Builder")
+		mainWindow.ShowAndRun()
+	}
+}
@@ -1,11 +1,8 @@
 package main
 
 import (
+	cli "LiveBuilder/CLI"
 	filesystem "LiveBuilder/Filesystem"
-	preflightchecks "LiveBuilder/PreFlightChecks"
-	usbimager "LiveBuilder/USBImager"
-	frontend "LiveBuilder/frontend"
-	"fmt"
 	"log"
 	"os"
 )
@@ -25,21 +22,9 @@ func configureLogging() {
 }
 
 func main() {
-	defer LOGFILE.Close()
 	configureLogging()
 	log.Println("App Start")
-	testMain()
-}
-
-func guiMain() {
-	preflightchecks.CheckAll(false)
-
-	mainWindow := frontend.NewMainWindow("Live Builder")
-	mainWindow.ShowAndRun()
-}
-
-func testMain() {
-	imager := usbimager.NewUSBImager()
-	err := imager.ImageUSB("/tmp/Fake.iso", "/dev/sde")
-	fmt.Println(err)
+	code := cli.Run(os.Args[1:])
+	LOGFILE.Close()
+	os.Exit(code)
 }